@@ -0,0 +1,169 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker-registry/configuration"
+	"github.com/docker/docker-registry/storage"
+)
+
+// defaultAdmissionTimeout limits the time taken by the admission endpoint if
+// no timeout is configured.
+const defaultAdmissionTimeout = 10 * time.Second
+
+// admissionHook asks an external HTTP endpoint whether a manifest may be
+// pushed, such that pushes can be subject to policies maintained outside of
+// the registry.
+type admissionHook struct {
+	url    string
+	client *http.Client
+
+	// failOpen admits pushes when the endpoint fails to decide.
+	failOpen bool
+}
+
+// newAdmissionHook returns the admission hook configured by the admission
+// section, or nil if no endpoint is configured.
+func newAdmissionHook(config configuration.Admission) (*admissionHook, error) {
+	if config.URL == "" {
+		return nil, nil
+	}
+
+	timeout := defaultAdmissionTimeout
+	if config.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(config.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid admission timeout %q: %v", config.Timeout, err)
+		}
+	}
+
+	return &admissionHook{
+		url: config.URL,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+		failOpen: config.FailOpen,
+	}, nil
+}
+
+// admissionRequest is posted to the admission endpoint for each manifest
+// revision stored or tagged.
+type admissionRequest struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+
+	// Digest is the revision of the manifest.
+	Digest string `json:"digest"`
+
+	// Identity is the client pushing the manifest, if known. It is not set
+	// for revisions copied by the registry itself.
+	Identity string `json:"identity,omitempty"`
+
+	// Manifest is the manifest as pushed or, for revisions tagged again or
+	// copied, as stored by the registry. It is embedded as a JSON object,
+	// so its formatting may differ from the stored bytes.
+	Manifest json.RawMessage `json:"manifest"`
+}
+
+// admissionResponse is the decision returned by the admission endpoint.
+type admissionResponse struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// errAdmissionDenied is returned when the admission endpoint denies a push.
+type errAdmissionDenied struct {
+	Reason string
+}
+
+func (err errAdmissionDenied) Error() string {
+	if err.Reason == "" {
+		return "manifest denied by admission endpoint"
+	}
+
+	return fmt.Sprintf("manifest denied by admission endpoint: %s", err.Reason)
+}
+
+// errAdmissionUnavailable is returned when the admission endpoint cannot be
+// reached or does not return a valid decision.
+type errAdmissionUnavailable struct {
+	Err error
+}
+
+func (err errAdmissionUnavailable) Error() string {
+	return fmt.Sprintf("admission endpoint unavailable: %v", err.Err)
+}
+
+// admitFunc returns the function asking the admission endpoint whether a
+// manifest may be stored on behalf of identity, or nil if no endpoint is
+// configured. If the endpoint fails to decide and the hook fails open, the
+// failure is logged and the manifest is admitted.
+func (ah *admissionHook) admitFunc(identity string) storage.AdmitFunc {
+	if ah == nil {
+		return nil
+	}
+
+	return func(name, tag string, manifest *storage.SignedManifest) error {
+		err := ah.admit(name, tag, identity, manifest)
+		if _, ok := err.(errAdmissionUnavailable); ok && ah.failOpen {
+			log.Warnf("admitting manifest %s:%s: %v", name, tag, err)
+			return nil
+		}
+
+		return err
+	}
+}
+
+// admit posts the manifest to the admission endpoint. It returns
+// errAdmissionDenied if the endpoint denies the manifest, or
+// errAdmissionUnavailable if it fails to decide.
+func (ah *admissionHook) admit(name, tag, identity string, manifest *storage.SignedManifest) error {
+	dgst, err := manifest.Digest()
+	if err != nil {
+		return err
+	}
+
+	request := admissionRequest{
+		Repository: name,
+		Tag:        tag,
+		Digest:     dgst.String(),
+		Identity:   identity,
+		Manifest:   json.RawMessage(manifest.Raw),
+	}
+
+	p, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	resp, err := ah.client.Post(ah.url, "application/json", bytes.NewReader(p))
+	if err != nil {
+		return errAdmissionUnavailable{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Drain the body, so that the connection may be reused.
+		io.Copy(ioutil.Discard, resp.Body)
+		return errAdmissionUnavailable{Err: fmt.Errorf("unexpected status %s", resp.Status)}
+	}
+
+	var decision admissionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return errAdmissionUnavailable{Err: fmt.Errorf("invalid response: %v", err)}
+	}
+
+	if !decision.Allowed {
+		return errAdmissionDenied{Reason: decision.Reason}
+	}
+
+	return nil
+}
@@ -17,6 +17,14 @@ var (
 		Description: `Name of the target repository.`,
 	}
 
+	referenceParameterDescriptor = ParameterDescriptor{
+		Name:        "reference",
+		Type:        "string",
+		Format:      common.TagNameRegexp.String() + "|" + digest.DigestRegexp.String(),
+		Required:    true,
+		Description: `Tag or digest of the target manifest. A digest may only be used to fetch a manifest.`,
+	}
+
 	tagParameterDescriptor = ParameterDescriptor{
 		Name:        "tag",
 		Type:        "string",
@@ -58,12 +66,115 @@ var (
 		},
 	}
 
+	acceptManifestListHeader = ParameterDescriptor{
+		Name:        "Accept",
+		Description: "Include `application/vnd.docker.distribution.manifest.list.v1+prettyjws` to receive manifest lists. Otherwise, the image manifest for `linux/amd64` is returned in place of a manifest list fetched by tag. Manifest lists fetched by digest are always returned.",
+		Type:        "string",
+		Format:      "<media type>, ...",
+	}
+
+	digestHeader = ParameterDescriptor{
+		Name:        "Docker-Content-Digest",
+		Description: "Digest of the targeted content for the request.",
+		Type:        "digest",
+		Format:      "<digest>",
+	}
+
+	paginationParameters = []ParameterDescriptor{
+		{
+			Name:        "n",
+			Type:        "integer",
+			Description: "Limit the number of entries in each response. If not present, all entries will be returned.",
+			Format:      "<integer>",
+			Required:    false,
+		},
+		{
+			Name:        "last",
+			Type:        "string",
+			Description: "Result set will include values lexically after last.",
+			Format:      "<string>",
+			Required:    false,
+		},
+	}
+
+	linkHeader = ParameterDescriptor{
+		Name:        "Link",
+		Type:        "link",
+		Description: "RFC5988 compliant rel='next' with URL to next result set, if available. Only present if more results are available.",
+		Format:      `<<url>?n=<n>&last=<last>>; rel="next"`,
+	}
+
 	contentLengthZeroHeader = ParameterDescriptor{
 		Name:        "Content-Length",
 		Description: "The `Content-Length` header must be zero and the body must be empty.",
 		Type:        "integer",
 		Format:      "0",
 	}
+
+	readOnlyResponse = ResponseDescriptor{
+		Name:        "Read-Only",
+		Description: "The registry is in read-only mode and is not accepting writes. The request may be retried once the registry is writable.",
+		StatusCode:  http.StatusServiceUnavailable,
+		ErrorCodes: []ErrorCode{
+			ErrorCodeReadOnly,
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+	}
+
+	tagImmutableResponse = ResponseDescriptor{
+		Name:        "Tag Immutable",
+		Description: "The tag is immutable and already exists. It cannot be changed or deleted.",
+		StatusCode:  http.StatusConflict,
+		ErrorCodes: []ErrorCode{
+			ErrorCodeTagImmutable,
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+	}
+
+	manifestDeniedResponse = ResponseDescriptor{
+		Name:        "Manifest Denied",
+		Description: "The admission endpoint of the registry denied the manifest. The error detail holds the reason given by the endpoint.",
+		StatusCode:  http.StatusForbidden,
+		ErrorCodes: []ErrorCode{
+			ErrorCodeManifestDenied,
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+	}
+
+	admissionUnavailableResponse = ResponseDescriptor{
+		Name:        "Admission Unavailable",
+		Description: "The admission endpoint of the registry could not be reached or failed to decide in time. The request may be retried.",
+		StatusCode:  http.StatusServiceUnavailable,
+		ErrorCodes: []ErrorCode{
+			ErrorCodeAdmissionUnavailable,
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+	}
+
+	quotaExceededResponse = ResponseDescriptor{
+		Name:        "Quota Exceeded",
+		Description: "Storing the content would exceed a quota applying to the repository or to a namespace containing it. The error detail describes the quota.",
+		StatusCode:  http.StatusForbidden,
+		ErrorCodes: []ErrorCode{
+			ErrorCodeQuotaExceeded,
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+	}
 )
 
 const (
@@ -81,6 +192,25 @@ const (
    "signature": <JWS>
 }`
 
+	manifestListBody = `{
+   "schemaVersion": 1,
+   "mediaType": "application/vnd.docker.distribution.manifest.list.v1+prettyjws",
+   "name": <name>,
+   "tag": <tag>,
+   "manifests": [
+      {
+         "digest": <digest>,
+         "platform": {
+            "architecture": <architecture>,
+            "os": <os>,
+            "variant": <variant>
+         }
+      },
+      ...
+   ],
+   "signature": <JWS>
+}`
+
 	errorsBody = `{
 	"errors:" [{
             "code": <error code>,
@@ -90,6 +220,36 @@ const (
         ...
     ]
 }`
+
+	readOnlyBody = `{
+    "readonly": <true or false>
+}`
+
+	repositoryMoveBody = `{
+    "from": <name>,
+    "to": <name>
+}`
+
+	signingKeyBody = `{
+    "keys": [
+        <jwk>
+    ]
+}`
+
+	usageBody = `{
+    "name": <name>,
+    "layers": <bytes>,
+    "manifests": <bytes>,
+    "quotas": [
+        {
+            "scope": "repository" | "namespace",
+            "name": <name or namespace>,
+            "limit": <bytes>,
+            "usage": <bytes>
+        },
+        ...
+    ]
+}`
 )
 
 // APIDescriptor exports descriptions of the layout of the v2 registry API.
@@ -305,6 +465,336 @@ var routeDescriptors = []RouteDescriptor{
 			},
 		},
 	},
+	{
+		Name:        RouteNameCatalog,
+		Path:        "/v2/_catalog",
+		Entity:      "Catalog",
+		Description: "List the repositories available in the registry.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Retrieve a sorted list of the repositories available in the registry. The list may be paginated with the `n` and `last` parameters.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							authHeader,
+						},
+						QueryParameters: paginationParameters,
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "A list of repositories, in lexical order. If more repositories are available, a `Link` header will provide the url for the next page.",
+								Headers: []ParameterDescriptor{
+									linkHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "repositories": [
+        <name>,
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusBadRequest,
+								Description: "The pagination parameters were invalid.",
+								ErrorCodes: []ErrorCode{
+									ErrorCodePaginationInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								StatusCode:  http.StatusUnauthorized,
+								Description: "The client doesn't have access to the catalog.",
+								Headers: []ParameterDescriptor{
+									authChallengeHeader,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameReadOnly,
+		Path:        "/v2/_admin/readonly",
+		Entity:      "Read-Only Mode",
+		Description: "Query or toggle read-only mode. While read-only, the registry rejects all `POST`, `PUT`, `PATCH` and `DELETE` requests, other than those to this endpoint, while continuing to serve pulls. Access requires the `registry:admin:*` scope.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Retrieve the current mode of the registry.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							authHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      readOnlyBody,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusUnauthorized,
+								Description: "The client doesn't have administrative access to the registry.",
+								Headers: []ParameterDescriptor{
+									authChallengeHeader,
+								},
+							},
+						},
+					},
+				},
+			},
+			{
+				Method:      "PUT",
+				Description: "Enable or disable read-only mode. The mode is held in memory by the registry instance handling the request and reverts to the configured mode on restart.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							authHeader,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format:      readOnlyBody,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "The mode has been set. The body contains the new mode.",
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      readOnlyBody,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusBadRequest,
+								Description: "The request body could not be parsed.",
+							},
+							{
+								StatusCode:  http.StatusUnauthorized,
+								Description: "The client doesn't have administrative access to the registry.",
+								Headers: []ParameterDescriptor{
+									authChallengeHeader,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameRename,
+		Path:        "/v2/_admin/rename",
+		Entity:      "Repository Rename",
+		Description: "Rename a repository, moving its layer links, manifests, tags and tag history to a new name. Layer data is not copied. Manifests are signed over the repository name, so each revision is rewritten with the new name and re-signed with the registry signing key, giving it a new digest; without a signing key, only repositories holding no manifests may be renamed. Repositories nested under the old name are not moved. Access requires the `registry:admin:*` scope.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "POST",
+				Description: "Rename the repository `from` to `to`, which must not exist. Each manifest is checked against the trust policies and admission of the destination before anything is written. The operation is not atomic, but if it fails part way, what was written to the destination is removed again.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							authHeader,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format:      repositoryMoveBody,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusNoContent,
+								Description: "The repository has been renamed.",
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusBadRequest,
+								Description: "The request body could not be parsed or a repository name is invalid.",
+								ErrorCodes: []ErrorCode{
+									ErrorCodeNameInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								StatusCode:  http.StatusUnauthorized,
+								Description: "The client doesn't have administrative access to the registry.",
+								Headers: []ParameterDescriptor{
+									authChallengeHeader,
+								},
+							},
+							{
+								StatusCode:  http.StatusNotFound,
+								Description: "The source repository has no manifests or layers.",
+								ErrorCodes: []ErrorCode{
+									ErrorCodeNameUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								StatusCode:  http.StatusConflict,
+								Description: "The destination repository already exists, the source repository has manifests and the registry has no signing key to re-sign them, or a rewritten manifest was rejected by the destination, such as by a trust policy that does not trust the registry signing key. Rejected manifests are reported as for a manifest put.",
+								ErrorCodes: []ErrorCode{
+									ErrorCodeNameExists,
+									ErrorCodeSigningKeyRequired,
+									ErrorCodeManifestInvalid,
+									ErrorCodeManifestUnverified,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							quotaExceededResponse,
+							manifestDeniedResponse,
+							admissionUnavailableResponse,
+							readOnlyResponse,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameCopy,
+		Path:        "/v2/_admin/copy",
+		Entity:      "Repository Copy",
+		Description: "Copy a repository to a new name, sharing its layer data. Manifests are re-signed under the new name as for a rename, dropping the signatures of their original signers, and are subject to the validation and trust policies of the destination. Access requires the `registry:admin:*` scope.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "POST",
+				Description: "Copy the repository `from` to `to`, which must not exist. The copy counts towards the quotas applying to `to`.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							authHeader,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format:      repositoryMoveBody,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusNoContent,
+								Description: "The repository has been copied.",
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusBadRequest,
+								Description: "The request body could not be parsed or a repository name is invalid.",
+								ErrorCodes: []ErrorCode{
+									ErrorCodeNameInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								StatusCode:  http.StatusUnauthorized,
+								Description: "The client doesn't have administrative access to the registry.",
+								Headers: []ParameterDescriptor{
+									authChallengeHeader,
+								},
+							},
+							{
+								StatusCode:  http.StatusNotFound,
+								Description: "The source repository has no manifests or layers.",
+								ErrorCodes: []ErrorCode{
+									ErrorCodeNameUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								StatusCode:  http.StatusConflict,
+								Description: "The destination repository already exists, the source repository has manifests and the registry has no signing key to re-sign them, or a rewritten manifest was rejected by the destination, such as by a trust policy that does not trust the registry signing key. Rejected manifests are reported as for a manifest put.",
+								ErrorCodes: []ErrorCode{
+									ErrorCodeNameExists,
+									ErrorCodeSigningKeyRequired,
+									ErrorCodeManifestInvalid,
+									ErrorCodeManifestUnverified,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							quotaExceededResponse,
+							manifestDeniedResponse,
+							admissionUnavailableResponse,
+							readOnlyResponse,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameSigningKey,
+		Path:        "/v2/_trust/key",
+		Entity:      "Signing Key",
+		Description: "Retrieve the public key with which the registry signs manifests, such as those pushed unsigned or rewritten by a rename or copy. The key can be used to verify such manifests, or added to a trust policy.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Fetch the public key of the registry as a JSON Web Key Set.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							authHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      signingKeyBody,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusNotFound,
+								Description: "The registry has no signing key configured.",
+								ErrorCodes: []ErrorCode{
+									ErrorCodeSigningKeyRequired,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
 	{
 		Name:        RouteNameTags,
 		Path:        "/v2/{name:" + common.RepositoryNameRegexp.String() + "}/tags/list",
@@ -313,16 +803,20 @@ var routeDescriptors = []RouteDescriptor{
 		Methods: []MethodDescriptor{
 			{
 				Method:      "GET",
-				Description: "Fetch the tags under the repository identified by `name`.",
+				Description: "Fetch the tags under the repository identified by `name`, in lexical order. The list may be paginated with the `n` and `last` parameters.",
 				Requests: []RequestDescriptor{
 					{
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
 						},
+						QueryParameters: paginationParameters,
 						Successes: []ResponseDescriptor{
 							{
 								StatusCode:  http.StatusOK,
-								Description: "A list of tags for the named repository.",
+								Description: "A list of tags for the named repository. If more tags are available, a `Link` header will provide the url for the next page.",
+								Headers: []ParameterDescriptor{
+									linkHeader,
+								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
 									Format: `{
@@ -336,6 +830,17 @@ var routeDescriptors = []RouteDescriptor{
 							},
 						},
 						Failures: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusBadRequest,
+								Description: "The pagination parameters were invalid.",
+								ErrorCodes: []ErrorCode{
+									ErrorCodePaginationInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
 							{
 								StatusCode:  http.StatusNotFound,
 								Description: "The repository is not known to the registry.",
@@ -350,28 +855,207 @@ var routeDescriptors = []RouteDescriptor{
 			},
 		},
 	},
+	{
+		Name:        RouteNameTagHistory,
+		Path:        "/v2/{name:" + common.RepositoryNameRegexp.String() + "}/tags/{tag:" + common.TagNameRegexp.String() + "}/history",
+		Entity:      "Tag History",
+		Description: "Retrieve the history of a tag and roll the tag back to a previous revision.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Fetch the manifest revisions referenced by the tag identified by `name` and `tag`, oldest first.",
+				Requests: []RequestDescriptor{
+					{
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							tagParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "The history of the tag. The identity is only present if the client updating the tag was authenticated.",
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "tag": <tag>,
+    "history": [
+        {
+            "revision": <digest>,
+            "timestamp": <timestamp>,
+            "identity": <identity>
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusNotFound,
+								Description: "The tag is not known to the registry.",
+								ErrorCodes: []ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								StatusCode:  http.StatusUnauthorized,
+								Description: "The client doesn't have access to repository.",
+							},
+						},
+					},
+				},
+			},
+			{
+				Method:      "PUT",
+				Description: "Point the tag identified by `name` and `tag` at a revision from its history. The change is recorded in the history of the tag.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							tagParameterDescriptor,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `{
+    "revision": <digest>
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The tag now references the revision.",
+								StatusCode:  http.StatusAccepted,
+								Headers: []ParameterDescriptor{
+									digestHeader,
+									contentLengthZeroHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							readOnlyResponse,
+							tagImmutableResponse,
+							manifestDeniedResponse,
+							admissionUnavailableResponse,
+							{
+								StatusCode: http.StatusBadRequest,
+								ErrorCodes: []ErrorCode{
+									ErrorCodeDigestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The tag is not known or the revision is not in the history of the tag.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								StatusCode: http.StatusUnauthorized,
+								Headers: []ParameterDescriptor{
+									authChallengeHeader,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameUsage,
+		Path:        "/v2/{name:" + common.RepositoryNameRegexp.String() + "}/usage",
+		Entity:      "Usage",
+		Description: "Retrieve the storage used by a repository and the quotas applying to it.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Fetch the bytes stored by the repository identified by `name`. Layers count towards the usage of each repository they are linked into, along with the manifest revisions of the repository. Quotas are listed in order of increasing scope, with the usage they limit.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      usageBody,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusUnauthorized,
+								Description: "The client doesn't have access to repository.",
+								Headers: []ParameterDescriptor{
+									authChallengeHeader,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
 	{
 		Name:        RouteNameManifest,
-		Path:        "/v2/{name:" + common.RepositoryNameRegexp.String() + "}/manifests/{tag:" + common.TagNameRegexp.String() + "}",
+		Path:        "/v2/{name:" + common.RepositoryNameRegexp.String() + "}/manifests/{reference:" + common.TagNameRegexp.String() + "|" + digest.DigestRegexp.String() + "}",
 		Entity:      "Manifest",
 		Description: "Create, update and retrieve manifests.",
 		Methods: []MethodDescriptor{
 			{
 				Method:      "GET",
-				Description: "Fetch the manifest identified by `name` and `tag`.",
+				Description: "Fetch the manifest identified by `name` and `reference` where `reference` can be a tag or digest. If a tag identifies a manifest list, the response depends on the `Accept` header.",
 				Requests: []RequestDescriptor{
 					{
+						Headers: []ParameterDescriptor{
+							acceptManifestListHeader,
+						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
-							tagParameterDescriptor,
+							referenceParameterDescriptor,
 						},
 						Successes: []ResponseDescriptor{
 							{
-								Description: "The manifest idenfied by `name` and `tag`.",
+								Description: "The manifest idenfied by `name` and `reference`. The contents can be used to identify and resolve resources required to run the specified image.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									digestHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      manifestBody,
+								},
+							},
+							{
+								Description: "The manifest list identified by `name` and `reference`, returned if the client accepts manifest lists or `reference` is a digest. Each entry references an image manifest in the repository by digest, which can be fetched with this endpoint.",
 								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									digestHeader,
+								},
 								Body: BodyDescriptor{
-									ContentType: "application/json",
-									Format:      manifestBody,
+									ContentType: "application/vnd.docker.distribution.manifest.list.v1+prettyjws",
+									Format:      manifestListBody,
 								},
 							},
 						},
@@ -400,13 +1084,24 @@ var routeDescriptors = []RouteDescriptor{
 									Format:      errorsBody,
 								},
 							},
+							{
+								Description: "The manifest is not signed by a signer trusted for the repository, and the registry refuses to serve untrusted manifests.",
+								StatusCode:  http.StatusForbidden,
+								ErrorCodes: []ErrorCode{
+									ErrorCodeManifestUnverified,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
 						},
 					},
 				},
 			},
 			{
 				Method:      "PUT",
-				Description: "Put the manifest identified by `name` and `tag`.",
+				Description: "Put the manifest identified by `name` and `reference` where `reference` must be a tag. The body may also be a signed manifest list, whose entries must reference image manifests already stored in the repository.",
 				Requests: []RequestDescriptor{
 					{
 						Headers: []ParameterDescriptor{
@@ -414,7 +1109,7 @@ var routeDescriptors = []RouteDescriptor{
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
-							tagParameterDescriptor,
+							referenceParameterDescriptor,
 						},
 						Body: BodyDescriptor{
 							ContentType: "application/json",
@@ -422,10 +1117,19 @@ var routeDescriptors = []RouteDescriptor{
 						},
 						Successes: []ResponseDescriptor{
 							{
-								StatusCode: http.StatusAccepted,
+								Description: "The manifest has been accepted by the registry and is stored under the specified `name` and `tag`.",
+								StatusCode:  http.StatusAccepted,
+								Headers: []ParameterDescriptor{
+									digestHeader,
+								},
 							},
 						},
 						Failures: []ResponseDescriptor{
+							readOnlyResponse,
+							quotaExceededResponse,
+							tagImmutableResponse,
+							manifestDeniedResponse,
+							admissionUnavailableResponse,
 							{
 								StatusCode: http.StatusBadRequest,
 								ErrorCodes: []ErrorCode{
@@ -433,7 +1137,22 @@ var routeDescriptors = []RouteDescriptor{
 									ErrorCodeTagInvalid,
 									ErrorCodeManifestInvalid,
 									ErrorCodeManifestUnverified,
+									ErrorCodeManifestHistoryInvalid,
+									ErrorCodeManifestLayersMismatch,
+									ErrorCodeManifestParentInvalid,
 									ErrorCodeBlobUnknown,
+									ErrorCodeManifestUnknown,
+								},
+							},
+							{
+								Description: "A manifest list references a manifest that is not stored in the repository. The unknown revisions are enumerated in the error response.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
 								},
 							},
 							{
@@ -469,7 +1188,7 @@ var routeDescriptors = []RouteDescriptor{
 			},
 			{
 				Method:      "DELETE",
-				Description: "Delete the manifest identified by `name` and `tag`.",
+				Description: "Delete the tag identified by `name` and `reference` where `reference` must be a tag. The manifest revision remains available by digest until it is removed by garbage collection, unless it is referenced by another tag or manifest list.",
 				Requests: []RequestDescriptor{
 					{
 						Headers: []ParameterDescriptor{
@@ -477,7 +1196,7 @@ var routeDescriptors = []RouteDescriptor{
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
-							tagParameterDescriptor,
+							referenceParameterDescriptor,
 						},
 						Successes: []ResponseDescriptor{
 							{
@@ -485,6 +1204,8 @@ var routeDescriptors = []RouteDescriptor{
 							},
 						},
 						Failures: []ResponseDescriptor{
+							readOnlyResponse,
+							tagImmutableResponse,
 							{
 								StatusCode: http.StatusBadRequest,
 								ErrorCodes: []ErrorCode{
@@ -512,11 +1233,95 @@ var routeDescriptors = []RouteDescriptor{
 		},
 	},
 
+	{
+		Name:        RouteNameSignatures,
+		Path:        "/v2/{name:" + common.RepositoryNameRegexp.String() + "}/manifests/{digest:" + digest.DigestRegexp.String() + "}/signatures",
+		Entity:      "Manifest Signatures",
+		Description: "Add signatures to a stored manifest revision without pushing it again. Signatures are stored separately from the manifest and merged into its `signatures` when it is fetched.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "POST",
+				Description: "Add the signatures of the signed manifest in the body to the revision identified by `name` and `digest`. The body must have the same payload as the revision, such as the fetched manifest signed again by the client. Every signature in the body must verify. Signatures the revision already holds are only stored once.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							{
+								Name:        "digest",
+								Type:        "path",
+								Required:    true,
+								Format:      digest.DigestRegexp.String(),
+								Description: `Digest of the manifest revision.`,
+							},
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format:      manifestBody,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The signatures have been added. The revision, with all of its signatures, is available at the location.",
+								StatusCode:  http.StatusCreated,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Location",
+										Type:        "url",
+										Description: "The location of the manifest revision.",
+										Format:      "<url>",
+									},
+									digestHeader,
+									contentLengthZeroHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							readOnlyResponse,
+							{
+								Description: "The body could not be parsed, its payload differs from that of the revision or a signature could not be verified.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []ErrorCode{
+									ErrorCodeNameInvalid,
+									ErrorCodeManifestInvalid,
+									ErrorCodeManifestUnverified,
+									ErrorCodeDigestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The manifest revision is not known to the repository.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								StatusCode: http.StatusUnauthorized,
+								Headers: []ParameterDescriptor{
+									authChallengeHeader,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+
 	{
 		Name:        RouteNameBlob,
 		Path:        "/v2/{name:" + common.RepositoryNameRegexp.String() + "}/blobs/{digest:" + digest.DigestRegexp.String() + "}",
 		Entity:      "Blob",
-		Description: "Fetch the blob identified by `name` and `digest`. Used to fetch layers by tarsum digest.",
+		Description: "Operations on blobs identified by `name` and `digest`. Used to fetch and delete layers by tarsum digest.",
 		Methods: []MethodDescriptor{
 
 			{
@@ -584,6 +1389,57 @@ var routeDescriptors = []RouteDescriptor{
 					},
 				},
 			},
+			{
+				Method:      "DELETE",
+				Description: "Remove the blob identified by `name` and `digest` from the repository. The blob content is not removed from the registry, since other repositories may reference it. Unreferenced content is removed by garbage collection.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							digestPathParameter,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusAccepted,
+								Headers: []ParameterDescriptor{
+									contentLengthZeroHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							readOnlyResponse,
+							{
+								StatusCode: http.StatusBadRequest,
+								ErrorCodes: []ErrorCode{
+									ErrorCodeNameInvalid,
+									ErrorCodeDigestInvalid,
+								},
+							},
+							{
+								Description: "The client does not have delete access to the repository.",
+								StatusCode:  http.StatusUnauthorized,
+								Headers: []ParameterDescriptor{
+									authChallengeHeader,
+								},
+								ErrorCodes: []ErrorCode{
+									ErrorCodeUnauthorized,
+								},
+							},
+							{
+								Description: "The blob, identified by `name` and `digest`, is unknown to the registry.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []ErrorCode{
+									ErrorCodeNameUnknown,
+									ErrorCodeBlobUnknown,
+								},
+							},
+						},
+					},
+				},
+			},
 			// TODO(stevvooe): We may want to add a PUT request here to
 			// kickoff an upload of a blob, integrated with the blob upload
 			// API.
@@ -618,7 +1474,7 @@ var routeDescriptors = []RouteDescriptor{
 							{
 								Name:        "digest",
 								Type:        "query",
-								Format:      "<tarsum>",
+								Format:      "<digest>",
 								Regexp:      digest.DigestRegexp,
 								Description: `Digest of uploaded blob. If present, the upload will be completed, in a single request, with contents of the request body as the resulting blob.`,
 							},
@@ -641,6 +1497,7 @@ var routeDescriptors = []RouteDescriptor{
 							},
 						},
 						Failures: []ResponseDescriptor{
+							readOnlyResponse,
 							{
 								Name:       "Invalid Name or Digest",
 								StatusCode: http.StatusBadRequest,
@@ -692,6 +1549,90 @@ var routeDescriptors = []RouteDescriptor{
 								},
 							},
 						},
+						Failures: []ResponseDescriptor{
+							readOnlyResponse,
+						},
+					},
+					{
+						Name:        "Mount Blob",
+						Description: "Mount a blob identified by the `mount` parameter from the repository identified by the `from` parameter, without uploading it again. The client must have pull access to the source repository. The request body should be empty.",
+						Headers: []ParameterDescriptor{
+							authHeader,
+							contentLengthZeroHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "mount",
+								Type:        "query",
+								Format:      "<digest>",
+								Regexp:      digest.DigestRegexp,
+								Description: "Digest of the blob to mount from the source repository.",
+							},
+							{
+								Name:        "from",
+								Type:        "query",
+								Format:      "<repository name>",
+								Regexp:      common.RepositoryNameRegexp,
+								Description: "Name of the repository containing the blob.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The blob has been mounted in the repository and is available at the provided location.",
+								StatusCode:  http.StatusCreated,
+								Headers: []ParameterDescriptor{
+									{
+										Name:   "Location",
+										Type:   "url",
+										Format: "<blob location>",
+									},
+									contentLengthZeroHeader,
+								},
+							},
+							{
+								Description: "The blob is not available in the source repository. A resumable upload has been created instead, as if the `mount` and `from` parameters were not provided.",
+								StatusCode:  http.StatusAccepted,
+								Headers: []ParameterDescriptor{
+									contentLengthZeroHeader,
+									{
+										Name:        "Location",
+										Type:        "url",
+										Format:      "/v2/<name>/blobs/uploads/<uuid>",
+										Description: "The location of the created upload. Clients should use the contents verbatim to complete the upload, adding parameters where required.",
+									},
+									{
+										Name:        "Range",
+										Format:      "0-0",
+										Description: "Range header indicating the progress of the upload. When starting an upload, it will return an empty range, since no content has been received.",
+									},
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							readOnlyResponse,
+							quotaExceededResponse,
+							{
+								Name:       "Invalid Name or Digest",
+								StatusCode: http.StatusBadRequest,
+								ErrorCodes: []ErrorCode{
+									ErrorCodeDigestInvalid,
+									ErrorCodeNameInvalid,
+								},
+							},
+							{
+								Name:       "Unauthorized",
+								StatusCode: http.StatusUnauthorized,
+								Headers: []ParameterDescriptor{
+									authChallengeHeader,
+								},
+								ErrorCodes: []ErrorCode{
+									ErrorCodeUnauthorized,
+								},
+							},
+						},
 					},
 				},
 			},
@@ -799,6 +1740,9 @@ var routeDescriptors = []RouteDescriptor{
 								},
 							},
 						},
+						Failures: []ResponseDescriptor{
+							readOnlyResponse,
+						},
 					},
 				},
 			},
@@ -821,10 +1765,10 @@ var routeDescriptors = []RouteDescriptor{
 							{
 								Name:        "digest",
 								Type:        "string",
-								Format:      "<tarsum>",
+								Format:      "<digest>",
 								Regexp:      digest.DigestRegexp,
 								Required:    true,
-								Description: `Digest of uploaded blob.`,
+								Description: `Digest of uploaded blob. A tarsum or a plain content digest, such as sha256, may be provided, as accepted by the registry configuration.`,
 							},
 						},
 						Successes: []ResponseDescriptor{
@@ -850,6 +1794,22 @@ var routeDescriptors = []RouteDescriptor{
 								},
 							},
 						},
+						Failures: []ResponseDescriptor{
+							readOnlyResponse,
+							quotaExceededResponse,
+							{
+								Description: "The uploaded content did not match the provided digest or size, or the digest algorithm is not accepted by the registry.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []ErrorCode{
+									ErrorCodeDigestInvalid,
+									ErrorCodeSizeInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+						},
 					},
 				},
 			},
@@ -863,6 +1823,9 @@ var routeDescriptors = []RouteDescriptor{
 							nameParameterDescriptor,
 							uuidParameterDescriptor,
 						},
+						Failures: []ResponseDescriptor{
+							readOnlyResponse,
+						},
 					},
 				},
 			},
@@ -937,8 +1900,10 @@ var errorDescriptors = []ErrorDescriptor{
 		Value:   "MANIFEST_UNKNOWN",
 		Message: "manifest unknown",
 		Description: `This error is returned when the manifest, identified by
-		name and tag is unknown to the repository.`,
-		HTTPStatusCodes: []int{http.StatusNotFound},
+		name and tag is unknown to the repository. It is also returned
+		when putting a manifest list that references a manifest unknown
+		to the repository.`,
+		HTTPStatusCodes: []int{http.StatusNotFound, http.StatusBadRequest},
 	},
 	{
 		Code:    ErrorCodeManifestInvalid,
@@ -955,8 +1920,11 @@ var errorDescriptors = []ErrorDescriptor{
 		Value:   "MANIFEST_UNVERIFIED",
 		Message: "manifest failed signature verification",
 		Description: `During manifest upload, if the manifest fails signature
-		verification, this error will be returned.`,
-		HTTPStatusCodes: []int{http.StatusBadRequest},
+		verification or is not signed by a signer trusted for the repository,
+		this error will be returned. It is also returned when fetching a
+		manifest without a trusted signer, if the registry refuses to serve
+		untrusted manifests.`,
+		HTTPStatusCodes: []int{http.StatusBadRequest, http.StatusForbidden},
 	},
 	{
 		Code:    ErrorCodeBlobUnknown,
@@ -977,6 +1945,107 @@ var errorDescriptors = []ErrorDescriptor{
 		started, this error code may be returned.`,
 		HTTPStatusCodes: []int{http.StatusNotFound},
 	},
+	{
+		Code:    ErrorCodePaginationInvalid,
+		Value:   "PAGINATION_INVALID",
+		Message: "invalid pagination parameters",
+		Description: `Returned when the pagination parameters of a list
+		request are invalid, such as when "n" is not a positive integer.`,
+		HTTPStatusCodes: []int{http.StatusBadRequest},
+	},
+	{
+		Code:    ErrorCodeReadOnly,
+		Value:   "READ_ONLY",
+		Message: "registry is in read-only mode",
+		Description: `Returned for any request that would modify the registry
+		while it is in read-only mode, such as during maintenance. Pulls are
+		unaffected. The request may be retried once the registry is
+		writable.`,
+		HTTPStatusCodes: []int{http.StatusServiceUnavailable},
+	},
+	{
+		Code:    ErrorCodeQuotaExceeded,
+		Value:   "QUOTA_EXCEEDED",
+		Message: "storage quota exceeded",
+		Description: `Returned when storing a layer or manifest would take the
+		repository, or a namespace containing it, over its storage quota.
+		Content must be removed from the repository or namespace before the
+		request can succeed.`,
+		HTTPStatusCodes: []int{http.StatusForbidden},
+	},
+	{
+		Code:    ErrorCodeTagImmutable,
+		Value:   "TAG_IMMUTABLE",
+		Message: "tag is immutable",
+		Description: `Returned when a manifest put, tag delete or retag would
+		change a tag that matches an immutability rule of the registry and
+		already exists. Pushing the revision already referenced by the tag
+		is allowed.`,
+		HTTPStatusCodes: []int{http.StatusConflict},
+	},
+	{
+		Code:    ErrorCodeNameExists,
+		Value:   "NAME_EXISTS",
+		Message: "repository name already exists",
+		Description: `Returned when renaming or copying a repository to a
+		name that already holds manifests or layers.`,
+		HTTPStatusCodes: []int{http.StatusConflict},
+	},
+	{
+		Code:    ErrorCodeSigningKeyRequired,
+		Value:   "SIGNING_KEY_REQUIRED",
+		Message: "registry signing key required",
+		Description: `Returned when renaming or copying a repository with
+		manifests, which must be re-signed under the new name, or when
+		fetching the public key of the registry, while the registry has no
+		signing key configured.`,
+		HTTPStatusCodes: []int{http.StatusConflict, http.StatusNotFound},
+	},
+	{
+		Code:    ErrorCodeManifestHistoryInvalid,
+		Value:   "MANIFEST_HISTORY_INVALID",
+		Message: "manifest history invalid",
+		Description: `During manifest upload, if the v1 compatibility data of a
+		history entry is not a JSON object, this error will be returned. The
+		detail identifies the entry.`,
+		HTTPStatusCodes: []int{http.StatusBadRequest},
+	},
+	{
+		Code:    ErrorCodeManifestLayersMismatch,
+		Value:   "MANIFEST_LAYERS_MISMATCH",
+		Message: "manifest layers do not match history",
+		Description: `During manifest upload, if the number of history entries
+		differs from the number of layers, this error will be returned.`,
+		HTTPStatusCodes: []int{http.StatusBadRequest},
+	},
+	{
+		Code:    ErrorCodeManifestParentInvalid,
+		Value:   "MANIFEST_PARENT_INVALID",
+		Message: "manifest history parent invalid",
+		Description: `During manifest upload, if a history entry has no image id
+		or its parent is not the image of the next entry, such that the
+		entries do not form a chain from the top layer to the base layer, this
+		error will be returned. The detail identifies the entry.`,
+		HTTPStatusCodes: []int{http.StatusBadRequest},
+	},
+	{
+		Code:    ErrorCodeManifestDenied,
+		Value:   "MANIFEST_DENIED",
+		Message: "manifest denied by admission policy",
+		Description: `Returned when the admission endpoint configured for the
+		registry denies a manifest being pushed, tagged or copied. The detail
+		holds the reason given by the endpoint, if any.`,
+		HTTPStatusCodes: []int{http.StatusForbidden},
+	},
+	{
+		Code:    ErrorCodeAdmissionUnavailable,
+		Value:   "ADMISSION_UNAVAILABLE",
+		Message: "admission endpoint unavailable",
+		Description: `Returned when the admission endpoint configured for the
+		registry cannot be reached or fails to decide on a manifest in time.
+		The request may be retried.`,
+		HTTPStatusCodes: []int{http.StatusServiceUnavailable},
+	},
 }
 
 var errorCodeToDescriptors map[ErrorCode]ErrorDescriptor
@@ -54,6 +54,50 @@ const (
 
 	// ErrorCodeBlobUploadUnknown is returned when an upload is unknown.
 	ErrorCodeBlobUploadUnknown
+
+	// ErrorCodePaginationInvalid is returned when the parameters of a
+	// paginated request are invalid.
+	ErrorCodePaginationInvalid
+
+	// ErrorCodeReadOnly is returned when a request would modify the registry
+	// while it is in read-only mode.
+	ErrorCodeReadOnly
+
+	// ErrorCodeQuotaExceeded is returned when storing content would exceed a
+	// quota applying to the repository.
+	ErrorCodeQuotaExceeded
+
+	// ErrorCodeTagImmutable is returned when a request would change or
+	// delete a tag matching an immutability rule.
+	ErrorCodeTagImmutable
+
+	// ErrorCodeNameExists is returned when a repository cannot be created
+	// because the name is already in use.
+	ErrorCodeNameExists
+
+	// ErrorCodeSigningKeyRequired is returned when an operation would need
+	// the registry to re-sign manifests, but no signing key is configured.
+	ErrorCodeSigningKeyRequired
+
+	// ErrorCodeManifestHistoryInvalid is returned when the v1 compatibility
+	// data of a manifest history entry is not a JSON object.
+	ErrorCodeManifestHistoryInvalid
+
+	// ErrorCodeManifestLayersMismatch is returned when a manifest does not
+	// have a history entry for each layer.
+	ErrorCodeManifestLayersMismatch
+
+	// ErrorCodeManifestParentInvalid is returned when the history entries of
+	// a manifest do not form a chain of parent images.
+	ErrorCodeManifestParentInvalid
+
+	// ErrorCodeManifestDenied is returned when the admission endpoint of the
+	// registry denies a manifest push.
+	ErrorCodeManifestDenied
+
+	// ErrorCodeAdmissionUnavailable is returned when the admission endpoint
+	// of the registry fails to decide on a manifest push.
+	ErrorCodeAdmissionUnavailable
 )
 
 // ParseErrorCode attempts to parse the error code string, returning
@@ -6,16 +6,32 @@ import "github.com/gorilla/mux"
 // registered. These symbols can be used to look up a route based on the name.
 const (
 	RouteNameBase            = "base"
+	RouteNameCatalog         = "catalog"
+	RouteNameReadOnly        = "read-only"
+	RouteNameRename          = "rename"
+	RouteNameCopy            = "copy"
+	RouteNameSigningKey      = "signing-key"
 	RouteNameManifest        = "manifest"
+	RouteNameSignatures      = "signatures"
 	RouteNameTags            = "tags"
+	RouteNameTagHistory      = "tag-history"
+	RouteNameUsage           = "usage"
 	RouteNameBlob            = "blob"
 	RouteNameBlobUpload      = "blob-upload"
 	RouteNameBlobUploadChunk = "blob-upload-chunk"
 )
 
 var allEndpoints = []string{
+	RouteNameCatalog,
+	RouteNameReadOnly,
+	RouteNameRename,
+	RouteNameCopy,
+	RouteNameSigningKey,
 	RouteNameManifest,
+	RouteNameSignatures,
 	RouteNameTags,
+	RouteNameTagHistory,
+	RouteNameUsage,
 	RouteNameBlob,
 	RouteNameBlobUpload,
 	RouteNameBlobUploadChunk,
@@ -51,12 +51,45 @@ func TestRouter(t *testing.T) {
 			RequestURI: "/v2/",
 			Vars:       map[string]string{},
 		},
+		{
+			RouteName:  RouteNameCatalog,
+			RequestURI: "/v2/_catalog",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameReadOnly,
+			RequestURI: "/v2/_admin/readonly",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameRename,
+			RequestURI: "/v2/_admin/rename",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameCopy,
+			RequestURI: "/v2/_admin/copy",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameSigningKey,
+			RequestURI: "/v2/_trust/key",
+			Vars:       map[string]string{},
+		},
 		{
 			RouteName:  RouteNameManifest,
 			RequestURI: "/v2/foo/bar/manifests/tag",
 			Vars: map[string]string{
-				"name": "foo/bar",
-				"tag":  "tag",
+				"name":      "foo/bar",
+				"reference": "tag",
+			},
+		},
+		{
+			RouteName:  RouteNameManifest,
+			RequestURI: "/v2/foo/bar/manifests/sha256:abcdef0919234",
+			Vars: map[string]string{
+				"name":      "foo/bar",
+				"reference": "sha256:abcdef0919234",
 			},
 		},
 		{
@@ -66,6 +99,29 @@ func TestRouter(t *testing.T) {
 				"name": "foo/bar",
 			},
 		},
+		{
+			RouteName:  RouteNameSignatures,
+			RequestURI: "/v2/foo/bar/manifests/sha256:abcdef0919234/signatures",
+			Vars: map[string]string{
+				"name":   "foo/bar",
+				"digest": "sha256:abcdef0919234",
+			},
+		},
+		{
+			RouteName:  RouteNameTagHistory,
+			RequestURI: "/v2/foo/bar/tags/latest/history",
+			Vars: map[string]string{
+				"name": "foo/bar",
+				"tag":  "latest",
+			},
+		},
+		{
+			RouteName:  RouteNameUsage,
+			RequestURI: "/v2/foo/bar/usage",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
 		{
 			RouteName:  RouteNameBlob,
 			RequestURI: "/v2/foo/bar/blobs/tarsum.dev+foo:abcdef0919234",
@@ -120,8 +176,8 @@ func TestRouter(t *testing.T) {
 			RouteName:  RouteNameManifest,
 			RequestURI: "/v2/foo/bar/manifests/manifests/tags",
 			Vars: map[string]string{
-				"name": "foo/bar/manifests",
-				"tag":  "tags",
+				"name":      "foo/bar/manifests",
+				"reference": "tags",
 			},
 		},
 		{
@@ -63,8 +63,72 @@ func (ub *URLBuilder) BuildBaseURL() (string, error) {
 	return baseURL.String(), nil
 }
 
-// BuildTagsURL constructs a url to list the tags in the named repository.
-func (ub *URLBuilder) BuildTagsURL(name string) (string, error) {
+// BuildCatalogURL constructs a url to list the repositories in the registry,
+// including any url values, such as pagination parameters.
+func (ub *URLBuilder) BuildCatalogURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameCatalog)
+
+	catalogURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(catalogURL, values...).String(), nil
+}
+
+// BuildReadOnlyURL constructs a url to query or toggle the read-only mode of
+// the registry.
+func (ub *URLBuilder) BuildReadOnlyURL() (string, error) {
+	route := ub.cloneRoute(RouteNameReadOnly)
+
+	readOnlyURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return readOnlyURL.String(), nil
+}
+
+// BuildRenameURL constructs a url to rename a repository.
+func (ub *URLBuilder) BuildRenameURL() (string, error) {
+	route := ub.cloneRoute(RouteNameRename)
+
+	renameURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return renameURL.String(), nil
+}
+
+// BuildCopyURL constructs a url to copy a repository.
+func (ub *URLBuilder) BuildCopyURL() (string, error) {
+	route := ub.cloneRoute(RouteNameCopy)
+
+	copyURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return copyURL.String(), nil
+}
+
+// BuildSigningKeyURL constructs a url to fetch the public key with which the
+// registry signs manifests.
+func (ub *URLBuilder) BuildSigningKeyURL() (string, error) {
+	route := ub.cloneRoute(RouteNameSigningKey)
+
+	signingKeyURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return signingKeyURL.String(), nil
+}
+
+// BuildTagsURL constructs a url to list the tags in the named repository,
+// including any url values, such as pagination parameters.
+func (ub *URLBuilder) BuildTagsURL(name string, values ...url.Values) (string, error) {
 	route := ub.cloneRoute(RouteNameTags)
 
 	tagsURL, err := route.URL("name", name)
@@ -72,14 +136,54 @@ func (ub *URLBuilder) BuildTagsURL(name string) (string, error) {
 		return "", err
 	}
 
-	return tagsURL.String(), nil
+	return appendValuesURL(tagsURL, values...).String(), nil
+}
+
+// BuildSignaturesURL constructs a url to add signatures to the manifest
+// revision identified by dgst in the named repository.
+func (ub *URLBuilder) BuildSignaturesURL(name string, dgst digest.Digest) (string, error) {
+	route := ub.cloneRoute(RouteNameSignatures)
+
+	signaturesURL, err := route.URL("name", name, "digest", dgst.String())
+	if err != nil {
+		return "", err
+	}
+
+	return signaturesURL.String(), nil
+}
+
+// BuildTagHistoryURL constructs a url for the history of the tag in the named
+// repository.
+func (ub *URLBuilder) BuildTagHistoryURL(name, tag string) (string, error) {
+	route := ub.cloneRoute(RouteNameTagHistory)
+
+	tagHistoryURL, err := route.URL("name", name, "tag", tag)
+	if err != nil {
+		return "", err
+	}
+
+	return tagHistoryURL.String(), nil
+}
+
+// BuildUsageURL constructs a url to retrieve the storage usage and quotas of
+// the named repository.
+func (ub *URLBuilder) BuildUsageURL(name string) (string, error) {
+	route := ub.cloneRoute(RouteNameUsage)
+
+	usageURL, err := route.URL("name", name)
+	if err != nil {
+		return "", err
+	}
+
+	return usageURL.String(), nil
 }
 
-// BuildManifestURL constructs a url for the manifest identified by name and tag.
-func (ub *URLBuilder) BuildManifestURL(name, tag string) (string, error) {
+// BuildManifestURL constructs a url for the manifest identified by name and
+// reference. The reference may be a tag or a digest.
+func (ub *URLBuilder) BuildManifestURL(name, reference string) (string, error) {
 	route := ub.cloneRoute(RouteNameManifest)
 
-	manifestURL, err := route.URL("name", name, "tag", tag)
+	manifestURL, err := route.URL("name", name, "reference", reference)
 	if err != nil {
 		return "", err
 	}
@@ -31,6 +31,40 @@ func TestURLBuilder(t *testing.T) {
 			expected:    "http://localhost:5000/v2/",
 			build:       urlBuilder.BuildBaseURL,
 		},
+		{
+			description: "test catalog url",
+			expected:    "http://localhost:5000/v2/_catalog",
+			build: func() (string, error) {
+				return urlBuilder.BuildCatalogURL()
+			},
+		},
+		{
+			description: "test paginated catalog url",
+			expected:    "http://localhost:5000/v2/_catalog?last=foo%2Fbar&n=10",
+			build: func() (string, error) {
+				return urlBuilder.BuildCatalogURL(url.Values{"n": []string{"10"}, "last": []string{"foo/bar"}})
+			},
+		},
+		{
+			description: "test read-only url",
+			expected:    "http://localhost:5000/v2/_admin/readonly",
+			build:       urlBuilder.BuildReadOnlyURL,
+		},
+		{
+			description: "test rename url",
+			expected:    "http://localhost:5000/v2/_admin/rename",
+			build:       urlBuilder.BuildRenameURL,
+		},
+		{
+			description: "test copy url",
+			expected:    "http://localhost:5000/v2/_admin/copy",
+			build:       urlBuilder.BuildCopyURL,
+		},
+		{
+			description: "test signing key url",
+			expected:    "http://localhost:5000/v2/_trust/key",
+			build:       urlBuilder.BuildSigningKeyURL,
+		},
 		{
 			description: "test tags url",
 			expected:    "http://localhost:5000/v2/foo/bar/tags/list",
@@ -38,6 +72,34 @@ func TestURLBuilder(t *testing.T) {
 				return urlBuilder.BuildTagsURL("foo/bar")
 			},
 		},
+		{
+			description: "test paginated tags url",
+			expected:    "http://localhost:5000/v2/foo/bar/tags/list?last=abc&n=10",
+			build: func() (string, error) {
+				return urlBuilder.BuildTagsURL("foo/bar", url.Values{"n": []string{"10"}, "last": []string{"abc"}})
+			},
+		},
+		{
+			description: "test tag history url",
+			expected:    "http://localhost:5000/v2/foo/bar/tags/tag/history",
+			build: func() (string, error) {
+				return urlBuilder.BuildTagHistoryURL("foo/bar", "tag")
+			},
+		},
+		{
+			description: "test signatures url",
+			expected:    "http://localhost:5000/v2/foo/bar/manifests/sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5/signatures",
+			build: func() (string, error) {
+				return urlBuilder.BuildSignaturesURL("foo/bar", "sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5")
+			},
+		},
+		{
+			description: "test usage url",
+			expected:    "http://localhost:5000/v2/foo/bar/usage",
+			build: func() (string, error) {
+				return urlBuilder.BuildUsageURL("foo/bar")
+			},
+		},
 		{
 			description: "test manifest url",
 			expected:    "http://localhost:5000/v2/foo/bar/manifests/tag",
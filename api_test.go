@@ -2,6 +2,7 @@ package registry
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,7 +12,11 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/docker/docker-registry/api/v2"
 	"github.com/docker/docker-registry/common/testutil"
@@ -177,9 +182,138 @@ func TestLayerAPI(t *testing.T) {
 		t.Fatalf("response body did not pass verification")
 	}
 
-	// Missing tests:
-	// 	- Upload the same tarsum file under and different repository and
-	//       ensure the content remains uncorrupted.
+	// ------------------------------------------
+	// Mount the layer into another repository.
+	mountedImageName := "foo/mounted"
+	mountedLayerURL, err := builder.BuildBlobURL(mountedImageName, layerDigest)
+	if err != nil {
+		t.Fatalf("error building url: %v", err)
+	}
+
+	// Mounting from a repository without the layer starts an upload.
+	layerMountURL, err := builder.BuildBlobUploadURL(mountedImageName, url.Values{
+		"mount": []string{layerDigest.String()},
+		"from":  []string{"foo/unknown"},
+	})
+	if err != nil {
+		t.Fatalf("error building mount url: %v", err)
+	}
+
+	resp, err = http.Post(layerMountURL, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error mounting layer: %v", err)
+	}
+
+	checkResponse(t, "mounting layer from unknown repository", resp, http.StatusAccepted)
+
+	layerMountURL, err = builder.BuildBlobUploadURL(mountedImageName, url.Values{
+		"mount": []string{layerDigest.String()},
+		"from":  []string{imageName},
+	})
+	if err != nil {
+		t.Fatalf("error building mount url: %v", err)
+	}
+
+	resp, err = http.Post(layerMountURL, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error mounting layer: %v", err)
+	}
+
+	checkResponse(t, "mounting layer", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{
+		"Location":       []string{mountedLayerURL},
+		"Content-Length": []string{"0"},
+	})
+
+	resp, err = http.Get(mountedLayerURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching mounted layer: %v", err)
+	}
+
+	checkResponse(t, "fetching mounted layer", resp, http.StatusOK)
+
+	verifier = digest.NewDigestVerifier(layerDigest)
+	io.Copy(verifier, resp.Body)
+
+	if !verifier.Verified() {
+		t.Fatalf("mounted layer response body did not pass verification")
+	}
+
+	// ------------------------------------------
+	// Delete the mounted layer, leaving the original in place.
+	resp = httpDelete(t, "deleting layer", mountedLayerURL)
+	checkResponse(t, "deleting layer", resp, http.StatusAccepted)
+
+	resp, err = http.Head(mountedLayerURL)
+	if err != nil {
+		t.Fatalf("unexpected error checking head on deleted layer: %v", err)
+	}
+
+	checkResponse(t, "checking head on deleted layer", resp, http.StatusNotFound)
+
+	resp, err = http.Head(layerURL)
+	if err != nil {
+		t.Fatalf("unexpected error checking head on existing layer: %v", err)
+	}
+
+	checkResponse(t, "checking head on original layer after delete", resp, http.StatusOK)
+
+	resp = httpDelete(t, "deleting unknown layer", mountedLayerURL)
+	checkResponse(t, "deleting unknown layer", resp, http.StatusNotFound)
+
+	// ------------------------------------------
+	// Upload content that is not a tar archive, identified by sha256.
+	content := []byte("not a tar archive")
+	h := sha256.New()
+	h.Write(content)
+	contentDigest := digest.NewDigest("sha256", h)
+
+	uploadURLBase = startPushLayer(t, builder, imageName)
+	contentURL := pushLayer(t, builder, imageName, contentDigest, uploadURLBase, bytes.NewReader(content))
+
+	resp, err = http.Get(contentURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching layer: %v", err)
+	}
+
+	checkResponse(t, "fetching sha256 layer", resp, http.StatusOK)
+
+	verifier = digest.NewDigestVerifier(contentDigest)
+	io.Copy(verifier, resp.Body)
+
+	if !verifier.Verified() {
+		t.Fatalf("sha256 layer response body did not pass verification")
+	}
+
+	// Completing an upload with a mismatched digest is rejected.
+	uploadURLBase = startPushLayer(t, builder, imageName)
+	u, err := url.Parse(uploadURLBase)
+	if err != nil {
+		t.Fatalf("unexpected error parsing upload url: %v", err)
+	}
+	u.RawQuery = url.Values{"digest": []string{contentDigest.String()}}.Encode()
+
+	req, err := http.NewRequest("PUT", u.String(), bytes.NewReader(content[1:]))
+	if err != nil {
+		t.Fatalf("unexpected error creating new request: %v", err)
+	}
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error doing put: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "putting layer with mismatched digest", resp, http.StatusBadRequest)
+
+	var respErrs v2.Errors
+	if err := json.NewDecoder(resp.Body).Decode(&respErrs); err != nil {
+		t.Fatalf("unexpected error decoding error response: %v", err)
+	}
+
+	if len(respErrs.Errors) == 0 || respErrs.Errors[0].Code != v2.ErrorCodeDigestInvalid {
+		t.Fatalf("expected digest invalid error: got %v", respErrs)
+	}
 }
 
 func TestManifestAPI(t *testing.T) {
@@ -279,6 +413,7 @@ func TestManifestAPI(t *testing.T) {
 				BlobSum: "qwer",
 			},
 		},
+		History: testManifestHistory(2),
 	}
 
 	resp = putManifest(t, "putting unsigned manifest", manifestURL, unsignedManifest)
@@ -350,9 +485,17 @@ func TestManifestAPI(t *testing.T) {
 		t.Fatalf("unexpected error signing manifest: %v", err)
 	}
 
+	dgst, err := signedManifest.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
 	resp = putManifest(t, "putting signed manifest", manifestURL, signedManifest)
 
 	checkResponse(t, "putting signed manifest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
 
 	resp, err = http.Get(manifestURL)
 	if err != nil {
@@ -361,6 +504,9 @@ func TestManifestAPI(t *testing.T) {
 	defer resp.Body.Close()
 
 	checkResponse(t, "fetching uploaded manifest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
 
 	var fetchedManifest storage.SignedManifest
 	dec = json.NewDecoder(resp.Body)
@@ -372,6 +518,39 @@ func TestManifestAPI(t *testing.T) {
 		t.Fatalf("manifests do not match")
 	}
 
+	// ---------------------------
+	// Fetch the manifest by digest
+	manifestDigestURL, err := builder.BuildManifestURL(imageName, dgst.String())
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest url: %v", err)
+	}
+
+	resp, err = http.Get(manifestDigestURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest by digest: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching manifest by digest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	var fetchedManifestByDigest storage.SignedManifest
+	dec = json.NewDecoder(resp.Body)
+	if err := dec.Decode(&fetchedManifestByDigest); err != nil {
+		t.Fatalf("error decoding fetched manifest: %v", err)
+	}
+
+	if !bytes.Equal(fetchedManifestByDigest.Raw, signedManifest.Raw) {
+		t.Fatalf("manifests do not match")
+	}
+
+	// Manifests cannot be put by digest.
+	resp = putManifest(t, "putting manifest by digest", manifestDigestURL, signedManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest by digest", resp, http.StatusBadRequest)
+
 	// Ensure that the tag is listed.
 	resp, err = http.Get(tagsURL)
 	if err != nil {
@@ -400,28 +579,1644 @@ func TestManifestAPI(t *testing.T) {
 	if tagsResponse.Tags[0] != tag {
 		t.Fatalf("tag not as expected: %q != %q", tagsResponse.Tags[0], tag)
 	}
+
+	// ------------------------------------------
+	// Update the tag, then roll it back using the tag history.
+	unsignedManifest.Architecture = "amd64"
+	updatedManifest, err := unsignedManifest.Sign(pk)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	updatedDigest, err := updatedManifest.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	resp = putManifest(t, "putting updated manifest", manifestURL, updatedManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "putting updated manifest", resp, http.StatusOK)
+
+	tagHistoryURL, err := builder.BuildTagHistoryURL(imageName, tag)
+	if err != nil {
+		t.Fatalf("unexpected error building tag history url: %v", err)
+	}
+
+	checkTagHistoryAPI(t, tagHistoryURL, dgst, updatedDigest)
+
+	req, err := http.NewRequest("PUT", tagHistoryURL, strings.NewReader(`{"revision": "`+dgst.String()+`"}`))
+	if err != nil {
+		t.Fatalf("error creating rollback request: %v", err)
+	}
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error rolling back tag: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "rolling back tag", resp, http.StatusAccepted)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	resp, err = http.Get(manifestURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching rolled back manifest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	checkTagHistoryAPI(t, tagHistoryURL, dgst, updatedDigest, dgst)
+
+	// A revision that the tag never referenced cannot be used.
+	req, err = http.NewRequest("PUT", tagHistoryURL, strings.NewReader(`{"revision": "sha256:abcdef0919234"}`))
+	if err != nil {
+		t.Fatalf("error creating rollback request: %v", err)
+	}
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error rolling back tag: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "rolling back tag to unknown revision", resp, http.StatusNotFound)
+
+	// ----------------------------------
+	// Push more tags and page through them.
+	for _, extraTag := range []string{"thetag3", "thetag2"} {
+		unsignedManifest.Tag = extraTag
+		extraManifest, err := unsignedManifest.Sign(pk)
+		if err != nil {
+			t.Fatalf("unexpected error signing manifest: %v", err)
+		}
+
+		extraManifestURL, err := builder.BuildManifestURL(imageName, extraTag)
+		if err != nil {
+			t.Fatalf("unexpected error getting manifest url: %v", err)
+		}
+
+		resp = putManifest(t, "putting tagged manifest", extraManifestURL, extraManifest)
+		defer resp.Body.Close()
+		checkResponse(t, "putting tagged manifest", resp, http.StatusOK)
+	}
+
+	pagedTagsURL, err := builder.BuildTagsURL(imageName, url.Values{"n": []string{"2"}})
+	if err != nil {
+		t.Fatalf("unexpected error building tags url: %v", err)
+	}
+
+	nextTagsURL, err := builder.BuildTagsURL(imageName, url.Values{"n": []string{"2"}, "last": []string{"thetag2"}})
+	if err != nil {
+		t.Fatalf("unexpected error building tags url: %v", err)
+	}
+
+	for _, page := range []struct {
+		url      string
+		expected []string
+		link     string
+	}{
+		{
+			url:      pagedTagsURL,
+			expected: []string{"thetag", "thetag2"},
+			link:     `<` + nextTagsURL + `>; rel="next"`,
+		},
+		{
+			url:      nextTagsURL,
+			expected: []string{"thetag3"},
+		},
+	} {
+		resp, err = http.Get(page.url)
+		if err != nil {
+			t.Fatalf("unexpected error getting tags: %v", err)
+		}
+		defer resp.Body.Close()
+
+		checkResponse(t, "getting paged tags", resp, http.StatusOK)
+
+		if resp.Header.Get("Link") != page.link {
+			t.Fatalf("unexpected link header: %q != %q", resp.Header.Get("Link"), page.link)
+		}
+
+		var pagedTagsResponse tagsAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&pagedTagsResponse); err != nil {
+			t.Fatalf("unexpected error decoding tags response: %v", err)
+		}
+
+		if !reflect.DeepEqual(pagedTagsResponse.Tags, page.expected) {
+			t.Fatalf("unexpected tags: %v != %v", pagedTagsResponse.Tags, page.expected)
+		}
+	}
 }
 
-func putManifest(t *testing.T, msg, url string, v interface{}) *http.Response {
-	var body []byte
-	if sm, ok := v.(*storage.SignedManifest); ok {
-		body = sm.Raw
-	} else {
-		var err error
-		body, err = json.MarshalIndent(v, "", "   ")
+func checkTagHistoryAPI(t *testing.T, tagHistoryURL string, expected ...digest.Digest) {
+	resp, err := http.Get(tagHistoryURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching tag history: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching tag history", resp, http.StatusOK)
+
+	var tagHistoryResponse tagHistoryAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tagHistoryResponse); err != nil {
+		t.Fatalf("unexpected error decoding tag history response: %v", err)
+	}
+
+	if len(tagHistoryResponse.History) != len(expected) {
+		t.Fatalf("unexpected tag history: %v != %v", tagHistoryResponse.History, expected)
+	}
+
+	for i, entry := range tagHistoryResponse.History {
+		if entry.Revision != expected[i] {
+			t.Fatalf("unexpected revision in tag history at %d: %v != %v", i, entry.Revision, expected[i])
+		}
+	}
+}
+
+// TestCatalogAPI pushes layers to several repositories and pages through
+// the catalog.
+func TestCatalogAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+
+	app := NewApp(config)
+	server := httptest.NewServer(handlers.CombinedLoggingHandler(os.Stderr, app))
+	builder, err := v2.NewURLBuilderFromString(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating url builder: %v", err)
+	}
+
+	catalogURL, err := builder.BuildCatalogURL()
+	if err != nil {
+		t.Fatalf("unexpected error building catalog url: %v", err)
+	}
+
+	checkCatalogAPI(t, catalogURL, []string{}, "")
+
+	expected := []string{"foo/aaa", "foo/bar", "foo/bar/baz"}
+	for _, name := range []string{"foo/bar/baz", "foo/aaa", "foo/bar"} {
+		rs, dgstStr, err := testutil.CreateRandomTarFile()
 		if err != nil {
-			t.Fatalf("unexpected error marshaling %v: %v", v, err)
+			t.Fatalf("error creating random layer: %v", err)
 		}
+
+		uploadURLBase := startPushLayer(t, builder, name)
+		pushLayer(t, builder, name, digest.Digest(dgstStr), uploadURLBase, rs)
 	}
 
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(body))
+	checkCatalogAPI(t, catalogURL, expected, "")
+
+	// Page through the catalog, following the link header.
+	pagedURL, err := builder.BuildCatalogURL(url.Values{"n": []string{"2"}})
 	if err != nil {
-		t.Fatalf("error creating request for %s: %v", msg, err)
+		t.Fatalf("unexpected error building catalog url: %v", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	nextURL, err := builder.BuildCatalogURL(url.Values{"n": []string{"2"}, "last": []string{"foo/bar"}})
 	if err != nil {
-		t.Fatalf("error doing put request while %s: %v", msg, err)
+		t.Fatalf("unexpected error building catalog url: %v", err)
+	}
+
+	checkCatalogAPI(t, pagedURL, expected[:2], `<`+nextURL+`>; rel="next"`)
+	checkCatalogAPI(t, nextURL, expected[2:], "")
+
+	invalidURL, err := builder.BuildCatalogURL(url.Values{"n": []string{"-1"}})
+	if err != nil {
+		t.Fatalf("unexpected error building catalog url: %v", err)
+	}
+
+	resp, err := http.Get(invalidURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching catalog: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching catalog with invalid pagination", resp, http.StatusBadRequest)
+}
+
+func checkCatalogAPI(t *testing.T, catalogURL string, expected []string, link string) {
+	resp, err := http.Get(catalogURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching catalog: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching catalog", resp, http.StatusOK)
+
+	if resp.Header.Get("Link") != link {
+		t.Fatalf("unexpected link header: %q != %q", resp.Header.Get("Link"), link)
+	}
+
+	var catalogResponse catalogAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&catalogResponse); err != nil {
+		t.Fatalf("unexpected error decoding catalog response: %v", err)
+	}
+
+	if !reflect.DeepEqual(catalogResponse.Repositories, expected) {
+		t.Fatalf("unexpected repositories: %v != %v", catalogResponse.Repositories, expected)
+	}
+}
+
+// TestReadOnlyAPI ensures that writes are rejected while the registry is in
+// read-only mode, while pulls continue, and that the mode can be toggled.
+func TestReadOnlyAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{
+				"readonly": true,
+			},
+		},
+	}
+
+	app := NewApp(config)
+	server := httptest.NewServer(handlers.CombinedLoggingHandler(os.Stderr, app))
+	builder, err := v2.NewURLBuilderFromString(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating url builder: %v", err)
+	}
+
+	imageName := "foo/bar"
+
+	readOnlyURL, err := builder.BuildReadOnlyURL()
+	if err != nil {
+		t.Fatalf("unexpected error building read-only url: %v", err)
+	}
+
+	checkReadOnlyMode(t, "GET", readOnlyURL, "", true)
+
+	catalogURL, err := builder.BuildCatalogURL()
+	if err != nil {
+		t.Fatalf("unexpected error building catalog url: %v", err)
+	}
+
+	checkCatalogAPI(t, catalogURL, []string{}, "")
+
+	layerUploadURL, err := builder.BuildBlobUploadURL(imageName)
+	if err != nil {
+		t.Fatalf("unexpected error building layer upload url: %v", err)
+	}
+
+	resp, err := http.Post(layerUploadURL, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error starting layer push: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkReadOnlyResponse(t, "starting layer push", resp)
+
+	manifestURL, err := builder.BuildManifestURL(imageName, "thetag")
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	resp = putManifest(t, "putting manifest", manifestURL, &storage.Manifest{Name: imageName, Tag: "thetag"})
+	defer resp.Body.Close()
+
+	checkReadOnlyResponse(t, "putting manifest", resp)
+
+	// Start an upload while writable, then finish it once the registry is
+	// writable again.
+	checkReadOnlyMode(t, "PUT", readOnlyURL, `{"readonly": false}`, false)
+	uploadURLBase := startPushLayer(t, builder, imageName)
+	checkReadOnlyMode(t, "PUT", readOnlyURL, `{"readonly": true}`, true)
+
+	rs, dgstStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer: %v", err)
+	}
+
+	for _, method := range []string{"PATCH", "PUT", "DELETE"} {
+		req, err := http.NewRequest(method, uploadURLBase, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error during %s: %v", method, err)
+		}
+		defer resp.Body.Close()
+
+		checkReadOnlyResponse(t, method+" layer upload", resp)
+	}
+
+	checkReadOnlyMode(t, "PUT", readOnlyURL, `{"readonly": false}`, false)
+	pushLayer(t, builder, imageName, digest.Digest(dgstStr), uploadURLBase, rs)
+
+	// The mode must be provided.
+	req, err := http.NewRequest("PUT", readOnlyURL, strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("unexpected error creating request: %v", err)
+	}
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error setting read-only mode: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "setting read-only mode without a mode", resp, http.StatusBadRequest)
+	checkReadOnlyMode(t, "GET", readOnlyURL, "", false)
+}
+
+// checkReadOnlyMode makes a request to the read-only endpoint, ensuring the
+// response reports the expected mode.
+func checkReadOnlyMode(t *testing.T, method, readOnlyURL, body string, expected bool) {
+	req, err := http.NewRequest(method, readOnlyURL, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error creating request: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error during %s of read-only mode: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, method+" read-only mode", resp, http.StatusOK)
+
+	var mode struct {
+		ReadOnly bool `json:"readonly"`
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&mode); err != nil {
+		t.Fatalf("unexpected error decoding read-only mode: %v", err)
+	}
+
+	if mode.ReadOnly != expected {
+		t.Fatalf("unexpected read-only mode: %v != %v", mode.ReadOnly, expected)
+	}
+}
+
+// checkReadOnlyResponse ensures that the request was rejected because the
+// registry is read-only.
+func checkReadOnlyResponse(t *testing.T, msg string, resp *http.Response) {
+	checkResponse(t, msg, resp, http.StatusServiceUnavailable)
+
+	var respErrs v2.Errors
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&respErrs); err != nil {
+		t.Fatalf("unexpected error decoding error response: %v", err)
+	}
+
+	if len(respErrs.Errors) != 1 || respErrs.Errors[0].Code != v2.ErrorCodeReadOnly {
+		t.Fatalf("expected read-only error %s: got %v", msg, respErrs)
+	}
+}
+
+// TestQuotaAPI ensures that pushes exceeding a quota are rejected and that
+// usage is reported.
+func TestQuotaAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"quotas": configuration.Parameters{
+				"repositories": map[interface{}]interface{}{
+					"foo/bar": "1",
+				},
+			},
+		},
+	}
+
+	app := NewApp(config)
+	server := httptest.NewServer(handlers.CombinedLoggingHandler(os.Stderr, app))
+	builder, err := v2.NewURLBuilderFromString(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating url builder: %v", err)
+	}
+
+	rs, dgstStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer: %v", err)
+	}
+	dgst := digest.Digest(dgstStr)
+
+	rsLength, _ := rs.Seek(0, os.SEEK_END)
+	rs.Seek(0, os.SEEK_SET)
+
+	// Any layer exceeds the quota of foo/bar.
+	uploadURLBase := startPushLayer(t, builder, "foo/bar")
+	u, err := url.Parse(uploadURLBase)
+	if err != nil {
+		t.Fatalf("unexpected error parsing upload url: %v", err)
+	}
+
+	u.RawQuery = url.Values{
+		"digest": []string{dgst.String()},
+		"size":   []string{fmt.Sprint(rsLength)},
+	}.Encode()
+
+	req, err := http.NewRequest("PUT", u.String(), rs)
+	if err != nil {
+		t.Fatalf("unexpected error creating request: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error pushing layer: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "pushing layer over quota", resp, http.StatusForbidden)
+
+	var respErrs v2.Errors
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&respErrs); err != nil {
+		t.Fatalf("unexpected error decoding error response: %v", err)
+	}
+
+	if len(respErrs.Errors) != 1 || respErrs.Errors[0].Code != v2.ErrorCodeQuotaExceeded {
+		t.Fatalf("expected quota exceeded error: got %v", respErrs)
+	}
+
+	checkUsageAPI(t, builder, "foo/bar", storage.RepositoryUsage{
+		Name: "foo/bar",
+		Quotas: []storage.Quota{
+			{Scope: storage.QuotaScopeRepository, Name: "foo/bar", Limit: 1},
+		},
+	})
+
+	// Repositories without a quota are only counted.
+	rs.Seek(0, os.SEEK_SET)
+	pushLayer(t, builder, "foo/baz", dgst, startPushLayer(t, builder, "foo/baz"), rs)
+
+	checkUsageAPI(t, builder, "foo/baz", storage.RepositoryUsage{
+		Name:   "foo/baz",
+		Layers: rsLength,
+		Quotas: []storage.Quota{},
+	})
+}
+
+// checkUsageAPI fetches the usage of the named repository, ensuring that it
+// matches expected.
+func checkUsageAPI(t *testing.T, ub *v2.URLBuilder, name string, expected storage.RepositoryUsage) {
+	usageURL, err := ub.BuildUsageURL(name)
+	if err != nil {
+		t.Fatalf("unexpected error building usage url: %v", err)
+	}
+
+	resp, err := http.Get(usageURL)
+	if err != nil {
+		t.Fatalf("unexpected error getting usage: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "getting usage", resp, http.StatusOK)
+
+	var usage storage.RepositoryUsage
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&usage); err != nil {
+		t.Fatalf("unexpected error decoding usage: %v", err)
+	}
+
+	if !reflect.DeepEqual(usage, expected) {
+		t.Fatalf("unexpected usage: %#v != %#v", usage, expected)
+	}
+}
+
+// TestImmutableTagAPI ensures that immutable tags cannot be overwritten or
+// deleted through the api.
+func TestImmutableTagAPI(t *testing.T) {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Tags: configuration.Tags{
+			Immutable: []configuration.TagRule{
+				{Tag: `v[0-9.]+`},
+			},
+		},
+	}
+
+	app := NewApp(config)
+	server := httptest.NewServer(handlers.CombinedLoggingHandler(os.Stderr, app))
+	builder, err := v2.NewURLBuilderFromString(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating url builder: %v", err)
+	}
+
+	imageName := "foo/bar"
+	tag := "v1.0"
+
+	rs, dgstStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer: %v", err)
+	}
+	dgst := digest.Digest(dgstStr)
+
+	pushLayer(t, builder, imageName, dgst, startPushLayer(t, builder, imageName), rs)
+
+	manifestURL, err := builder.BuildManifestURL(imageName, tag)
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	manifest := &storage.Manifest{
+		Versioned: storage.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:     imageName,
+		Tag:      tag,
+		FSLayers: []storage.FSLayer{{BlobSum: dgst}},
+		History:  testManifestHistory(1),
+	}
+
+	for i, architecture := range []string{"amd64", "amd64", "arm"} {
+		manifest.Architecture = architecture
+		signedManifest, err := manifest.Sign(pk)
+		if err != nil {
+			t.Fatalf("unexpected error signing manifest: %v", err)
+		}
+
+		resp := putManifest(t, "putting manifest", manifestURL, signedManifest)
+		defer resp.Body.Close()
+
+		if i < 2 {
+			// The same revision may be pushed again.
+			checkResponse(t, "putting manifest", resp, http.StatusOK)
+			continue
+		}
+
+		checkTagImmutableResponse(t, "overwriting immutable tag", resp)
+	}
+
+	resp := httpDelete(t, "deleting immutable tag", manifestURL)
+	defer resp.Body.Close()
+
+	checkTagImmutableResponse(t, "deleting immutable tag", resp)
+}
+
+// checkTagImmutableResponse ensures that the request was rejected because
+// the tag is immutable.
+func checkTagImmutableResponse(t *testing.T, msg string, resp *http.Response) {
+	checkResponse(t, msg, resp, http.StatusConflict)
+
+	var respErrs v2.Errors
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&respErrs); err != nil {
+		t.Fatalf("unexpected error decoding error response: %v", err)
+	}
+
+	if len(respErrs.Errors) != 1 || respErrs.Errors[0].Code != v2.ErrorCodeTagImmutable {
+		t.Fatalf("expected tag immutable error %s: got %v", msg, respErrs)
+	}
+}
+
+// TestRepositoryAdminAPI ensures that repositories can be renamed and copied
+// through the admin api.
+func TestRepositoryAdminAPI(t *testing.T) {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+
+	app := NewApp(config)
+	server := httptest.NewServer(handlers.CombinedLoggingHandler(os.Stderr, app))
+	builder, err := v2.NewURLBuilderFromString(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating url builder: %v", err)
+	}
+
+	renameURL, err := builder.BuildRenameURL()
+	if err != nil {
+		t.Fatalf("unexpected error building rename url: %v", err)
+	}
+
+	copyURL, err := builder.BuildCopyURL()
+	if err != nil {
+		t.Fatalf("unexpected error building copy url: %v", err)
+	}
+
+	rs, dgstStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer: %v", err)
+	}
+	dgst := digest.Digest(dgstStr)
+
+	pushLayer(t, builder, "foo/bar", dgst, startPushLayer(t, builder, "foo/bar"), rs)
+
+	resp := postRepositoryAdmin(t, renameURL, "foo/bar", "foo/baz")
+	defer resp.Body.Close()
+	checkResponse(t, "renaming repository", resp, http.StatusNoContent)
+
+	checkLayerAPI(t, builder, "foo/bar", dgst, http.StatusNotFound)
+	checkLayerAPI(t, builder, "foo/baz", dgst, http.StatusOK)
+
+	resp = postRepositoryAdmin(t, copyURL, "foo/baz", "foo/qux")
+	defer resp.Body.Close()
+	checkResponse(t, "copying repository", resp, http.StatusNoContent)
+
+	checkLayerAPI(t, builder, "foo/baz", dgst, http.StatusOK)
+	checkLayerAPI(t, builder, "foo/qux", dgst, http.StatusOK)
+
+	resp = postRepositoryAdmin(t, copyURL, "foo/baz", "foo/qux")
+	defer resp.Body.Close()
+	checkErrorResponse(t, "copying to existing repository", resp, http.StatusConflict, v2.ErrorCodeNameExists)
+
+	resp = postRepositoryAdmin(t, renameURL, "foo/bar", "foo/quux")
+	defer resp.Body.Close()
+	checkErrorResponse(t, "renaming unknown repository", resp, http.StatusNotFound, v2.ErrorCodeNameUnknown)
+
+	resp = postRepositoryAdmin(t, renameURL, "foo/baz", "Foo")
+	defer resp.Body.Close()
+	checkErrorResponse(t, "renaming to invalid name", resp, http.StatusBadRequest, v2.ErrorCodeNameInvalid)
+
+	// Without a signing key, repositories with manifests cannot be renamed.
+	manifestURL, err := builder.BuildManifestURL("foo/baz", "latest")
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	manifest := &storage.Manifest{
+		Versioned: storage.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:     "foo/baz",
+		Tag:      "latest",
+		FSLayers: []storage.FSLayer{{BlobSum: dgst}},
+		History:  testManifestHistory(1),
+	}
+
+	signedManifest, err := manifest.Sign(pk)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	resp = putManifest(t, "putting manifest", manifestURL, signedManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest", resp, http.StatusOK)
+
+	resp = postRepositoryAdmin(t, renameURL, "foo/baz", "foo/quux")
+	defer resp.Body.Close()
+	checkErrorResponse(t, "renaming without signing key", resp, http.StatusConflict, v2.ErrorCodeSigningKeyRequired)
+
+	checkLayerAPI(t, builder, "foo/baz", dgst, http.StatusOK)
+}
+
+// postRepositoryAdmin posts a rename or copy request for the repositories to
+// the admin url.
+func postRepositoryAdmin(t *testing.T, url, from, to string) *http.Response {
+	body, err := json.Marshal(map[string]string{"from": from, "to": to})
+	if err != nil {
+		t.Fatalf("unexpected error encoding request: %v", err)
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error posting to %s: %v", url, err)
+	}
+
+	return resp
+}
+
+// checkLayerAPI ensures that checking for the layer in the named repository
+// returns the expected status.
+func checkLayerAPI(t *testing.T, ub *v2.URLBuilder, name string, dgst digest.Digest, expectedStatus int) {
+	layerURL, err := ub.BuildBlobURL(name, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error building blob url: %v", err)
+	}
+
+	resp, err := http.Head(layerURL)
+	if err != nil {
+		t.Fatalf("unexpected error checking layer: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "checking layer in "+name, resp, expectedStatus)
+}
+
+// checkErrorResponse ensures that the response has the expected status and
+// a single error with the expected code.
+func checkErrorResponse(t *testing.T, msg string, resp *http.Response, expectedStatus int, expectedCode v2.ErrorCode) {
+	checkResponse(t, msg, resp, expectedStatus)
+
+	var respErrs v2.Errors
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&respErrs); err != nil {
+		t.Fatalf("unexpected error decoding error response: %v", err)
+	}
+
+	if len(respErrs.Errors) != 1 || respErrs.Errors[0].Code != expectedCode {
+		t.Fatalf("expected %v error %s: got %v", expectedCode, msg, respErrs)
+	}
+}
+
+// TestManifestListAPI ensures that manifest lists fetched by tag are only
+// served to clients accepting them and that other clients receive the default
+// platform, while lists fetched by digest are always served.
+func TestManifestListAPI(t *testing.T) {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+
+	app := NewApp(config)
+	server := httptest.NewServer(handlers.CombinedLoggingHandler(os.Stderr, app))
+	builder, err := v2.NewURLBuilderFromString(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating url builder: %v", err)
+	}
+
+	imageName := "foo/bar"
+	digests := make(map[string]digest.Digest)
+
+	list := storage.ManifestList{
+		Versioned: storage.Versioned{
+			SchemaVersion: 1,
+		},
+		MediaType: storage.MediaTypeManifestList,
+		Name:      imageName,
+		Tag:       "latest",
+	}
+
+	for _, architecture := range []string{"arm", "amd64"} {
+		manifestURL, err := builder.BuildManifestURL(imageName, architecture)
+		if err != nil {
+			t.Fatalf("unexpected error getting manifest url: %v", err)
+		}
+
+		manifest := &storage.Manifest{
+			Versioned: storage.Versioned{
+				SchemaVersion: 1,
+			},
+			Name:         imageName,
+			Tag:          architecture,
+			Architecture: architecture,
+		}
+
+		signedManifest, err := manifest.Sign(pk)
+		if err != nil {
+			t.Fatalf("unexpected error signing manifest: %v", err)
+		}
+
+		resp := putManifest(t, "putting manifest", manifestURL, signedManifest)
+		defer resp.Body.Close()
+		checkResponse(t, "putting manifest", resp, http.StatusOK)
+
+		digests[architecture] = digest.Digest(resp.Header.Get("Docker-Content-Digest"))
+		list.Manifests = append(list.Manifests, storage.ManifestDescriptor{
+			Digest:   digests[architecture],
+			Platform: storage.Platform{Architecture: architecture, OS: "linux"},
+		})
+	}
+
+	manifestURL, err := builder.BuildManifestURL(imageName, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	// Lists may only reference manifests in the repository.
+	unknown := list
+	unknown.Manifests = []storage.ManifestDescriptor{{
+		Digest:   "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		Platform: storage.Platform{Architecture: "amd64", OS: "linux"},
+	}}
+
+	signedList, err := unknown.Sign(pk)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest list: %v", err)
+	}
+
+	resp := putManifest(t, "putting manifest list", manifestURL, signedList)
+	defer resp.Body.Close()
+	checkErrorResponse(t, "putting manifest list with unknown manifest", resp, http.StatusBadRequest, v2.ErrorCodeManifestUnknown)
+
+	signedList, err = list.Sign(pk)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest list: %v", err)
+	}
+
+	resp = putManifest(t, "putting manifest list", manifestURL, signedList)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest list", resp, http.StatusOK)
+	listDigest := resp.Header.Get("Docker-Content-Digest")
+
+	for _, testcase := range []struct {
+		accept      string
+		contentType string
+		digest      string
+	}{
+		{
+			contentType: "application/json",
+			digest:      digests["amd64"].String(),
+		},
+		{
+			accept:      "application/json",
+			contentType: "application/json",
+			digest:      digests["amd64"].String(),
+		},
+		{
+			accept:      "application/json, " + storage.MediaTypeManifestList + "; q=0.5",
+			contentType: storage.MediaTypeManifestList,
+			digest:      listDigest,
+		},
+	} {
+		req, err := http.NewRequest("GET", manifestURL, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+
+		if testcase.accept != "" {
+			req.Header.Set("Accept", testcase.accept)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error fetching manifest: %v", err)
+		}
+		defer resp.Body.Close()
+
+		checkResponse(t, "fetching manifest with accept "+testcase.accept, resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Content-Type":          []string{testcase.contentType},
+			"Docker-Content-Digest": []string{testcase.digest},
+			"Vary":                  []string{"Accept"},
+		})
+	}
+
+	// A manifest list fetched by digest is served whatever the client
+	// accepts.
+	listURL, err := builder.BuildManifestURL(imageName, listDigest)
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", listURL, nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest list by digest: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching manifest list by digest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type":          []string{storage.MediaTypeManifestList},
+		"Docker-Content-Digest": []string{listDigest},
+	})
+}
+
+// TestManifestSignaturesAPI ensures that signatures posted for a manifest
+// revision are served with the manifest.
+func TestManifestSignaturesAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+
+	app := NewApp(config)
+	server := httptest.NewServer(handlers.CombinedLoggingHandler(os.Stderr, app))
+	builder, err := v2.NewURLBuilderFromString(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating url builder: %v", err)
+	}
+
+	imageName := "foo/bar"
+	manifestURL, err := builder.BuildManifestURL(imageName, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	manifest := &storage.Manifest{
+		Versioned: storage.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:         imageName,
+		Tag:          "latest",
+		Architecture: "amd64",
+	}
+
+	signedManifest, err := manifest.Sign(pk)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	resp := putManifest(t, "putting manifest", manifestURL, signedManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest", resp, http.StatusOK)
+	dgst := digest.Digest(resp.Header.Get("Docker-Content-Digest"))
+
+	// Countersign the manifest with a second key.
+	pk2, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	js, err := libtrust.ParsePrettySignature(signedManifest.Raw, "signatures")
+	if err != nil {
+		t.Fatalf("unexpected error parsing manifest signature: %v", err)
+	}
+
+	if err := js.Sign(pk2); err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	countersigned, err := js.PrettySignature("signatures")
+	if err != nil {
+		t.Fatalf("unexpected error formatting manifest signature: %v", err)
+	}
+
+	// Signatures may only be added to existing revisions.
+	unknownURL, err := builder.BuildSignaturesURL("foo/baz", dgst)
+	if err != nil {
+		t.Fatalf("unexpected error building signatures url: %v", err)
+	}
+
+	resp, err = http.Post(unknownURL, "application/json", bytes.NewReader(countersigned))
+	if err != nil {
+		t.Fatalf("unexpected error posting signatures: %v", err)
+	}
+	defer resp.Body.Close()
+	checkErrorResponse(t, "posting signatures for unknown revision", resp, http.StatusNotFound, v2.ErrorCodeManifestUnknown)
+
+	signaturesURL, err := builder.BuildSignaturesURL(imageName, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error building signatures url: %v", err)
+	}
+
+	// Signatures over another payload are rejected.
+	manifest.Tag = "other"
+	other, err := manifest.Sign(pk2)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	resp, err = http.Post(signaturesURL, "application/json", bytes.NewReader(other.Raw))
+	if err != nil {
+		t.Fatalf("unexpected error posting signatures: %v", err)
+	}
+	defer resp.Body.Close()
+	checkErrorResponse(t, "posting signatures over another payload", resp, http.StatusBadRequest, v2.ErrorCodeDigestInvalid)
+
+	resp, err = http.Post(signaturesURL, "application/json", bytes.NewReader(countersigned))
+	if err != nil {
+		t.Fatalf("unexpected error posting signatures: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "posting signatures", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+		"Content-Length":        []string{"0"},
+	})
+
+	resp, err = http.Get(manifestURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching countersigned manifest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+
+	var fetched storage.SignedManifest
+	if err := json.NewDecoder(resp.Body).Decode(&fetched); err != nil {
+		t.Fatalf("unexpected error decoding fetched manifest: %v", err)
+	}
+
+	keys, err := fetched.Verify()
+	if err != nil {
+		t.Fatalf("unexpected error verifying fetched manifest: %v", err)
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 signing keys, got %d", len(keys))
+	}
+}
+
+// TestTrustPolicyAPI ensures that manifests without a trusted signer are
+// rejected on push and refused on pull.
+func TestTrustPolicyAPI(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "registry-trust")
+	if err != nil {
+		t.Fatalf("unexpected error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	trustedKey, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	keysPath := tmpDir + "/trusted.pem"
+	if err := libtrust.SavePublicKey(keysPath, trustedKey.PublicKey()); err != nil {
+		t.Fatalf("unexpected error saving trusted key: %v", err)
+	}
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+
+	app := NewApp(config)
+	server := httptest.NewServer(handlers.CombinedLoggingHandler(os.Stderr, app))
+	builder, err := v2.NewURLBuilderFromString(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating url builder: %v", err)
+	}
+
+	imageName := "foo/bar"
+	untrustedKey, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	manifest := &storage.Manifest{
+		Versioned: storage.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:         imageName,
+		Tag:          "untrusted",
+		Architecture: "amd64",
+	}
+
+	untrustedURL, err := builder.BuildManifestURL(imageName, "untrusted")
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	// Push a manifest before any policy applies to the repository.
+	signedManifest, err := manifest.Sign(untrustedKey)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	resp := putManifest(t, "putting manifest", untrustedURL, signedManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest", resp, http.StatusOK)
+
+	config.Trust.Policies = []configuration.TrustPolicy{
+		{Repository: "foo/.*", Keys: keysPath, Pull: true},
+	}
+
+	options, err := storageOptions(config)
+	if err != nil {
+		t.Fatalf("unexpected error configuring storage: %v", err)
+	}
+
+	app.services, err = storage.NewServicesWithOptions(app.driver, options)
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	resp, err = http.Get(untrustedURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	checkErrorResponse(t, "fetching untrusted manifest", resp, http.StatusForbidden, v2.ErrorCodeManifestUnverified)
+
+	resp = putManifest(t, "putting untrusted manifest", untrustedURL, signedManifest)
+	defer resp.Body.Close()
+	checkErrorResponse(t, "putting untrusted manifest", resp, http.StatusBadRequest, v2.ErrorCodeManifestUnverified)
+
+	manifest.Tag = "trusted"
+	trustedURL, err := builder.BuildManifestURL(imageName, "trusted")
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	signedManifest, err = manifest.Sign(trustedKey)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	resp = putManifest(t, "putting trusted manifest", trustedURL, signedManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "putting trusted manifest", resp, http.StatusOK)
+
+	resp, err = http.Get(trustedURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "fetching trusted manifest", resp, http.StatusOK)
+}
+
+// TestSigningKeyAPI ensures that unsigned manifests are signed with the
+// registry signing key, which is served to clients.
+func TestSigningKeyAPI(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "registry-signing-key")
+	if err != nil {
+		t.Fatalf("unexpected error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+
+	app := NewApp(config)
+	server := httptest.NewServer(handlers.CombinedLoggingHandler(os.Stderr, app))
+	builder, err := v2.NewURLBuilderFromString(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating url builder: %v", err)
+	}
+
+	signingKeyURL, err := builder.BuildSigningKeyURL()
+	if err != nil {
+		t.Fatalf("unexpected error building signing key url: %v", err)
+	}
+
+	resp, err := http.Get(signingKeyURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching signing key: %v", err)
+	}
+	defer resp.Body.Close()
+	checkErrorResponse(t, "fetching missing signing key", resp, http.StatusNotFound, v2.ErrorCodeSigningKeyRequired)
+
+	// The key is generated when the registry first starts.
+	config.Trust.SigningKey = tmpDir + "/key.json"
+	config.Trust.SignUnsigned = true
+	app = NewApp(config)
+	server = httptest.NewServer(handlers.CombinedLoggingHandler(os.Stderr, app))
+	builder, err = v2.NewURLBuilderFromString(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating url builder: %v", err)
+	}
+
+	signingKey, err := libtrust.LoadKeyFile(config.Trust.SigningKey)
+	if err != nil {
+		t.Fatalf("expected signing key to be generated: %v", err)
+	}
+
+	signingKeyURL, err = builder.BuildSigningKeyURL()
+	if err != nil {
+		t.Fatalf("unexpected error building signing key url: %v", err)
+	}
+
+	resp, err = http.Get(signingKeyURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching signing key: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching signing key", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type": []string{"application/json"},
+	})
+
+	p, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error reading signing key: %v", err)
+	}
+
+	keys, err := libtrust.UnmarshalPublicKeyJWKSet(p)
+	if err != nil {
+		t.Fatalf("unexpected error decoding signing key: %v", err)
+	}
+
+	if len(keys) != 1 || keys[0].KeyID() != signingKey.KeyID() {
+		t.Fatalf("unexpected signing keys: %v", keys)
+	}
+
+	imageName := "foo/bar"
+	manifestURL, err := builder.BuildManifestURL(imageName, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	resp = putManifest(t, "putting unsigned manifest", manifestURL, &storage.Manifest{
+		Versioned: storage.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:         imageName,
+		Tag:          "latest",
+		Architecture: "amd64",
+	})
+	defer resp.Body.Close()
+	checkResponse(t, "putting unsigned manifest", resp, http.StatusOK)
+	dgst := resp.Header.Get("Docker-Content-Digest")
+
+	resp, err = http.Get(manifestURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "fetching signed manifest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst},
+	})
+
+	var fetched storage.SignedManifest
+	if err := json.NewDecoder(resp.Body).Decode(&fetched); err != nil {
+		t.Fatalf("unexpected error decoding fetched manifest: %v", err)
+	}
+
+	signers, err := fetched.Verify()
+	if err != nil {
+		t.Fatalf("unexpected error verifying fetched manifest: %v", err)
+	}
+
+	if len(signers) != 1 || signers[0].KeyID() != signingKey.KeyID() {
+		t.Fatalf("expected manifest to be signed by the registry: %v", signers)
+	}
+}
+
+// TestManifestValidationAPI ensures that manifests with invalid history are
+// rejected with an error code describing the problem.
+func TestManifestValidationAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+
+	app := NewApp(config)
+	server := httptest.NewServer(handlers.CombinedLoggingHandler(os.Stderr, app))
+	builder, err := v2.NewURLBuilderFromString(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating url builder: %v", err)
+	}
+
+	imageName := "foo/bar"
+	var layers []storage.FSLayer
+	for i := 0; i < 2; i++ {
+		rs, dgstStr, err := testutil.CreateRandomTarFile()
+		if err != nil {
+			t.Fatalf("error creating random layer: %v", err)
+		}
+		dgst := digest.Digest(dgstStr)
+
+		pushLayer(t, builder, imageName, dgst, startPushLayer(t, builder, imageName), rs)
+		layers = append(layers, storage.FSLayer{BlobSum: dgst})
+	}
+
+	manifestURL, err := builder.BuildManifestURL(imageName, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	for _, testcase := range []struct {
+		description string
+		history     []storage.ManifestHistory
+		code        v2.ErrorCode
+	}{
+		{
+			description: "missing history",
+			history:     testManifestHistory(1),
+			code:        v2.ErrorCodeManifestLayersMismatch,
+		},
+		{
+			description: "malformed history",
+			history: []storage.ManifestHistory{
+				{V1Compatibility: `{"id": "b", "parent": "a"}`},
+				{V1Compatibility: "a"},
+			},
+			code: v2.ErrorCodeManifestHistoryInvalid,
+		},
+		{
+			description: "broken parent chain",
+			history: []storage.ManifestHistory{
+				{V1Compatibility: `{"id": "c", "parent": "a"}`},
+				{V1Compatibility: `{"id": "b"}`},
+			},
+			code: v2.ErrorCodeManifestParentInvalid,
+		},
+	} {
+		manifest := &storage.Manifest{
+			Versioned: storage.Versioned{
+				SchemaVersion: 1,
+			},
+			Name:     imageName,
+			Tag:      "latest",
+			FSLayers: layers,
+			History:  testcase.history,
+		}
+
+		signedManifest, err := manifest.Sign(pk)
+		if err != nil {
+			t.Fatalf("unexpected error signing manifest: %v", err)
+		}
+
+		resp := putManifest(t, "putting manifest with "+testcase.description, manifestURL, signedManifest)
+		defer resp.Body.Close()
+		checkErrorResponse(t, "putting manifest with "+testcase.description, resp, http.StatusBadRequest, testcase.code)
+	}
+}
+
+// TestAdmissionAPI ensures that manifest pushes and tag rollbacks are posted
+// to the admission endpoint and rejected if it denies them or, unless
+// configured to fail open, fails to decide.
+func TestAdmissionAPI(t *testing.T) {
+	// The endpoint is called from the goroutines serving the registry, so
+	// its state is guarded.
+	var (
+		mu       sync.Mutex
+		requests []admissionRequest
+		revoked  = make(map[string]bool)
+	)
+
+	admissionServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request admissionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("unexpected error decoding admission request: %v", err)
+		}
+
+		mu.Lock()
+		requests = append(requests, request)
+		allowed := request.Tag != "denied" && !revoked[request.Digest]
+		mu.Unlock()
+
+		switch request.Tag {
+		case "slow":
+			time.Sleep(250 * time.Millisecond)
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(admissionResponse{
+			Allowed: allowed,
+			Reason:  "tag is reserved",
+		})
+	}))
+	defer admissionServer.Close()
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Admission: configuration.Admission{
+			URL:     admissionServer.URL,
+			Timeout: "50ms",
+		},
+	}
+
+	app := NewApp(config)
+	server := httptest.NewServer(handlers.CombinedLoggingHandler(os.Stderr, app))
+	builder, err := v2.NewURLBuilderFromString(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating url builder: %v", err)
+	}
+
+	imageName := "foo/bar"
+	rs, dgstStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer: %v", err)
+	}
+	dgst := digest.Digest(dgstStr)
+	pushLayer(t, builder, imageName, dgst, startPushLayer(t, builder, imageName), rs)
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	push := func(tag string) (*http.Response, *storage.SignedManifest) {
+		manifestURL, err := builder.BuildManifestURL(imageName, tag)
+		if err != nil {
+			t.Fatalf("unexpected error getting manifest url: %v", err)
+		}
+
+		signedManifest, err := (&storage.Manifest{
+			Versioned: storage.Versioned{
+				SchemaVersion: 1,
+			},
+			Name:     imageName,
+			Tag:      tag,
+			FSLayers: []storage.FSLayer{{BlobSum: dgst}},
+			History:  testManifestHistory(1),
+		}).Sign(pk)
+		if err != nil {
+			t.Fatalf("unexpected error signing manifest: %v", err)
+		}
+
+		return putManifest(t, "putting manifest "+tag, manifestURL, signedManifest), signedManifest
+	}
+
+	resp, signedManifest := push("latest")
+	defer resp.Body.Close()
+	checkResponse(t, "putting admitted manifest", resp, http.StatusOK)
+
+	mu.Lock()
+	if len(requests) != 1 {
+		t.Fatalf("unexpected admission requests: %d != 1", len(requests))
+	}
+	request := requests[0]
+	mu.Unlock()
+
+	expectedDigest, err := signedManifest.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	if request.Repository != imageName || request.Tag != "latest" || request.Digest != expectedDigest.String() {
+		t.Fatalf("unexpected admission request: %+v", request)
+	}
+
+	// The manifest is embedded in the request as a JSON object, so it is
+	// compacted.
+	var expectedManifest bytes.Buffer
+	if err := json.Compact(&expectedManifest, signedManifest.Raw); err != nil {
+		t.Fatalf("unexpected error compacting manifest: %v", err)
+	}
+
+	if !bytes.Equal(request.Manifest, expectedManifest.Bytes()) {
+		t.Fatalf("unexpected manifest in admission request: %s", request.Manifest)
+	}
+
+	resp, _ = push("denied")
+	defer resp.Body.Close()
+	checkErrorResponse(t, "putting denied manifest", resp, http.StatusForbidden, v2.ErrorCodeManifestDenied)
+
+	for _, tag := range []string{"slow", "broken"} {
+		resp, _ = push(tag)
+		defer resp.Body.Close()
+		checkErrorResponse(t, "putting manifest while admission is unavailable", resp, http.StatusServiceUnavailable, v2.ErrorCodeAdmissionUnavailable)
+	}
+
+	// Neither denied nor unadmitted manifests may have been stored.
+	for _, tag := range []string{"denied", "slow", "broken"} {
+		manifestURL, err := builder.BuildManifestURL(imageName, tag)
+		if err != nil {
+			t.Fatalf("unexpected error getting manifest url: %v", err)
+		}
+
+		resp, err := http.Get(manifestURL)
+		if err != nil {
+			t.Fatalf("unexpected error fetching manifest: %v", err)
+		}
+		defer resp.Body.Close()
+		checkResponse(t, "fetching rejected manifest", resp, http.StatusNotFound)
+	}
+
+	// Rolling a tag back to a revision the endpoint no longer admits is
+	// rejected, leaving the tag unchanged.
+	manifestURL, err := builder.BuildManifestURL(imageName, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	updatedManifest, err := (&storage.Manifest{
+		Versioned: storage.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:         imageName,
+		Tag:          "latest",
+		Architecture: "amd64",
+		FSLayers:     []storage.FSLayer{{BlobSum: dgst}},
+		History:      testManifestHistory(1),
+	}).Sign(pk)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	resp = putManifest(t, "putting updated manifest", manifestURL, updatedManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "putting updated manifest", resp, http.StatusOK)
+
+	updatedDigest, err := updatedManifest.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	mu.Lock()
+	revoked[expectedDigest.String()] = true
+	mu.Unlock()
+
+	tagHistoryURL, err := builder.BuildTagHistoryURL(imageName, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error building tag history url: %v", err)
+	}
+
+	req, err := http.NewRequest("PUT", tagHistoryURL, strings.NewReader(`{"revision": "`+expectedDigest.String()+`"}`))
+	if err != nil {
+		t.Fatalf("error creating rollback request: %v", err)
+	}
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error rolling back tag: %v", err)
+	}
+	defer resp.Body.Close()
+	checkErrorResponse(t, "rolling back to revoked revision", resp, http.StatusForbidden, v2.ErrorCodeManifestDenied)
+
+	resp, err = http.Get(manifestURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest after denied rollback", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{updatedDigest.String()},
+	})
+
+	// Failing open admits pushes the endpoint fails to decide, but still
+	// rejects denied pushes.
+	app.admission.failOpen = true
+
+	for _, tag := range []string{"slow", "broken"} {
+		resp, _ = push(tag)
+		defer resp.Body.Close()
+		checkResponse(t, "putting manifest while admission fails open", resp, http.StatusOK)
+	}
+
+	resp, _ = push("denied")
+	defer resp.Body.Close()
+	checkErrorResponse(t, "putting denied manifest while admission fails open", resp, http.StatusForbidden, v2.ErrorCodeManifestDenied)
+}
+
+// testManifestHistory returns the history of a chain of n images, from the
+// top image to the base image, for the layers of test manifests.
+func testManifestHistory(n int) []storage.ManifestHistory {
+	history := make([]storage.ManifestHistory, n)
+	for i := range history {
+		image := fmt.Sprintf(`{"id": "%064d"`, n-i)
+		if i+1 < n {
+			image += fmt.Sprintf(`, "parent": "%064d"`, n-i-1)
+		}
+
+		history[i] = storage.ManifestHistory{V1Compatibility: image + "}"}
+	}
+
+	return history
+}
+
+func putManifest(t *testing.T, msg, url string, v interface{}) *http.Response {
+	var body []byte
+	if sm, ok := v.(*storage.SignedManifest); ok {
+		body = sm.Raw
+	} else {
+		var err error
+		body, err = json.MarshalIndent(v, "", "   ")
+		if err != nil {
+			t.Fatalf("unexpected error marshaling %v: %v", v, err)
+		}
+	}
+
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("error creating request for %s: %v", msg, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error doing put request while %s: %v", msg, err)
+	}
+
+	return resp
+}
+
+func httpDelete(t *testing.T, msg, url string) *http.Response {
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		t.Fatalf("error creating request for %s: %v", msg, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error doing delete request while %s: %v", msg, err)
 	}
 
 	return resp
@@ -3,6 +3,9 @@ package registry
 import (
 	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/docker/docker-registry/api/v2"
 	"github.com/docker/docker-registry/auth"
@@ -12,6 +15,7 @@ import (
 	"github.com/docker/docker-registry/storagedriver/factory"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libtrust"
 	"github.com/gorilla/mux"
 )
 
@@ -30,6 +34,14 @@ type App struct {
 	services *storage.Services
 
 	accessController auth.AccessController
+
+	// admission decides whether manifests may be pushed, tagged again or
+	// copied. It is nil if no admission endpoint is configured.
+	admission *admissionHook
+
+	// readOnly is non-zero while the registry rejects writes. It is accessed
+	// atomically, since it may be toggled at runtime.
+	readOnly int32
 }
 
 // NewApp takes a configuration and returns a configured app, ready to serve
@@ -45,8 +57,16 @@ func NewApp(configuration configuration.Configuration) *App {
 	app.register(v2.RouteNameBase, func(ctx *Context, r *http.Request) http.Handler {
 		return http.HandlerFunc(apiBase)
 	})
+	app.register(v2.RouteNameCatalog, catalogDispatcher)
+	app.register(v2.RouteNameReadOnly, readOnlyDispatcher)
+	app.register(v2.RouteNameRename, renameDispatcher)
+	app.register(v2.RouteNameCopy, copyDispatcher)
+	app.register(v2.RouteNameSigningKey, signingKeyDispatcher)
 	app.register(v2.RouteNameManifest, imageManifestDispatcher)
+	app.register(v2.RouteNameSignatures, signaturesDispatcher)
 	app.register(v2.RouteNameTags, tagsDispatcher)
+	app.register(v2.RouteNameTagHistory, tagHistoryDispatcher)
+	app.register(v2.RouteNameUsage, usageDispatcher)
 	app.register(v2.RouteNameBlob, layerDispatcher)
 	app.register(v2.RouteNameBlobUpload, layerUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, layerUploadDispatcher)
@@ -61,7 +81,51 @@ func NewApp(configuration configuration.Configuration) *App {
 	}
 
 	app.driver = driver
-	app.services = storage.NewServices(app.driver)
+
+	options, err := storageOptions(configuration)
+	if err != nil {
+		panic(fmt.Sprintf("unable to configure storage services: %v", err))
+	}
+
+	app.services, err = storage.NewServicesWithOptions(app.driver, options)
+	if err != nil {
+		panic(fmt.Sprintf("unable to configure storage services: %v", err))
+	}
+
+	// Content stored with an earlier layout is not served until migrated,
+	// which would otherwise go unnoticed.
+	if err := app.services.CheckMigrated(); err != nil {
+		log.Warnf("storage: %v; run the migrate command", err)
+	}
+
+	readOnly, err := parseReadOnlyConfig(configuration)
+	if err != nil {
+		panic(fmt.Sprintf("unable to configure read-only mode: %v", err))
+	}
+	app.setReadOnly(readOnly)
+
+	upc, err := parseUploadPurgeConfig(configuration)
+	if err != nil {
+		panic(fmt.Sprintf("unable to configure upload purging: %v", err))
+	}
+
+	if upc.enabled {
+		go app.purgeUploads(upc)
+	}
+
+	rc, err := parseRetentionConfig(configuration)
+	if err != nil {
+		panic(fmt.Sprintf("unable to configure retention: %v", err))
+	}
+
+	if rc.enabled && len(rc.policies) > 0 {
+		go app.expireTags(rc)
+	}
+
+	app.admission, err = newAdmissionHook(configuration.Admission)
+	if err != nil {
+		panic(fmt.Sprintf("unable to configure admission: %v", err))
+	}
 
 	authType := configuration.Auth.Type()
 
@@ -76,6 +140,230 @@ func NewApp(configuration configuration.Configuration) *App {
 	return app
 }
 
+// storageOptions returns the options for the storage services, as
+// configured by the storage sections of the configuration.
+func storageOptions(config configuration.Configuration) (storage.Options, error) {
+	var options storage.Options
+
+	if store, ok := config.Storage["uploads"]["store"]; ok {
+		options.UploadStore = fmt.Sprint(store)
+	}
+
+	if algorithms, ok := config.Storage["digests"]["algorithms"]; ok {
+		var err error
+		options.DigestAlgorithms, err = parseStringList(algorithms)
+		if err != nil {
+			return options, err
+		}
+	}
+
+	for k, v := range config.Storage["quotas"] {
+		limits, err := parseQuotaLimits(v)
+		if err != nil {
+			return options, fmt.Errorf("quotas %v: %v", k, err)
+		}
+
+		switch k {
+		case "repositories":
+			options.Quotas.Repositories = limits
+		case "namespaces":
+			options.Quotas.Namespaces = limits
+		default:
+			return options, fmt.Errorf("quotas %v: unknown parameter", k)
+		}
+	}
+
+	for _, rule := range config.Tags.Immutable {
+		tagRule, err := compileTagRule(rule)
+		if err != nil {
+			return options, err
+		}
+
+		options.ImmutableTags = append(options.ImmutableTags, tagRule)
+	}
+
+	if config.Trust.SigningKey != "" {
+		key, err := libtrust.LoadOrCreateTrustKey(config.Trust.SigningKey)
+		if err != nil {
+			return options, err
+		}
+
+		options.SigningKey = key
+	}
+
+	options.SignUnsigned = config.Trust.SignUnsigned
+
+	for _, name := range config.Validation.Manifests {
+		validator, err := storage.GetManifestValidator(name)
+		if err != nil {
+			return options, err
+		}
+
+		options.ManifestValidators = append(options.ManifestValidators, validator)
+	}
+
+	for _, policy := range config.Trust.Policies {
+		trustPolicy, err := loadTrustPolicy(policy)
+		if err != nil {
+			return options, err
+		}
+
+		options.TrustPolicies = append(options.TrustPolicies, trustPolicy)
+	}
+
+	return options, nil
+}
+
+// loadTrustPolicy compiles the repository expression of a configured trust
+// policy and loads its trusted keys and roots.
+func loadTrustPolicy(policy configuration.TrustPolicy) (storage.TrustPolicy, error) {
+	var trustPolicy storage.TrustPolicy
+
+	if err := policy.Validate(); err != nil {
+		return trustPolicy, err
+	}
+
+	if policy.Repository != "" {
+		trustPolicy.Repository, _ = compileAnchored(policy.Repository)
+	}
+
+	if policy.Keys != "" {
+		keys, err := libtrust.LoadKeySetFile(policy.Keys)
+		if err != nil {
+			return trustPolicy, fmt.Errorf("loading trusted keys from %s: %v", policy.Keys, err)
+		}
+
+		trustPolicy.Keys = keys
+	}
+
+	if policy.Roots != "" {
+		roots, err := libtrust.LoadCertificatePool(policy.Roots)
+		if err != nil {
+			return trustPolicy, fmt.Errorf("loading trusted roots from %s: %v", policy.Roots, err)
+		}
+
+		trustPolicy.Roots = roots
+	}
+
+	trustPolicy.Pull = policy.Pull
+
+	return trustPolicy, nil
+}
+
+// compileTagRule compiles the expressions of a configured tag rule, anchoring
+// them so that they match the whole repository name or tag.
+func compileTagRule(rule configuration.TagRule) (storage.TagRule, error) {
+	var tagRule storage.TagRule
+
+	if err := rule.Validate(); err != nil {
+		return tagRule, err
+	}
+
+	tagRule.Tag, _ = compileAnchored(rule.Tag)
+	if rule.Repository != "" {
+		tagRule.Repository, _ = compileAnchored(rule.Repository)
+	}
+
+	return tagRule, nil
+}
+
+// compileAnchored compiles the regular expression such that it only matches
+// whole strings.
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	if _, err := regexp.Compile(expr); err != nil {
+		return nil, err
+	}
+
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// parseQuotaLimits reads a map of repository names or namespaces to their
+// quota, in bytes.
+func parseQuotaLimits(v interface{}) (map[string]int64, error) {
+	params, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid quotas: %#v", v)
+	}
+
+	limits := make(map[string]int64, len(params))
+	for name, limit := range params {
+		size, err := parseByteSize(limit)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", name, err)
+		}
+
+		limits[fmt.Sprint(name)] = size
+	}
+
+	return limits, nil
+}
+
+// byteSizeUnits are the suffixes accepted by parseByteSize, in binary
+// multiples.
+var byteSizeUnits = map[string]int64{
+	"":  1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// parseByteSize reads a size configuration value, which may be a number of
+// bytes or a string with a unit suffix, such as "10g" or "512MB".
+func parseByteSize(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case string:
+		s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(v)), "b")
+
+		unit := strings.TrimLeft(s, "0123456789")
+		multiple, ok := byteSizeUnits[unit]
+		if !ok {
+			break
+		}
+
+		n, err := strconv.ParseInt(strings.TrimSuffix(s, unit), 10, 64)
+		if err != nil {
+			break
+		}
+
+		return n * multiple, nil
+	}
+
+	return 0, fmt.Errorf("invalid size: %#v", v)
+}
+
+// parseStringList reads a list configuration value, which may be provided as
+// a list or as a comma separated string, such as from the environment.
+func parseStringList(v interface{}) ([]string, error) {
+	var items []string
+
+	switch v := v.(type) {
+	case string:
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid list item: %#v", item)
+			}
+
+			items = append(items, s)
+		}
+	default:
+		return nil, fmt.Errorf("invalid list: %#v", v)
+	}
+
+	return items, nil
+}
+
 func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	app.router.ServeHTTP(w, r)
 }
@@ -130,6 +418,17 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		}
 
 		context.log = log.WithField("name", context.Name)
+
+		// Writes are rejected before dispatch, since constructing some
+		// handlers, such as for layer uploads, touches storage.
+		if app.rejectedByReadOnly(r) {
+			context.Errors.Push(v2.ErrorCodeReadOnly)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			serveJSON(w, context.Errors)
+			return
+		}
+
 		handler := dispatch(context, r)
 
 		ssrw := &singleStatusResponseWriter{ResponseWriter: w}
@@ -196,6 +495,19 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 					Resource: resource,
 					Action:   "push",
 				})
+
+			// Mounting a layer from another repository requires pull
+			// access on the source repository.
+			if from := r.FormValue("from"); from != "" && routeName(r) == v2.RouteNameBlobUpload {
+				accessRecords = append(accessRecords,
+					auth.Access{
+						Resource: auth.Resource{
+							Type: "repository",
+							Name: from,
+						},
+						Action: "pull",
+					})
+			}
 		case "DELETE":
 			// DELETE access requires full admin rights, which is represented
 			// as "*". This may not be ideal.
@@ -206,10 +518,30 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 				})
 		}
 	} else {
-		// Only allow the name not to be set on the base route.
-		route := mux.CurrentRoute(r)
-
-		if route == nil || route.GetName() != v2.RouteNameBase {
+		// Only allow the name not to be set on the base route, the signing
+		// key route and the registry level routes, which require their own
+		// scope.
+		switch routeName(r) {
+		case v2.RouteNameBase, v2.RouteNameSigningKey:
+		case v2.RouteNameCatalog:
+			accessRecords = append(accessRecords,
+				auth.Access{
+					Resource: auth.Resource{
+						Type: "registry",
+						Name: "catalog",
+					},
+					Action: "*",
+				})
+		case v2.RouteNameReadOnly, v2.RouteNameRename, v2.RouteNameCopy:
+			accessRecords = append(accessRecords,
+				auth.Access{
+					Resource: auth.Resource{
+						Type: "registry",
+						Name: "admin",
+					},
+					Action: "*",
+				})
+		default:
 			// For this to be properly secured, context.Name must always be set
 			// for a resource that may make a modification. The only condition
 			// under which name is not set and we still allow access is when the
@@ -221,10 +553,13 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 			var errs v2.Errors
 			errs.Push(v2.ErrorCodeUnauthorized)
 			serveJSON(w, errs)
+
+			return fmt.Errorf("forbidden: no repository name")
 		}
 	}
 
-	if err := app.accessController.Authorized(r, accessRecords...); err != nil {
+	user, err := app.accessController.Authorized(r, accessRecords...)
+	if err != nil {
 		switch err := err.(type) {
 		case auth.Challenge:
 			w.Header().Set("Content-Type", "application/json")
@@ -245,9 +580,21 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 		return err
 	}
 
+	context.identity = user.Name
+
 	return nil
 }
 
+// routeName returns the name of the route matched by the request, or an
+// empty string if no route matched.
+func routeName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		return route.GetName()
+	}
+
+	return ""
+}
+
 // apiBase implements a simple yes-man for doing overall checks against the
 // api. This can support auth roundtrips to support docker login.
 func apiBase(w http.ResponseWriter, r *http.Request) {
@@ -2,14 +2,21 @@ package registry
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/docker/docker-registry/api/v2"
+	"github.com/docker/docker-registry/auth"
 	_ "github.com/docker/docker-registry/auth/silly"
 	"github.com/docker/docker-registry/configuration"
+	"github.com/docker/docker-registry/storage"
+
+	log "github.com/Sirupsen/logrus"
 )
 
 // TestAppDispatcher builds an application with a test dispatcher and ensures
@@ -77,7 +84,7 @@ func TestAppDispatcher(t *testing.T) {
 			endpoint: v2.RouteNameManifest,
 			vars: []string{
 				"name", "foo/bar",
-				"tag", "sometag",
+				"reference", "sometag",
 			},
 		},
 		{
@@ -191,4 +198,297 @@ func TestNewApp(t *testing.T) {
 	if errs.Errors[0].Code != v2.ErrorCodeUnauthorized {
 		t.Fatalf("unexpected error code: %v != %v", errs.Errors[0].Code, v2.ErrorCodeUnauthorized)
 	}
+
+	// The catalog should ask for the registry catalog scope.
+	catalogURL, err := builder.BuildCatalogURL()
+	if err != nil {
+		t.Fatalf("error creating catalogURL: %v", err)
+	}
+
+	req, err = http.Get(catalogURL)
+	if err != nil {
+		t.Fatalf("unexpected error during GET: %v", err)
+	}
+	defer req.Body.Close()
+
+	if req.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code during request: %v", req.StatusCode)
+	}
+
+	expectedAuthHeader = "Bearer realm=\"realm-test\",service=\"service-test\",scope=\"registry:catalog:*\""
+	if req.Header.Get("Authorization") != expectedAuthHeader {
+		t.Fatalf("unexpected authorization header: %q != %q", req.Header.Get("Authorization"), expectedAuthHeader)
+	}
+
+	// Mounting a blob should also ask for pull access on the source.
+	mountURL, err := builder.BuildBlobUploadURL("foo/bar", url.Values{
+		"mount": []string{"tarsum.dev+sha256:abcdef0123456789"},
+		"from":  []string{"foo/source"},
+	})
+	if err != nil {
+		t.Fatalf("error creating mountURL: %v", err)
+	}
+
+	req, err = http.Post(mountURL, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error during POST: %v", err)
+	}
+	defer req.Body.Close()
+
+	if req.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code during request: %v", req.StatusCode)
+	}
+
+	expectedAuthHeader = "Bearer realm=\"realm-test\",service=\"service-test\",scope=\"repository:foo/bar:pull repository:foo/bar:push repository:foo/source:pull\""
+	if req.Header.Get("Authorization") != expectedAuthHeader {
+		t.Fatalf("unexpected authorization header: %q != %q", req.Header.Get("Authorization"), expectedAuthHeader)
+	}
+
+	// Deleting a blob requires full access to the repository.
+	blobURL, err := builder.BuildBlobURL("foo/bar", "tarsum.dev+sha256:abcdef0123456789")
+	if err != nil {
+		t.Fatalf("error creating blobURL: %v", err)
+	}
+
+	deleteReq, err := http.NewRequest("DELETE", blobURL, nil)
+	if err != nil {
+		t.Fatalf("error creating delete request: %v", err)
+	}
+
+	req, err = http.DefaultClient.Do(deleteReq)
+	if err != nil {
+		t.Fatalf("unexpected error during DELETE: %v", err)
+	}
+	defer req.Body.Close()
+
+	if req.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code during request: %v", req.StatusCode)
+	}
+
+	expectedAuthHeader = "Bearer realm=\"realm-test\",service=\"service-test\",scope=\"repository:foo/bar:*\""
+	if req.Header.Get("Authorization") != expectedAuthHeader {
+		t.Fatalf("unexpected authorization header: %q != %q", req.Header.Get("Authorization"), expectedAuthHeader)
+	}
+
+	// Read-only mode is administered with the registry admin scope.
+	readOnlyURL, err := builder.BuildReadOnlyURL()
+	if err != nil {
+		t.Fatalf("error creating readOnlyURL: %v", err)
+	}
+
+	req, err = http.Get(readOnlyURL)
+	if err != nil {
+		t.Fatalf("unexpected error during GET: %v", err)
+	}
+	defer req.Body.Close()
+
+	if req.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code during request: %v", req.StatusCode)
+	}
+
+	expectedAuthHeader = "Bearer realm=\"realm-test\",service=\"service-test\",scope=\"registry:admin:*\""
+	if req.Header.Get("Authorization") != expectedAuthHeader {
+		t.Fatalf("unexpected authorization header: %q != %q", req.Header.Get("Authorization"), expectedAuthHeader)
+	}
+
+	// So are repository renames.
+	renameURL, err := builder.BuildRenameURL()
+	if err != nil {
+		t.Fatalf("error creating renameURL: %v", err)
+	}
+
+	req, err = http.Post(renameURL, "application/json", strings.NewReader(`{"from": "foo/bar", "to": "foo/baz"}`))
+	if err != nil {
+		t.Fatalf("unexpected error during POST: %v", err)
+	}
+	defer req.Body.Close()
+
+	if req.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code during request: %v", req.StatusCode)
+	}
+
+	if req.Header.Get("Authorization") != expectedAuthHeader {
+		t.Fatalf("unexpected authorization header: %q != %q", req.Header.Get("Authorization"), expectedAuthHeader)
+	}
+}
+
+// TestAuthorizedIdentity ensures that the identity of the client is taken
+// from the access controller once the request is authorized.
+func TestAuthorizedIdentity(t *testing.T) {
+	app := &App{
+		accessController: identityAccessController{},
+	}
+
+	for _, testcase := range []struct {
+		authorization string
+		identity      string
+	}{
+		{
+			authorization: "Bearer verified",
+			identity:      "test-user",
+		},
+		{
+			authorization: "Bearer forged",
+		},
+	} {
+		r, err := http.NewRequest("GET", "/v2/foo/bar/tags/list", nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+		r.Header.Set("Authorization", testcase.authorization)
+
+		context := &Context{
+			App:  app,
+			Name: "foo/bar",
+			log:  log.WithField("name", "foo/bar"),
+		}
+
+		app.authorized(httptest.NewRecorder(), r, context)
+
+		if context.identity != testcase.identity {
+			t.Fatalf("unexpected identity for %q: %q != %q", testcase.authorization, context.identity, testcase.identity)
+		}
+	}
+}
+
+// identityAccessController authorizes requests bearing the "verified" token
+// as test-user.
+type identityAccessController struct{}
+
+func (identityAccessController) Authorized(r *http.Request, access ...auth.Access) (auth.UserInfo, error) {
+	if r.Header.Get("Authorization") != "Bearer verified" {
+		return auth.UserInfo{}, fmt.Errorf("invalid token")
+	}
+
+	return auth.UserInfo{Name: "test-user"}, nil
+}
+
+// TestStorageOptions ensures that the storage sections of the configuration
+// are read into the storage options.
+func TestStorageOptions(t *testing.T) {
+	for _, testcase := range []struct {
+		yaml     string
+		expected storage.Options
+		err      bool
+	}{
+		{
+			yaml: "",
+		},
+		{
+			yaml: `
+  uploads:
+    store: driver
+  digests:
+    algorithms: [tarsum.v1+sha256, sha512]
+`,
+			expected: storage.Options{
+				UploadStore:      storage.UploadStoreDriver,
+				DigestAlgorithms: []string{"tarsum.v1+sha256", "sha512"},
+			},
+		},
+		{
+			// As provided by the environment.
+			yaml: `
+  digests:
+    algorithms: sha256, sha384
+`,
+			expected: storage.Options{
+				DigestAlgorithms: []string{"sha256", "sha384"},
+			},
+		},
+		{
+			yaml: `
+  digests:
+    algorithms: {sha256: true}
+`,
+			err: true,
+		},
+		{
+			yaml: `
+  quotas:
+    repositories:
+      foo/bar: 1024
+      foo/baz: 10g
+    namespaces:
+      foo: 512MB
+`,
+			expected: storage.Options{
+				Quotas: storage.Quotas{
+					Repositories: map[string]int64{
+						"foo/bar": 1024,
+						"foo/baz": 10 << 30,
+					},
+					Namespaces: map[string]int64{
+						"foo": 512 << 20,
+					},
+				},
+			},
+		},
+		{
+			yaml: `
+  quotas:
+    repositories:
+      foo/bar: 10x
+`,
+			err: true,
+		},
+		{
+			yaml: `
+  quotas:
+    users:
+      foo: 10g
+`,
+			err: true,
+		},
+	} {
+		config, err := configuration.Parse(strings.NewReader("version: 0.1\nstorage:\n  inmemory: {}\n" + testcase.yaml))
+		if err != nil {
+			t.Fatalf("unexpected error parsing configuration: %v", err)
+		}
+
+		options, err := storageOptions(*config)
+		if testcase.err {
+			if err == nil {
+				t.Fatalf("expected error parsing %q", testcase.yaml)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("unexpected error parsing storage options: %v", err)
+		}
+
+		if !reflect.DeepEqual(options, testcase.expected) {
+			t.Fatalf("unexpected storage options: %#v != %#v", options, testcase.expected)
+		}
+	}
+}
+
+// TestCompileTagRule ensures that the expressions of configured tag rules
+// must match the whole repository name or tag.
+func TestCompileTagRule(t *testing.T) {
+	rule, err := compileTagRule(configuration.TagRule{Repository: "foo", Tag: "v1|v1\\.2"})
+	if err != nil {
+		t.Fatalf("unexpected error compiling tag rule: %v", err)
+	}
+
+	for _, testcase := range []struct {
+		name, tag string
+		expected  bool
+	}{
+		{"foo", "v1", true},
+		{"foo", "v1.2", true},
+		{"foo", "v1.2.3", false},
+		{"foo", "latest-v1", false},
+		{"foo/bar", "v1", false},
+		{"bar/foo", "v1", false},
+	} {
+		if rule.Matches(testcase.name, testcase.tag) != testcase.expected {
+			t.Fatalf("unexpected match of %s:%s, expected %v", testcase.name, testcase.tag, testcase.expected)
+		}
+	}
+
+	if _, err := compileTagRule(configuration.TagRule{Repository: "foo"}); err == nil {
+		t.Fatalf("expected error compiling tag rule without a tag expression")
+	}
 }
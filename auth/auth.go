@@ -18,7 +18,7 @@
 //			resource := auth.Resource{Type: "customerOrder", Name: orderNumber}
 // 			access := auth.Access{Resource: resource, Action: "update"}
 //
-// 			if err := accessController.Authorized(r, access); err != nil {
+// 			if _, err := accessController.Authorized(r, access); err != nil {
 //				if challenge, ok := err.(auth.Challenge) {
 //					// Let the challenge write the response.
 //					challenge.ServeHTTP(w, r)
@@ -48,6 +48,12 @@ type Access struct {
 	Action string
 }
 
+// UserInfo carries information about the client of an authorized request.
+type UserInfo struct {
+	// Name identifies the client, if known to the access controller.
+	Name string
+}
+
 // Challenge is a special error type which is used for HTTP 401 Unauthorized
 // responses and is able to write the response with WWW-Authenticate challenge
 // header values based on the error.
@@ -71,7 +77,9 @@ type AccessController interface {
 	// should always be denied. The error may be of type Challenge, in which
 	// case the caller may have the Challenge handle the request or choose
 	// what action to take based on the Challenge header or response status.
-	Authorized(req *http.Request, access ...Access) error
+	// If access is granted, the client is described by the returned
+	// UserInfo, as verified by the access controller.
+	Authorized(req *http.Request, access ...Access) (UserInfo, error)
 }
 
 // InitFunc is the type of an AccessController factory function and is used
@@ -40,8 +40,9 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 }
 
 // Authorized simply checks for the existence of the authorization header,
-// responding with a bearer challenge if it doesn't exist.
-func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.Access) error {
+// responding with a bearer challenge if it doesn't exist. The client is not
+// identified.
+func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.Access) (auth.UserInfo, error) {
 	if req.Header.Get("Authorization") == "" {
 		challenge := challenge{
 			realm:   ac.realm,
@@ -56,10 +57,10 @@ func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.
 			challenge.scope = strings.Join(scopes, " ")
 		}
 
-		return &challenge
+		return auth.UserInfo{}, &challenge
 	}
 
-	return nil
+	return auth.UserInfo{}, nil
 }
 
 type challenge struct {
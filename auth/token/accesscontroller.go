@@ -217,8 +217,9 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 }
 
 // Authorized handles checking whether the given request is authorized
-// for actions on resources described by the given access items.
-func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Access) error {
+// for actions on resources described by the given access items. The client
+// is identified by the subject of the verified token.
+func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Access) (auth.UserInfo, error) {
 	challenge := &authChallenge{
 		realm:     ac.realm,
 		service:   ac.service,
@@ -229,7 +230,7 @@ func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Ac
 
 	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
 		challenge.err = ErrTokenRequired
-		return challenge
+		return auth.UserInfo{}, challenge
 	}
 
 	rawToken := parts[1]
@@ -237,7 +238,7 @@ func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Ac
 	token, err := NewToken(rawToken)
 	if err != nil {
 		challenge.err = err
-		return challenge
+		return auth.UserInfo{}, challenge
 	}
 
 	verifyOpts := VerifyOptions{
@@ -249,18 +250,18 @@ func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Ac
 
 	if err = token.Verify(verifyOpts); err != nil {
 		challenge.err = err
-		return challenge
+		return auth.UserInfo{}, challenge
 	}
 
 	accessSet := token.accessSet()
 	for _, access := range accessItems {
 		if !accessSet.contains(access) {
 			challenge.err = ErrInsufficientScope
-			return challenge
+			return auth.UserInfo{}, challenge
 		}
 	}
 
-	return nil
+	return auth.UserInfo{Name: token.Claims.Subject}, nil
 }
 
 // init handles registering the token auth backend.
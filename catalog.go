@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/docker/docker-registry/api/v2"
+	"github.com/gorilla/handlers"
+)
+
+// catalogDispatcher constructs the catalog handler api endpoint.
+func catalogDispatcher(ctx *Context, r *http.Request) http.Handler {
+	catalogHandler := &catalogHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(catalogHandler.GetCatalog),
+	}
+}
+
+// catalogHandler handles requests for the list of repositories.
+type catalogHandler struct {
+	*Context
+}
+
+type catalogAPIResponse struct {
+	Repositories []string `json:"repositories"`
+}
+
+// GetCatalog returns a json list of the repositories in the registry, in
+// lexical order.
+func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
+	n, last, err := paginationParameters(r)
+	if err != nil {
+		ch.Errors.Push(v2.ErrorCodePaginationInvalid, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	limit := n
+	if limit > 0 {
+		// Ask for one extra entry to find out if there is a next page.
+		limit++
+	}
+
+	repositories, err := ch.services.Repositories(last, limit)
+	if err != nil {
+		ch.Errors.PushErr(err)
+		return
+	}
+
+	if n > 0 && len(repositories) > n {
+		repositories = repositories[:n]
+
+		nextURL, err := ch.urlBuilder.BuildCatalogURL(url.Values{
+			"n":    []string{fmt.Sprint(n)},
+			"last": []string{repositories[n-1]},
+		})
+		if err != nil {
+			ch.Errors.PushErr(err)
+			return
+		}
+
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL))
+	}
+
+	if repositories == nil {
+		repositories = []string{}
+	}
+
+	if err := serveJSON(w, catalogAPIResponse{
+		Repositories: repositories,
+	}); err != nil {
+		ch.Errors.PushErr(err)
+		return
+	}
+}
@@ -29,9 +29,17 @@ type Client interface {
 	DeleteImage(name, tag string) error
 
 	// ListImageTags returns a list of all image tags with the given repository
-	// name.
+	// name, in lexical order. If the registry paginates the response, all
+	// pages are fetched.
 	ListImageTags(name string) ([]string, error)
 
+	// ListImageTagsPage returns at most n image tags with the given
+	// repository name, starting after the tag last. If last is empty, the
+	// list starts with the first tag. The returned bool is true if more tags
+	// are available, in which case the final tag returned can be passed as
+	// last to fetch the next page.
+	ListImageTagsPage(name string, n int, last string) ([]string, bool, error)
+
 	// BlobLength returns the length of the blob stored at the given name,
 	// digest pair.
 	// Returns a length value of -1 on error or if the blob does not exist.
@@ -47,6 +55,13 @@ type Client interface {
 	// and returns a unique location url to use for other blob upload methods.
 	InitiateBlobUpload(name string) (string, error)
 
+	// MountBlob makes the blob identified by dgst in the repository from
+	// available in the repository identified by name, without uploading it.
+	// If the registry cannot mount the blob, it starts a regular upload and
+	// the location url of the upload is returned. An empty location
+	// indicates that the blob was mounted.
+	MountBlob(name string, dgst digest.Digest, from string) (string, error)
+
 	// GetBlobUploadStatus returns the byte offset and length of the blob at the
 	// given upload location.
 	GetBlobUploadStatus(location string) (int, int, error)
@@ -211,9 +226,48 @@ func (r *clientImpl) ListImageTags(name string) ([]string, error) {
 		return nil, err
 	}
 
+	var tags []string
+	for tagsURL != "" {
+		var page []string
+		page, tagsURL, err = r.listImageTags(name, tagsURL)
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, page...)
+	}
+
+	return tags, nil
+}
+
+func (r *clientImpl) ListImageTagsPage(name string, n int, last string) ([]string, bool, error) {
+	values := url.Values{
+		"n": []string{strconv.Itoa(n)},
+	}
+
+	if last != "" {
+		values.Set("last", last)
+	}
+
+	tagsURL, err := r.ub.BuildTagsURL(name, values)
+	if err != nil {
+		return nil, false, err
+	}
+
+	tags, next, err := r.listImageTags(name, tagsURL)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return tags, next != "", nil
+}
+
+// listImageTags fetches a single page of tags from tagsURL, returning the url
+// of the next page, if the response provided one.
+func (r *clientImpl) listImageTags(name, tagsURL string) ([]string, string, error) {
 	response, err := http.Get(tagsURL)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer response.Body.Close()
 
@@ -222,17 +276,17 @@ func (r *clientImpl) ListImageTags(name string) ([]string, error) {
 	case response.StatusCode == http.StatusOK:
 		break
 	case response.StatusCode == http.StatusNotFound:
-		return nil, &RepositoryNotFoundError{Name: name}
+		return nil, "", &RepositoryNotFoundError{Name: name}
 	case response.StatusCode >= 400 && response.StatusCode < 500:
 		var errs v2.Errors
 		decoder := json.NewDecoder(response.Body)
 		err = decoder.Decode(&errs)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
-		return nil, &errs
+		return nil, "", &errs
 	default:
-		return nil, &UnexpectedHTTPStatusError{Status: response.Status}
+		return nil, "", &UnexpectedHTTPStatusError{Status: response.Status}
 	}
 
 	tags := struct {
@@ -242,10 +296,15 @@ func (r *clientImpl) ListImageTags(name string) ([]string, error) {
 	decoder := json.NewDecoder(response.Body)
 	err = decoder.Decode(&tags)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	next, err := nextLink(tagsURL, response.Header.Get("Link"))
+	if err != nil {
+		return nil, "", err
 	}
 
-	return tags.Tags, nil
+	return tags.Tags, next, nil
 }
 
 func (r *clientImpl) BlobLength(name string, dgst digest.Digest) (int, error) {
@@ -364,6 +423,44 @@ func (r *clientImpl) InitiateBlobUpload(name string) (string, error) {
 	}
 }
 
+func (r *clientImpl) MountBlob(name string, dgst digest.Digest, from string) (string, error) {
+	mountURL, err := r.ub.BuildBlobUploadURL(name, url.Values{
+		"mount": []string{dgst.String()},
+		"from":  []string{from},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	postRequest, err := http.NewRequest("POST", mountURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := http.DefaultClient.Do(postRequest)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusCreated:
+		return "", nil
+	case response.StatusCode == http.StatusAccepted:
+		return response.Header.Get("Location"), nil
+	case response.StatusCode >= 400 && response.StatusCode < 500:
+		var errs v2.Errors
+		decoder := json.NewDecoder(response.Body)
+		err = decoder.Decode(&errs)
+		if err != nil {
+			return "", err
+		}
+		return "", &errs
+	default:
+		return "", &UnexpectedHTTPStatusError{Status: response.Status}
+	}
+}
+
 func (r *clientImpl) GetBlobUploadStatus(location string) (int, int, error) {
 	response, err := http.Get(location)
 	if err != nil {
@@ -566,3 +663,29 @@ func parseRangeHeader(byteRangeHeader string) (int, int, error) {
 	}
 	return int(offset), int(length), nil
 }
+
+// nextLinkRegexp matches the url of the next page in an RFC 5988 Link
+// header.
+var nextLinkRegexp = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// nextLink parses an RFC 5988 Link header, returning the url of the next page
+// resolved against the url of the current page. If the header has no next
+// link, an empty string is returned.
+func nextLink(current, linkHeader string) (string, error) {
+	submatches := nextLinkRegexp.FindStringSubmatch(linkHeader)
+	if submatches == nil {
+		return "", nil
+	}
+
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+
+	next, err := url.Parse(submatches[1])
+	if err != nil {
+		return "", err
+	}
+
+	return base.ResolveReference(next).String(), nil
+}
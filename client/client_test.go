@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"sync"
 	"testing"
 
@@ -407,3 +408,138 @@ func (hirw *headerInterceptingResponseWriter) WriteHeader(status int) {
 
 	hirw.ResponseWriter.WriteHeader(status)
 }
+
+func TestListImageTags(t *testing.T) {
+	name := "hello/world"
+	tagsRoute := "/v2/" + name + "/tags/list"
+
+	handler := testutil.NewHandler(testutil.RequestResponseMap{
+		{
+			Request: testutil.Request{
+				Method: "GET",
+				Route:  tagsRoute,
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusOK,
+				Headers: http.Header{
+					"Link": []string{`<` + tagsRoute + `?last=bb&n=2>; rel="next"`},
+				},
+				Body: []byte(`{"name": "hello/world", "tags": ["aa", "bb"]}`),
+			},
+		},
+		{
+			Request: testutil.Request{
+				Method:      "GET",
+				Route:       tagsRoute,
+				QueryParams: map[string][]string{"n": {"2"}, "last": {"bb"}},
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusOK,
+				Body:       []byte(`{"name": "hello/world", "tags": ["cc"]}`),
+			},
+		},
+		{
+			Request: testutil.Request{
+				Method:      "GET",
+				Route:       tagsRoute,
+				QueryParams: map[string][]string{"n": {"2"}},
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusOK,
+				Headers: http.Header{
+					"Link": []string{`<` + tagsRoute + `?last=bb&n=2>; rel="next"`},
+				},
+				Body: []byte(`{"name": "hello/world", "tags": ["aa", "bb"]}`),
+			},
+		},
+	})
+	server := httptest.NewServer(handler)
+	client, err := New(server.URL)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	// All pages should be followed.
+	tags, err := client.ListImageTags(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(tags, []string{"aa", "bb", "cc"}) {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+
+	tags, more, err := client.ListImageTagsPage(name, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(tags, []string{"aa", "bb"}) {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+
+	if !more {
+		t.Fatalf("expected more tags to be available")
+	}
+}
+
+func TestMountBlob(t *testing.T) {
+	name := "hello/world"
+	dgst := digest.Digest("tarsum.dev+sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	uploadsRoute := "/v2/" + name + "/blobs/uploads/"
+	uploadLocation := uploadsRoute + "abcdefg"
+
+	handler := testutil.NewHandler(testutil.RequestResponseMap{
+		{
+			Request: testutil.Request{
+				Method:      "POST",
+				Route:       uploadsRoute,
+				QueryParams: map[string][]string{"mount": {dgst.String()}, "from": {"hello/source"}},
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusCreated,
+				Headers: http.Header{
+					"Location":       []string{"/v2/" + name + "/blobs/" + dgst.String()},
+					"Content-Length": []string{"0"},
+				},
+			},
+		},
+		{
+			Request: testutil.Request{
+				Method:      "POST",
+				Route:       uploadsRoute,
+				QueryParams: map[string][]string{"mount": {dgst.String()}, "from": {"hello/empty"}},
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusAccepted,
+				Headers: http.Header{
+					"Location":       []string{uploadLocation},
+					"Content-Length": []string{"0"},
+				},
+			},
+		},
+	})
+	server := httptest.NewServer(handler)
+	client, err := New(server.URL)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	location, err := client.MountBlob(name, dgst, "hello/source")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if location != "" {
+		t.Fatalf("expected blob to be mounted, got upload location %q", location)
+	}
+
+	location, err = client.MountBlob(name, dgst, "hello/empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if location != uploadLocation {
+		t.Fatalf("unexpected upload location: %q != %q", location, uploadLocation)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/docker/docker-registry/storage"
+	"github.com/docker/docker-registry/storagedriver/factory"
+)
+
+// fsck runs the fsck command, checking the consistency of the content in the
+// configured storage backend. A report of the problems found is written to
+// stdout as json and the command exits non-zero if any remain. With -repair,
+// corrupt blobs are quarantined and dangling links are removed.
+//
+// The registry should not be accepting pushes while repairing.
+func fsck(args []string) {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	flags.Usage = usage
+	repair := flags.Bool("repair", false, "quarantine corrupt blobs and remove dangling links")
+	flags.Parse(args)
+
+	config, err := resolveConfiguration(flags.Args())
+	if err != nil {
+		fatalf("configuration error: %v", err)
+	}
+
+	log.SetLevel(logLevel(config.Loglevel))
+
+	driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		fatalf("error creating storage driver: %v", err)
+	}
+
+	report, err := storage.NewServices(driver).Fsck(*repair)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "consistency check failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	p, err := json.MarshalIndent(report, "", "   ")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	fmt.Println(string(p))
+
+	if n := report.Unrepaired(); n > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems found\n", n)
+		os.Exit(1)
+	}
+}
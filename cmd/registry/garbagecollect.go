@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/docker/docker-registry/storage"
+	"github.com/docker/docker-registry/storagedriver/factory"
+)
+
+// garbageCollect runs the garbage-collect command, removing manifest
+// revisions that are no longer reachable from a tag, and layers and blobs
+// from the configured storage backend that are not referenced by a remaining
+// manifest. A report of the collected content is written to stdout as json.
+//
+// The registry should not be accepting pushes while this runs. Layers that
+// have been uploaded but not yet referenced by a manifest will be removed.
+// Nothing is collected until content stored with an earlier layout has been
+// migrated with the migrate command.
+func garbageCollect(args []string) {
+	flags := flag.NewFlagSet("garbage-collect", flag.ExitOnError)
+	flags.Usage = usage
+	dryRun := flags.Bool("dry-run", false, "report content that would be removed, without removing it")
+	flags.Parse(args)
+
+	config, err := resolveConfiguration(flags.Args())
+	if err != nil {
+		fatalf("configuration error: %v", err)
+	}
+
+	log.SetLevel(logLevel(config.Loglevel))
+
+	driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		fatalf("error creating storage driver: %v", err)
+	}
+
+	report, err := storage.NewServices(driver).GarbageCollect(*dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "garbage collection failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	p, err := json.MarshalIndent(report, "", "   ")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	fmt.Println(string(p))
+}
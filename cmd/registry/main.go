@@ -24,7 +24,19 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 
-	config, err := resolveConfiguration()
+	switch flag.Arg(0) {
+	case "garbage-collect":
+		garbageCollect(flag.Args()[1:])
+		return
+	case "migrate":
+		migrate(flag.Args()[1:])
+		return
+	case "fsck":
+		fsck(flag.Args()[1:])
+		return
+	}
+
+	config, err := resolveConfiguration(flag.Args())
 	if err != nil {
 		fatalf("configuration error: %v", err)
 	}
@@ -42,6 +54,9 @@ func main() {
 
 func usage() {
 	fmt.Fprintln(os.Stderr, "usage:", os.Args[0], "<config>")
+	fmt.Fprintln(os.Stderr, "      ", os.Args[0], "garbage-collect [-dry-run] <config>")
+	fmt.Fprintln(os.Stderr, "      ", os.Args[0], "migrate [-dry-run] <config>")
+	fmt.Fprintln(os.Stderr, "      ", os.Args[0], "fsck [-repair] <config>")
 	flag.PrintDefaults()
 }
 
@@ -51,11 +66,13 @@ func fatalf(format string, args ...interface{}) {
 	os.Exit(1)
 }
 
-func resolveConfiguration() (*configuration.Configuration, error) {
+// resolveConfiguration parses the configuration from the path in the first
+// argument, falling back to REGISTRY_CONFIGURATION_PATH if args is empty.
+func resolveConfiguration(args []string) (*configuration.Configuration, error) {
 	var configurationPath string
 
-	if flag.NArg() > 0 {
-		configurationPath = flag.Arg(0)
+	if len(args) > 0 {
+		configurationPath = args[0]
 	} else if os.Getenv("REGISTRY_CONFIGURATION_PATH") != "" {
 		configurationPath = os.Getenv("REGISTRY_CONFIGURATION_PATH")
 	}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/docker/docker-registry"
+	"github.com/docker/docker-registry/storage"
+	"github.com/docker/docker-registry/storagedriver/factory"
+)
+
+// migrate runs the migrate command, converting content stored with the
+// layouts of earlier storage path versions into the current layout. A report
+// of the migrations is written to stdout as json. With -dry-run, the report
+// is a plan of the remaining work and nothing is written.
+//
+// Content is moved into the new layout rather than copied. An interrupted
+// migration resumes where it left off when run again. No registry may accept
+// pushes while this runs, so the configuration must enable read-only mode,
+// as it should for every registry sharing the storage. Content cannot be
+// pulled from a registry using the earlier layout once it is migrated.
+func migrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = usage
+	dryRun := flags.Bool("dry-run", false, "report the migrations that would be run, without running them")
+	flags.Parse(args)
+
+	config, err := resolveConfiguration(flags.Args())
+	if err != nil {
+		fatalf("configuration error: %v", err)
+	}
+
+	log.SetLevel(logLevel(config.Loglevel))
+
+	if !*dryRun {
+		readOnly, err := registry.ReadOnlyConfigured(*config)
+		if err != nil {
+			fatalf("configuration error: %v", err)
+		}
+
+		if !readOnly {
+			fmt.Fprintln(os.Stderr, "refusing to migrate: storage.maintenance.readonly must be enabled, and no registry sharing the storage may accept pushes while content is moved")
+			os.Exit(1)
+		}
+	}
+
+	driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		fatalf("error creating storage driver: %v", err)
+	}
+
+	report, err := storage.NewServices(driver).Migrate(*dryRun)
+	if report != nil {
+		p, err := json.MarshalIndent(report, "", "   ")
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		fmt.Println(string(p))
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migration failed: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"reflect"
+	"regexp"
 	"strings"
+	"time"
 )
 
 // Configuration is a versioned registry configuration, intended to be provided by a yaml file, and
@@ -27,6 +30,21 @@ type Configuration struct {
 	// Reporting is the configuration for error reporting
 	Reporting Reporting `yaml:"reporting"`
 
+	// Tags configures rules applying to the tags of repositories.
+	Tags Tags `yaml:"tags"`
+
+	// Trust configures the signers trusted for the manifests of
+	// repositories.
+	Trust Trust `yaml:"trust"`
+
+	// Validation configures the validation of manifests pushed to the
+	// registry.
+	Validation Validation `yaml:"validation"`
+
+	// Admission configures an external endpoint deciding whether manifests
+	// may be pushed.
+	Admission Admission `yaml:"admission"`
+
 	// HTTP contains configuration parameters for the registry's http
 	// interface.
 	HTTP struct {
@@ -95,10 +113,33 @@ type Parameters map[string]interface{}
 // Storage defines the configuration for registry object storage
 type Storage map[string]Parameters
 
+// storageSections are the keys of the storage configuration that configure
+// how the registry uses its storage, rather than naming the storage driver.
+var storageSections = map[string]struct{}{
+	// uploads configures where in-progress uploads are kept. The "store"
+	// parameter may be "local" (the default) or "driver".
+	"uploads": {},
+
+	// maintenance configures background maintenance tasks, such as
+	// uploadpurging.
+	"maintenance": {},
+
+	// digests configures the digest algorithms accepted for uploaded
+	// layers, with the "algorithms" parameter.
+	"digests": {},
+
+	// quotas configures limits on the bytes stored by repositories, with the
+	// "repositories" and "namespaces" parameters.
+	"quotas": {},
+}
+
 // Type returns the storage driver type, such as filesystem or s3
 func (storage Storage) Type() string {
-	// Return only key in this map
+	// Return the only key in this map that is not a storage section
 	for k := range storage {
+		if _, ok := storageSections[k]; ok {
+			continue
+		}
 		return k
 	}
 	return ""
@@ -120,11 +161,13 @@ func (storage *Storage) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var storageMap map[string]Parameters
 	err := unmarshal(&storageMap)
 	if err == nil {
-		if len(storageMap) > 1 {
-			types := make([]string, 0, len(storageMap))
-			for k := range storageMap {
+		types := make([]string, 0, len(storageMap))
+		for k := range storageMap {
+			if _, ok := storageSections[k]; !ok {
 				types = append(types, k)
 			}
+		}
+		if len(types) > 1 {
 			return fmt.Errorf("Must provide exactly one storage type. Provided: %v", types)
 		}
 		*storage = storageMap
@@ -143,7 +186,7 @@ func (storage *Storage) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 // MarshalYAML implements the yaml.Marshaler interface
 func (storage Storage) MarshalYAML() (interface{}, error) {
-	if storage.Parameters() == nil {
+	if storage.Parameters() == nil && len(storage) == 1 {
 		return storage.Type(), nil
 	}
 	return map[string]Parameters(storage), nil
@@ -210,6 +253,150 @@ func (auth Auth) MarshalYAML() (interface{}, error) {
 	return map[string]Parameters(auth), nil
 }
 
+// Tags defines rules applying to the tags of repositories.
+type Tags struct {
+	// Immutable lists rules matching tags that may not be overwritten or
+	// deleted once pushed, such as release tags.
+	Immutable []TagRule `yaml:"immutable,omitempty"`
+}
+
+// TagRule matches tags with regular expressions. The expressions must match
+// the whole of the repository name or tag.
+type TagRule struct {
+	// Repository matches the names of the repositories to which the rule
+	// applies. If empty, the rule applies to all repositories.
+	Repository string `yaml:"repository,omitempty"`
+
+	// Tag matches the tags to which the rule applies.
+	Tag string `yaml:"tag"`
+}
+
+// Validate ensures that the expressions of the rule are valid and that a
+// tag expression is provided.
+func (rule TagRule) Validate() error {
+	if rule.Tag == "" {
+		return fmt.Errorf("tag rule must provide a tag expression")
+	}
+
+	if _, err := regexp.Compile(rule.Tag); err != nil {
+		return fmt.Errorf("invalid tag expression %q: %v", rule.Tag, err)
+	}
+
+	if _, err := regexp.Compile(rule.Repository); err != nil {
+		return fmt.Errorf("invalid repository expression %q: %v", rule.Repository, err)
+	}
+
+	return nil
+}
+
+// Trust defines the signers trusted for the manifests of repositories and
+// the key with which the registry signs manifests.
+type Trust struct {
+	// Policies lists the signers trusted for manifests, by repository.
+	Policies []TrustPolicy `yaml:"policies,omitempty"`
+
+	// SigningKey is the path of the libtrust private key file with which
+	// the registry signs manifests. The key is generated if the file does
+	// not exist.
+	SigningKey string `yaml:"signingkey,omitempty"`
+
+	// SignUnsigned allows unsigned manifests to be pushed, signing them
+	// with the signing key.
+	SignUnsigned bool `yaml:"signunsigned,omitempty"`
+}
+
+// TrustPolicy lists the keys and certificate authorities trusted to sign the
+// manifests of the repositories matched by a regular expression, which must
+// match the whole repository name.
+type TrustPolicy struct {
+	// Repository matches the names of the repositories to which the policy
+	// applies. If empty, the policy applies to all repositories.
+	Repository string `yaml:"repository,omitempty"`
+
+	// Keys is the path of a file of trusted public keys, either a JSON web
+	// key set or PEM encoded keys.
+	Keys string `yaml:"keys,omitempty"`
+
+	// Roots is the path of a file of PEM encoded certificate authorities
+	// trusted to issue the certificate chains of signing keys.
+	Roots string `yaml:"roots,omitempty"`
+
+	// Pull also refuses to serve manifests without a trusted signer.
+	Pull bool `yaml:"pull,omitempty"`
+}
+
+// Validate ensures that the repository expression of the policy is valid and
+// that trusted keys or roots are provided.
+func (policy TrustPolicy) Validate() error {
+	if policy.Keys == "" && policy.Roots == "" {
+		return fmt.Errorf("trust policy must provide keys or roots")
+	}
+
+	if _, err := regexp.Compile(policy.Repository); err != nil {
+		return fmt.Errorf("invalid repository expression %q: %v", policy.Repository, err)
+	}
+
+	return nil
+}
+
+// Validation defines the validation of manifests pushed to the registry,
+// beyond the checks always made by the registry.
+type Validation struct {
+	// Manifests lists the names of custom manifest validators to run on
+	// image manifests. Validators must be compiled into the registry and
+	// registered under their name.
+	Manifests []string `yaml:"manifests,omitempty"`
+}
+
+// Admission defines an HTTP endpoint asked to admit each manifest push before
+// it is stored. If no URL is set, all pushes are admitted.
+type Admission struct {
+	// URL is the endpoint to which the manifest and repository are posted.
+	URL string `yaml:"url,omitempty"`
+
+	// Timeout limits the time taken by the endpoint to decide, such as
+	// "5s". If empty, a default timeout is used.
+	Timeout string `yaml:"timeout,omitempty"`
+
+	// FailOpen admits pushes if the endpoint cannot be reached or fails to
+	// decide. Otherwise, such pushes are rejected.
+	FailOpen bool `yaml:"failopen,omitempty"`
+}
+
+// Validate ensures that the URL and timeout of the admission endpoint are
+// valid.
+func (admission Admission) Validate() error {
+	if admission.URL == "" {
+		if admission.Timeout != "" || admission.FailOpen {
+			return fmt.Errorf("admission endpoint url required")
+		}
+
+		return nil
+	}
+
+	u, err := url.Parse(admission.URL)
+	if err != nil {
+		return fmt.Errorf("invalid admission url %q: %v", admission.URL, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid admission url %q: scheme must be http or https", admission.URL)
+	}
+
+	if admission.Timeout != "" {
+		timeout, err := time.ParseDuration(admission.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid admission timeout %q: %v", admission.Timeout, err)
+		}
+
+		if timeout <= 0 {
+			return fmt.Errorf("admission timeout must be positive: %v", timeout)
+		}
+	}
+
+	return nil
+}
+
 // Reporting defines error reporting methods.
 type Reporting struct {
 	// Bugsnag configures error reporting for Bugsnag (bugsnag.com).
@@ -262,6 +449,22 @@ func Parse(rd io.Reader) (*Configuration, error) {
 					if v0_1.Storage.Type() == "" {
 						return nil, fmt.Errorf("No storage configuration provided")
 					}
+					for _, rule := range v0_1.Tags.Immutable {
+						if err := rule.Validate(); err != nil {
+							return nil, fmt.Errorf("Invalid immutable tag rule: %v", err)
+						}
+					}
+					if v0_1.Trust.SignUnsigned && v0_1.Trust.SigningKey == "" {
+						return nil, fmt.Errorf("A signing key is required to sign unsigned manifests")
+					}
+					for _, policy := range v0_1.Trust.Policies {
+						if err := policy.Validate(); err != nil {
+							return nil, fmt.Errorf("Invalid trust policy: %v", err)
+						}
+					}
+					if err := v0_1.Admission.Validate(); err != nil {
+						return nil, err
+					}
 					return (*Configuration)(v0_1), nil
 				}
 				return nil, fmt.Errorf("Expected *v0_1Configuration, received %#v", c)
@@ -118,6 +118,172 @@ func (suite *ConfigSuite) TestParseInmemory(c *C) {
 	c.Assert(config, DeepEquals, suite.expectedConfig)
 }
 
+// TestParseStorageSections validates that storage sections, such as uploads,
+// are not mistaken for the storage driver type.
+func (suite *ConfigSuite) TestParseStorageSections(c *C) {
+	configYaml := `
+version: 0.1
+loglevel: info
+storage:
+  inmemory: {}
+  uploads:
+    store: driver
+auth:
+  silly:
+    realm: silly
+`
+	expectedConfig := &Configuration{
+		Version:  "0.1",
+		Loglevel: "info",
+		Storage: Storage{
+			"inmemory": Parameters{},
+			"uploads":  Parameters{"store": "driver"},
+		},
+		Auth: Auth{
+			"silly": Parameters{"realm": "silly"},
+		},
+	}
+
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, expectedConfig)
+	c.Assert(config.Storage.Type(), Equals, "inmemory")
+
+	configBytes, err := yaml.Marshal(config)
+	c.Assert(err, IsNil)
+	config, err = Parse(bytes.NewReader(configBytes))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, expectedConfig)
+}
+
+// TestParseImmutableTags validates that immutable tag rules are parsed and
+// that invalid expressions are rejected.
+func (suite *ConfigSuite) TestParseImmutableTags(c *C) {
+	configYaml := `
+version: 0.1
+storage: inmemory
+tags:
+  immutable:
+    - repository: library/.*
+      tag: v[0-9]+\.[0-9]+\.[0-9]+
+    - tag: release-.*
+`
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	c.Assert(err, IsNil)
+	c.Assert(config.Tags, DeepEquals, Tags{
+		Immutable: []TagRule{
+			{Repository: "library/.*", Tag: `v[0-9]+\.[0-9]+\.[0-9]+`},
+			{Tag: "release-.*"},
+		},
+	})
+
+	for _, rule := range []string{
+		"{repository: library/.*}",
+		"{tag: \"v[0-9\"}",
+		"{repository: \"(library\", tag: latest}",
+	} {
+		_, err := Parse(bytes.NewReader([]byte("version: 0.1\nstorage: inmemory\ntags:\n  immutable: [" + rule + "]\n")))
+		c.Assert(err, NotNil)
+	}
+}
+
+// TestParseTrustPolicies validates that trust policies are parsed and that
+// policies without trusted signers are rejected.
+func (suite *ConfigSuite) TestParseTrustPolicies(c *C) {
+	configYaml := `
+version: 0.1
+storage: inmemory
+trust:
+  policies:
+    - repository: library/.*
+      keys: /etc/registry/library.jwk
+      pull: true
+    - roots: /etc/registry/roots.pem
+`
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	c.Assert(err, IsNil)
+	c.Assert(config.Trust, DeepEquals, Trust{
+		Policies: []TrustPolicy{
+			{Repository: "library/.*", Keys: "/etc/registry/library.jwk", Pull: true},
+			{Roots: "/etc/registry/roots.pem"},
+		},
+	})
+
+	for _, policy := range []string{
+		"{repository: library/.*}",
+		"{repository: \"(library\", keys: /etc/registry/library.jwk}",
+	} {
+		_, err := Parse(bytes.NewReader([]byte("version: 0.1\nstorage: inmemory\ntrust:\n  policies: [" + policy + "]\n")))
+		c.Assert(err, NotNil)
+	}
+}
+
+// TestParseSigningKey validates that the registry signing key is parsed and
+// that unsigned manifests may only be accepted with a signing key.
+func (suite *ConfigSuite) TestParseSigningKey(c *C) {
+	configYaml := `
+version: 0.1
+storage: inmemory
+trust:
+  signingkey: /etc/registry/key.json
+  signunsigned: true
+`
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	c.Assert(err, IsNil)
+	c.Assert(config.Trust, DeepEquals, Trust{
+		SigningKey:   "/etc/registry/key.json",
+		SignUnsigned: true,
+	})
+
+	_, err = Parse(bytes.NewReader([]byte("version: 0.1\nstorage: inmemory\ntrust:\n  signunsigned: true\n")))
+	c.Assert(err, NotNil)
+}
+
+// TestParseValidation validates that custom manifest validators are parsed.
+func (suite *ConfigSuite) TestParseValidation(c *C) {
+	configYaml := `
+version: 0.1
+storage: inmemory
+validation:
+  manifests: [labels, licenses]
+`
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	c.Assert(err, IsNil)
+	c.Assert(config.Validation, DeepEquals, Validation{
+		Manifests: []string{"labels", "licenses"},
+	})
+}
+
+// TestParseAdmission validates that the admission endpoint is parsed and
+// that invalid urls and timeouts are rejected.
+func (suite *ConfigSuite) TestParseAdmission(c *C) {
+	configYaml := `
+version: 0.1
+storage: inmemory
+admission:
+  url: https://policy.example.com/admit
+  timeout: 5s
+  failopen: true
+`
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	c.Assert(err, IsNil)
+	c.Assert(config.Admission, DeepEquals, Admission{
+		URL:      "https://policy.example.com/admit",
+		Timeout:  "5s",
+		FailOpen: true,
+	})
+
+	for _, admission := range []string{
+		"{timeout: 5s}",
+		"{url: \"ftp://policy.example.com\"}",
+		"{url: \"https://policy.example.com\", timeout: soon}",
+		"{url: \"https://policy.example.com\", timeout: -1s}",
+	} {
+		_, err := Parse(bytes.NewReader([]byte("version: 0.1\nstorage: inmemory\nadmission: " + admission + "\n")))
+		c.Assert(err, NotNil)
+	}
+}
+
 // TestParseIncomplete validates that an incomplete yaml configuration cannot
 // be parsed without providing environment variables to fill in the missing
 // components.
@@ -25,6 +25,11 @@ type Context struct {
 	// assignment.
 	vars map[string]string
 
+	// identity is the client making the request, as verified by the access
+	// controller. It is empty if the client is not known, such as when
+	// access control is disabled.
+	identity string
+
 	// log provides a context specific logger.
 	log *logrus.Entry
 
@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"hash"
 	"io"
 	"io/ioutil"
@@ -32,7 +33,7 @@ type Verifier interface {
 func NewDigestVerifier(d Digest) Verifier {
 	alg := d.Algorithm()
 	switch alg {
-	case "md5", "sha1", "sha256":
+	case "md5", "sha1", "sha256", "sha384", "sha512":
 		return hashVerifier{
 			hash:   newHash(alg),
 			digest: d,
@@ -54,19 +55,23 @@ func NewDigestVerifier(d Digest) Verifier {
 			panic(err)
 		}
 
+		tv := &tarsumVerifier{
+			digest: d,
+			ts:     ts,
+			pr:     pr,
+			pw:     pw,
+			done:   make(chan struct{}),
+		}
+
 		// TODO(sday): Ick! A goroutine per digest verification? We'll have to
 		// get the tarsum library to export an io.Writer variant.
 		go func() {
 			io.Copy(ioutil.Discard, ts)
 			pw.Close()
+			close(tv.done)
 		}()
 
-		return &tarsumVerifier{
-			digest: d,
-			ts:     ts,
-			pr:     pr,
-			pw:     pw,
-		}
+		return tv
 	}
 }
 
@@ -95,6 +100,10 @@ func (lv *lengthVerifier) Verified() bool {
 
 func newHash(name string) hash.Hash {
 	switch name {
+	case "sha512":
+		return sha512.New()
+	case "sha384":
+		return sha512.New384()
 	case "sha256":
 		return sha256.New()
 	case "sha1":
@@ -124,12 +133,18 @@ type tarsumVerifier struct {
 	ts     tarsum.TarSum
 	pr     *io.PipeReader
 	pw     *io.PipeWriter
+	done   chan struct{} // closed once the tarsum has consumed its input
 }
 
 func (tv *tarsumVerifier) Write(p []byte) (n int, err error) {
 	return tv.pw.Write(p)
 }
 
+// Verified completes the tarsum calculation, so no more data may be written
+// after calling it.
 func (tv *tarsumVerifier) Verified() bool {
+	tv.pw.Close()
+	<-tv.done
+
 	return tv.digest == Digest(tv.ts.Sum(nil))
 }
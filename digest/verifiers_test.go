@@ -59,6 +59,37 @@ func TestDigestVerifier(t *testing.T) {
 	}
 }
 
+// TestHashDigestVerifiers ensures that each supported hash algorithm can be
+// verified.
+func TestHashDigestVerifiers(t *testing.T) {
+	p := make([]byte, 1<<20)
+	rand.Read(p)
+
+	for _, alg := range []string{"md5", "sha1", "sha256", "sha384", "sha512"} {
+		h := newHash(alg)
+		h.Write(p)
+
+		digest, err := ParseDigest(NewDigest(alg, h).String())
+		if err != nil {
+			t.Fatalf("unexpected error parsing %s digest: %v", alg, err)
+		}
+
+		verifier := NewDigestVerifier(digest)
+		io.Copy(verifier, bytes.NewReader(p))
+
+		if !verifier.Verified() {
+			t.Fatalf("%s: bytes not verified", alg)
+		}
+
+		verifier = NewDigestVerifier(digest)
+		io.Copy(verifier, bytes.NewReader(p[1:]))
+
+		if verifier.Verified() {
+			t.Fatalf("%s: unexpected verification of modified bytes", alg)
+		}
+	}
+}
+
 // TODO(stevvooe): Add benchmarks to measure bytes/second throughput for
 // DigestVerifier. We should be tarsum/gzip limited for common cases but we
 // want to verify this.
@@ -2,8 +2,11 @@ package registry
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 // serveJSON marshals v and sets the content-type header to
@@ -30,3 +33,38 @@ func closeResources(handler http.Handler, closers ...io.Closer) http.Handler {
 		handler.ServeHTTP(w, r)
 	})
 }
+
+// paginationParameters parses the "n" and "last" query parameters used by
+// paginated list endpoints. If "n" is not present, zero is returned,
+// indicating that all entries should be returned.
+func paginationParameters(r *http.Request) (n int, last string, err error) {
+	q := r.URL.Query()
+	last = q.Get("last")
+
+	if nstr := q.Get("n"); nstr != "" {
+		n, err = strconv.Atoi(nstr)
+		if err != nil || n <= 0 {
+			return 0, "", fmt.Errorf("n must be a positive integer: %q", nstr)
+		}
+	}
+
+	return n, last, nil
+}
+
+// accepts returns true if the Accept header of the request lists the media
+// type. Parameters, such as quality values, are ignored.
+func accepts(r *http.Request, mediaType string) bool {
+	for _, accept := range r.Header["Accept"] {
+		for _, mt := range strings.Split(accept, ",") {
+			if i := strings.Index(mt, ";"); i >= 0 {
+				mt = mt[:i]
+			}
+
+			if strings.TrimSpace(mt) == mediaType {
+				return true
+			}
+		}
+	}
+
+	return false
+}
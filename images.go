@@ -16,10 +16,16 @@ import (
 func imageManifestDispatcher(ctx *Context, r *http.Request) http.Handler {
 	imageManifestHandler := &imageManifestHandler{
 		Context: ctx,
-		Tag:     ctx.vars["tag"],
 	}
 
-	imageManifestHandler.log = imageManifestHandler.log.WithField("tag", imageManifestHandler.Tag)
+	reference := ctx.vars["reference"]
+	if dgst, err := digest.ParseDigest(reference); err == nil {
+		imageManifestHandler.Digest = dgst
+		imageManifestHandler.log = imageManifestHandler.log.WithField("digest", dgst)
+	} else {
+		imageManifestHandler.Tag = reference
+		imageManifestHandler.log = imageManifestHandler.log.WithField("tag", reference)
+	}
 
 	return handlers.MethodHandler{
 		"GET":    http.HandlerFunc(imageManifestHandler.GetImageManifest),
@@ -32,13 +38,30 @@ func imageManifestDispatcher(ctx *Context, r *http.Request) http.Handler {
 type imageManifestHandler struct {
 	*Context
 
-	Tag string
+	// One of Tag or Digest is set, depending on the reference in the
+	// request.
+	Tag    string
+	Digest digest.Digest
 }
 
-// GetImageManifest fetches the image manifest from the storage backend, if it exists.
+// defaultPlatform selects the image manifest served from a manifest list to
+// clients that do not accept manifest lists.
+var defaultPlatform = storage.Platform{Architecture: "amd64", OS: "linux"}
+
+// GetImageManifest fetches the image manifest from the storage backend, if it
+// exists. Manifest lists fetched by tag are only served to clients including
+// their media type in the Accept header. A manifest list fetched by digest is
+// always served as is, since no other content matches the digest.
 func (imh *imageManifestHandler) GetImageManifest(w http.ResponseWriter, r *http.Request) {
 	manifests := imh.services.Manifests()
-	manifest, err := manifests.Get(imh.Name, imh.Tag)
+
+	var manifest *storage.SignedManifest
+	var err error
+	if imh.Digest != "" {
+		manifest, err = manifests.GetByDigest(imh.Name, imh.Digest)
+	} else {
+		manifest, err = manifests.Get(imh.Name, imh.Tag)
+	}
 
 	if err != nil {
 		imh.Errors.Push(v2.ErrorCodeManifestUnknown, err)
@@ -46,14 +69,69 @@ func (imh *imageManifestHandler) GetImageManifest(w http.ResponseWriter, r *http
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	contentType := "application/json"
+	if manifest.List != nil {
+		if imh.Digest == "" {
+			w.Header().Set("Vary", "Accept")
+		}
+
+		if imh.Digest != "" || accepts(r, storage.MediaTypeManifestList) {
+			contentType = storage.MediaTypeManifestList
+		} else {
+			// Clients unaware of manifest lists are served the image
+			// manifest for the default platform.
+			descriptor, ok := manifest.List.Match(defaultPlatform)
+			if !ok {
+				imh.Errors.Push(v2.ErrorCodeManifestUnknown, fmt.Sprintf("manifest list has no manifest for %s", defaultPlatform))
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			manifest, err = manifests.GetByDigest(imh.Name, descriptor.Digest)
+			if err != nil {
+				imh.Errors.Push(v2.ErrorCodeManifestUnknown, err)
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		}
+	}
+
+	if err := manifests.CheckTrust(imh.Name, manifest); err != nil {
+		switch err := err.(type) {
+		case storage.ErrManifestUntrusted:
+			imh.Errors.Push(v2.ErrorCodeManifestUnverified, err)
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			imh.Errors.PushErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	dgst, err := manifest.Digest()
+	if err != nil {
+		imh.Errors.PushErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Content-Length", fmt.Sprint(len(manifest.Raw)))
 	w.Write(manifest.Raw)
 }
 
 // PutImageManifest validates and stores and image in the registry.
 func (imh *imageManifestHandler) PutImageManifest(w http.ResponseWriter, r *http.Request) {
-	manifests := imh.services.Manifests()
+	if imh.Tag == "" {
+		// Manifests can only be pushed by tag. The digest is assigned by
+		// the registry.
+		imh.Errors.Push(v2.ErrorCodeTagInvalid, "manifests must be put by tag")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	manifests := imh.services.ManifestsAs(imh.identity)
 	dec := json.NewDecoder(r.Body)
 
 	var manifest storage.SignedManifest
@@ -63,27 +141,39 @@ func (imh *imageManifestHandler) PutImageManifest(w http.ResponseWriter, r *http
 		return
 	}
 
+	// The admission endpoint decides before anything is written, so that
+	// denied pushes leave no trace in the repository.
+	if admit := imh.admission.admitFunc(imh.identity); admit != nil {
+		if err := admit(imh.Name, imh.Tag, &manifest); err != nil {
+			switch err := err.(type) {
+			case errAdmissionDenied:
+				imh.Errors.Push(v2.ErrorCodeManifestDenied, err)
+				w.WriteHeader(http.StatusForbidden)
+			case errAdmissionUnavailable:
+				imh.Errors.Push(v2.ErrorCodeAdmissionUnavailable, err)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			default:
+				imh.Errors.PushErr(err)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
 	if err := manifests.Put(imh.Name, imh.Tag, &manifest); err != nil {
 		// TODO(stevvooe): These error handling switches really need to be
 		// handled by an app global mapper.
 		switch err := err.(type) {
 		case storage.ErrManifestVerification:
-			for _, verificationError := range err {
-				switch verificationError := verificationError.(type) {
-				case storage.ErrUnknownLayer:
-					imh.Errors.Push(v2.ErrorCodeBlobUnknown, verificationError.FSLayer)
-				case storage.ErrManifestUnverified:
-					imh.Errors.Push(v2.ErrorCodeManifestUnverified)
-				default:
-					if verificationError == digest.ErrDigestInvalidFormat {
-						// TODO(stevvooe): We need to really need to move all
-						// errors to types. Its much more straightforward.
-						imh.Errors.Push(v2.ErrorCodeDigestInvalid)
-					} else {
-						imh.Errors.PushErr(verificationError)
-					}
-				}
-			}
+			pushVerificationErrors(&imh.Errors, err)
+		case storage.ErrQuotaExceeded:
+			imh.Errors.Push(v2.ErrorCodeQuotaExceeded, err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		case storage.ErrTagImmutable:
+			imh.Errors.Push(v2.ErrorCodeTagImmutable, err)
+			w.WriteHeader(http.StatusConflict)
+			return
 		default:
 			imh.Errors.PushErr(err)
 		}
@@ -91,16 +181,68 @@ func (imh *imageManifestHandler) PutImageManifest(w http.ResponseWriter, r *http
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+
+	dgst, err := manifest.Digest()
+	if err != nil {
+		imh.Errors.PushErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+}
+
+// pushVerificationErrors adds an error for each failure of a manifest
+// verification to errs.
+func pushVerificationErrors(errs *v2.Errors, err storage.ErrManifestVerification) {
+	for _, verificationError := range err {
+		switch verificationError := verificationError.(type) {
+		case storage.ErrUnknownLayer:
+			errs.Push(v2.ErrorCodeBlobUnknown, verificationError.FSLayer)
+		case storage.ErrManifestUnverified:
+			errs.Push(v2.ErrorCodeManifestUnverified)
+		case storage.ErrManifestUntrusted:
+			errs.Push(v2.ErrorCodeManifestUnverified, verificationError)
+		case storage.ErrUnknownManifestRevision:
+			errs.Push(v2.ErrorCodeManifestUnknown, verificationError)
+		case storage.ErrManifestHistoryInvalid:
+			errs.Push(v2.ErrorCodeManifestHistoryInvalid, verificationError)
+		case storage.ErrManifestHistoryLength:
+			errs.Push(v2.ErrorCodeManifestLayersMismatch, verificationError)
+		case storage.ErrManifestParentInvalid:
+			errs.Push(v2.ErrorCodeManifestParentInvalid, verificationError)
+		case storage.ErrManifestInvalid:
+			errs.Push(v2.ErrorCodeManifestInvalid, verificationError)
+		default:
+			if verificationError == digest.ErrDigestInvalidFormat {
+				// TODO(stevvooe): We need to really need to move all
+				// errors to types. Its much more straightforward.
+				errs.Push(v2.ErrorCodeDigestInvalid)
+			} else {
+				errs.PushErr(verificationError)
+			}
+		}
+	}
 }
 
-// DeleteImageManifest removes the image with the given tag from the registry.
+// DeleteImageManifest removes the given tag from the registry. The manifest
+// revision remains available by digest until garbage collected.
 func (imh *imageManifestHandler) DeleteImageManifest(w http.ResponseWriter, r *http.Request) {
+	if imh.Tag == "" {
+		imh.Errors.Push(v2.ErrorCodeTagInvalid, "manifests can only be deleted by tag")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	manifests := imh.services.Manifests()
 	if err := manifests.Delete(imh.Name, imh.Tag); err != nil {
 		switch err := err.(type) {
 		case storage.ErrUnknownManifest:
 			imh.Errors.Push(v2.ErrorCodeManifestUnknown, err)
 			w.WriteHeader(http.StatusNotFound)
+		case storage.ErrTagImmutable:
+			imh.Errors.Push(v2.ErrorCodeTagImmutable, err)
+			w.WriteHeader(http.StatusConflict)
 		default:
 			imh.Errors.Push(v2.ErrorCodeUnknown, err)
 			w.WriteHeader(http.StatusBadRequest)
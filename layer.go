@@ -27,8 +27,9 @@ func layerDispatcher(ctx *Context, r *http.Request) http.Handler {
 	layerHandler.log = layerHandler.log.WithField("digest", dgst)
 
 	return handlers.MethodHandler{
-		"GET":  http.HandlerFunc(layerHandler.GetLayer),
-		"HEAD": http.HandlerFunc(layerHandler.GetLayer),
+		"GET":    http.HandlerFunc(layerHandler.GetLayer),
+		"HEAD":   http.HandlerFunc(layerHandler.GetLayer),
+		"DELETE": http.HandlerFunc(layerHandler.DeleteLayer),
 	}
 }
 
@@ -60,3 +61,23 @@ func (lh *layerHandler) GetLayer(w http.ResponseWriter, r *http.Request) {
 
 	http.ServeContent(w, r, layer.Digest().String(), layer.CreatedAt(), layer)
 }
+
+// DeleteLayer removes the layer from the repository. The underlying blob is
+// left in place, since other repositories may reference it.
+func (lh *layerHandler) DeleteLayer(w http.ResponseWriter, r *http.Request) {
+	layers := lh.services.Layers()
+
+	if err := layers.Delete(lh.Name, lh.Digest); err != nil {
+		switch err := err.(type) {
+		case storage.ErrUnknownLayer:
+			w.WriteHeader(http.StatusNotFound)
+			lh.Errors.Push(v2.ErrorCodeBlobUnknown, err.FSLayer)
+		default:
+			lh.Errors.Push(v2.ErrorCodeUnknown, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusAccepted)
+}
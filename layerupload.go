@@ -8,6 +8,7 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker-registry/api/v2"
+	"github.com/docker/docker-registry/common"
 	"github.com/docker/docker-registry/digest"
 	"github.com/docker/docker-registry/storage"
 	"github.com/gorilla/handlers"
@@ -64,6 +65,15 @@ type layerUploadHandler struct {
 // side upload session.
 func (luh *layerUploadHandler) StartLayerUpload(w http.ResponseWriter, r *http.Request) {
 	layers := luh.services.Layers()
+
+	if mount := r.FormValue("mount"); mount != "" {
+		if luh.mountLayer(w, r, mount, r.FormValue("from")) {
+			return
+		}
+
+		// The layer could not be mounted, so proceed with a regular upload.
+	}
+
 	upload, err := layers.Upload(luh.Name)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError) // Error conditions here?
@@ -82,6 +92,56 @@ func (luh *layerUploadHandler) StartLayerUpload(w http.ResponseWriter, r *http.R
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// mountLayer attempts to mount the layer identified by mount from the
+// repository from into the repository of the request. If the layer was
+// mounted or an error response was written, true is returned. If the layer
+// is not available in the source repository, false is returned and the
+// caller should fall back to a regular upload.
+func (luh *layerUploadHandler) mountLayer(w http.ResponseWriter, r *http.Request, mount, from string) bool {
+	dgst, err := digest.ParseDigest(mount)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		luh.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+		return true
+	}
+
+	if err := common.ValidateRespositoryName(from); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		luh.Errors.Push(v2.ErrorCodeNameInvalid, map[string]string{"from": from})
+		return true
+	}
+
+	layers := luh.services.Layers()
+	layer, err := layers.Mount(luh.Name, dgst, from)
+	if err != nil {
+		switch err.(type) {
+		case storage.ErrUnknownLayer:
+			return false
+		case storage.ErrQuotaExceeded:
+			w.WriteHeader(http.StatusForbidden)
+			luh.Errors.Push(v2.ErrorCodeQuotaExceeded, err)
+			return true
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			luh.Errors.Push(v2.ErrorCodeUnknown, err)
+			return true
+		}
+	}
+	defer layer.Close()
+
+	layerURL, err := luh.urlBuilder.BuildBlobURL(layer.Name(), layer.Digest())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		luh.Errors.Push(v2.ErrorCodeUnknown, err)
+		return true
+	}
+
+	w.Header().Set("Location", layerURL)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+	return true
+}
+
 // GetUploadStatus returns the status of a given upload, identified by uuid.
 func (luh *layerUploadHandler) GetUploadStatus(w http.ResponseWriter, r *http.Request) {
 	if luh.Upload == nil {
@@ -211,8 +271,26 @@ func (luh *layerUploadHandler) maybeCompleteUpload(w http.ResponseWriter, r *htt
 func (luh *layerUploadHandler) completeUpload(w http.ResponseWriter, r *http.Request, size int64, dgst digest.Digest) {
 	layer, err := luh.Upload.Finish(size, dgst)
 	if err != nil {
-		luh.Errors.Push(v2.ErrorCodeUnknown, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		switch err := err.(type) {
+		case storage.ErrLayerInvalidDigest:
+			luh.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+			w.WriteHeader(http.StatusBadRequest)
+		case storage.ErrLayerInvalidSize:
+			luh.Errors.Push(v2.ErrorCodeSizeInvalid, err)
+			w.WriteHeader(http.StatusBadRequest)
+		case storage.ErrQuotaExceeded:
+			luh.Errors.Push(v2.ErrorCodeQuotaExceeded, err)
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			switch err {
+			case storage.ErrLayerDigestUnsupported, storage.ErrLayerTarSumVersionUnsupported:
+				luh.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+				w.WriteHeader(http.StatusBadRequest)
+			default:
+				luh.Errors.Push(v2.ErrorCodeUnknown, err)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}
 		return
 	}
 
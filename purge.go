@@ -0,0 +1,161 @@
+package registry
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker-registry/configuration"
+)
+
+// uploadPurgeConfig configures the periodic removal of abandoned uploads.
+type uploadPurgeConfig struct {
+	// enabled starts the purger with the app.
+	enabled bool
+
+	// age is the time after which an upload is considered abandoned.
+	age time.Duration
+
+	// interval is the time between runs of the purger.
+	interval time.Duration
+
+	// dryRun only logs the uploads that would be purged.
+	dryRun bool
+}
+
+// defaultUploadPurgeConfig is used for any settings not present in the
+// configuration. Purging removes data, so it must be enabled explicitly.
+var defaultUploadPurgeConfig = uploadPurgeConfig{
+	enabled:  false,
+	age:      168 * time.Hour,
+	interval: 24 * time.Hour,
+}
+
+// parseUploadPurgeConfig reads the upload purging settings from the
+// maintenance section of the storage configuration.
+func parseUploadPurgeConfig(config configuration.Configuration) (uploadPurgeConfig, error) {
+	upc := defaultUploadPurgeConfig
+
+	section, ok := config.Storage["maintenance"]["uploadpurging"]
+	if !ok || section == nil {
+		return upc, nil
+	}
+
+	params, ok := section.(map[interface{}]interface{})
+	if !ok {
+		return upc, fmt.Errorf("uploadpurging must be a map: %#v", section)
+	}
+
+	for k, v := range params {
+		var err error
+
+		switch k {
+		case "enabled":
+			upc.enabled, err = parseBool(v)
+		case "dryrun":
+			upc.dryRun, err = parseBool(v)
+		case "age":
+			upc.age, err = parseDuration(v)
+		case "interval":
+			upc.interval, err = parseDuration(v)
+		default:
+			err = fmt.Errorf("unknown parameter")
+		}
+
+		if err != nil {
+			return upc, fmt.Errorf("uploadpurging %v: %v", k, err)
+		}
+	}
+
+	if upc.interval <= 0 {
+		return upc, fmt.Errorf("uploadpurging interval must be positive: %v", upc.interval)
+	}
+
+	return upc, nil
+}
+
+// purgeUploads runs the upload purger at the configured interval, starting
+// immediately. It does not return.
+func (app *App) purgeUploads(upc uploadPurgeConfig) {
+	for {
+		app.purgeUploadsOnce(upc)
+		time.Sleep(upc.interval)
+	}
+}
+
+// purgeUploadsOnce removes the uploads that are older than the configured
+// age, logging each purged upload. Nothing is purged while the registry is
+// read-only.
+func (app *App) purgeUploadsOnce(upc uploadPurgeConfig) {
+	if app.isReadOnly() {
+		log.Infof("upload purge: skipped, registry is read-only")
+		return
+	}
+
+	olderThan := time.Now().Add(-upc.age)
+
+	purged, err := app.services.PurgeUploads(olderThan, upc.dryRun)
+	if err != nil {
+		log.Errorf("upload purge failed: %v", err)
+		return
+	}
+
+	for _, lus := range purged {
+		entry := log.WithFields(log.Fields{
+			"name":      lus.Name,
+			"uuid":      lus.UUID,
+			"offset":    lus.Offset,
+			"startedat": lus.StartedAt,
+		})
+
+		if upc.dryRun {
+			entry.Info("upload purge (dry run): would purge upload")
+		} else {
+			entry.Info("upload purge: purged upload")
+		}
+	}
+
+	log.Infof("upload purge: %d uploads started before %v purged (dry run: %v)", len(purged), olderThan, upc.dryRun)
+}
+
+// parseBool reads a boolean configuration value, which may be provided as a
+// string.
+func parseBool(v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case string:
+		switch v {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+
+	return false, fmt.Errorf("invalid boolean: %#v", v)
+}
+
+// parseInt reads an integer configuration value, which may be provided as a
+// string.
+func parseInt(v interface{}) (int, error) {
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case string:
+		return strconv.Atoi(v)
+	}
+
+	return 0, fmt.Errorf("invalid integer: %#v", v)
+}
+
+// parseDuration reads a duration configuration value, such as "24h".
+func parseDuration(v interface{}) (time.Duration, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid duration: %#v", v)
+	}
+
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker-registry/configuration"
+)
+
+func TestParseUploadPurgeConfig(t *testing.T) {
+	for _, testcase := range []struct {
+		yaml     string
+		expected uploadPurgeConfig
+		err      bool
+	}{
+		{
+			yaml:     "",
+			expected: defaultUploadPurgeConfig,
+		},
+		{
+			yaml: `
+  maintenance:
+    uploadpurging:
+      enabled: true
+`,
+			expected: uploadPurgeConfig{
+				enabled:  true,
+				age:      defaultUploadPurgeConfig.age,
+				interval: defaultUploadPurgeConfig.interval,
+			},
+		},
+		{
+			yaml: `
+  maintenance:
+    uploadpurging:
+      enabled: true
+      age: 12h
+      interval: 30m
+      dryrun: true
+`,
+			expected: uploadPurgeConfig{
+				enabled:  true,
+				age:      12 * time.Hour,
+				interval: 30 * time.Minute,
+				dryRun:   true,
+			},
+		},
+		{
+			yaml: `
+  maintenance:
+    uploadpurging:
+      age: forever
+`,
+			err: true,
+		},
+		{
+			yaml: `
+  maintenance:
+    uploadpurging:
+      interval: 0s
+`,
+			err: true,
+		},
+	} {
+		config, err := configuration.Parse(strings.NewReader("version: 0.1\nstorage:\n  inmemory: {}\n" + testcase.yaml))
+		if err != nil {
+			t.Fatalf("unexpected error parsing configuration: %v", err)
+		}
+
+		upc, err := parseUploadPurgeConfig(*config)
+		if testcase.err {
+			if err == nil {
+				t.Fatalf("expected error parsing %q", testcase.yaml)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("unexpected error parsing upload purge config: %v", err)
+		}
+
+		if upc != testcase.expected {
+			t.Fatalf("unexpected upload purge config: %#v != %#v", upc, testcase.expected)
+		}
+	}
+}
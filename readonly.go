@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker-registry/api/v2"
+	"github.com/docker/docker-registry/configuration"
+	"github.com/gorilla/handlers"
+)
+
+// parseReadOnlyConfig reads whether the registry starts in read-only mode
+// from the maintenance section of the storage configuration. The setting may
+// be a boolean or, like the other maintenance sections, a map with an
+// enabled parameter.
+func parseReadOnlyConfig(config configuration.Configuration) (bool, error) {
+	section, ok := config.Storage["maintenance"]["readonly"]
+	if !ok || section == nil {
+		return false, nil
+	}
+
+	params, ok := section.(map[interface{}]interface{})
+	if !ok {
+		readOnly, err := parseBool(section)
+		if err != nil {
+			return false, fmt.Errorf("readonly: %v", err)
+		}
+
+		return readOnly, nil
+	}
+
+	var readOnly bool
+	for k, v := range params {
+		var err error
+
+		switch k {
+		case "enabled":
+			readOnly, err = parseBool(v)
+		default:
+			err = fmt.Errorf("unknown parameter")
+		}
+
+		if err != nil {
+			return false, fmt.Errorf("readonly %v: %v", k, err)
+		}
+	}
+
+	return readOnly, nil
+}
+
+// ReadOnlyConfigured returns true if the configuration starts the registry
+// in read-only mode. Maintenance commands moving content, such as migrate,
+// require it.
+func ReadOnlyConfigured(config configuration.Configuration) (bool, error) {
+	return parseReadOnlyConfig(config)
+}
+
+// isReadOnly returns true if the registry is rejecting writes.
+func (app *App) isReadOnly() bool {
+	return atomic.LoadInt32(&app.readOnly) != 0
+}
+
+// setReadOnly enables or disables read-only mode.
+func (app *App) setReadOnly(readOnly bool) {
+	var v int32
+	if readOnly {
+		v = 1
+	}
+
+	atomic.StoreInt32(&app.readOnly, v)
+}
+
+// rejectedByReadOnly returns true if the request must be rejected because
+// the registry is in read-only mode. Only requests that may modify the
+// registry are rejected. The mode itself can always be changed, so that it
+// can be switched off.
+func (app *App) rejectedByReadOnly(r *http.Request) bool {
+	if !app.isReadOnly() {
+		return false
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		return false
+	}
+
+	return routeName(r) != v2.RouteNameReadOnly
+}
+
+// readOnlyDispatcher constructs the handler for the read-only mode admin
+// endpoint.
+func readOnlyDispatcher(ctx *Context, r *http.Request) http.Handler {
+	readOnlyHandler := &readOnlyHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(readOnlyHandler.GetReadOnly),
+		"PUT": http.HandlerFunc(readOnlyHandler.PutReadOnly),
+	}
+}
+
+// readOnlyHandler handles requests to query and toggle read-only mode.
+type readOnlyHandler struct {
+	*Context
+}
+
+type readOnlyAPIResponse struct {
+	ReadOnly bool `json:"readonly"`
+}
+
+// GetReadOnly returns the current mode of the registry.
+func (roh *readOnlyHandler) GetReadOnly(w http.ResponseWriter, r *http.Request) {
+	if err := serveJSON(w, readOnlyAPIResponse{
+		ReadOnly: roh.isReadOnly(),
+	}); err != nil {
+		roh.Errors.PushErr(err)
+		return
+	}
+}
+
+// PutReadOnly sets the mode of the registry, returning the new mode. The
+// mode only applies to this registry instance and is not persisted.
+func (roh *readOnlyHandler) PutReadOnly(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ReadOnly *bool `json:"readonly"`
+	}
+
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&request); err != nil {
+		roh.Errors.Push(v2.ErrorCodeUnknown, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if request.ReadOnly == nil {
+		roh.Errors.Push(v2.ErrorCodeUnknown, "readonly must be provided")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	roh.setReadOnly(*request.ReadOnly)
+	log.Infof("read-only mode set to %v", *request.ReadOnly)
+
+	if err := serveJSON(w, readOnlyAPIResponse{
+		ReadOnly: *request.ReadOnly,
+	}); err != nil {
+		roh.Errors.PushErr(err)
+		return
+	}
+}
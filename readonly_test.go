@@ -0,0 +1,86 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker-registry/configuration"
+)
+
+func TestParseReadOnlyConfig(t *testing.T) {
+	for _, testcase := range []struct {
+		yaml     string
+		expected bool
+		err      bool
+	}{
+		{
+			yaml: "",
+		},
+		{
+			yaml: `
+  maintenance:
+    readonly: true
+`,
+			expected: true,
+		},
+		{
+			// As provided by the environment.
+			yaml: `
+  maintenance:
+    readonly: "true"
+`,
+			expected: true,
+		},
+		{
+			yaml: `
+  maintenance:
+    readonly:
+      enabled: true
+`,
+			expected: true,
+		},
+		{
+			yaml: `
+  maintenance:
+    readonly:
+      enabled: false
+`,
+		},
+		{
+			yaml: `
+  maintenance:
+    readonly: sometimes
+`,
+			err: true,
+		},
+		{
+			yaml: `
+  maintenance:
+    readonly:
+      reason: migrating
+`,
+			err: true,
+		},
+	} {
+		config, err := configuration.Parse(strings.NewReader("version: 0.1\nstorage:\n  inmemory: {}\n" + testcase.yaml))
+		if err != nil {
+			t.Fatalf("unexpected error parsing configuration: %v", err)
+		}
+
+		readOnly, err := parseReadOnlyConfig(*config)
+		if testcase.err {
+			if err == nil {
+				t.Fatalf("expected error parsing %q", testcase.yaml)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("unexpected error parsing read-only config: %v", err)
+		}
+
+		if readOnly != testcase.expected {
+			t.Fatalf("unexpected read-only mode for %q: %v != %v", testcase.yaml, readOnly, testcase.expected)
+		}
+	}
+}
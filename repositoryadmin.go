@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker-registry/api/v2"
+	"github.com/docker/docker-registry/common"
+	"github.com/docker/docker-registry/storage"
+	"github.com/gorilla/handlers"
+)
+
+// renameDispatcher constructs the handler for the repository rename admin
+// endpoint.
+func renameDispatcher(ctx *Context, r *http.Request) http.Handler {
+	repositoryAdminHandler := &repositoryAdminHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"POST": http.HandlerFunc(repositoryAdminHandler.PostRename),
+	}
+}
+
+// copyDispatcher constructs the handler for the repository copy admin
+// endpoint.
+func copyDispatcher(ctx *Context, r *http.Request) http.Handler {
+	repositoryAdminHandler := &repositoryAdminHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"POST": http.HandlerFunc(repositoryAdminHandler.PostCopy),
+	}
+}
+
+// repositoryAdminHandler handles requests to rename and copy repositories.
+type repositoryAdminHandler struct {
+	*Context
+}
+
+type repositoryMoveRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// PostRename renames the repository given in the request body.
+func (rah *repositoryAdminHandler) PostRename(w http.ResponseWriter, r *http.Request) {
+	rah.move(w, r, "renamed", rah.services.Rename)
+}
+
+// PostCopy copies the repository given in the request body.
+func (rah *repositoryAdminHandler) PostCopy(w http.ResponseWriter, r *http.Request) {
+	rah.move(w, r, "copied", rah.services.Copy)
+}
+
+// move decodes the request and applies the operation to it, writing the
+// response.
+func (rah *repositoryAdminHandler) move(w http.ResponseWriter, r *http.Request, verb string, operation func(from, to string, admit storage.AdmitFunc) error) {
+	var request repositoryMoveRequest
+
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&request); err != nil {
+		rah.Errors.Push(v2.ErrorCodeUnknown, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, name := range []string{request.From, request.To} {
+		if err := common.ValidateRespositoryName(name); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			rah.Errors.Push(v2.ErrorCodeNameInvalid, map[string]string{"name": name})
+			return
+		}
+	}
+
+	// Copied revisions are stored by the registry itself, so are admitted
+	// without an identity.
+	if err := operation(request.From, request.To, rah.admission.admitFunc("")); err != nil {
+		switch err := err.(type) {
+		case storage.ErrUnknownRepository:
+			w.WriteHeader(http.StatusNotFound)
+			rah.Errors.Push(v2.ErrorCodeNameUnknown, map[string]string{"name": err.Name})
+		case storage.ErrRepositoryExists:
+			w.WriteHeader(http.StatusConflict)
+			rah.Errors.Push(v2.ErrorCodeNameExists, map[string]string{"name": err.Name})
+		case storage.ErrManifestResignRequired:
+			w.WriteHeader(http.StatusConflict)
+			rah.Errors.Push(v2.ErrorCodeSigningKeyRequired, err)
+		case storage.ErrManifestVerification:
+			w.WriteHeader(http.StatusConflict)
+			pushVerificationErrors(&rah.Errors, err)
+		case storage.ErrQuotaExceeded:
+			w.WriteHeader(http.StatusForbidden)
+			rah.Errors.Push(v2.ErrorCodeQuotaExceeded, err)
+		case errAdmissionDenied:
+			w.WriteHeader(http.StatusForbidden)
+			rah.Errors.Push(v2.ErrorCodeManifestDenied, err)
+		case errAdmissionUnavailable:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			rah.Errors.Push(v2.ErrorCodeAdmissionUnavailable, err)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			rah.Errors.Push(v2.ErrorCodeUnknown, err)
+		}
+
+		return
+	}
+
+	log.Infof("repository %s %s to %s", request.From, verb, request.To)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusNoContent)
+}
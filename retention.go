@@ -0,0 +1,168 @@
+package registry
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker-registry/configuration"
+	"github.com/docker/docker-registry/storage"
+)
+
+// retentionConfig configures the periodic expiry of tags by retention
+// policies.
+type retentionConfig struct {
+	// enabled starts the retention engine with the app, if any policies are
+	// configured.
+	enabled bool
+
+	// interval is the time between runs of the retention engine.
+	interval time.Duration
+
+	// dryRun only reports the tags that would be deleted.
+	dryRun bool
+
+	// policies select the tags to delete.
+	policies []storage.RetentionPolicy
+}
+
+// defaultRetentionConfig is used for any settings not present in the
+// configuration.
+var defaultRetentionConfig = retentionConfig{
+	enabled:  true,
+	interval: 24 * time.Hour,
+}
+
+// parseRetentionConfig reads the retention settings from the maintenance
+// section of the storage configuration.
+func parseRetentionConfig(config configuration.Configuration) (retentionConfig, error) {
+	rc := defaultRetentionConfig
+
+	section, ok := config.Storage["maintenance"]["retention"]
+	if !ok || section == nil {
+		return rc, nil
+	}
+
+	params, ok := section.(map[interface{}]interface{})
+	if !ok {
+		return rc, fmt.Errorf("retention must be a map: %#v", section)
+	}
+
+	for k, v := range params {
+		var err error
+
+		switch k {
+		case "enabled":
+			rc.enabled, err = parseBool(v)
+		case "dryrun":
+			rc.dryRun, err = parseBool(v)
+		case "interval":
+			rc.interval, err = parseDuration(v)
+		case "policies":
+			rc.policies, err = parseRetentionPolicies(v)
+		default:
+			err = fmt.Errorf("unknown parameter")
+		}
+
+		if err != nil {
+			return rc, fmt.Errorf("retention %v: %v", k, err)
+		}
+	}
+
+	if rc.interval <= 0 {
+		return rc, fmt.Errorf("retention interval must be positive: %v", rc.interval)
+	}
+
+	return rc, nil
+}
+
+// parseRetentionPolicies reads a list of retention policies. The repository
+// and tag expressions of each policy must match the whole name or tag.
+func parseRetentionPolicies(v interface{}) ([]storage.RetentionPolicy, error) {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid policies: %#v", v)
+	}
+
+	var policies []storage.RetentionPolicy
+	for i, item := range items {
+		params, ok := item.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid policy %d: %#v", i, item)
+		}
+
+		var policy storage.RetentionPolicy
+		for k, v := range params {
+			var err error
+
+			switch k {
+			case "repository":
+				policy.Repository, err = compileAnchored(fmt.Sprint(v))
+			case "tag":
+				policy.Tag, err = compileAnchored(fmt.Sprint(v))
+			case "keeplast":
+				policy.KeepLast, err = parseInt(v)
+			case "maxage":
+				policy.MaxAge, err = parseDuration(v)
+			default:
+				err = fmt.Errorf("unknown parameter")
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("policy %d %v: %v", i, k, err)
+			}
+		}
+
+		if policy.KeepLast <= 0 && policy.MaxAge <= 0 {
+			return nil, fmt.Errorf("policy %d must provide a positive keeplast or maxage", i)
+		}
+
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// expireTags runs the retention engine at the configured interval, starting
+// immediately. It does not return.
+func (app *App) expireTags(rc retentionConfig) {
+	for {
+		app.expireTagsOnce(rc)
+		time.Sleep(rc.interval)
+	}
+}
+
+// expireTagsOnce deletes the tags expired by the retention policies, logging
+// each. Nothing is deleted while the registry is read-only.
+func (app *App) expireTagsOnce(rc retentionConfig) {
+	if app.isReadOnly() {
+		log.Infof("retention: skipped, registry is read-only")
+		return
+	}
+
+	expired, err := app.services.ExpireTags(rc.policies, time.Now(), rc.dryRun)
+	if err != nil {
+		log.Errorf("retention failed: %v", err)
+	}
+
+	var deleted int
+	for _, et := range expired {
+		entry := log.WithFields(log.Fields{
+			"name":     et.Name,
+			"tag":      et.Tag,
+			"modified": et.Modified,
+		})
+
+		switch {
+		case rc.dryRun:
+			entry.Info("retention (dry run): would delete tag")
+		case et.Err != nil:
+			entry.Warnf("retention: unable to delete tag: %v", et.Err)
+		default:
+			entry.Info("retention: deleted tag")
+			deleted++
+		}
+	}
+
+	log.Infof("retention: %d tags expired, %d deleted (dry run: %v)", len(expired), deleted, rc.dryRun)
+}
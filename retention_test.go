@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker-registry/configuration"
+	"github.com/docker/docker-registry/storage"
+)
+
+func TestParseRetentionConfig(t *testing.T) {
+	for _, testcase := range []struct {
+		yaml     string
+		expected retentionConfig
+		err      bool
+	}{
+		{
+			yaml:     "",
+			expected: defaultRetentionConfig,
+		},
+		{
+			yaml: `
+  maintenance:
+    retention:
+      interval: 1h
+      dryrun: true
+      policies:
+        - repository: ci/.*
+          tag: commit-.*
+          keeplast: 10
+        - tag: pr-.*
+          keeplast: "1"
+          maxage: 720h
+`,
+			expected: retentionConfig{
+				enabled:  true,
+				interval: time.Hour,
+				dryRun:   true,
+				policies: []storage.RetentionPolicy{
+					{
+						Repository: regexp.MustCompile(`^(?:ci/.*)$`),
+						Tag:        regexp.MustCompile(`^(?:commit-.*)$`),
+						KeepLast:   10,
+					},
+					{
+						Tag:      regexp.MustCompile(`^(?:pr-.*)$`),
+						KeepLast: 1,
+						MaxAge:   720 * time.Hour,
+					},
+				},
+			},
+		},
+		{
+			yaml: `
+  maintenance:
+    retention:
+      policies:
+        - tag: commit-.*
+`,
+			err: true,
+		},
+		{
+			yaml: `
+  maintenance:
+    retention:
+      policies:
+        - tag: "commit-("
+          keeplast: 1
+`,
+			err: true,
+		},
+		{
+			yaml: `
+  maintenance:
+    retention:
+      policies:
+        - keeplast: many
+`,
+			err: true,
+		},
+		{
+			yaml: `
+  maintenance:
+    retention:
+      interval: 0s
+`,
+			err: true,
+		},
+	} {
+		config, err := configuration.Parse(strings.NewReader("version: 0.1\nstorage:\n  inmemory: {}\n" + testcase.yaml))
+		if err != nil {
+			t.Fatalf("unexpected error parsing configuration: %v", err)
+		}
+
+		rc, err := parseRetentionConfig(*config)
+		if testcase.err {
+			if err == nil {
+				t.Fatalf("expected error parsing %q", testcase.yaml)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("unexpected error parsing retention config: %v", err)
+		}
+
+		if !reflect.DeepEqual(rc, testcase.expected) {
+			t.Fatalf("unexpected retention config: %#v != %#v", rc, testcase.expected)
+		}
+	}
+}
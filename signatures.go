@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/docker/docker-registry/api/v2"
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/docker-registry/storage"
+	"github.com/gorilla/handlers"
+)
+
+// signaturesDispatcher constructs the handler for adding signatures to a
+// manifest revision.
+func signaturesDispatcher(ctx *Context, r *http.Request) http.Handler {
+	signaturesHandler := &signaturesHandler{
+		Context: ctx,
+	}
+
+	dgst, err := digest.ParseDigest(ctx.vars["digest"])
+	if err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			signaturesHandler.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+		})
+	}
+
+	signaturesHandler.Digest = dgst
+	signaturesHandler.log = signaturesHandler.log.WithField("digest", dgst)
+
+	return handlers.MethodHandler{
+		"POST": http.HandlerFunc(signaturesHandler.PostSignatures),
+	}
+}
+
+// signaturesHandler handles requests to add signatures to a manifest
+// revision.
+type signaturesHandler struct {
+	*Context
+
+	Digest digest.Digest
+}
+
+// PostSignatures adds the signatures of the manifest in the request body to
+// the manifest revision.
+func (sh *signaturesHandler) PostSignatures(w http.ResponseWriter, r *http.Request) {
+	var manifest storage.SignedManifest
+	if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil {
+		sh.Errors.Push(v2.ErrorCodeManifestInvalid, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	manifests := sh.services.Manifests()
+	if err := manifests.AddSignatures(sh.Name, sh.Digest, &manifest); err != nil {
+		switch err := err.(type) {
+		case storage.ErrUnknownManifestRevision:
+			sh.Errors.Push(v2.ErrorCodeManifestUnknown, err)
+			w.WriteHeader(http.StatusNotFound)
+		case storage.ErrManifestPayloadMismatch:
+			sh.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+			w.WriteHeader(http.StatusBadRequest)
+		case storage.ErrManifestVerification:
+			sh.Errors.Push(v2.ErrorCodeManifestUnverified, err)
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			sh.Errors.PushErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	manifestURL, err := sh.urlBuilder.BuildManifestURL(sh.Name, sh.Digest.String())
+	if err != nil {
+		sh.Errors.PushErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", manifestURL)
+	w.Header().Set("Docker-Content-Digest", sh.Digest.String())
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,50 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/docker/docker-registry/api/v2"
+	"github.com/docker/libtrust"
+	"github.com/gorilla/handlers"
+)
+
+// signingKeyDispatcher constructs the handler serving the public key of the
+// registry.
+func signingKeyDispatcher(ctx *Context, r *http.Request) http.Handler {
+	signingKeyHandler := &signingKeyHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(signingKeyHandler.GetSigningKey),
+	}
+}
+
+// signingKeyHandler serves the public key with which the registry signs
+// manifests.
+type signingKeyHandler struct {
+	*Context
+}
+
+type signingKeyAPIResponse struct {
+	Keys []libtrust.PublicKey `json:"keys"`
+}
+
+// GetSigningKey returns the public key of the registry as a JSON web key set,
+// which may be used directly as the trusted keys of a trust policy.
+func (skh *signingKeyHandler) GetSigningKey(w http.ResponseWriter, r *http.Request) {
+	key := skh.services.SigningKey()
+	if key == nil {
+		skh.Errors.Push(v2.ErrorCodeSigningKeyRequired, fmt.Errorf("registry has no signing key"))
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := serveJSON(w, signingKeyAPIResponse{
+		Keys: []libtrust.PublicKey{key},
+	}); err != nil {
+		skh.Errors.PushErr(err)
+		return
+	}
+}
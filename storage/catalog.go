@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/docker/docker-registry/common"
+	"github.com/docker/docker-registry/storagedriver"
+)
+
+// errCatalogFull stops walking the repositories once enough names have been
+// found. It is never returned by Repositories.
+var errCatalogFull = fmt.Errorf("catalog full")
+
+// Repositories returns the names of the repositories in the registry, in
+// lexical order. Only names sorting after last are returned, allowing a
+// caller to page through the results. If n is greater than zero, at most n
+// names are returned.
+//
+// The repositories are found by walking the storage backend in lexical
+// order, skipping the directories holding only names up to last and stopping
+// once n names are found. Directories that do not form a valid repository
+// name are skipped.
+func (ss *Services) Repositories(last string, n int) ([]string, error) {
+	root, err := ss.pathMapper.path(repositoriesRootPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	err = walkRepositoriesAfter(ss.driver, root, root, last, func(name string) error {
+		if err := common.ValidateRespositoryName(name); err != nil {
+			return nil
+		}
+
+		names = append(names, name)
+
+		if n > 0 && len(names) >= n {
+			return errCatalogFull
+		}
+
+		return nil
+	})
+
+	if err != nil && err != errCatalogFull {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// No repositories have been created, yet.
+		default:
+			return nil, err
+		}
+	}
+
+	return names, nil
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker-registry/storagedriver"
+	"github.com/docker/docker-registry/storagedriver/inmemory"
+)
+
+func TestRepositories(t *testing.T) {
+	driver := inmemory.New()
+	ss := NewServices(driver)
+
+	names, err := ss.Repositories("", 0)
+	if err != nil {
+		t.Fatalf("unexpected error listing empty catalog: %v", err)
+	}
+
+	if len(names) != 0 {
+		t.Fatalf("expected no repositories: %v", names)
+	}
+
+	// Include nested names, a name that prefixes another repository and
+	// names sorting between a name and those nested under it.
+	expected := []string{
+		"foo/bar",
+		"foo/bar-baz",
+		"foo/bar.baz",
+		"foo/bar/baz",
+		"foo/bar0",
+		"foo/qux",
+		"other/deeply/nested/name",
+	}
+
+	for _, name := range []string{"foo/qux", "other/deeply/nested/name", "foo/bar0", "foo/bar", "foo/bar/baz", "foo/bar.baz", "foo/bar-baz"} {
+		if _, _, _, err := writeRandomLayer(driver, ss.pathMapper, name); err != nil {
+			t.Fatalf("unexpected error writing layer to %q: %v", name, err)
+		}
+	}
+
+	names, err = ss.Repositories("", 0)
+	if err != nil {
+		t.Fatalf("unexpected error listing catalog: %v", err)
+	}
+
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected repositories: %v != %v", names, expected)
+	}
+
+	// Page through the catalog, two at a time.
+	var paged []string
+	var last string
+	for {
+		names, err := ss.Repositories(last, 2)
+		if err != nil {
+			t.Fatalf("unexpected error listing catalog: %v", err)
+		}
+
+		if len(names) == 0 {
+			break
+		}
+
+		if len(names) > 2 {
+			t.Fatalf("too many repositories returned: %v", names)
+		}
+
+		paged = append(paged, names...)
+		last = names[len(names)-1]
+	}
+
+	if !reflect.DeepEqual(paged, expected) {
+		t.Fatalf("unexpected paged repositories: %v != %v", paged, expected)
+	}
+}
+
+// TestRepositoriesPaging ensures that paging through the catalog does not
+// list the directories of earlier pages, nor of later ones.
+func TestRepositoriesPaging(t *testing.T) {
+	driver := &listRecordingDriver{StorageDriver: inmemory.New()}
+	ss := NewServices(driver)
+
+	for _, name := range []string{"bar/baz", "foo/bar", "foo/bar/baz", "foo/qux", "qux/foo"} {
+		if _, _, _, err := writeRandomLayer(driver, ss.pathMapper, name); err != nil {
+			t.Fatalf("unexpected error writing layer to %q: %v", name, err)
+		}
+	}
+
+	root, err := ss.pathMapper.path(repositoriesRootPathSpec{})
+	if err != nil {
+		t.Fatalf("unexpected error getting repositories path: %v", err)
+	}
+
+	driver.listed = nil
+	names, err := ss.Repositories("foo/bar/baz", 1)
+	if err != nil {
+		t.Fatalf("unexpected error listing catalog: %v", err)
+	}
+
+	if !reflect.DeepEqual(names, []string{"foo/qux"}) {
+		t.Fatalf("unexpected repositories: %v", names)
+	}
+
+	for _, name := range []string{"bar", "bar/baz", "qux"} {
+		if driver.listed[path.Join(root, name)] {
+			t.Fatalf("unexpected listing of %s: %v", name, driver.listed)
+		}
+	}
+}
+
+// listRecordingDriver records the paths listed through the driver.
+type listRecordingDriver struct {
+	storagedriver.StorageDriver
+	listed map[string]bool
+}
+
+func (d *listRecordingDriver) List(p string) ([]string, error) {
+	if d.listed == nil {
+		d.listed = make(map[string]bool)
+	}
+
+	d.listed[p] = true
+	return d.StorageDriver.List(p)
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/docker/docker-registry/storagedriver"
+)
+
+// driverLayerUploadStore implements a layerUploadStore backed by the storage
+// driver. Since the upload data and state are kept in the backend, an upload
+// started on one registry instance may be resumed on another instance
+// sharing the same storage and uploads survive restarts.
+//
+// The data and state of each upload, identified by uuid, are kept under the
+// uploads directory, as described by uploadDataPathSpec and
+// uploadStatePathSpec.
+type driverLayerUploadStore struct {
+	driver     storagedriver.StorageDriver
+	pathMapper *pathMapper
+}
+
+func newDriverLayerUploadStore(driver storagedriver.StorageDriver, pathMapper *pathMapper) layerUploadStore {
+	return &driverLayerUploadStore{
+		driver:     driver,
+		pathMapper: pathMapper,
+	}
+}
+
+func (dlus *driverLayerUploadStore) New(name string) (LayerUploadState, error) {
+	lus := LayerUploadState{
+		Name:      name,
+		UUID:      uuid.New(),
+		StartedAt: time.Now().UTC(),
+	}
+
+	if err := dlus.SaveState(lus); err != nil {
+		return lus, err
+	}
+
+	return lus, nil
+}
+
+func (dlus *driverLayerUploadStore) Open(uuid string) (layerFile, error) {
+	dataPath, err := dlus.pathMapper.path(uploadDataPathSpec{uuid: uuid})
+	if err != nil {
+		return nil, err
+	}
+
+	// The data file is created on the first write, so a missing file is an
+	// empty upload.
+	var size int64
+	fi, err := dlus.driver.Stat(dataPath)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+		default:
+			return nil, err
+		}
+	} else {
+		size = fi.Size()
+	}
+
+	return &driverLayerFile{
+		driver: dlus.driver,
+		path:   dataPath,
+		size:   size,
+	}, nil
+}
+
+func (dlus *driverLayerUploadStore) GetState(uuid string) (LayerUploadState, error) {
+	var lus LayerUploadState
+
+	statePath, err := dlus.pathMapper.path(uploadStatePathSpec{uuid: uuid})
+	if err != nil {
+		return lus, err
+	}
+
+	p, err := dlus.driver.GetContent(statePath)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			return lus, ErrLayerUploadUnknown
+		case storagedriver.InvalidPathError, *storagedriver.InvalidPathError:
+			// The uuid is not valid, so it cannot be a known upload.
+			return lus, ErrLayerUploadUnknown
+		default:
+			return lus, err
+		}
+	}
+
+	if err := json.Unmarshal(p, &lus); err != nil {
+		return lus, err
+	}
+
+	return lus, nil
+}
+
+func (dlus *driverLayerUploadStore) SaveState(lus LayerUploadState) error {
+	p, err := json.Marshal(lus)
+	if err != nil {
+		return err
+	}
+
+	statePath, err := dlus.pathMapper.path(uploadStatePathSpec{uuid: lus.UUID})
+	if err != nil {
+		return err
+	}
+
+	return dlus.driver.PutContent(statePath, p)
+}
+
+func (dlus *driverLayerUploadStore) DeleteState(uuid string) error {
+	uploadPath, err := dlus.pathMapper.path(uploadPathSpec{uuid: uuid})
+	if err != nil {
+		return err
+	}
+
+	if err := dlus.driver.Delete(uploadPath); err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			return ErrLayerUploadUnknown
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (dlus *driverLayerUploadStore) List() ([]string, error) {
+	uploadsPath, err := dlus.pathMapper.path(uploadsPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := dlus.driver.List(uploadsPath)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// No uploads have been started.
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	uuids := make([]string, 0, len(children))
+	for _, child := range children {
+		uuids = append(uuids, path.Base(child))
+	}
+
+	return uuids, nil
+}
+
+// driverLayerFile implements layerFile for upload data kept in the storage
+// driver. Writes are passed directly to the driver, so Sync is a no-op.
+type driverLayerFile struct {
+	driver storagedriver.StorageDriver
+	path   string
+	size   int64 // size is the current size of the upload data.
+	offset int64
+
+	rc io.ReadCloser // rc reads from offset, if open.
+}
+
+var _ layerFile = &driverLayerFile{}
+
+func (dlf *driverLayerFile) Read(p []byte) (int, error) {
+	if dlf.offset >= dlf.size {
+		return 0, io.EOF
+	}
+
+	if dlf.rc == nil {
+		rc, err := dlf.driver.ReadStream(dlf.path, dlf.offset)
+		if err != nil {
+			return 0, err
+		}
+
+		dlf.rc = rc
+	}
+
+	n, err := dlf.rc.Read(p)
+	dlf.offset += int64(n)
+
+	return n, err
+}
+
+func (dlf *driverLayerFile) Write(p []byte) (int, error) {
+	dlf.reset()
+
+	nn, err := dlf.driver.WriteStream(dlf.path, dlf.offset, bytes.NewReader(p))
+	dlf.offset += nn
+
+	if dlf.offset > dlf.size {
+		dlf.size = dlf.offset
+	}
+
+	return int(nn), err
+}
+
+func (dlf *driverLayerFile) Seek(offset int64, whence int) (int64, error) {
+	newOffset := dlf.offset
+
+	switch whence {
+	case os.SEEK_CUR:
+		newOffset += offset
+	case os.SEEK_END:
+		newOffset = dlf.size + offset
+	case os.SEEK_SET:
+		newOffset = offset
+	}
+
+	if newOffset < 0 {
+		return dlf.offset, fmt.Errorf("cannot seek to negative position")
+	} else if newOffset > dlf.size {
+		return dlf.offset, fmt.Errorf("cannot seek passed end of file")
+	}
+
+	if newOffset != dlf.offset {
+		dlf.reset()
+	}
+
+	dlf.offset = newOffset
+	return dlf.offset, nil
+}
+
+// Sync is a no-op, since the data is committed to the driver on each write.
+func (dlf *driverLayerFile) Sync() error {
+	return nil
+}
+
+func (dlf *driverLayerFile) Close() error {
+	dlf.reset()
+	return nil
+}
+
+// reset closes the current reader, if open.
+func (dlf *driverLayerFile) reset() {
+	if dlf.rc != nil {
+		dlf.rc.Close()
+		dlf.rc = nil
+	}
+}
@@ -0,0 +1,620 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/docker-registry/storagedriver"
+	"github.com/docker/docker/pkg/tarsum"
+)
+
+// FsckProblemType identifies the kind of problem found by a consistency
+// check.
+type FsckProblemType string
+
+const (
+	// FsckCorruptBlob is a blob whose content does not match its digest.
+	// Repaired by moving the blob into quarantine.
+	FsckCorruptBlob FsckProblemType = "CORRUPT_BLOB"
+
+	// FsckDanglingLayerLink is a layer link that cannot be parsed or whose
+	// target blob is missing. Repaired by removing the link.
+	FsckDanglingLayerLink FsckProblemType = "DANGLING_LAYER_LINK"
+
+	// FsckDanglingRevisionLink is a manifest revision link whose manifest
+	// content is missing. Repaired by removing the link.
+	FsckDanglingRevisionLink FsckProblemType = "DANGLING_REVISION_LINK"
+
+	// FsckDanglingTag is a tag referencing a manifest revision that is not
+	// linked into the repository. These are reported but not repaired.
+	FsckDanglingTag FsckProblemType = "DANGLING_TAG"
+
+	// FsckManifestInvalid is a manifest revision that cannot be parsed.
+	FsckManifestInvalid FsckProblemType = "MANIFEST_INVALID"
+
+	// FsckManifestUnverified is a manifest revision whose signatures cannot
+	// be verified.
+	FsckManifestUnverified FsckProblemType = "MANIFEST_UNVERIFIED"
+
+	// FsckManifestUnknownLayer is a manifest revision referencing a layer
+	// that is not available in its repository.
+	FsckManifestUnknownLayer FsckProblemType = "MANIFEST_UNKNOWN_LAYER"
+
+	// FsckUsageMismatch is usage recorded for a repository or namespace that
+	// differs from the usage counted from the content. Repaired by recording
+	// the counted usage.
+	FsckUsageMismatch FsckProblemType = "USAGE_MISMATCH"
+)
+
+// FsckReport describes the result of a consistency check over the storage
+// backend. If Repair is set, problems that could be repaired are marked as
+// such.
+type FsckReport struct {
+	// Repair is true if the checker repaired the problems it could.
+	Repair bool `json:"repair"`
+
+	// CheckedBlobs is the number of blobs whose content was verified.
+	CheckedBlobs int `json:"checkedBlobs"`
+
+	// CheckedLayerLinks is the number of layer links checked, across all
+	// repositories.
+	CheckedLayerLinks int `json:"checkedLayerLinks"`
+
+	// CheckedManifests is the number of manifest revisions checked, across
+	// all repositories.
+	CheckedManifests int `json:"checkedManifests"`
+
+	// Problems lists the problems found, in the order they were found.
+	Problems []FsckProblem `json:"problems"`
+}
+
+// Unrepaired returns the number of problems that remain in the storage
+// backend.
+func (fr *FsckReport) Unrepaired() int {
+	var n int
+	for _, problem := range fr.Problems {
+		if !problem.Repaired {
+			n++
+		}
+	}
+
+	return n
+}
+
+// FsckProblem describes a single problem found by a consistency check.
+type FsckProblem struct {
+	Type FsckProblemType `json:"type"`
+
+	// Name is the repository of the problem, if it is found within one, or
+	// the namespace of a usage mismatch.
+	Name string `json:"name,omitempty"`
+
+	// Digest identifies the blob, layer or manifest revision with the
+	// problem.
+	Digest digest.Digest `json:"digest,omitempty"`
+
+	// Tag is set for problems with a tag.
+	Tag string `json:"tag,omitempty"`
+
+	// Path is the storage path where the problem was found.
+	Path string `json:"path"`
+
+	// Detail describes the problem.
+	Detail string `json:"detail,omitempty"`
+
+	// Repaired is true if the problem was repaired.
+	Repaired bool `json:"repaired"`
+}
+
+// Fsck checks the consistency of the content in the storage backend. Each
+// blob is read back and verified against its digest. The layer links, manifest
+// revisions and tags of each repository are then checked, verifying the
+// signatures of each manifest and that the layers it references are present.
+// Finally, the usage recorded for each repository and namespace is checked
+// against the usage counted from the content.
+//
+// If repair is true, corrupt blobs are moved into quarantine, links left
+// dangling are removed and the counted usage is recorded. Other problems,
+// such as manifests failing verification, are only reported. Since corrupt blobs are checked first,
+// links to a quarantined blob are removed in the same run.
+//
+// Repair should not be run concurrently with pushes, since a layer link may
+// be written before its blob.
+func (ss *Services) Fsck(repair bool) (*FsckReport, error) {
+	fc := &fsckChecker{
+		driver:     ss.driver,
+		pathMapper: ss.pathMapper,
+		manifests: &manifestStore{
+			driver:       ss.driver,
+			pathMapper:   ss.pathMapper,
+			layerService: ss.Layers(),
+		},
+		quotas:     ss.quotas,
+		namespaces: make(map[string]repositoryUsage),
+		report: FsckReport{
+			Repair: repair,
+		},
+	}
+
+	if err := fc.run(); err != nil {
+		return nil, err
+	}
+
+	return &fc.report, nil
+}
+
+// fsckChecker carries the state of a single consistency check.
+type fsckChecker struct {
+	driver     storagedriver.StorageDriver
+	pathMapper *pathMapper
+	manifests  *manifestStore
+	quotas     *quotaStore
+	report     FsckReport
+
+	// namespaces holds the usage counted for each namespace containing a
+	// checked repository.
+	namespaces map[string]repositoryUsage
+}
+
+func (fc *fsckChecker) run() error {
+	if err := fc.checkBlobs(); err != nil {
+		return err
+	}
+
+	if err := walkRepositories(fc.driver, fc.pathMapper, fc.checkRepository); err != nil {
+		return err
+	}
+
+	return fc.checkNamespaceUsage()
+}
+
+// checkBlobs verifies the content of each blob in the blob store.
+func (fc *fsckChecker) checkBlobs() error {
+	blobsPath, err := fc.pathMapper.path(blobsPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	err = walk(fc.driver, blobsPath, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		dgst, err := digestFromPathComponents(strings.Split(strings.TrimPrefix(fileInfo.Path(), blobsPath+"/"), "/"))
+		if err != nil {
+			logrus.Warnf("fsck: skipping unknown blob %q: %v", fileInfo.Path(), err)
+			return nil
+		}
+
+		if _, err := tarsum.GetVersionFromTarsum(string(dgst)); err != nil && strings.HasPrefix(string(dgst), "tarsum") {
+			logrus.Warnf("fsck: skipping blob %q with unsupported digest: %v", fileInfo.Path(), err)
+			return nil
+		}
+
+		fc.report.CheckedBlobs++
+
+		verified, err := fc.verifyBlob(fileInfo.Path(), dgst)
+		if err != nil {
+			return err
+		}
+
+		if verified {
+			return nil
+		}
+
+		problem := FsckProblem{
+			Type:   FsckCorruptBlob,
+			Digest: dgst,
+			Path:   fileInfo.Path(),
+			Detail: "content does not match digest",
+		}
+
+		if fc.report.Repair {
+			quarantinePath, err := fc.pathMapper.path(quarantinePathSpec{digest: dgst})
+			if err != nil {
+				return err
+			}
+
+			logrus.Infof("fsck: moving %s to %s", fileInfo.Path(), quarantinePath)
+			if err := fc.driver.Move(fileInfo.Path(), quarantinePath); err != nil {
+				return err
+			}
+
+			problem.Repaired = true
+		}
+
+		fc.report.Problems = append(fc.report.Problems, problem)
+		return nil
+	})
+
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// No blobs have been stored, yet.
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyBlob returns true if the content at path p matches dgst. Manifest
+// revisions stored in the blob store before they were kept apart from layers
+// are addressed by the digest of their payload, rather than their content, so
+// blobs failing verification are checked as manifests.
+func (fc *fsckChecker) verifyBlob(p string, dgst digest.Digest) (bool, error) {
+	rc, err := fc.driver.ReadStream(p, 0)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	verifier := digest.NewDigestVerifier(dgst)
+	if _, err := io.Copy(verifier, rc); err != nil {
+		if err != io.ErrClosedPipe {
+			return false, err
+		}
+
+		// A tarsum verifier stops accepting content that is not a valid tar
+		// archive.
+		return false, nil
+	}
+
+	if verifier.Verified() {
+		return true, nil
+	}
+
+	if dgst.Algorithm() != "sha256" {
+		return false, nil
+	}
+
+	content, err := fc.driver.GetContent(p)
+	if err != nil {
+		return false, err
+	}
+
+	var sm SignedManifest
+	if err := json.Unmarshal(content, &sm); err != nil {
+		return false, nil
+	}
+
+	revision, err := sm.Digest()
+	if err != nil {
+		return false, nil
+	}
+
+	return revision == dgst, nil
+}
+
+// checkRepository checks the layer links, manifest revisions, tags and
+// recorded usage of the named repository. Layer links are checked first, so
+// that manifests referencing a link removed by a repair are reported, and
+// usage last, so that it is counted without removed links.
+func (fc *fsckChecker) checkRepository(name string) error {
+	if err := fc.checkLayerLinks(name); err != nil {
+		return err
+	}
+
+	if err := fc.checkRevisions(name); err != nil {
+		return err
+	}
+
+	if err := fc.checkTags(name); err != nil {
+		return err
+	}
+
+	return fc.checkUsage(name)
+}
+
+// checkLayerLinks ensures each layer link of the named repository targets a
+// blob in the blob store.
+func (fc *fsckChecker) checkLayerLinks(name string) error {
+	layersPath, err := fc.pathMapper.path(layersPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	err = walk(fc.driver, layersPath, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		dgst, err := digestFromPathComponents(strings.Split(strings.TrimPrefix(fileInfo.Path(), layersPath+"/"), "/"))
+		if err != nil {
+			logrus.Warnf("fsck: skipping unknown layer link %q: %v", fileInfo.Path(), err)
+			return nil
+		}
+
+		fc.report.CheckedLayerLinks++
+
+		content, err := fc.driver.GetContent(fileInfo.Path())
+		if err != nil {
+			return err
+		}
+
+		problem := FsckProblem{
+			Type:   FsckDanglingLayerLink,
+			Name:   name,
+			Digest: dgst,
+			Path:   fileInfo.Path(),
+		}
+
+		linked, err := digest.ParseDigest(string(content))
+		if err != nil {
+			problem.Detail = fmt.Sprintf("invalid link content: %v", err)
+			return fc.removeLink(problem)
+		}
+
+		blobPath, err := fc.pathMapper.path(blobPathSpec{digest: linked})
+		if err != nil {
+			problem.Detail = fmt.Sprintf("invalid link content: %v", err)
+			return fc.removeLink(problem)
+		}
+
+		if _, err := fc.driver.Stat(blobPath); err != nil {
+			switch err.(type) {
+			case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+				problem.Detail = fmt.Sprintf("blob %s not found", linked)
+				return fc.removeLink(problem)
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// The repository has no layers.
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkRevisions verifies each manifest revision of the named repository and
+// ensures the layers it references are available.
+func (fc *fsckChecker) checkRevisions(name string) error {
+	revisionsPath, err := fc.pathMapper.path(manifestRevisionsPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	err = walk(fc.driver, revisionsPath, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		revision, err := digestFromPathComponents(strings.Split(strings.TrimPrefix(fileInfo.Path(), revisionsPath+"/"), "/"))
+		if err != nil {
+			logrus.Warnf("fsck: skipping unknown manifest revision %q: %v", fileInfo.Path(), err)
+			return nil
+		}
+
+		fc.report.CheckedManifests++
+
+		problem := FsckProblem{
+			Name:   name,
+			Digest: revision,
+			Path:   fileInfo.Path(),
+		}
+
+		manifest, err := fc.manifests.getRevision(revision)
+		if err != nil {
+			switch err.(type) {
+			case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+				problem.Type = FsckDanglingRevisionLink
+				problem.Detail = "manifest not found"
+				return fc.removeLink(problem)
+			case *json.SyntaxError, *json.UnmarshalTypeError:
+				problem.Type = FsckManifestInvalid
+				problem.Detail = err.Error()
+				fc.report.Problems = append(fc.report.Problems, problem)
+				return nil
+			default:
+				return err
+			}
+		}
+
+		if _, err := manifest.Verify(); err != nil {
+			problem.Type = FsckManifestUnverified
+			problem.Detail = err.Error()
+			fc.report.Problems = append(fc.report.Problems, problem)
+		}
+
+		for _, fsLayer := range manifest.FSLayers {
+			exists := false
+			if err := fsLayer.BlobSum.Validate(); err == nil {
+				exists, err = fc.manifests.layerService.Exists(name, fsLayer.BlobSum)
+				if err != nil {
+					return err
+				}
+			}
+
+			if !exists {
+				problem.Type = FsckManifestUnknownLayer
+				problem.Detail = fmt.Sprintf("layer %s not found", fsLayer.BlobSum)
+				fc.report.Problems = append(fc.report.Problems, problem)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// The repository has no manifests.
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkTags ensures the current revision of each tag of the named repository
+// is linked into the repository.
+func (fc *fsckChecker) checkTags(name string) error {
+	tags, err := fc.manifests.Tags(name)
+	if err != nil {
+		switch err.(type) {
+		case ErrUnknownRepository:
+			// The repository has no tags.
+			return nil
+		default:
+			return err
+		}
+	}
+
+	for _, tag := range tags {
+		revision, err := fc.manifests.resolveTag(name, tag)
+		if err != nil {
+			switch err.(type) {
+			case ErrUnknownManifest:
+				// The tag has been deleted, leaving only its history.
+				continue
+			default:
+				return err
+			}
+		}
+
+		revisionPath, err := fc.pathMapper.path(manifestRevisionLinkPathSpec{name: name, revision: revision})
+		if err != nil {
+			return err
+		}
+
+		if _, err := fc.driver.Stat(revisionPath); err != nil {
+			switch err.(type) {
+			case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+				currentPath, err := fc.pathMapper.path(manifestTagCurrentPathSpec{name: name, tag: tag})
+				if err != nil {
+					return err
+				}
+
+				fc.report.Problems = append(fc.report.Problems, FsckProblem{
+					Type:   FsckDanglingTag,
+					Name:   name,
+					Digest: revision,
+					Tag:    tag,
+					Path:   currentPath,
+					Detail: "manifest revision not found",
+				})
+			default:
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkUsage ensures the usage recorded for the named repository, if any,
+// matches the usage counted from its content. The counted usage is added to
+// each namespace containing the repository, to be checked once all
+// repositories have been checked.
+func (fc *fsckChecker) checkUsage(name string) error {
+	counted, err := fc.quotas.count(name)
+	if err != nil {
+		return err
+	}
+
+	components := strings.Split(name, "/")
+	for i := range components {
+		namespace := strings.Join(components[:i+1], "/")
+
+		usage := fc.namespaces[namespace]
+		usage.Layers += counted.Layers
+		usage.Manifests += counted.Manifests
+		fc.namespaces[namespace] = usage
+	}
+
+	usagePath, err := fc.pathMapper.path(repositoryUsagePathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	return fc.checkRecordedUsage(FsckProblem{Name: name, Path: usagePath}, counted)
+}
+
+// checkNamespaceUsage ensures the usage recorded for each namespace, if any,
+// matches the usage counted for the repositories within it.
+func (fc *fsckChecker) checkNamespaceUsage() error {
+	var namespaces []string
+	for namespace := range fc.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	for _, namespace := range namespaces {
+		usagePath, err := fc.pathMapper.path(namespaceUsagePathSpec{namespace: namespace})
+		if err != nil {
+			return err
+		}
+
+		if err := fc.checkRecordedUsage(FsckProblem{Name: namespace, Path: usagePath}, fc.namespaces[namespace]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkRecordedUsage records a usage mismatch if usage recorded at the path
+// of the problem differs from counted, recording the counted usage if
+// repairing.
+func (fc *fsckChecker) checkRecordedUsage(problem FsckProblem, counted repositoryUsage) error {
+	recorded, ok, err := fc.quotas.recorded(problem.Path)
+	if err != nil {
+		switch err.(type) {
+		case *json.SyntaxError, *json.UnmarshalTypeError:
+			problem.Detail = fmt.Sprintf("invalid usage: %v", err)
+		default:
+			return err
+		}
+	} else if !ok || recorded == counted {
+		return nil
+	} else {
+		problem.Detail = fmt.Sprintf("recorded %d layer and %d manifest bytes, counted %d and %d",
+			recorded.Layers, recorded.Manifests, counted.Layers, counted.Manifests)
+	}
+
+	problem.Type = FsckUsageMismatch
+
+	if fc.report.Repair {
+		logrus.Infof("fsck: recording usage at %s", problem.Path)
+		if err := fc.quotas.record(problem.Path, counted); err != nil {
+			return err
+		}
+
+		problem.Repaired = true
+	}
+
+	fc.report.Problems = append(fc.report.Problems, problem)
+	return nil
+}
+
+// removeLink records the problem with a link, removing the link if repairing.
+func (fc *fsckChecker) removeLink(problem FsckProblem) error {
+	if fc.report.Repair {
+		logrus.Infof("fsck: deleting %s", problem.Path)
+		if err := fc.driver.Delete(problem.Path); err != nil {
+			return err
+		}
+
+		problem.Repaired = true
+	}
+
+	fc.report.Problems = append(fc.report.Problems, problem)
+	return nil
+}
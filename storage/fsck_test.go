@@ -0,0 +1,350 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/docker/libtrust"
+
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/docker-registry/storagedriver/inmemory"
+)
+
+// TestFsck ensures that corrupt blobs, dangling links and broken manifests are
+// reported and that repair quarantines and removes what it can.
+func TestFsck(t *testing.T) {
+	driver := inmemory.New()
+	ss := NewServices(driver)
+	name := "foo/bar"
+
+	_, corrupt, _, err := writeRandomLayer(driver, ss.pathMapper, name)
+	if err != nil {
+		t.Fatalf("unexpected error writing corrupt layer: %v", err)
+	}
+
+	_, dangling, _, err := writeRandomLayer(driver, ss.pathMapper, name)
+	if err != nil {
+		t.Fatalf("unexpected error writing dangling layer: %v", err)
+	}
+
+	_, unlinked, _, err := writeRandomLayer(driver, ss.pathMapper, name)
+	if err != nil {
+		t.Fatalf("unexpected error writing unlinked layer: %v", err)
+	}
+
+	manifest := Manifest{
+		Versioned: Versioned{
+			SchemaVersion: 1,
+		},
+		Name: name,
+		Tag:  "thetag",
+		FSLayers: []FSLayer{
+			{
+				BlobSum: unlinked,
+			},
+		},
+		History: testHistory(1),
+	}
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	sm, err := manifest.Sign(pk)
+	if err != nil {
+		t.Fatalf("error signing manifest: %v", err)
+	}
+
+	if err := ss.Manifests().Put(name, manifest.Tag, sm); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	revision, err := sm.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	// A consistent registry has no problems.
+	report, err := ss.Fsck(false)
+	if err != nil {
+		t.Fatalf("unexpected error checking registry: %v", err)
+	}
+
+	if len(report.Problems) != 0 {
+		t.Fatalf("unexpected problems: %#v", report.Problems)
+	}
+
+	if report.CheckedBlobs != 3 || report.CheckedLayerLinks != 3 || report.CheckedManifests != 1 {
+		t.Fatalf("unexpected counts: %#v", report)
+	}
+
+	// Corrupt the content of one blob and remove another.
+	corruptPath, err := ss.pathMapper.path(blobPathSpec{digest: corrupt})
+	if err != nil {
+		t.Fatalf("unexpected error getting blob path: %v", err)
+	}
+
+	if err := driver.PutContent(corruptPath, []byte("not the layer")); err != nil {
+		t.Fatalf("unexpected error corrupting blob: %v", err)
+	}
+
+	danglingPath, err := ss.pathMapper.path(blobPathSpec{digest: dangling})
+	if err != nil {
+		t.Fatalf("unexpected error getting blob path: %v", err)
+	}
+
+	if err := driver.Delete(danglingPath); err != nil {
+		t.Fatalf("unexpected error deleting blob: %v", err)
+	}
+
+	// Remove the link of the layer referenced by the manifest.
+	if err := ss.Layers().Delete(name, unlinked); err != nil {
+		t.Fatalf("unexpected error deleting layer: %v", err)
+	}
+
+	// Tamper with the manifest signature, leaving the payload intact.
+	revisionPath, err := ss.pathMapper.path(manifestBlobPathSpec{revision: revision})
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest path: %v", err)
+	}
+
+	content, err := driver.GetContent(revisionPath)
+	if err != nil {
+		t.Fatalf("unexpected error reading manifest: %v", err)
+	}
+
+	i := bytes.Index(content, []byte(`"signature": "`)) + len(`"signature": "`)
+	if content[i] == 'A' {
+		content[i] = 'B'
+	} else {
+		content[i] = 'A'
+	}
+
+	if err := driver.PutContent(revisionPath, content); err != nil {
+		t.Fatalf("unexpected error writing manifest: %v", err)
+	}
+
+	report, err = ss.Fsck(false)
+	if err != nil {
+		t.Fatalf("unexpected error checking registry: %v", err)
+	}
+
+	// The blobs are no longer counted in the usage of the repository.
+	checkFsckProblems(t, report, []FsckProblem{
+		{Type: FsckCorruptBlob, Digest: corrupt},
+		{Type: FsckDanglingLayerLink, Name: name, Digest: dangling},
+		{Type: FsckManifestUnverified, Name: name, Digest: revision},
+		{Type: FsckManifestUnknownLayer, Name: name, Digest: revision},
+		{Type: FsckUsageMismatch, Name: name},
+	})
+
+	// Nothing is changed without repair.
+	checkLayerExists(t, ss, name, corrupt, true)
+
+	report, err = ss.Fsck(true)
+	if err != nil {
+		t.Fatalf("unexpected error repairing registry: %v", err)
+	}
+
+	// Quarantining the corrupt blob leaves its link dangling.
+	checkFsckProblems(t, report, []FsckProblem{
+		{Type: FsckCorruptBlob, Digest: corrupt, Repaired: true},
+		{Type: FsckDanglingLayerLink, Name: name, Digest: corrupt, Repaired: true},
+		{Type: FsckDanglingLayerLink, Name: name, Digest: dangling, Repaired: true},
+		{Type: FsckManifestUnverified, Name: name, Digest: revision},
+		{Type: FsckManifestUnknownLayer, Name: name, Digest: revision},
+		{Type: FsckUsageMismatch, Name: name, Repaired: true},
+	})
+
+	if report.Unrepaired() != 2 {
+		t.Fatalf("unexpected number of unrepaired problems: %d", report.Unrepaired())
+	}
+
+	quarantinePath, err := ss.pathMapper.path(quarantinePathSpec{digest: corrupt})
+	if err != nil {
+		t.Fatalf("unexpected error getting quarantine path: %v", err)
+	}
+
+	if _, err := driver.Stat(quarantinePath); err != nil {
+		t.Fatalf("expected corrupt blob in quarantine: %v", err)
+	}
+
+	for _, dgst := range []digest.Digest{corrupt, dangling} {
+		checkLayerExists(t, ss, name, dgst, false)
+	}
+
+	// Only the problems that cannot be repaired remain.
+	report, err = ss.Fsck(false)
+	if err != nil {
+		t.Fatalf("unexpected error checking registry: %v", err)
+	}
+
+	checkFsckProblems(t, report, []FsckProblem{
+		{Type: FsckManifestUnverified, Name: name, Digest: revision},
+		{Type: FsckManifestUnknownLayer, Name: name, Digest: revision},
+	})
+}
+
+// TestFsckDanglingRevision ensures that links to missing manifests are
+// reported and removed on repair, while the tag referencing them is only
+// reported.
+func TestFsckDanglingRevision(t *testing.T) {
+	driver := inmemory.New()
+	ss := NewServices(driver)
+	name := "foo/bar"
+
+	_, layer, _, err := writeRandomLayer(driver, ss.pathMapper, name)
+	if err != nil {
+		t.Fatalf("unexpected error writing layer: %v", err)
+	}
+
+	manifest := Manifest{
+		Versioned: Versioned{
+			SchemaVersion: 1,
+		},
+		Name:     name,
+		Tag:      "thetag",
+		FSLayers: []FSLayer{{BlobSum: layer}},
+		History:  testHistory(1),
+	}
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	sm, err := manifest.Sign(pk)
+	if err != nil {
+		t.Fatalf("error signing manifest: %v", err)
+	}
+
+	if err := ss.Manifests().Put(name, manifest.Tag, sm); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	revision, err := sm.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	revisionPath, err := ss.pathMapper.path(manifestBlobPathSpec{revision: revision})
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest path: %v", err)
+	}
+
+	if err := driver.Delete(revisionPath); err != nil {
+		t.Fatalf("unexpected error deleting manifest: %v", err)
+	}
+
+	report, err := ss.Fsck(true)
+	if err != nil {
+		t.Fatalf("unexpected error repairing registry: %v", err)
+	}
+
+	checkFsckProblems(t, report, []FsckProblem{
+		{Type: FsckDanglingRevisionLink, Name: name, Digest: revision, Repaired: true},
+		{Type: FsckDanglingTag, Name: name, Digest: revision},
+		{Type: FsckUsageMismatch, Name: name, Repaired: true},
+	})
+}
+
+// TestFsckUsage ensures that recorded repository and namespace usage
+// differing from the content is reported and recorded again on repair.
+func TestFsckUsage(t *testing.T) {
+	ss, err := NewServicesWithOptions(inmemory.New(), Options{
+		Quotas: Quotas{
+			Namespaces: map[string]int64{
+				"foo": 1 << 20,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	// Check the quota of the namespace first, such that its usage is
+	// recorded.
+	checkUsage(t, ss, "foo/bar", 0, 0)
+
+	for _, name := range []string{"foo/bar", "foo/baz", "other/repo"} {
+		p, dgst := randomQuotaContent(t)
+		checkQuotaUpload(t, ss.Layers(), name, p, dgst, "")
+	}
+
+	report, err := ss.Fsck(false)
+	if err != nil {
+		t.Fatalf("unexpected error checking registry: %v", err)
+	}
+
+	checkFsckProblems(t, report, nil)
+
+	for _, spec := range []pathSpec{
+		repositoryUsagePathSpec{name: "foo/baz"},
+		namespaceUsagePathSpec{namespace: "foo"},
+	} {
+		usagePath, err := ss.pathMapper.path(spec)
+		if err != nil {
+			t.Fatalf("unexpected error getting usage path: %v", err)
+		}
+
+		if err := ss.quotas.record(usagePath, repositoryUsage{Layers: 5000}); err != nil {
+			t.Fatalf("unexpected error recording usage: %v", err)
+		}
+	}
+
+	report, err = ss.Fsck(false)
+	if err != nil {
+		t.Fatalf("unexpected error checking registry: %v", err)
+	}
+
+	checkFsckProblems(t, report, []FsckProblem{
+		{Type: FsckUsageMismatch, Name: "foo/baz"},
+		{Type: FsckUsageMismatch, Name: "foo"},
+	})
+
+	report, err = ss.Fsck(true)
+	if err != nil {
+		t.Fatalf("unexpected error repairing registry: %v", err)
+	}
+
+	checkFsckProblems(t, report, []FsckProblem{
+		{Type: FsckUsageMismatch, Name: "foo/baz", Repaired: true},
+		{Type: FsckUsageMismatch, Name: "foo", Repaired: true},
+	})
+
+	usage, err := ss.Usage("foo/baz")
+	if err != nil {
+		t.Fatalf("unexpected error getting usage: %v", err)
+	}
+
+	if usage.Layers != 1000 || len(usage.Quotas) != 1 || usage.Quotas[0].Usage != 2000 {
+		t.Fatalf("unexpected usage after repair: %#v", usage)
+	}
+}
+
+// checkFsckProblems ensures the report contains the expected problems, in
+// any order, comparing only their type, repository, digest and repair status.
+func checkFsckProblems(t *testing.T, report *FsckReport, expected []FsckProblem) {
+	if len(report.Problems) != len(expected) {
+		t.Fatalf("unexpected problems: %#v != %#v", report.Problems, expected)
+	}
+
+	key := func(problem FsckProblem) string {
+		return fmt.Sprintf("%s %s %s %t", problem.Type, problem.Name, problem.Digest, problem.Repaired)
+	}
+
+	found := make(map[string]int)
+	for _, problem := range report.Problems {
+		found[key(problem)]++
+	}
+
+	for _, problem := range expected {
+		if found[key(problem)] == 0 {
+			t.Fatalf("expected problem %#v in %#v", problem, report.Problems)
+		}
+
+		found[key(problem)]--
+	}
+}
@@ -0,0 +1,574 @@
+package storage
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/docker-registry/storagedriver"
+)
+
+// GCReport describes the result of a garbage collection run. If DryRun is
+// set, the report describes the content that would have been removed.
+type GCReport struct {
+	// DryRun is true if the collector did not remove any content.
+	DryRun bool `json:"dryRun"`
+
+	// MarkedBlobs lists the manifest revisions reachable from tags and the
+	// layer blobs they reference. These are retained.
+	MarkedBlobs []digest.Digest `json:"markedBlobs"`
+
+	// DeletedRevisionLinks lists the manifest revisions swept from each
+	// repository.
+	DeletedRevisionLinks []GCRevisionLink `json:"deletedRevisionLinks"`
+
+	// DeletedLayerLinks lists the layer links swept from each repository.
+	DeletedLayerLinks []GCLayerLink `json:"deletedLayerLinks"`
+
+	// DeletedBlobs lists the blobs and manifest revisions swept from storage.
+	DeletedBlobs []digest.Digest `json:"deletedBlobs"`
+
+	// DeletedAliases lists the digests whose aliases were swept, since
+	// neither digest identifies a retained blob.
+	DeletedAliases []digest.Digest `json:"deletedAliases"`
+
+	// SkippedRepositories lists the repositories holding manifest entries
+	// the collector does not recognise. Everything linked into them is
+	// retained.
+	SkippedRepositories []string `json:"skippedRepositories"`
+}
+
+// GCLayerLink identifies a layer link in a repository.
+type GCLayerLink struct {
+	Name   string        `json:"name"`
+	Digest digest.Digest `json:"digest"`
+}
+
+// GCRevisionLink identifies a manifest revision linked into a repository.
+type GCRevisionLink struct {
+	Name     string        `json:"name"`
+	Revision digest.Digest `json:"revision"`
+}
+
+// GarbageCollect runs a mark and sweep garbage collection over the storage
+// backend. The roots of each repository are the revisions referenced by its
+// tags, either currently or in the tag history, and the revisions referenced
+// by the manifest lists among them. These are marked, along with the blobs
+// of their layers. Revisions that are not reachable from a tag, such as those
+// of deleted tags, are unlinked from the repository and are no longer
+// available by digest. Layer links not referenced by a retained revision in
+// their repository and blobs that are not marked are then removed, and the
+// usage of each repository is recounted. If dryRun is true, nothing is
+// removed and the report describes what would have been.
+//
+// Content that cannot be classified is never removed. If content remains
+// stored with the layout of an earlier path version, ErrMigrationRequired is
+// returned and nothing is collected, since the content would not be seen as
+// referenced. A repository with manifest entries that are not recognised is
+// skipped, retaining all of its links and the blobs they reference.
+//
+// Garbage collection is not safe to run concurrently with pushes: a layer
+// uploaded but not yet referenced by a manifest will be collected. The
+// registry should not be accepting writes while this runs.
+func (ss *Services) GarbageCollect(dryRun bool) (*GCReport, error) {
+	if err := ss.CheckMigrated(); err != nil {
+		return nil, err
+	}
+
+	gc := &garbageCollector{
+		driver:     ss.driver,
+		pathMapper: ss.pathMapper,
+		manifests: &manifestStore{
+			driver:       ss.driver,
+			pathMapper:   ss.pathMapper,
+			layerService: ss.Layers(),
+		},
+		quotas: ss.quotas,
+		report: GCReport{
+			DryRun: dryRun,
+		},
+		marked: make(map[digest.Digest]struct{}),
+	}
+
+	if err := gc.run(); err != nil {
+		return nil, err
+	}
+
+	return &gc.report, nil
+}
+
+// garbageCollector carries the state of a single collection run.
+type garbageCollector struct {
+	driver     storagedriver.StorageDriver
+	pathMapper *pathMapper
+	manifests  *manifestStore
+	quotas     *quotaStore
+	report     GCReport
+
+	// marked contains the set of blob digests that must be retained.
+	marked map[digest.Digest]struct{}
+}
+
+func (gc *garbageCollector) run() error {
+	// Marking and sweeping of layer links is done one repository at a time,
+	// since the links are only meaningful within a repository. Blobs are
+	// swept once all repositories have been seen.
+	if err := walkRepositories(gc.driver, gc.pathMapper, gc.collectRepository); err != nil {
+		return err
+	}
+
+	for dgst := range gc.marked {
+		gc.report.MarkedBlobs = append(gc.report.MarkedBlobs, dgst)
+	}
+
+	// Layer blobs and manifest content are stored apart, but share the
+	// marked set, since both are addressed by digest.
+	for _, spec := range []pathSpec{blobsPathSpec{}, manifestBlobsPathSpec{}} {
+		if err := gc.sweepBlobs(spec); err != nil {
+			return err
+		}
+	}
+
+	return gc.sweepAliases()
+}
+
+// collectRepository marks the blobs referenced by the manifest revisions of
+// the named repository reachable from its tags and sweeps any revision and
+// layer links that are not referenced.
+func (gc *garbageCollector) collectRepository(name string) error {
+	recognised, err := gc.recognisedManifests(name)
+	if err != nil {
+		return err
+	}
+
+	if !recognised {
+		gc.report.SkippedRepositories = append(gc.report.SkippedRepositories, name)
+		return gc.retainRepository(name)
+	}
+
+	reachable, err := gc.reachableRevisions(name)
+	if err != nil {
+		return err
+	}
+
+	if err := gc.sweepRevisions(name, reachable); err != nil {
+		return err
+	}
+
+	referenced, err := gc.referencedLayers(reachable)
+	if err != nil {
+		return err
+	}
+
+	layersPath, err := gc.pathMapper.path(layersPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	err = walk(gc.driver, layersPath, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		dgst, err := digestFromPathComponents(strings.Split(strings.TrimPrefix(fileInfo.Path(), layersPath+"/"), "/"))
+		if err != nil {
+			// Don't touch what we don't understand.
+			logrus.Warnf("gc: skipping unknown layer link %q: %v", fileInfo.Path(), err)
+			return nil
+		}
+
+		if _, ok := referenced[dgst]; ok {
+			content, err := gc.driver.GetContent(fileInfo.Path())
+			if err != nil {
+				return err
+			}
+
+			linked, err := digest.ParseDigest(string(content))
+			if err != nil {
+				return err
+			}
+
+			gc.marked[linked] = struct{}{}
+			return nil
+		}
+
+		gc.report.DeletedLayerLinks = append(gc.report.DeletedLayerLinks, GCLayerLink{Name: name, Digest: dgst})
+		return gc.delete(fileInfo.Path())
+	})
+
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// The repository has no layers.
+		default:
+			return err
+		}
+	}
+
+	if gc.report.DryRun {
+		return nil
+	}
+
+	// The usage of the repository is counted again, releasing the swept
+	// links.
+	return gc.quotas.recount(name)
+}
+
+// recognisedManifests returns false if the manifests directory of the named
+// repository holds entries other than the tags, revisions and signatures of
+// the current layout, such as manifests stored by an earlier layout.
+func (gc *garbageCollector) recognisedManifests(name string) (bool, error) {
+	manifestsPath, err := gc.pathMapper.path(manifestsPathSpec{name: name})
+	if err != nil {
+		return false, err
+	}
+
+	entries, err := gc.driver.List(manifestsPath)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// The repository has no manifests.
+			return true, nil
+		default:
+			return false, err
+		}
+	}
+
+	for _, entry := range entries {
+		switch path.Base(entry) {
+		case "tags", "revisions", "signatures":
+		default:
+			logrus.Warnf("gc: skipping repository %s, unknown manifest entry %q", name, entry)
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// retainRepository marks the blobs linked into the named repository, as
+// layers or manifest revisions, without sweeping anything.
+func (gc *garbageCollector) retainRepository(name string) error {
+	layersPath, err := gc.pathMapper.path(layersPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	revisionsPath, err := gc.pathMapper.path(manifestRevisionsPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	for _, root := range []string{layersPath, revisionsPath} {
+		err := walk(gc.driver, root, func(fileInfo storagedriver.FileInfo) error {
+			if fileInfo.IsDir() {
+				return nil
+			}
+
+			content, err := gc.driver.GetContent(fileInfo.Path())
+			if err != nil {
+				return err
+			}
+
+			linked, err := digest.ParseDigest(string(content))
+			if err != nil {
+				logrus.Warnf("gc: skipping unknown link %q: %v", fileInfo.Path(), err)
+				return nil
+			}
+
+			gc.marked[linked] = struct{}{}
+			return nil
+		})
+
+		if err != nil {
+			switch err.(type) {
+			case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			default:
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// reachableRevisions returns the manifest revisions of the named repository
+// reachable from its tags, mapped to their manifests. Revisions referenced by
+// a tag but no longer linked into the repository are skipped.
+func (gc *garbageCollector) reachableRevisions(name string) (map[digest.Digest]*SignedManifest, error) {
+	roots, err := gc.taggedRevisions(name)
+	if err != nil {
+		return nil, err
+	}
+
+	reachable := make(map[digest.Digest]*SignedManifest)
+	for len(roots) > 0 {
+		revision := roots[len(roots)-1]
+		roots = roots[:len(roots)-1]
+
+		if _, ok := reachable[revision]; ok {
+			continue
+		}
+
+		manifest, err := gc.manifests.GetByDigest(name, revision)
+		if err != nil {
+			switch err.(type) {
+			case ErrUnknownManifestRevision:
+				logrus.Warnf("gc: skipping revision %s of %s, referenced but not linked", revision, name)
+				continue
+			default:
+				// If a manifest cannot be read, we cannot be sure what it
+				// references. Abort, rather than removing live data.
+				return nil, err
+			}
+		}
+
+		reachable[revision] = manifest
+
+		if manifest.List != nil {
+			for _, descriptor := range manifest.List.Manifests {
+				roots = append(roots, descriptor.Digest)
+			}
+		}
+	}
+
+	return reachable, nil
+}
+
+// taggedRevisions returns the revisions referenced by the tags of the named
+// repository, either currently or in their history.
+func (gc *garbageCollector) taggedRevisions(name string) ([]digest.Digest, error) {
+	tagsPath, err := gc.pathMapper.path(manifestTagsPath{name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []digest.Digest
+	err = walk(gc.driver, tagsPath, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		content, err := gc.driver.GetContent(fileInfo.Path())
+		if err != nil {
+			return err
+		}
+
+		// Each tag has a link to its current revision and an index of json
+		// encoded entries.
+		var revision digest.Digest
+		if strings.HasSuffix(fileInfo.Path(), "/current") {
+			revision, err = digest.ParseDigest(string(content))
+		} else {
+			var entry TagIndexEntry
+			err = json.Unmarshal(content, &entry)
+			revision = entry.Revision
+		}
+
+		if err != nil {
+			// A tag that cannot be read may reference any revision. Abort,
+			// rather than removing live data.
+			return err
+		}
+
+		revisions = append(revisions, revision)
+		return nil
+	})
+
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// The repository has no tags.
+		default:
+			return nil, err
+		}
+	}
+
+	return revisions, nil
+}
+
+// sweepRevisions removes the revision links, and the signatures, of the
+// revisions of the named repository that are not reachable. Reachable
+// revisions are marked.
+func (gc *garbageCollector) sweepRevisions(name string, reachable map[digest.Digest]*SignedManifest) error {
+	revisionsPath, err := gc.pathMapper.path(manifestRevisionsPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	err = walk(gc.driver, revisionsPath, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		revision, err := digestFromPathComponents(strings.Split(strings.TrimPrefix(fileInfo.Path(), revisionsPath+"/"), "/"))
+		if err != nil {
+			logrus.Warnf("gc: skipping unknown manifest revision %q: %v", fileInfo.Path(), err)
+			return nil
+		}
+
+		if _, ok := reachable[revision]; ok {
+			gc.marked[revision] = struct{}{}
+			return nil
+		}
+
+		gc.report.DeletedRevisionLinks = append(gc.report.DeletedRevisionLinks, GCRevisionLink{Name: name, Revision: revision})
+		if err := gc.delete(fileInfo.Path()); err != nil {
+			return err
+		}
+
+		signaturesPath, err := gc.pathMapper.path(manifestSignaturesPathSpec{name: name, revision: revision})
+		if err != nil {
+			return err
+		}
+
+		if _, err := gc.driver.Stat(signaturesPath); err != nil {
+			switch err.(type) {
+			case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+				// The revision has no stored signatures.
+				return nil
+			default:
+				return err
+			}
+		}
+
+		return gc.delete(signaturesPath)
+	})
+
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// No manifests, nothing to sweep.
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// referencedLayers returns the set of layer digests referenced by the
+// manifests, including their aliases.
+func (gc *garbageCollector) referencedLayers(manifests map[digest.Digest]*SignedManifest) (map[digest.Digest]struct{}, error) {
+	referenced := make(map[digest.Digest]struct{})
+
+	for _, manifest := range manifests {
+		for _, fsLayer := range manifest.FSLayers {
+			referenced[fsLayer.BlobSum] = struct{}{}
+
+			// The layer may be linked by its alias.
+			alias, err := gc.manifests.layerService.Alias(fsLayer.BlobSum)
+			switch err.(type) {
+			case nil:
+				referenced[alias] = struct{}{}
+			case ErrUnknownLayer:
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	return referenced, nil
+}
+
+// sweepBlobs removes all blobs under the directory described by spec that
+// have not been marked.
+func (gc *garbageCollector) sweepBlobs(spec pathSpec) error {
+	blobsPath, err := gc.pathMapper.path(spec)
+	if err != nil {
+		return err
+	}
+
+	err = walk(gc.driver, blobsPath, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		dgst, err := digestFromPathComponents(strings.Split(strings.TrimPrefix(fileInfo.Path(), blobsPath+"/"), "/"))
+		if err != nil {
+			logrus.Warnf("gc: skipping unknown blob %q: %v", fileInfo.Path(), err)
+			return nil
+		}
+
+		if _, ok := gc.marked[dgst]; ok {
+			return nil
+		}
+
+		gc.report.DeletedBlobs = append(gc.report.DeletedBlobs, dgst)
+		return gc.delete(fileInfo.Path())
+	})
+
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// No blobs have been stored, yet.
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sweepAliases removes the aliases recorded for content that is no longer in
+// the blob store. An alias is retained if either of its digests is marked.
+func (gc *garbageCollector) sweepAliases() error {
+	aliasesPath, err := gc.pathMapper.path(aliasesPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	err = walk(gc.driver, aliasesPath, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		dgst, err := digestFromPathComponents(strings.Split(strings.TrimPrefix(fileInfo.Path(), aliasesPath+"/"), "/"))
+		if err != nil {
+			logrus.Warnf("gc: skipping unknown alias %q: %v", fileInfo.Path(), err)
+			return nil
+		}
+
+		if _, ok := gc.marked[dgst]; ok {
+			return nil
+		}
+
+		content, err := gc.driver.GetContent(fileInfo.Path())
+		if err != nil {
+			return err
+		}
+
+		if alias, err := digest.ParseDigest(string(content)); err == nil {
+			if _, ok := gc.marked[alias]; ok {
+				return nil
+			}
+		}
+
+		gc.report.DeletedAliases = append(gc.report.DeletedAliases, dgst)
+		return gc.delete(fileInfo.Path())
+	})
+
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// No aliases have been recorded.
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// delete removes the path, unless running in dry run mode.
+func (gc *garbageCollector) delete(p string) error {
+	if gc.report.DryRun {
+		logrus.Infof("gc: would delete %s", p)
+		return nil
+	}
+
+	logrus.Infof("gc: deleting %s", p)
+	return gc.driver.Delete(p)
+}
@@ -0,0 +1,395 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/docker/libtrust"
+
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/docker-registry/storagedriver/inmemory"
+)
+
+// TestGarbageCollect ensures that manifests and the layers they reference are
+// retained while unreferenced layer links and blobs are removed.
+func TestGarbageCollect(t *testing.T) {
+	driver := inmemory.New()
+	ss := NewServices(driver)
+	name := "foo/bar"
+
+	_, referenced, _, err := writeRandomLayer(driver, ss.pathMapper, name)
+	if err != nil {
+		t.Fatalf("unexpected error writing referenced layer: %v", err)
+	}
+
+	_, orphaned, _, err := writeRandomLayer(driver, ss.pathMapper, name)
+	if err != nil {
+		t.Fatalf("unexpected error writing orphaned layer: %v", err)
+	}
+
+	// A blob left behind in another repository, without a manifest.
+	_, unreferenced, _, err := writeRandomLayer(driver, ss.pathMapper, "foo/baz")
+	if err != nil {
+		t.Fatalf("unexpected error writing unreferenced layer: %v", err)
+	}
+
+	manifest := Manifest{
+		Versioned: Versioned{
+			SchemaVersion: 1,
+		},
+		Name: name,
+		Tag:  "thetag",
+		FSLayers: []FSLayer{
+			{
+				BlobSum: referenced,
+			},
+		},
+		History: testHistory(1),
+	}
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	sm, err := manifest.Sign(pk)
+	if err != nil {
+		t.Fatalf("error signing manifest: %v", err)
+	}
+
+	if err := ss.Manifests().Put(name, manifest.Tag, sm); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	revision, err := sm.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	report, err := ss.GarbageCollect(true)
+	if err != nil {
+		t.Fatalf("unexpected error during dry run: %v", err)
+	}
+
+	checkGCReport(t, report, []digest.Digest{referenced, revision}, []GCLayerLink{
+		{Name: "foo/baz", Digest: unreferenced},
+		{Name: name, Digest: orphaned},
+	}, []digest.Digest{orphaned, unreferenced})
+
+	// Nothing should have been removed by the dry run.
+	for _, dgst := range []digest.Digest{referenced, orphaned} {
+		checkLayerExists(t, ss, name, dgst, true)
+	}
+	checkLayerExists(t, ss, "foo/baz", unreferenced, true)
+
+	report, err = ss.GarbageCollect(false)
+	if err != nil {
+		t.Fatalf("unexpected error collecting garbage: %v", err)
+	}
+
+	if report.DryRun {
+		t.Fatalf("report should not be marked as a dry run")
+	}
+
+	checkLayerExists(t, ss, name, referenced, true)
+	checkLayerExists(t, ss, name, orphaned, false)
+	checkLayerExists(t, ss, "foo/baz", unreferenced, false)
+
+	for _, dgst := range []digest.Digest{orphaned, unreferenced} {
+		blobPath, err := ss.pathMapper.path(blobPathSpec{digest: dgst})
+		if err != nil {
+			t.Fatalf("unexpected error building blob path: %v", err)
+		}
+
+		if _, err := driver.Stat(blobPath); err == nil {
+			t.Fatalf("blob %v should have been removed", dgst)
+		}
+	}
+
+	// A second run should find nothing to collect.
+	report, err = ss.GarbageCollect(false)
+	if err != nil {
+		t.Fatalf("unexpected error collecting garbage: %v", err)
+	}
+
+	checkGCReport(t, report, []digest.Digest{referenced, revision}, nil, nil)
+}
+
+// TestGarbageCollectAliases ensures that a layer is retained when referenced
+// by the alias of the digest it was linked by, and that the aliases of removed
+// blobs are swept.
+func TestGarbageCollectAliases(t *testing.T) {
+	ss := NewServices(inmemory.New())
+	ls := ss.Layers()
+	name := "foo/bar"
+
+	// Linked by sha256 but referenced by tarsum.
+	_, referencedTarSum, referenced := uploadTestLayer(t, ls, name, true)
+	_, orphanedTarSum, orphaned := uploadTestLayer(t, ls, name, false)
+
+	manifest := Manifest{
+		Versioned: Versioned{
+			SchemaVersion: 1,
+		},
+		Name: name,
+		Tag:  "thetag",
+		FSLayers: []FSLayer{
+			{
+				BlobSum: referencedTarSum,
+			},
+		},
+		History: testHistory(1),
+	}
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	sm, err := manifest.Sign(pk)
+	if err != nil {
+		t.Fatalf("error signing manifest: %v", err)
+	}
+
+	if err := ss.Manifests().Put(name, manifest.Tag, sm); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	revision, err := sm.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	report, err := ss.GarbageCollect(false)
+	if err != nil {
+		t.Fatalf("unexpected error collecting garbage: %v", err)
+	}
+
+	checkGCReport(t, report, []digest.Digest{referenced, revision}, []GCLayerLink{
+		{Name: name, Digest: orphanedTarSum},
+	}, []digest.Digest{orphaned})
+	checkDigests(t, "deleted aliases", report.DeletedAliases, []digest.Digest{orphanedTarSum, orphaned})
+
+	checkLayerExists(t, ss, name, referencedTarSum, true)
+	checkLayerExists(t, ss, name, referenced, true)
+	checkLayerExists(t, ss, name, orphanedTarSum, false)
+
+	if alias, err := ls.Alias(referenced); err != nil || alias != referencedTarSum {
+		t.Fatalf("unexpected alias for retained layer: %v, %v", alias, err)
+	}
+
+	if _, err := ls.Alias(orphaned); err == nil {
+		t.Fatalf("expected alias of removed layer to be swept")
+	}
+}
+
+// TestGarbageCollectUntagged ensures that revisions are retained while
+// referenced by a tag, its history or a retained manifest list, and that
+// other revisions and their layers are collected.
+func TestGarbageCollectUntagged(t *testing.T) {
+	ss := NewServices(inmemory.New())
+	ls := ss.Layers()
+	ms := ss.Manifests()
+	name := "foo/bar"
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	put := func(tag string, layer digest.Digest) digest.Digest {
+		sm := signTestManifest(t, pk, name, tag, layer)
+		if err := ms.Put(name, tag, sm); err != nil {
+			t.Fatalf("unexpected error putting manifest: %v", err)
+		}
+
+		revision, err := sm.Digest()
+		if err != nil {
+			t.Fatalf("unexpected error getting manifest digest: %v", err)
+		}
+
+		return revision
+	}
+
+	// Layers are uploaded by tarsum and stored by their sha256 digest.
+	_, historic, historicBlob := uploadTestLayer(t, ls, name, false)
+	_, current, currentBlob := uploadTestLayer(t, ls, name, false)
+	_, listed, listedBlob := uploadTestLayer(t, ls, name, false)
+	_, deleted, deletedBlob := uploadTestLayer(t, ls, name, false)
+
+	// The first revision of the tag is only referenced by its history.
+	historicRevision := put("latest", historic)
+	currentRevision := put("latest", current)
+
+	// The platform manifest is only referenced by the manifest list once its
+	// tag is deleted.
+	listedRevision := put("amd64", listed)
+	deletedRevision := put("old", deleted)
+
+	signedList, err := (&ManifestList{
+		Versioned: Versioned{
+			SchemaVersion: 1,
+		},
+		MediaType: MediaTypeManifestList,
+		Name:      name,
+		Tag:       "multi",
+		Manifests: []ManifestDescriptor{
+			{Digest: listedRevision, Platform: Platform{Architecture: "amd64", OS: "linux"}},
+		},
+	}).Sign(pk)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest list: %v", err)
+	}
+
+	if err := ms.Put(name, "multi", signedList); err != nil {
+		t.Fatalf("unexpected error putting manifest list: %v", err)
+	}
+
+	listRevision, err := signedList.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest list digest: %v", err)
+	}
+
+	for _, tag := range []string{"amd64", "old"} {
+		if err := ms.Delete(name, tag); err != nil {
+			t.Fatalf("unexpected error deleting tag: %v", err)
+		}
+	}
+
+	report, err := ss.GarbageCollect(false)
+	if err != nil {
+		t.Fatalf("unexpected error collecting garbage: %v", err)
+	}
+
+	checkGCReport(t, report, []digest.Digest{
+		historicRevision, currentRevision, listedRevision, listRevision,
+		historicBlob, currentBlob, listedBlob,
+	}, []GCLayerLink{
+		{Name: name, Digest: deleted},
+	}, []digest.Digest{deletedRevision, deletedBlob})
+
+	if len(report.DeletedRevisionLinks) != 1 || report.DeletedRevisionLinks[0] != (GCRevisionLink{Name: name, Revision: deletedRevision}) {
+		t.Fatalf("unexpected deleted revision links: %v", report.DeletedRevisionLinks)
+	}
+
+	for _, revision := range []digest.Digest{historicRevision, currentRevision, listedRevision, listRevision} {
+		if _, err := ms.GetByDigest(name, revision); err != nil {
+			t.Fatalf("unexpected error fetching retained revision %v: %v", revision, err)
+		}
+	}
+
+	if _, err := ms.GetByDigest(name, deletedRevision); err == nil {
+		t.Fatalf("expected collected revision to be unknown")
+	} else if _, ok := err.(ErrUnknownManifestRevision); !ok {
+		t.Fatalf("unexpected error fetching collected revision: %v", err)
+	}
+
+	checkLayerExists(t, ss, name, deleted, false)
+}
+
+// TestGarbageCollectEmpty ensures that collecting an empty backend succeeds.
+func TestGarbageCollectEmpty(t *testing.T) {
+	report, err := NewServices(inmemory.New()).GarbageCollect(false)
+	if err != nil {
+		t.Fatalf("unexpected error collecting empty backend: %v", err)
+	}
+
+	checkGCReport(t, report, nil, nil, nil)
+}
+
+func checkGCReport(t *testing.T, report *GCReport, marked []digest.Digest, links []GCLayerLink, blobs []digest.Digest) {
+	checkDigests(t, "marked blobs", report.MarkedBlobs, marked)
+	checkDigests(t, "deleted blobs", report.DeletedBlobs, blobs)
+
+	if len(report.DeletedLayerLinks) != len(links) {
+		t.Fatalf("unexpected deleted layer links: %v != %v", report.DeletedLayerLinks, links)
+	}
+
+	for _, link := range links {
+		var found bool
+		for _, deleted := range report.DeletedLayerLinks {
+			if deleted == link {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Fatalf("expected layer link %v to be deleted: %v", link, report.DeletedLayerLinks)
+		}
+	}
+}
+
+// checkDigests ensures that actual and expected contain the same digests, in
+// any order.
+func checkDigests(t *testing.T, what string, actual, expected []digest.Digest) {
+	if len(actual) != len(expected) {
+		t.Fatalf("unexpected %s: %v != %v", what, actual, expected)
+	}
+
+	for _, dgst := range expected {
+		var found bool
+		for _, a := range actual {
+			if a == dgst {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Fatalf("expected %v in %s: %v", dgst, what, actual)
+		}
+	}
+}
+
+func checkLayerExists(t *testing.T, ss *Services, name string, dgst digest.Digest, expected bool) {
+	exists, err := ss.Layers().Exists(name, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error checking layer existence: %v", err)
+	}
+
+	if exists != expected {
+		t.Fatalf("unexpected existence for layer %v in %q: %v != %v", dgst, name, exists, expected)
+	}
+}
+
+// TestGarbageCollectUnmigrated ensures that nothing is collected while
+// content remains stored with an earlier layout, and that repositories with
+// manifest entries that are not recognised are retained.
+func TestGarbageCollectUnmigrated(t *testing.T) {
+	driver := inmemory.New()
+	ss := NewServices(driver)
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	layer, _ := writeBaselineRepository(t, driver, "v2", pk, "foo/bar", "latest")
+
+	if _, err := ss.GarbageCollect(false); err == nil {
+		t.Fatalf("expected garbage collection of unmigrated content to fail")
+	} else if _, ok := err.(ErrMigrationRequired); !ok {
+		t.Fatalf("unexpected error collecting garbage: %v", err)
+	}
+
+	if _, err := ss.Migrate(false); err != nil {
+		t.Fatalf("unexpected error migrating: %v", err)
+	}
+
+	// A manifest stored by tag is not recognised in the current layout.
+	unknown, _ := writeBaselineRepository(t, driver, storagePathVersion, pk, "foo/legacy", "latest")
+
+	report, err := ss.GarbageCollect(false)
+	if err != nil {
+		t.Fatalf("unexpected error collecting garbage: %v", err)
+	}
+
+	if len(report.SkippedRepositories) != 1 || report.SkippedRepositories[0] != "foo/legacy" {
+		t.Fatalf("unexpected skipped repositories: %v", report.SkippedRepositories)
+	}
+
+	if len(report.DeletedLayerLinks) != 0 || len(report.DeletedBlobs) != 0 {
+		t.Fatalf("unexpected content collected: %#v", report)
+	}
+
+	checkLayerExists(t, ss, "foo/bar", layer, true)
+	checkLayerExists(t, ss, "foo/legacy", unknown, true)
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/docker/docker-registry/digest"
+)
+
+// TagRule matches tags by repository name and tag. The expressions should
+// be anchored to match the whole of the name or tag.
+type TagRule struct {
+	// Repository matches the names of the repositories to which the rule
+	// applies. If nil, the rule applies to all repositories.
+	Repository *regexp.Regexp
+
+	// Tag matches the tags to which the rule applies.
+	Tag *regexp.Regexp
+}
+
+// Matches returns true if the rule applies to the tag of the named
+// repository.
+func (rule TagRule) Matches(name, tag string) bool {
+	if rule.Repository != nil && !rule.Repository.MatchString(name) {
+		return false
+	}
+
+	return rule.Tag != nil && rule.Tag.MatchString(tag)
+}
+
+// ErrTagImmutable is returned when a request would change or delete a tag
+// matching an immutability rule.
+type ErrTagImmutable struct {
+	Name string
+	Tag  string
+
+	// Revision is the revision the tag is fixed to.
+	Revision digest.Digest
+}
+
+func (err ErrTagImmutable) Error() string {
+	return fmt.Sprintf("tag %s of %s is immutable and references %s", err.Tag, err.Name, err.Revision)
+}
+
+// checkImmutable returns ErrTagImmutable if the tag matches an immutability
+// rule and already exists, unless it already references revision. Deletes
+// pass an empty revision. Tags that do not exist yet may always be created.
+func (ms *manifestStore) checkImmutable(name, tag string, revision digest.Digest) error {
+	var immutable bool
+	for _, rule := range ms.immutableTags {
+		if rule.Matches(name, tag) {
+			immutable = true
+			break
+		}
+	}
+
+	if !immutable {
+		return nil
+	}
+
+	current, err := ms.resolveTag(name, tag)
+	if err != nil {
+		switch err.(type) {
+		case ErrUnknownManifest:
+			return nil
+		default:
+			return err
+		}
+	}
+
+	if current == revision {
+		// Pushing the same revision again leaves the tag unchanged.
+		return nil
+	}
+
+	return ErrTagImmutable{Name: name, Tag: tag, Revision: current}
+}
@@ -64,6 +64,10 @@ var (
 	// ErrLayerTarSumVersionUnsupported when tarsum is unsupported version.
 	ErrLayerTarSumVersionUnsupported = fmt.Errorf("unsupported tarsum version")
 
+	// ErrLayerDigestUnsupported when the digest algorithm is not accepted
+	// for layers.
+	ErrLayerDigestUnsupported = fmt.Errorf("unsupported layer digest algorithm")
+
 	// ErrLayerUploadUnknown returned when upload is not found.
 	ErrLayerUploadUnknown = fmt.Errorf("layer upload unknown")
 
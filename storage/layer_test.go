@@ -2,7 +2,9 @@ package storage
 
 import (
 	"bytes"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -15,24 +17,719 @@ import (
 	"github.com/docker/docker-registry/storagedriver/inmemory"
 )
 
-// TestSimpleLayerUpload covers the layer upload process, exercising common
-// error paths that might be seen during an upload.
+// TestSimpleLayerUpload runs the upload process with the upload data and state
+// kept on the local filesystem.
 func TestSimpleLayerUpload(t *testing.T) {
-	randomDataReader, tarSumStr, err := testutil.CreateRandomTarFile()
+	uploadStore, err := newTemporaryLocalFSLayerUploadStore()
+	if err != nil {
+		t.Fatalf("error allocating upload store: %v", err)
+	}
 
+	checkSimpleLayerUpload(t, inmemory.New(), uploadStore)
+}
+
+// TestDriverLayerUpload runs the upload process with the upload data and
+// state kept in the storage driver.
+func TestDriverLayerUpload(t *testing.T) {
+	driver := inmemory.New()
+	pm := &pathMapper{
+		root:    "/storage/testing",
+		version: storagePathVersion,
+	}
+
+	checkSimpleLayerUpload(t, driver, newDriverLayerUploadStore(driver, pm))
+}
+
+// TestDriverLayerUploadResume ensures that an upload kept in the storage
+// driver can be resumed by a separate instance sharing the driver.
+func TestDriverLayerUploadResume(t *testing.T) {
+	randomDataReader, tarSumStr, err := testutil.CreateRandomTarFile()
 	if err != nil {
 		t.Fatalf("error creating random reader: %v", err)
 	}
 
 	dgst := digest.Digest(tarSumStr)
 
+	randomDataSize, err := seekerSize(randomDataReader)
+	if err != nil {
+		t.Fatalf("error getting seeker size of random data: %v", err)
+	}
+
+	imageName := "foo/bar"
+	driver := inmemory.New()
+	newLayerStore := func() *layerStore {
+		pm := &pathMapper{
+			root:    "/storage/testing",
+			version: storagePathVersion,
+		}
+
+		return &layerStore{
+			driver:      driver,
+			pathMapper:  pm,
+			uploadStore: newDriverLayerUploadStore(driver, pm),
+		}
+	}
+
+	layerUpload, err := newLayerStore().Upload(imageName)
+	if err != nil {
+		t.Fatalf("unexpected error starting layer upload: %v", err)
+	}
+
+	// Write the first half of the layer through the first instance.
+	half := randomDataSize / 2
+	if _, err := io.CopyN(layerUpload, randomDataReader, half); err != nil {
+		t.Fatalf("unexpected error writing layer data: %v", err)
+	}
+	layerUpload.Close()
+
+	// Resume and complete the upload on a second instance.
+	ls := newLayerStore()
+	layerUpload, err = ls.Resume(layerUpload.UUID())
+	if err != nil {
+		t.Fatalf("unexpected error resuming upload: %v", err)
+	}
+
+	if layerUpload.Offset() != half {
+		t.Fatalf("unexpected offset after resume: %d != %d", layerUpload.Offset(), half)
+	}
+
+	if _, err := io.Copy(layerUpload, randomDataReader); err != nil {
+		t.Fatalf("unexpected error writing layer data: %v", err)
+	}
+
+	layer, err := layerUpload.Finish(randomDataSize, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error finishing layer upload: %v", err)
+	}
+
+	if _, err := ls.Resume(layerUpload.UUID()); err != ErrLayerUploadUnknown {
+		t.Fatalf("expected layer upload to be unknown, got %v", err)
+	}
+
+	if _, err := randomDataReader.Seek(0, os.SEEK_SET); err != nil {
+		t.Fatalf("error resetting random data: %v", err)
+	}
+
+	expected, err := ioutil.ReadAll(randomDataReader)
+	if err != nil {
+		t.Fatalf("error reading random data: %v", err)
+	}
+
+	p, err := ioutil.ReadAll(layer)
+	if err != nil {
+		t.Fatalf("error reading layer: %v", err)
+	}
+
+	if !bytes.Equal(p, expected) {
+		t.Fatalf("layer data not equal after resumed upload")
+	}
+}
+
+// TestLayerUploadDigestState ensures that the digest state is saved with
+// the upload state, so that a resumed upload is verified by sha256, and that
+// uploads without a saved digest state are caught up with the upload data.
+func TestLayerUploadDigestState(t *testing.T) {
+	p := make([]byte, 1<<20)
+	rand.Read(p)
+
+	h := sha256.New()
+	h.Write(p)
+	dgst := digest.NewDigest("sha256", h)
+
+	driver := inmemory.New()
+	pm := &pathMapper{
+		root:    "/storage/testing",
+		version: storagePathVersion,
+	}
+	uploadStore := newDriverLayerUploadStore(driver, pm)
+
+	for _, saveState := range []bool{true, false} {
+		ls := &layerStore{
+			driver:      driver,
+			pathMapper:  pm,
+			uploadStore: uploadStore,
+			algorithms:  map[string]bool{"sha256": true},
+		}
+
+		layerUpload, err := ls.Upload("foo/bar")
+		if err != nil {
+			t.Fatalf("unexpected error starting layer upload: %v", err)
+		}
+
+		if _, err := layerUpload.Write(p[:len(p)/2]); err != nil {
+			t.Fatalf("unexpected error writing layer data: %v", err)
+		}
+		layerUpload.Close()
+
+		lus, err := uploadStore.GetState(layerUpload.UUID())
+		if err != nil {
+			t.Fatalf("unexpected error getting upload state: %v", err)
+		}
+
+		if len(lus.DigestState) == 0 {
+			t.Fatalf("expected digest state to be saved")
+		}
+
+		if !saveState {
+			lus.DigestState = nil
+			if err := uploadStore.SaveState(lus); err != nil {
+				t.Fatalf("unexpected error saving upload state: %v", err)
+			}
+		}
+
+		layerUpload, err = ls.Resume(layerUpload.UUID())
+		if err != nil {
+			t.Fatalf("unexpected error resuming upload: %v", err)
+		}
+
+		if _, err := layerUpload.Write(p[len(p)/2:]); err != nil {
+			t.Fatalf("unexpected error writing layer data: %v", err)
+		}
+
+		layer, err := layerUpload.Finish(int64(len(p)), dgst)
+		if err != nil {
+			t.Fatalf("unexpected error finishing layer upload: %v", err)
+		}
+
+		if layer.Digest() != dgst {
+			t.Fatalf("unexpected layer digest: %v != %v", layer.Digest(), dgst)
+		}
+
+		if err := ls.Delete("foo/bar", dgst); err != nil {
+			t.Fatalf("unexpected error deleting layer: %v", err)
+		}
+	}
+}
+
+// TestLayerUploadTarSumState ensures that the tarsum state is saved when an
+// upload is closed, so that a resumed upload is verified by tarsum and
+// aliased by sha256, and that uploads without a saved tarsum state are
+// caught up with the upload data.
+func TestLayerUploadTarSumState(t *testing.T) {
+	randomDataReader, tarSumStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random reader: %v", err)
+	}
+
+	p, err := ioutil.ReadAll(randomDataReader)
+	if err != nil {
+		t.Fatalf("error reading random data: %v", err)
+	}
+
+	tarSum := digest.Digest(tarSumStr)
+
+	h := sha256.New()
+	h.Write(p)
+	canonical := digest.NewDigest("sha256", h)
+
+	driver := inmemory.New()
+	pm := &pathMapper{
+		root:    "/storage/testing",
+		version: storagePathVersion,
+	}
+	uploadStore := newDriverLayerUploadStore(driver, pm)
+
+	for _, testcase := range []struct {
+		dgst      digest.Digest
+		alias     digest.Digest
+		saveState bool
+	}{
+		{dgst: tarSum, alias: canonical, saveState: true},
+		{dgst: tarSum, alias: canonical, saveState: false},
+		{dgst: canonical, alias: tarSum, saveState: true},
+	} {
+		ls := &layerStore{
+			driver:      driver,
+			pathMapper:  pm,
+			uploadStore: uploadStore,
+		}
+
+		layerUpload, err := ls.Upload("foo/bar")
+		if err != nil {
+			t.Fatalf("unexpected error starting layer upload: %v", err)
+		}
+
+		if _, err := layerUpload.Write(p[:len(p)/2]); err != nil {
+			t.Fatalf("unexpected error writing layer data: %v", err)
+		}
+		layerUpload.Close()
+
+		lus, err := uploadStore.GetState(layerUpload.UUID())
+		if err != nil {
+			t.Fatalf("unexpected error getting upload state: %v", err)
+		}
+
+		tsd, err := restoreTarSumDigester(lus.TarSumState)
+		if err != nil {
+			t.Fatalf("unexpected error restoring tarsum state: %v", err)
+		}
+
+		if tsd.offset != lus.Offset {
+			t.Fatalf("expected tarsum state to be saved at %d, got %d", lus.Offset, tsd.offset)
+		}
+
+		if !testcase.saveState {
+			lus.TarSumState = nil
+			if err := uploadStore.SaveState(lus); err != nil {
+				t.Fatalf("unexpected error saving upload state: %v", err)
+			}
+		}
+
+		layerUpload, err = ls.Resume(layerUpload.UUID())
+		if err != nil {
+			t.Fatalf("unexpected error resuming upload: %v", err)
+		}
+
+		if _, err := layerUpload.Write(p[len(p)/2:]); err != nil {
+			t.Fatalf("unexpected error writing layer data: %v", err)
+		}
+
+		layer, err := layerUpload.Finish(int64(len(p)), testcase.dgst)
+		if err != nil {
+			t.Fatalf("unexpected error finishing layer upload: %v", err)
+		}
+		layerUpload.Close()
+
+		if layer.Digest() != testcase.dgst {
+			t.Fatalf("unexpected layer digest: %v != %v", layer.Digest(), testcase.dgst)
+		}
+
+		alias, err := ls.Alias(testcase.dgst)
+		if err != nil {
+			t.Fatalf("unexpected error getting alias: %v", err)
+		}
+
+		if alias != testcase.alias {
+			t.Fatalf("unexpected alias: %v != %v", alias, testcase.alias)
+		}
+
+		if err := ls.Delete("foo/bar", testcase.dgst); err != nil {
+			t.Fatalf("unexpected error deleting layer: %v", err)
+		}
+	}
+}
+
+// TestContentDigestLayerUpload ensures that layers may be uploaded and
+// fetched by plain content digests, including content that is not a tar
+// archive.
+func TestContentDigestLayerUpload(t *testing.T) {
+	p := make([]byte, 1<<20)
+	rand.Read(p)
+
 	uploadStore, err := newTemporaryLocalFSLayerUploadStore()
 	if err != nil {
 		t.Fatalf("error allocating upload store: %v", err)
 	}
 
-	imageName := "foo/bar"
+	ls := &layerStore{
+		driver: inmemory.New(),
+		pathMapper: &pathMapper{
+			root:    "/storage/testing",
+			version: storagePathVersion,
+		},
+		uploadStore: uploadStore,
+	}
+
+	sha256Hash := sha256.New()
+	sha256Hash.Write(p)
+	sha512Hash := sha512.New()
+	sha512Hash.Write(p)
+
+	for _, dgst := range []digest.Digest{
+		digest.NewDigest("sha256", sha256Hash),
+		digest.NewDigest("sha512", sha512Hash),
+	} {
+		layerUpload, err := ls.Upload("foo/bar")
+		if err != nil {
+			t.Fatalf("unexpected error starting layer upload: %v", err)
+		}
+
+		if _, err := layerUpload.Write(p); err != nil {
+			t.Fatalf("unexpected error writing layer data: %v", err)
+		}
+
+		layer, err := layerUpload.Finish(int64(len(p)), dgst)
+		if err != nil {
+			t.Fatalf("unexpected error finishing layer upload: %v", err)
+		}
+
+		if layer.Digest() != dgst {
+			t.Fatalf("unexpected layer digest: %v != %v", layer.Digest(), dgst)
+		}
+
+		layer, err = ls.Fetch("foo/bar", dgst)
+		if err != nil {
+			t.Fatalf("unexpected error fetching layer: %v", err)
+		}
+
+		fetched, err := ioutil.ReadAll(layer)
+		if err != nil {
+			t.Fatalf("error reading layer: %v", err)
+		}
+
+		if !bytes.Equal(fetched, p) {
+			t.Fatalf("layer data not equal for %v", dgst)
+		}
+	}
+
+	// Upload modified content, which must fail verification.
+	layerUpload, err := ls.Upload("foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error starting layer upload: %v", err)
+	}
+
+	if _, err := layerUpload.Write(p[1:]); err != nil {
+		t.Fatalf("unexpected error writing layer data: %v", err)
+	}
+
+	if _, err := layerUpload.Finish(-1, digest.NewDigest("sha256", sha256Hash)); err == nil {
+		t.Fatalf("expected error finishing upload with modified content")
+	} else if _, ok := err.(ErrLayerInvalidDigest); !ok {
+		t.Fatalf("unexpected error finishing upload with modified content: %v", err)
+	}
+}
+
+// TestLayerUploadDigestAlgorithms ensures that uploads are only completed
+// with the accepted digest algorithms.
+func TestLayerUploadDigestAlgorithms(t *testing.T) {
+	randomDataReader, tarSumStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random reader: %v", err)
+	}
+
+	p, err := ioutil.ReadAll(randomDataReader)
+	if err != nil {
+		t.Fatalf("error reading random data: %v", err)
+	}
+
+	uploadStore, err := newTemporaryLocalFSLayerUploadStore()
+	if err != nil {
+		t.Fatalf("error allocating upload store: %v", err)
+	}
+
+	ls := &layerStore{
+		driver: inmemory.New(),
+		pathMapper: &pathMapper{
+			root:    "/storage/testing",
+			version: storagePathVersion,
+		},
+		uploadStore: uploadStore,
+		algorithms:  map[string]bool{"sha256": true},
+	}
+
+	h := sha256.New()
+	h.Write(p)
+
+	for _, testcase := range []struct {
+		dgst digest.Digest
+		err  error
+	}{
+		{
+			dgst: digest.Digest(tarSumStr),
+			err:  ErrLayerDigestUnsupported,
+		},
+		{
+			dgst: digest.Digest("tarsum+sha256:" + digest.Digest(tarSumStr).Hex()),
+			err:  ErrLayerTarSumVersionUnsupported,
+		},
+		{
+			dgst: digest.NewDigest("sha256", h),
+		},
+	} {
+		layerUpload, err := ls.Upload("foo/bar")
+		if err != nil {
+			t.Fatalf("unexpected error starting layer upload: %v", err)
+		}
+
+		if _, err := layerUpload.Write(p); err != nil {
+			t.Fatalf("unexpected error writing layer data: %v", err)
+		}
+
+		if _, err := layerUpload.Finish(int64(len(p)), testcase.dgst); err != testcase.err {
+			t.Fatalf("unexpected error finishing upload with %v: %v != %v", testcase.dgst, err, testcase.err)
+		}
+
+		layerUpload.Close()
+	}
+}
+
+// TestLayerAlias ensures that a layer uploaded by tarsum is stored once, by
+// its sha256 digest, and may be fetched and deleted by either digest. Layers
+// uploaded by sha256 are only aliased by tarsum if tarsums are accepted.
+func TestLayerAlias(t *testing.T) {
 	driver := inmemory.New()
+	ss := NewServices(driver)
+	ls := ss.Layers()
+
+	p, tarSum, canonical := uploadTestLayer(t, ls, "foo/bar", false)
+
+	if alias, err := ls.Alias(tarSum); err != nil {
+		t.Fatalf("unexpected error getting alias: %v", err)
+	} else if alias != canonical {
+		t.Fatalf("unexpected alias for %v: %v != %v", tarSum, alias, canonical)
+	}
+
+	if alias, err := ls.Alias(canonical); err != nil {
+		t.Fatalf("unexpected error getting alias: %v", err)
+	} else if alias != tarSum {
+		t.Fatalf("unexpected alias for %v: %v != %v", canonical, alias, tarSum)
+	}
+
+	for _, dgst := range []digest.Digest{tarSum, canonical} {
+		layer, err := ls.Fetch("foo/bar", dgst)
+		if err != nil {
+			t.Fatalf("unexpected error fetching layer by %v: %v", dgst, err)
+		}
+
+		fetched, err := ioutil.ReadAll(layer)
+		if err != nil {
+			t.Fatalf("error reading layer: %v", err)
+		}
+
+		if !bytes.Equal(fetched, p) {
+			t.Fatalf("layer data not equal for %v", dgst)
+		}
+	}
+
+	// The blob is only stored by its sha256 digest.
+	for dgst, expected := range map[digest.Digest]bool{tarSum: false, canonical: true} {
+		blobPath, err := ss.pathMapper.path(blobPathSpec{digest: dgst})
+		if err != nil {
+			t.Fatalf("unexpected error building blob path: %v", err)
+		}
+
+		if _, err := driver.Stat(blobPath); (err == nil) != expected {
+			t.Fatalf("unexpected existence of blob %v: %v", dgst, err)
+		}
+	}
+
+	// Uploading the same content by sha256 records the same alias.
+	if _, tarSum2, canonical2 := uploadTestLayerContent(t, ls, "foo/baz", p, canonical); tarSum2 != tarSum || canonical2 != canonical {
+		t.Fatalf("unexpected digests uploading by sha256: %v, %v", tarSum2, canonical2)
+	}
+
+	// Deleting by the alias removes the layer from the repository.
+	if err := ls.Delete("foo/bar", canonical); err != nil {
+		t.Fatalf("unexpected error deleting layer by alias: %v", err)
+	}
+
+	if _, err := ls.Fetch("foo/bar", tarSum); err == nil {
+		t.Fatalf("expected layer to be deleted")
+	} else if _, ok := err.(ErrUnknownLayer); !ok {
+		t.Fatalf("unexpected error fetching deleted layer: %v", err)
+	}
+
+	// Content that is not a tar archive has no alias.
+	content := []byte("not a tar archive")
+	h := sha256.New()
+	h.Write(content)
+	uploadTestLayerContent(t, ls, "foo/bar", content, digest.NewDigest("sha256", h))
+
+	if _, err := ls.Alias(digest.NewDigest("sha256", h)); err == nil {
+		t.Fatalf("expected no alias for non-tar content")
+	} else if _, ok := err.(ErrUnknownLayer); !ok {
+		t.Fatalf("unexpected error getting alias: %v", err)
+	}
+
+	// Layers uploaded when tarsums are not accepted have no alias.
+	ss, err := NewServicesWithOptions(inmemory.New(), Options{DigestAlgorithms: []string{"sha256"}})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	_, tarSum, canonical = uploadTestLayer(t, ss.Layers(), "foo/bar", true)
+	if tarSum != "" {
+		t.Fatalf("unexpected alias for %v when tarsums are not accepted: %v", canonical, tarSum)
+	}
+}
+
+// BenchmarkLayerUploadFinish measures completing an upload by tarsum, in the
+// default configuration, verified with the digest states saved while
+// writing.
+func BenchmarkLayerUploadFinish(b *testing.B) {
+	benchmarkLayerUploadFinish(b, false, true)
+}
+
+// BenchmarkLayerUploadFinishUnsaved measures completing an upload by tarsum
+// without saved digest states, requiring the digests to be caught up with
+// the upload data.
+func BenchmarkLayerUploadFinishUnsaved(b *testing.B) {
+	benchmarkLayerUploadFinish(b, false, false)
+}
+
+// BenchmarkLayerUploadFinishSHA256 measures completing an upload by sha256,
+// verified with the digest state saved while writing.
+func BenchmarkLayerUploadFinishSHA256(b *testing.B) {
+	benchmarkLayerUploadFinish(b, true, true)
+}
+
+// BenchmarkLayerUploadFinishSHA256Unsaved measures completing an upload by
+// sha256 without a saved digest state, requiring the digest to be caught up
+// with the upload data.
+func BenchmarkLayerUploadFinishSHA256Unsaved(b *testing.B) {
+	benchmarkLayerUploadFinish(b, true, false)
+}
+
+// benchmarkLayerUploadFinish writes a random layer then measures the time to
+// resume and finish the upload, by sha256 or tarsum. If saveState is false,
+// the digest states are removed from the upload state before resuming.
+func benchmarkLayerUploadFinish(b *testing.B, bySHA256, saveState bool) {
+	randomDataReader, tarSumStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		b.Fatalf("error creating random reader: %v", err)
+	}
+
+	p, err := ioutil.ReadAll(randomDataReader)
+	if err != nil {
+		b.Fatalf("error reading random data: %v", err)
+	}
+
+	dgst := digest.Digest(tarSumStr)
+	var algorithms map[string]bool
+	if bySHA256 {
+		h := sha256.New()
+		h.Write(p)
+		dgst = digest.NewDigest("sha256", h)
+		algorithms = map[string]bool{"sha256": true}
+	}
+
+	uploadStore, err := newTemporaryLocalFSLayerUploadStore()
+	if err != nil {
+		b.Fatalf("error allocating upload store: %v", err)
+	}
+
+	ls := &layerStore{
+		driver: inmemory.New(),
+		pathMapper: &pathMapper{
+			root:    "/storage/testing",
+			version: storagePathVersion,
+		},
+		uploadStore: uploadStore,
+		algorithms:  algorithms,
+	}
+
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		layerUpload, err := ls.Upload("foo/bar")
+		if err != nil {
+			b.Fatalf("unexpected error starting layer upload: %v", err)
+		}
+
+		if _, err := layerUpload.Write(p); err != nil {
+			b.Fatalf("unexpected error writing layer data: %v", err)
+		}
+		layerUpload.Close()
+
+		if !saveState {
+			lus, err := uploadStore.GetState(layerUpload.UUID())
+			if err != nil {
+				b.Fatalf("unexpected error getting upload state: %v", err)
+			}
+
+			lus.DigestState = nil
+			lus.TarSumState = nil
+			if err := uploadStore.SaveState(lus); err != nil {
+				b.Fatalf("unexpected error saving upload state: %v", err)
+			}
+		}
+
+		// Remove the blob written by the previous iteration.
+		if err := ls.driver.Delete("/storage/testing"); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+				b.Fatalf("unexpected error removing blobs: %v", err)
+			}
+		}
+		b.StartTimer()
+
+		layerUpload, err = ls.Resume(layerUpload.UUID())
+		if err != nil {
+			b.Fatalf("unexpected error resuming upload: %v", err)
+		}
+
+		if _, err := layerUpload.Finish(int64(len(p)), dgst); err != nil {
+			b.Fatalf("unexpected error finishing layer upload: %v", err)
+		}
+		layerUpload.Close()
+	}
+}
+
+// BenchmarkLayerUploadWrite measures writing upload data in the default
+// configuration, calculating both the sha256 digest and the tarsum.
+func BenchmarkLayerUploadWrite(b *testing.B) {
+	benchmarkLayerUploadWrite(b, nil)
+}
+
+// BenchmarkLayerUploadWriteSHA256 measures writing upload data when only
+// sha256 digests are accepted.
+func BenchmarkLayerUploadWriteSHA256(b *testing.B) {
+	benchmarkLayerUploadWrite(b, map[string]bool{"sha256": true})
+}
+
+// benchmarkLayerUploadWrite measures the time to write a random layer to an
+// upload, in chunks, accepting the given digest algorithms.
+func benchmarkLayerUploadWrite(b *testing.B, algorithms map[string]bool) {
+	randomDataReader, _, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		b.Fatalf("error creating random reader: %v", err)
+	}
+
+	p, err := ioutil.ReadAll(randomDataReader)
+	if err != nil {
+		b.Fatalf("error reading random data: %v", err)
+	}
+
+	uploadStore, err := newTemporaryLocalFSLayerUploadStore()
+	if err != nil {
+		b.Fatalf("error allocating upload store: %v", err)
+	}
+
+	ls := &layerStore{
+		driver: inmemory.New(),
+		pathMapper: &pathMapper{
+			root:    "/storage/testing",
+			version: storagePathVersion,
+		},
+		uploadStore: uploadStore,
+		algorithms:  algorithms,
+	}
+
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		layerUpload, err := ls.Upload("foo/bar")
+		if err != nil {
+			b.Fatalf("unexpected error starting layer upload: %v", err)
+		}
+
+		if _, err := io.CopyBuffer(layerUpload, bytes.NewReader(p), make([]byte, 1<<20)); err != nil {
+			b.Fatalf("unexpected error writing layer data: %v", err)
+		}
+		layerUpload.Close()
+
+		b.StopTimer()
+		if err := uploadStore.DeleteState(layerUpload.UUID()); err != nil {
+			b.Fatalf("unexpected error removing layer upload: %v", err)
+		}
+		b.StartTimer()
+	}
+}
+
+// checkSimpleLayerUpload covers the layer upload process using uploadStore,
+// exercising common error paths that might be seen during an upload.
+func checkSimpleLayerUpload(t *testing.T, driver storagedriver.StorageDriver, uploadStore layerUploadStore) {
+	randomDataReader, tarSumStr, err := testutil.CreateRandomTarFile()
+
+	if err != nil {
+		t.Fatalf("error creating random reader: %v", err)
+	}
+
+	dgst := digest.Digest(tarSumStr)
+
+	imageName := "foo/bar"
 
 	ls := &layerStore{
 		driver: driver,
@@ -243,6 +940,122 @@ func TestSimpleLayerRead(t *testing.T) {
 	}
 }
 
+// TestLayerMount ensures that layers can be mounted from one repository into
+// another without copying the blob.
+func TestLayerMount(t *testing.T) {
+	sourceName := "foo/source"
+	targetName := "foo/target"
+	driver := inmemory.New()
+	ls := &layerStore{
+		driver: driver,
+		pathMapper: &pathMapper{
+			root:    "/storage/testing",
+			version: storagePathVersion,
+		},
+	}
+
+	_, tarSum, sha256Digest, err := writeRandomLayer(driver, ls.pathMapper, sourceName)
+	if err != nil {
+		t.Fatalf("unexpected error writing random layer: %v", err)
+	}
+
+	// Mounting from a repository without the layer must fail.
+	if _, err := ls.Mount(sourceName, tarSum, targetName); err == nil {
+		t.Fatalf("expected error mounting layer from repository without it")
+	} else if _, ok := err.(ErrUnknownLayer); !ok {
+		t.Fatalf("unexpected error mounting unknown layer: %v", err)
+	}
+
+	exists, err := ls.Exists(targetName, tarSum)
+	if err != nil {
+		t.Fatalf("unexpected error checking for existence: %v", err)
+	}
+
+	if exists {
+		t.Fatalf("layer should not exist in target repository before mount")
+	}
+
+	layer, err := ls.Mount(targetName, tarSum, sourceName)
+	if err != nil {
+		t.Fatalf("unexpected error mounting layer: %v", err)
+	}
+	defer layer.Close()
+
+	if layer.Name() != targetName {
+		t.Fatalf("unexpected name on mounted layer: %q != %q", layer.Name(), targetName)
+	}
+
+	if layer.Digest() != tarSum {
+		t.Fatalf("unexpected digest on mounted layer: %q != %q", layer.Digest(), tarSum)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, layer); err != nil {
+		t.Fatalf("unexpected error reading mounted layer: %v", err)
+	}
+
+	if digest.NewDigest("sha256", h) != sha256Digest {
+		t.Fatalf("mounted layer content does not match: %q != %q", digest.NewDigest("sha256", h), sha256Digest)
+	}
+
+	// Mounting again should be a no-op.
+	if _, err := ls.Mount(targetName, tarSum, sourceName); err != nil {
+		t.Fatalf("unexpected error mounting layer a second time: %v", err)
+	}
+}
+
+// TestLayerDelete ensures that deleting a layer only unlinks it from the
+// repository, leaving the blob available to other repositories.
+func TestLayerDelete(t *testing.T) {
+	imageName := "foo/bar"
+	otherName := "foo/other"
+	driver := inmemory.New()
+	ls := &layerStore{
+		driver: driver,
+		pathMapper: &pathMapper{
+			root:    "/storage/testing",
+			version: storagePathVersion,
+		},
+	}
+
+	_, tarSum, _, err := writeRandomLayer(driver, ls.pathMapper, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error writing random layer: %v", err)
+	}
+
+	if _, err := ls.Mount(otherName, tarSum, imageName); err != nil {
+		t.Fatalf("unexpected error mounting layer: %v", err)
+	}
+
+	if err := ls.Delete(imageName, tarSum); err != nil {
+		t.Fatalf("unexpected error deleting layer: %v", err)
+	}
+
+	exists, err := ls.Exists(imageName, tarSum)
+	if err != nil {
+		t.Fatalf("unexpected error checking for existence: %v", err)
+	}
+
+	if exists {
+		t.Fatalf("layer should not exist after delete")
+	}
+
+	exists, err = ls.Exists(otherName, tarSum)
+	if err != nil {
+		t.Fatalf("unexpected error checking for existence: %v", err)
+	}
+
+	if !exists {
+		t.Fatalf("layer should still exist in other repository")
+	}
+
+	if err := ls.Delete(imageName, tarSum); err == nil {
+		t.Fatalf("expected error deleting unknown layer")
+	} else if _, ok := err.(ErrUnknownLayer); !ok {
+		t.Fatalf("unexpected error deleting unknown layer: %v", err)
+	}
+}
+
 // writeRandomLayer creates a random layer under name and tarSum using driver
 // and pathMapper. An io.ReadSeeker with the data is returned, along with the
 // sha256 hex digest.
@@ -264,6 +1077,61 @@ func writeRandomLayer(driver storagedriver.StorageDriver, pathMapper *pathMapper
 	return reader, tarSum, randomLayerDigest, err
 }
 
+// uploadTestLayer uploads a random layer through ls by its tarsum, returning
+// the content with its tarsum and sha256 digests.
+func uploadTestLayer(t *testing.T, ls LayerService, name string, bySHA256 bool) (p []byte, tarSum, canonical digest.Digest) {
+	randomDataReader, tarSumStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random reader: %v", err)
+	}
+
+	p, err = ioutil.ReadAll(randomDataReader)
+	if err != nil {
+		t.Fatalf("error reading random data: %v", err)
+	}
+
+	dgst := digest.Digest(tarSumStr)
+	if bySHA256 {
+		h := sha256.New()
+		h.Write(p)
+		dgst = digest.NewDigest("sha256", h)
+	}
+
+	return uploadTestLayerContent(t, ls, name, p, dgst)
+}
+
+// uploadTestLayerContent uploads p through ls as dgst, returning the content
+// with the tarsum and sha256 digests recorded for it.
+func uploadTestLayerContent(t *testing.T, ls LayerService, name string, p []byte, dgst digest.Digest) ([]byte, digest.Digest, digest.Digest) {
+	layerUpload, err := ls.Upload(name)
+	if err != nil {
+		t.Fatalf("unexpected error starting layer upload: %v", err)
+	}
+	defer layerUpload.Close()
+
+	if _, err := layerUpload.Write(p); err != nil {
+		t.Fatalf("unexpected error writing layer data: %v", err)
+	}
+
+	if _, err := layerUpload.Finish(int64(len(p)), dgst); err != nil {
+		t.Fatalf("unexpected error finishing layer upload: %v", err)
+	}
+
+	alias, err := ls.Alias(dgst)
+	if err != nil {
+		if _, ok := err.(ErrUnknownLayer); !ok {
+			t.Fatalf("unexpected error getting alias: %v", err)
+		}
+		return p, "", dgst
+	}
+
+	if dgst.Algorithm() == "sha256" {
+		return p, alias, dgst
+	}
+
+	return p, dgst, alias
+}
+
 // seekerSize seeks to the end of seeker, checks the size and returns it to
 // the original state, returning the size. The state of the seeker should be
 // treated as unknown if an error is returned.
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/docker/pkg/tarsum"
+)
+
+// layerDigestAlgorithm is the tarsum algorithm accepted for layers.
+const layerDigestAlgorithm = "tarsum.v1+sha256"
+
+// layerDigester calculates the canonical sha256 digest of layer data as it is
+// written, so an upload can be verified on completion without reading the
+// data again. The state of the hash is saved with the upload state, allowing
+// the upload to be resumed and completed by any registry instance. If
+// tarsums are accepted, the tarsum of the data is calculated alongside it.
+type layerDigester struct {
+	h hash.Hash
+
+	// tarSum calculates the tarsum of the data, if set.
+	tarSum *tarSumDigester
+}
+
+func newLayerDigester() *layerDigester {
+	return &layerDigester{
+		h: sha256.New(),
+	}
+}
+
+// restoreLayerDigester returns a digester with the hash state saved by
+// state. If no state was saved, such as for uploads started before the state
+// was recorded, nil is returned.
+func restoreLayerDigester(state []byte) (*layerDigester, error) {
+	if len(state) == 0 {
+		return nil, nil
+	}
+
+	ld := newLayerDigester()
+	if err := ld.h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, fmt.Errorf("invalid upload digest state: %v", err)
+	}
+
+	return ld, nil
+}
+
+// Write adds p to the digest. Write never fails.
+func (ld *layerDigester) Write(p []byte) (int, error) {
+	if ld.tarSum != nil {
+		ld.tarSum.Write(p)
+	}
+
+	return ld.h.Write(p)
+}
+
+// Canonical returns the sha256 digest of the data written so far.
+func (ld *layerDigester) Canonical() digest.Digest {
+	return digest.NewDigest("sha256", ld.h)
+}
+
+// state returns the serialized state of the digest, to be restored by
+// restoreLayerDigester.
+func (ld *layerDigester) state() ([]byte, error) {
+	return ld.h.(encoding.BinaryMarshaler).MarshalBinary()
+}
+
+// layerTarSum reads the layer data from rd, returning its tarsum. It is used
+// for archives the tarSumDigester could not follow as they were written. An
+// error is returned if the data is not a tar archive.
+func layerTarSum(rd io.Reader) (digest.Digest, error) {
+	ts, err := tarsum.NewTarSum(rd, true, tarsum.Version1)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		return "", err
+	}
+
+	return digest.ParseDigest(ts.Sum(nil))
+}
@@ -9,6 +9,19 @@ type layerStore struct {
 	driver      storagedriver.StorageDriver
 	pathMapper  *pathMapper
 	uploadStore layerUploadStore
+	algorithms  map[string]bool // accepted digest algorithms, if set
+	quotas      *quotaStore
+}
+
+// acceptsDigestAlgorithm reports whether uploaded layers may be identified by
+// digests of the given algorithm. If no algorithms are set, all supported
+// algorithms are accepted.
+func (ls *layerStore) acceptsDigestAlgorithm(algorithm string) bool {
+	if ls.algorithms == nil {
+		return supportedDigestAlgorithms[algorithm]
+	}
+
+	return ls.algorithms[algorithm]
 }
 
 func (ls *layerStore) Exists(name string, digest digest.Digest) (bool, error) {
@@ -85,6 +98,160 @@ func (ls *layerStore) Resume(uuid string) (LayerUpload, error) {
 	return ls.newLayerUpload(lus), nil
 }
 
+// Mount links the layer identified by digest from the repository from into
+// the repository identified by name. The layer must exist in the source
+// repository, otherwise ErrUnknownLayer is returned. Mounting a layer that
+// is already present in the target repository is a no-op.
+func (ls *layerStore) Mount(name string, digest digest.Digest, from string) (Layer, error) {
+	// Resolving the blob through the source repository's link ensures that
+	// only content accessible under from can be mounted.
+	linked, err := ls.resolveLayerLink(from, digest)
+	if err != nil {
+		switch err := err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			return nil, ErrUnknownLayer{FSLayer{BlobSum: digest}}
+		default:
+			return nil, err
+		}
+	}
+
+	// Layers already available in the repository are not counted again.
+	exists, err := ls.Exists(name, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	if !exists {
+		size, err = ls.layerSize(from, digest)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := ls.quotas.check(name, size); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ls.linkLayer(name, digest, linked); err != nil {
+		return nil, err
+	}
+
+	ls.quotas.add(name, size, 0)
+
+	return ls.Fetch(name, digest)
+}
+
+// Delete removes the layer link for digest from the named repository. If the
+// layer is only linked by the alias of digest, that link is removed. If the
+// layer is not linked into the repository, ErrUnknownLayer is returned.
+func (ls *layerStore) Delete(name string, digest digest.Digest) error {
+	size, err := ls.layerSize(name, digest)
+	if err != nil {
+		return err
+	}
+
+	if err := ls.deleteLayer(name, digest); err != nil {
+		return err
+	}
+
+	ls.quotas.add(name, -size, 0)
+
+	return nil
+}
+
+// deleteLayer removes the layer link for digest, or its alias, from the named
+// repository.
+func (ls *layerStore) deleteLayer(name string, digest digest.Digest) error {
+	err := ls.deleteLayerLink(name, digest)
+	if _, ok := err.(ErrUnknownLayer); !ok {
+		return err
+	}
+
+	alias, aliasErr := ls.Alias(digest)
+	if aliasErr != nil {
+		if _, ok := aliasErr.(ErrUnknownLayer); ok {
+			return err
+		}
+
+		return aliasErr
+	}
+
+	if err := ls.deleteLayerLink(name, alias); err != nil {
+		if _, ok := err.(ErrUnknownLayer); ok {
+			return ErrUnknownLayer{FSLayer{BlobSum: digest}}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Alias returns the other digest identifying the layer content identified by
+// dgst: the sha256 digest of a layer identified by tarsum, or the tarsum of
+// a layer identified by sha256. If no alias is recorded, ErrUnknownLayer is
+// returned.
+func (ls *layerStore) Alias(dgst digest.Digest) (digest.Digest, error) {
+	aliasPath, err := ls.pathMapper.path(aliasPathSpec{digest: dgst})
+	if err != nil {
+		return "", err
+	}
+
+	content, err := ls.driver.GetContent(aliasPath)
+	if err != nil {
+		switch err := err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			return "", ErrUnknownLayer{FSLayer{BlobSum: dgst}}
+		default:
+			return "", err
+		}
+	}
+
+	return digest.ParseDigest(string(content))
+}
+
+// putAlias records dgst and alias as identifying the same content, in both
+// directions.
+func (ls *layerStore) putAlias(dgst, alias digest.Digest) error {
+	for _, pair := range [][2]digest.Digest{{dgst, alias}, {alias, dgst}} {
+		aliasPath, err := ls.pathMapper.path(aliasPathSpec{digest: pair[0]})
+		if err != nil {
+			return err
+		}
+
+		if err := ls.driver.PutContent(aliasPath, []byte(pair[1])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteLayerLink removes the layer link for dgst from the named repository,
+// returning ErrUnknownLayer if it does not exist.
+func (ls *layerStore) deleteLayerLink(name string, dgst digest.Digest) error {
+	layerLinkPath, err := ls.pathMapper.path(layerLinkPathSpec{
+		name:   name,
+		digest: dgst,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := ls.driver.Delete(layerLinkPath); err != nil {
+		switch err := err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			return ErrUnknownLayer{FSLayer{BlobSum: dgst}}
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
 // newLayerUpload allocates a new upload controller with the given state.
 func (ls *layerStore) newLayerUpload(lus LayerUploadState) LayerUpload {
 	return &layerUploadController{
@@ -97,6 +264,42 @@ func (ls *layerStore) newLayerUpload(lus LayerUploadState) LayerUpload {
 // resolveBlobId looks up the blob location in the repositories from a
 // layer/blob link file, returning blob path or an error on failure.
 func (ls *layerStore) resolveBlobPath(name string, dgst digest.Digest) (string, error) {
+	linked, err := ls.resolveLayerLink(name, dgst)
+	if err != nil {
+		return "", err
+	}
+
+	bp := blobPathSpec{digest: linked}
+
+	return ls.pathMapper.path(bp)
+}
+
+// resolveLayerLink returns the digest of the blob linked for dgst in the
+// named repository. If dgst is not linked, the link for its alias is used,
+// if one is recorded.
+func (ls *layerStore) resolveLayerLink(name string, dgst digest.Digest) (digest.Digest, error) {
+	linked, err := ls.readLayerLink(name, dgst)
+	switch err.(type) {
+	case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+	default:
+		return linked, err
+	}
+
+	alias, aliasErr := ls.Alias(dgst)
+	if aliasErr != nil {
+		if _, ok := aliasErr.(ErrUnknownLayer); ok {
+			return "", err
+		}
+
+		return "", aliasErr
+	}
+
+	return ls.readLayerLink(name, alias)
+}
+
+// readLayerLink returns the digest of the blob linked for dgst in the named
+// repository.
+func (ls *layerStore) readLayerLink(name string, dgst digest.Digest) (digest.Digest, error) {
 	pathSpec := layerLinkPathSpec{name: name, digest: dgst}
 	layerLinkPath, err := ls.pathMapper.path(pathSpec)
 
@@ -112,12 +315,47 @@ func (ls *layerStore) resolveBlobPath(name string, dgst digest.Digest) (string,
 	// NOTE(stevvooe): The content of the layer link should match the digest.
 	// This layer of indirection is for name-based content protection.
 
-	linked, err := digest.ParseDigest(string(layerLinkContent))
+	return digest.ParseDigest(string(layerLinkContent))
+}
+
+// layerSize returns the size of the layer identified by dgst in the named
+// repository. Zero is returned if the layer is not linked or its blob is
+// missing.
+func (ls *layerStore) layerSize(name string, dgst digest.Digest) (int64, error) {
+	blobPath, err := ls.resolveBlobPath(name, dgst)
 	if err != nil {
-		return "", err
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			return 0, nil
+		default:
+			return 0, err
+		}
 	}
 
-	bp := blobPathSpec{digest: linked}
+	fi, err := ls.driver.Stat(blobPath)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			return 0, nil
+		default:
+			return 0, err
+		}
+	}
 
-	return ls.pathMapper.path(bp)
+	return fi.Size(), nil
+}
+
+// linkLayer links a valid, written layer blob, stored by blobDigest, into the
+// registry under the named repository as dgst.
+func (ls *layerStore) linkLayer(name string, dgst, blobDigest digest.Digest) error {
+	layerLinkPath, err := ls.pathMapper.path(layerLinkPathSpec{
+		name:   name,
+		digest: dgst,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return ls.driver.PutContent(layerLinkPath, []byte(blobDigest))
 }
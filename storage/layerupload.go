@@ -1,11 +1,13 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	"code.google.com/p/go-uuid/uuid"
 
@@ -26,6 +28,18 @@ type LayerUploadState struct {
 
 	// offset contains the current progress of the upload.
 	Offset int64
+
+	// StartedAt is the time the upload was started.
+	StartedAt time.Time
+
+	// DigestState is the serialized state of the sha256 digest of the data
+	// written up to Offset.
+	DigestState []byte `json:",omitempty"`
+
+	// TarSumState is the serialized state of the tarsum of the data, saved
+	// when the upload is closed rather than on every write. It may cover
+	// less data than Offset, in which case the rest is read to catch up.
+	TarSumState []byte `json:",omitempty"`
 }
 
 // layerUploadController is used to control the various aspects of resumable
@@ -36,6 +50,7 @@ type layerUploadController struct {
 	layerStore  *layerStore
 	uploadStore layerUploadStore
 	fp          layerFile
+	digester    *layerDigester
 	err         error // terminal error, if set, controller is closed
 }
 
@@ -62,6 +77,9 @@ type layerUploadStore interface {
 	GetState(uuid string) (LayerUploadState, error)
 	SaveState(lus LayerUploadState) error
 	DeleteState(uuid string) error
+
+	// List returns the uuids of all uploads in the store.
+	List() ([]string, error)
 }
 
 var _ LayerUpload = &layerUploadController{}
@@ -87,13 +105,11 @@ func (luc *layerUploadController) Offset() int64 {
 // format <algorithm>:<hex digest>.
 func (luc *layerUploadController) Finish(size int64, digest digest.Digest) (Layer, error) {
 
-	// This section is going to be pretty ugly now. We will have to read the
-	// file twice. First, to get the tarsum and checksum. When those are
-	// available, and validated, we will upload it to the blob store and link
-	// it into the repository. In the future, we need to use resumable hash
-	// calculations for tarsum and checksum that can be calculated during the
-	// upload. This will allow us to cut the data directly into a temporary
-	// directory in the storage backend.
+	// The sha256 digest and tarsum of the layer are calculated as the data
+	// is written and saved with the upload state, so they are verified
+	// without reading the data again. Other content digests, and tarsums of
+	// archives the digester could not follow, are calculated by reading the
+	// data on completion.
 
 	fp, err := luc.file()
 
@@ -102,12 +118,31 @@ func (luc *layerUploadController) Finish(size int64, digest digest.Digest) (Laye
 		return nil, err
 	}
 
-	digest, err = luc.validateLayer(fp, size, digest)
+	digest, alias, err := luc.validateLayer(fp, size, digest)
 	if err != nil {
 		return nil, err
 	}
 
-	if nn, err := luc.writeLayer(fp, digest); err != nil {
+	// Layers identified by both a tarsum and a sha256 digest are stored once,
+	// by the sha256 digest, and may be fetched by either.
+	blobDigest := digest
+	if alias != "" && alias.Algorithm() == "sha256" {
+		blobDigest = alias
+	}
+
+	// Layers already available in the repository are not counted again.
+	exists, err := luc.layerStore.Exists(luc.Name(), digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		if err := luc.layerStore.quotas.check(luc.Name(), luc.Offset()); err != nil {
+			return nil, err
+		}
+	}
+
+	if nn, err := luc.writeLayer(fp, blobDigest); err != nil {
 		// Cleanup?
 		return nil, err
 	} else if size >= 0 && nn != size {
@@ -116,17 +151,28 @@ func (luc *layerUploadController) Finish(size int64, digest digest.Digest) (Laye
 		return nil, fmt.Errorf("short write writing layer")
 	}
 
+	if alias != "" {
+		if err := luc.layerStore.putAlias(digest, alias); err != nil {
+			return nil, err
+		}
+	}
+
 	// Yes! We have written some layer data. Let's make it visible. Link the
 	// layer blob into the repository.
-	if err := luc.linkLayer(digest); err != nil {
+	if err := luc.linkLayer(digest, blobDigest); err != nil {
 		return nil, err
 	}
 
+	if !exists {
+		luc.layerStore.quotas.add(luc.Name(), luc.Offset(), 0)
+	}
+
 	// Ok, the upload has completed and finished. Delete the state.
 	if err := luc.uploadStore.DeleteState(luc.UUID()); err != nil {
 		// Can we ignore this error?
 		return nil, err
 	}
+	luc.digester = nil
 
 	return luc.layerStore.Fetch(luc.Name(), digest)
 }
@@ -136,6 +182,7 @@ func (luc *layerUploadController) Cancel() error {
 	if err := luc.layerStore.uploadStore.DeleteState(luc.UUID()); err != nil {
 		return err
 	}
+	luc.digester = nil
 
 	return luc.Close()
 }
@@ -146,6 +193,11 @@ func (luc *layerUploadController) Write(p []byte) (int, error) {
 		return 0, err
 	}
 
+	ld, err := luc.layerDigester()
+	if err != nil {
+		return 0, err
+	}
+
 	n, err := wr.Write(p)
 
 	// Because we expect the reported offset to be consistent with the storage
@@ -168,8 +220,15 @@ func (luc *layerUploadController) Write(p []byte) (int, error) {
 		return 0, err
 	}
 
+	ld.Write(p[:n])
 	luc.LayerUploadState.Offset += int64(n)
 
+	state, err := ld.state()
+	if err != nil {
+		return n, err
+	}
+	luc.LayerUploadState.DigestState = state
+
 	if err := luc.uploadStore.SaveState(luc.LayerUploadState); err != nil {
 		// TODO(stevvooe): This failure case may require more thought.
 		return n, err
@@ -183,13 +242,40 @@ func (luc *layerUploadController) Close() error {
 		return luc.err
 	}
 
+	saveErr := luc.saveTarSumState()
+
 	if luc.fp != nil {
 		luc.err = luc.fp.Close()
 	}
 
+	if luc.err == nil && saveErr != nil {
+		return saveErr
+	}
+
 	return luc.err
 }
 
+// saveTarSumState saves the state of the tarsum with the upload state, if it
+// has progressed since it was restored.
+func (luc *layerUploadController) saveTarSumState() error {
+	if luc.digester == nil || luc.digester.tarSum == nil {
+		return nil
+	}
+
+	state, err := luc.digester.tarSum.state()
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(state, luc.TarSumState) {
+		return nil
+	}
+
+	luc.LayerUploadState.TarSumState = state
+
+	return luc.uploadStore.SaveState(luc.LayerUploadState)
+}
+
 func (luc *layerUploadController) file() (layerFile, error) {
 	if luc.fp != nil {
 		return luc.fp, nil
@@ -224,66 +310,162 @@ func (luc *layerUploadController) reset() {
 	}
 }
 
-// validateLayer runs several checks on the layer file to ensure its validity.
-// This is currently very expensive and relies on fast io and fast seek on the
-// local host. If successful, the latest digest is returned, which should be
-// used over the passed in value.
-func (luc *layerUploadController) validateLayer(fp layerFile, size int64, dgst digest.Digest) (digest.Digest, error) {
-	// First, check the incoming tarsum version of the digest.
-	version, err := tarsum.GetVersionFromTarsum(dgst.String())
+// layerDigester returns the digester for the upload, positioned at the
+// current offset. The digester is restored from the upload state. Uploads
+// started before the digest state was saved, and tarsum states saved before
+// the last write, are caught up by reading the data already written.
+func (luc *layerUploadController) layerDigester() (*layerDigester, error) {
+	if luc.digester != nil {
+		return luc.digester, nil
+	}
+
+	ld, err := restoreLayerDigester(luc.DigestState)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// TODO(stevvooe): Should we push this down into the digest type?
-	switch version {
-	case tarsum.Version1:
-	default:
-		// version 0 and dev, for now.
-		return "", ErrLayerTarSumVersionUnsupported
+	if ld == nil {
+		ld = newLayerDigester()
+		if err := luc.catchUp(ld, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if luc.layerStore.acceptsDigestAlgorithm(layerDigestAlgorithm) {
+		tsd, err := restoreTarSumDigester(luc.TarSumState)
+		if err != nil {
+			return nil, err
+		}
+
+		if tsd.offset > luc.Offset() {
+			// The state is ahead of the data, so start over.
+			tsd = newTarSumDigester()
+		}
+
+		if err := luc.catchUp(tsd, tsd.offset); err != nil {
+			return nil, err
+		}
+
+		ld.tarSum = tsd
 	}
 
-	digestVerifier := digest.NewDigestVerifier(dgst)
-	lengthVerifier := digest.NewLengthVerifier(size)
+	luc.digester = ld
+
+	return ld, nil
+}
+
+// catchUp writes the upload data from offset up to the current offset to w.
+func (luc *layerUploadController) catchUp(w io.Writer, offset int64) error {
+	if offset >= luc.Offset() {
+		return nil
+	}
 
-	// First, seek to the end of the file, checking the size is as expected.
-	end, err := fp.Seek(0, os.SEEK_END)
+	fp, err := luc.file()
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	// Only check size if it is greater than
-	if size >= 0 && end != size {
-		// Fast path length check.
-		return "", ErrLayerInvalidSize{Size: size}
+	if _, err := fp.Seek(offset, os.SEEK_SET); err != nil {
+		return err
 	}
 
-	// Now seek back to start and take care of the digest.
-	if _, err := fp.Seek(0, os.SEEK_SET); err != nil {
-		return "", err
+	if _, err := io.CopyN(w, fp, luc.Offset()-offset); err != nil {
+		luc.reset()
+		return err
 	}
 
-	tr := io.TeeReader(fp, digestVerifier)
+	return nil
+}
+
+// validateLayer checks the size and digest of the uploaded data against the
+// expected values. If successful, the calculated digest is returned, which
+// should be used over the passed in value. If the data has both a tarsum and
+// a sha256 digest, the other of the two is returned as an alias.
+func (luc *layerUploadController) validateLayer(fp layerFile, size int64, dgst digest.Digest) (calculated, alias digest.Digest, err error) {
+	// First, check the incoming algorithm of the digest.
+	if !luc.layerStore.acceptsDigestAlgorithm(dgst.Algorithm()) {
+		// TODO(stevvooe): Should we push this down into the digest type?
+		if version, err := tarsum.GetVersionFromTarsum(dgst.String()); err == nil && version != tarsum.Version1 {
+			// version 0 and dev, for now.
+			return "", "", ErrLayerTarSumVersionUnsupported
+		}
 
-	// Only verify the size if a positive size argument has been passed.
-	if size >= 0 {
-		tr = io.TeeReader(tr, lengthVerifier)
+		return "", "", ErrLayerDigestUnsupported
 	}
 
-	// TODO(stevvooe): This is one of the places we need a Digester write
-	// sink. Instead, its read driven. This migth be okay.
+	// Only check size if it is not negative.
+	if size >= 0 && luc.Offset() != size {
+		return "", "", ErrLayerInvalidSize{Size: size}
+	}
+
+	switch dgst.Algorithm() {
+	case layerDigestAlgorithm, "sha256":
+	default:
+		calculated, err := luc.verifyContentDigest(fp, dgst)
+		return calculated, "", err
+	}
 
-	// Calculate an updated digest with the latest version.
-	dgst, err = digest.FromReader(tr)
+	ld, err := luc.layerDigester()
 	if err != nil {
+		return "", "", err
+	}
+
+	canonical := ld.Canonical()
+
+	if dgst.Algorithm() == "sha256" {
+		if canonical != dgst {
+			return "", "", ErrLayerInvalidDigest{FSLayer{BlobSum: canonical}}
+		}
+
+		if ld.tarSum == nil {
+			// Tarsums are not accepted, so there is no alias to record.
+			return canonical, "", nil
+		}
+
+		tarSum, ok := ld.tarSum.TarSum()
+		if !ok {
+			// The data is not an archive the digester could follow, so the
+			// layer is only available by sha256, without reading the data
+			// again.
+			return canonical, "", nil
+		}
+
+		return canonical, tarSum, nil
+	}
+
+	tarSum, ok := ld.tarSum.TarSum()
+	if !ok {
+		if _, err := fp.Seek(0, os.SEEK_SET); err != nil {
+			return "", "", err
+		}
+
+		var err error
+		if tarSum, err = layerTarSum(fp); err != nil {
+			// The data is not a valid tar archive.
+			return "", "", ErrLayerInvalidDigest{FSLayer{BlobSum: dgst}}
+		}
+	}
+
+	if tarSum != dgst {
+		return "", "", ErrLayerInvalidDigest{FSLayer{BlobSum: tarSum}}
+	}
+
+	return tarSum, canonical, nil
+}
+
+// verifyContentDigest reads the uploaded data, verifying that it matches
+// dgst, a plain content digest such as sha256.
+func (luc *layerUploadController) verifyContentDigest(fp layerFile, dgst digest.Digest) (digest.Digest, error) {
+	if _, err := fp.Seek(0, os.SEEK_SET); err != nil {
 		return "", err
 	}
 
-	if size >= 0 && !lengthVerifier.Verified() {
-		return "", ErrLayerInvalidSize{Size: size}
+	verifier := digest.NewDigestVerifier(dgst)
+	if _, err := io.Copy(verifier, fp); err != nil {
+		return "", err
 	}
 
-	if !digestVerifier.Verified() {
+	if !verifier.Verified() {
 		return "", ErrLayerInvalidDigest{FSLayer{BlobSum: dgst}}
 	}
 
@@ -292,7 +474,9 @@ func (luc *layerUploadController) validateLayer(fp layerFile, size int64, dgst d
 
 // writeLayer actually writes the the layer file into its final destination,
 // identified by dgst. The layer should be validated before commencing the
-// write.
+// write. If the blob already exists, it is left untouched, since the blob
+// store is content addressable and the blob may be linked into other
+// repositories.
 func (luc *layerUploadController) writeLayer(fp layerFile, dgst digest.Digest) (nn int64, err error) {
 	blobPath, err := luc.layerStore.pathMapper.path(blobPathSpec{
 		digest: dgst,
@@ -303,18 +487,29 @@ func (luc *layerUploadController) writeLayer(fp layerFile, dgst digest.Digest) (
 	}
 
 	// Check for existence
-	if _, err := luc.layerStore.driver.Stat(blobPath); err != nil {
-		// TODO(stevvooe): This check is kind of problematic and very racy.
-		switch err := err.(type) {
-		case storagedriver.PathNotFoundError:
-			break // ensure that it doesn't exist.
-		default:
-			// TODO(stevvooe): This isn't actually an error: the blob store is
-			// content addressable and we should just use this to ensure we
-			// have it written. Although, we do need to verify that the
-			// content that is there is the correct length.
+	_, err = luc.layerStore.driver.Stat(blobPath)
+	switch err.(type) {
+	case nil:
+		// The content has been verified against the digest, so the existing
+		// blob holds the same data. A concurrent upload of the same content
+		// may still write the blob, but only with identical data.
+		return luc.Offset(), nil
+	case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+		break // ensure that it doesn't exist.
+	default:
+		return 0, err
+	}
+
+	// Upload data kept in the storage driver can be moved into place,
+	// avoiding a copy through the registry.
+	if dlf, ok := fp.(*driverLayerFile); ok {
+		dlf.reset()
+
+		if err := luc.layerStore.driver.Move(dlf.path, blobPath); err != nil {
 			return 0, err
 		}
+
+		return dlf.size, nil
 	}
 
 	// Seek our local layer file back now.
@@ -329,17 +524,8 @@ func (luc *layerUploadController) writeLayer(fp layerFile, dgst digest.Digest) (
 
 // linkLayer links a valid, written layer blob into the registry under the
 // named repository for the upload controller.
-func (luc *layerUploadController) linkLayer(digest digest.Digest) error {
-	layerLinkPath, err := luc.layerStore.pathMapper.path(layerLinkPathSpec{
-		name:   luc.Name(),
-		digest: digest,
-	})
-
-	if err != nil {
-		return err
-	}
-
-	return luc.layerStore.driver.PutContent(layerLinkPath, []byte(digest))
+func (luc *layerUploadController) linkLayer(digest, blobDigest digest.Digest) error {
+	return luc.layerStore.linkLayer(luc.Name(), digest, blobDigest)
 }
 
 // localFSLayerUploadStore implements a local layerUploadStore. There are some
@@ -375,8 +561,9 @@ func newTemporaryLocalFSLayerUploadStore() (layerUploadStore, error) {
 
 func (llufs *localFSLayerUploadStore) New(name string) (LayerUploadState, error) {
 	lus := LayerUploadState{
-		Name: name,
-		UUID: uuid.New(),
+		Name:      name,
+		UUID:      uuid.New(),
+		StartedAt: time.Now().UTC(),
 	}
 
 	if err := os.Mkdir(llufs.path(lus.UUID, ""), 0755); err != nil {
@@ -450,6 +637,22 @@ func (llufs *localFSLayerUploadStore) DeleteState(uuid string) error {
 	return nil
 }
 
+func (llufs *localFSLayerUploadStore) List() ([]string, error) {
+	fis, err := ioutil.ReadDir(llufs.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var uuids []string
+	for _, fi := range fis {
+		if fi.IsDir() {
+			uuids = append(uuids, fi.Name())
+		}
+	}
+
+	return uuids, nil
+}
+
 func (llufs *localFSLayerUploadStore) path(uuid, file string) string {
 	return filepath.Join(llufs.root, uuid, file)
 }
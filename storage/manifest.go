@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/json"
 
@@ -102,6 +103,11 @@ func (m *Manifest) SignWithChain(key libtrust.PrivateKey, chain []*x509.Certific
 type SignedManifest struct {
 	Manifest
 
+	// List is set if the content is a manifest list rather than an image
+	// manifest. Only the Versioned, Name and Tag fields of Manifest are then
+	// populated.
+	List *ManifestList `json:"-"`
+
 	// Raw is the byte representation of the ImageManifest, used for signature
 	// verification. The value of Raw must be used directly during
 	// serialization, or the signature check will fail. The manifest byte
@@ -121,6 +127,20 @@ func (sm *SignedManifest) Verify() ([]libtrust.PublicKey, error) {
 	return js.Verify()
 }
 
+// signed returns true if the raw manifest has signatures. Unsigned manifests
+// are plain JSON documents.
+func (sm *SignedManifest) signed() bool {
+	var envelope struct {
+		Signatures []json.RawMessage `json:"signatures"`
+	}
+
+	if err := json.Unmarshal(sm.Raw, &envelope); err != nil {
+		return false
+	}
+
+	return len(envelope.Signatures) > 0
+}
+
 // VerifyChains verifies the signature of the signed manifest against the
 // certificate pool returning the list of verified chains. Signatures without
 // an x509 chain are not checked.
@@ -133,6 +153,29 @@ func (sm *SignedManifest) VerifyChains(ca *x509.CertPool) ([][]*x509.Certificate
 	return js.VerifyChains(ca)
 }
 
+// Digest returns the sha256 digest of the manifest payload, which is the
+// content of the manifest without the signatures. The digest identifies the
+// manifest revision and is used to address the manifest content in the
+// registry.
+func (sm *SignedManifest) Digest() (digest.Digest, error) {
+	js, err := libtrust.ParsePrettySignature(sm.Raw, "signatures")
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := js.Payload()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if _, err := h.Write(payload); err != nil {
+		return "", err
+	}
+
+	return digest.NewDigest("sha256", h), nil
+}
+
 // UnmarshalJSON populates a new ImageManifest struct from JSON data.
 func (sm *SignedManifest) UnmarshalJSON(b []byte) error {
 	var manifest Manifest
@@ -140,7 +183,17 @@ func (sm *SignedManifest) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
+	var list ManifestList
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+
 	sm.Manifest = manifest
+	sm.List = nil
+	if list.MediaType == MediaTypeManifestList {
+		sm.List = &list
+	}
+
 	sm.Raw = make([]byte, len(b), len(b))
 	copy(sm.Raw, b)
 
@@ -157,6 +210,10 @@ func (sm *SignedManifest) MarshalJSON() ([]byte, error) {
 	}
 
 	// If the raw data is not available, just dump the inner content.
+	if sm.List != nil {
+		return json.Marshal(sm.List)
+	}
+
 	return json.Marshal(&sm.Manifest)
 }
 
@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"bytes"
+	"io/ioutil"
 	"reflect"
+	"regexp"
 	"testing"
 
 	"github.com/docker/libtrust"
@@ -56,6 +59,7 @@ func TestManifestStorage(t *testing.T) {
 				BlobSum: "qwer",
 			},
 		},
+		History: testHistory(2),
 	}
 
 	pk, err := libtrust.GenerateECP256PrivateKey()
@@ -113,6 +117,333 @@ func TestManifestStorage(t *testing.T) {
 	if tags[0] != tag {
 		t.Fatalf("unexpected tag found in tags: %v != %v", tags, []string{tag})
 	}
+
+	// The manifest should be available by its digest.
+	revision, err := sm.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	fetchedManifest, err = ms.GetByDigest(name, revision)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest by digest: %v", err)
+	}
+
+	if !reflect.DeepEqual(fetchedManifest, sm) {
+		t.Fatalf("fetched manifest not equal: %#v != %#v", fetchedManifest, sm)
+	}
+
+	// Overwrite the tag with a new manifest. The tag should resolve to the
+	// new manifest while the previous one remains available by digest.
+	manifest.FSLayers = manifest.FSLayers[:1]
+	manifest.History = testHistory(1)
+	updated, err := manifest.Sign(pk)
+	if err != nil {
+		t.Fatalf("error signing manifest: %v", err)
+	}
+
+	if err := ms.Put(name, tag, updated); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	fetchedManifest, err = ms.Get(name, tag)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest: %v", err)
+	}
+
+	if !reflect.DeepEqual(fetchedManifest, updated) {
+		t.Fatalf("fetched manifest not equal: %#v != %#v", fetchedManifest, updated)
+	}
+
+	updatedRevision, err := updated.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	checkTagHistory(t, ms, name, tag, revision, updatedRevision)
+
+	// Roll the tag back to the original revision.
+	if err := ms.Retag(name, tag, revision, nil); err != nil {
+		t.Fatalf("unexpected error retagging manifest: %v", err)
+	}
+
+	fetchedManifest, err = ms.Get(name, tag)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest: %v", err)
+	}
+
+	if !reflect.DeepEqual(fetchedManifest, sm) {
+		t.Fatalf("fetched manifest not equal: %#v != %#v", fetchedManifest, sm)
+	}
+
+	checkTagHistory(t, ms, name, tag, revision, updatedRevision, revision)
+
+	// Only revisions from the history of the tag can be used.
+	if err := ms.Retag(name, tag, "sha256:abcdef0919234", nil); true {
+		switch err.(type) {
+		case ErrUnknownManifestRevision:
+			break
+		default:
+			t.Fatalf("expected manifest revision unknown error: %#v", err)
+		}
+	}
+
+	if err := ms.Delete(name, tag); err != nil {
+		t.Fatalf("unexpected error deleting manifest: %v", err)
+	}
+
+	if _, err := ms.Get(name, tag); true {
+		switch err.(type) {
+		case ErrUnknownManifest:
+			break
+		default:
+			t.Fatalf("expected manifest unknown error: %#v", err)
+		}
+	}
+
+	fetchedManifest, err = ms.GetByDigest(name, revision)
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest by digest: %v", err)
+	}
+
+	if !reflect.DeepEqual(fetchedManifest, sm) {
+		t.Fatalf("fetched manifest not equal: %#v != %#v", fetchedManifest, sm)
+	}
+
+	// The revision should not be visible from another repository.
+	if _, err := ms.GetByDigest("foo/other", revision); true {
+		switch err.(type) {
+		case ErrUnknownManifestRevision:
+			break
+		default:
+			t.Fatalf("expected manifest revision unknown error: %#v", err)
+		}
+	}
+}
+
+// TestManifestLayerCollision ensures that manifest revisions and layers
+// with the same sha256 digest do not overwrite each other. A revision is
+// addressed by the digest of its payload, which may be uploaded as a layer.
+func TestManifestLayerCollision(t *testing.T) {
+	ss := NewServices(inmemory.New())
+	ls := ss.Layers()
+	ms := ss.Manifests()
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	_, tarSum, _ := uploadTestLayer(t, ls, "victim/repo", false)
+	sm := signTestManifest(t, pk, "victim/repo", "latest", tarSum)
+	payload, revision := manifestPayload(t, sm)
+
+	if err := ms.Put("victim/repo", "latest", sm); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	// Uploading the payload of the revision as a layer must not replace the
+	// stored manifest.
+	uploadTestLayerContent(t, ls, "attacker/repo", payload, revision)
+
+	fetched, err := ms.Get("victim/repo", "latest")
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest: %v", err)
+	}
+
+	if _, err := fetched.Verify(); err != nil {
+		t.Fatalf("unexpected error verifying fetched manifest: %v", err)
+	}
+
+	checkLayerContent(t, ls, "attacker/repo", revision, payload)
+
+	// Putting a manifest whose payload was already uploaded as a layer must
+	// not replace the layer.
+	sm = signTestManifest(t, pk, "victim/repo", "other", tarSum)
+	payload, revision = manifestPayload(t, sm)
+	uploadTestLayerContent(t, ls, "attacker/repo", payload, revision)
+
+	if err := ms.Put("victim/repo", "other", sm); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	checkLayerContent(t, ls, "attacker/repo", revision, payload)
+
+	if _, err := ms.GetByDigest("victim/repo", revision); err != nil {
+		t.Fatalf("unexpected error fetching manifest by digest: %v", err)
+	}
+}
+
+// manifestPayload returns the signed payload of the manifest and its
+// digest, which identifies the manifest revision.
+func manifestPayload(t *testing.T, sm *SignedManifest) ([]byte, digest.Digest) {
+	js, err := libtrust.ParsePrettySignature(sm.Raw, "signatures")
+	if err != nil {
+		t.Fatalf("unexpected error parsing manifest signature: %v", err)
+	}
+
+	payload, err := js.Payload()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest payload: %v", err)
+	}
+
+	revision, err := sm.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	return payload, revision
+}
+
+// checkLayerContent ensures that the layer identified by dgst in the named
+// repository has the expected content.
+func checkLayerContent(t *testing.T, ls LayerService, name string, dgst digest.Digest, expected []byte) {
+	layer, err := ls.Fetch(name, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error fetching layer %v: %v", dgst, err)
+	}
+	defer layer.Close()
+
+	p, err := ioutil.ReadAll(layer)
+	if err != nil {
+		t.Fatalf("unexpected error reading layer %v: %v", dgst, err)
+	}
+
+	if !bytes.Equal(p, expected) {
+		t.Fatalf("unexpected content of layer %v", dgst)
+	}
+}
+
+// TestImmutableTags ensures that tags matching an immutability rule cannot
+// be changed or deleted once they exist.
+func TestImmutableTags(t *testing.T) {
+	driver := inmemory.New()
+	pm := &pathMapper{
+		root:    "/storage/testing",
+		version: storagePathVersion,
+	}
+
+	layers := newMockedLayerService()
+	ms := &manifestStore{
+		driver:       driver,
+		pathMapper:   pm,
+		layerService: layers,
+		immutableTags: []TagRule{
+			{
+				Repository: regexp.MustCompile(`^foo/.*$`),
+				Tag:        regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+$`),
+			},
+		},
+	}
+
+	// A store without rules, to set up tags before they become immutable.
+	unrestricted := &manifestStore{
+		driver:       driver,
+		pathMapper:   pm,
+		layerService: layers,
+	}
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	for _, name := range []string{"foo/bar", "bar/baz"} {
+		layers.add(name, "asdf")
+		layers.add(name, "qwer")
+	}
+
+	release := signTestManifest(t, pk, "foo/bar", "v1.2.3", "asdf")
+	if err := ms.Put("foo/bar", "v1.2.3", release); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	// Pushing the same revision again is allowed.
+	if err := ms.Put("foo/bar", "v1.2.3", release); err != nil {
+		t.Fatalf("unexpected error putting manifest again: %v", err)
+	}
+
+	revision, err := release.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	checkTagImmutable(t, ms.Put("foo/bar", "v1.2.3", signTestManifest(t, pk, "foo/bar", "v1.2.3", "qwer")), revision)
+	checkTagImmutable(t, ms.Delete("foo/bar", "v1.2.3"), revision)
+	checkTagHistory(t, ms, "foo/bar", "v1.2.3", revision, revision)
+
+	// Tags and repositories not matching the rule are mutable.
+	for _, testcase := range []struct {
+		name, tag string
+	}{
+		{"foo/bar", "latest"},
+		{"foo/bar", "v1.2.3-rc1"},
+		{"bar/baz", "v1.2.3"},
+	} {
+		for _, layer := range []digest.Digest{"asdf", "qwer"} {
+			if err := ms.Put(testcase.name, testcase.tag, signTestManifest(t, pk, testcase.name, testcase.tag, layer)); err != nil {
+				t.Fatalf("unexpected error putting %s:%s: %v", testcase.name, testcase.tag, err)
+			}
+		}
+
+		if err := ms.Delete(testcase.name, testcase.tag); err != nil {
+			t.Fatalf("unexpected error deleting %s:%s: %v", testcase.name, testcase.tag, err)
+		}
+	}
+
+	// A tag that changed before the rule applied cannot be rolled back.
+	previous := signTestManifest(t, pk, "foo/bar", "v2.0.0", "asdf")
+	for _, sm := range []*SignedManifest{previous, signTestManifest(t, pk, "foo/bar", "v2.0.0", "qwer")} {
+		if err := unrestricted.Put("foo/bar", "v2.0.0", sm); err != nil {
+			t.Fatalf("unexpected error putting manifest: %v", err)
+		}
+	}
+
+	previousRevision, err := previous.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	current, err := ms.resolveTag("foo/bar", "v2.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error resolving tag: %v", err)
+	}
+
+	checkTagImmutable(t, ms.Retag("foo/bar", "v2.0.0", previousRevision, nil), current)
+}
+
+// checkTagImmutable ensures that err reports an immutable tag fixed to
+// revision.
+func checkTagImmutable(t *testing.T, err error, revision digest.Digest) {
+	immutableErr, ok := err.(ErrTagImmutable)
+	if !ok {
+		t.Fatalf("expected tag immutable error, got %#v", err)
+	}
+
+	if immutableErr.Revision != revision {
+		t.Fatalf("unexpected revision in error: %s != %s", immutableErr.Revision, revision)
+	}
+}
+
+func checkTagHistory(t *testing.T, ms *manifestStore, name, tag string, expected ...digest.Digest) {
+	history, err := ms.TagHistory(name, tag)
+	if err != nil {
+		t.Fatalf("unexpected error fetching tag history: %v", err)
+	}
+
+	if len(history) != len(expected) {
+		t.Fatalf("unexpected tag history: %v != %v", history, expected)
+	}
+
+	for i, entry := range history {
+		if entry.Revision != expected[i] {
+			t.Fatalf("unexpected revision in tag history at %d: %v != %v", i, entry.Revision, expected[i])
+		}
+
+		if i > 0 && entry.Timestamp.Before(history[i-1].Timestamp) {
+			t.Fatalf("tag history out of order: %v", history)
+		}
+	}
 }
 
 type layerKey struct {
@@ -156,3 +487,15 @@ func (mockedExistenceLayerService) Upload(name string) (LayerUpload, error) {
 func (mockedExistenceLayerService) Resume(uuid string) (LayerUpload, error) {
 	panic("not implemented")
 }
+
+func (mockedExistenceLayerService) Mount(name string, digest digest.Digest, from string) (Layer, error) {
+	panic("not implemented")
+}
+
+func (mockedExistenceLayerService) Delete(name string, digest digest.Digest) error {
+	panic("not implemented")
+}
+
+func (mockedExistenceLayerService) Alias(digest digest.Digest) (digest.Digest, error) {
+	panic("not implemented")
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/libtrust"
+)
+
+// The following are the media types of the manifests served by the
+// registry. Clients accepting manifest lists indicate so with the Accept
+// header.
+const (
+	// MediaTypeManifest identifies a signed image manifest.
+	MediaTypeManifest = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+
+	// MediaTypeManifestList identifies a signed manifest list.
+	MediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v1+prettyjws"
+)
+
+// ManifestList references the image manifests of a tag for each platform.
+// Like image manifests, manifest lists are signed, stored as revisions of
+// their repository and identified by the digest of their payload.
+type ManifestList struct {
+	Versioned
+
+	// MediaType is always MediaTypeManifestList. It distinguishes manifest
+	// lists from image manifests.
+	MediaType string `json:"mediaType"`
+
+	// Name is the name of the list's repository
+	Name string `json:"name"`
+
+	// Tag is the tag of the list
+	Tag string `json:"tag"`
+
+	// Manifests references the image manifest for each platform
+	Manifests []ManifestDescriptor `json:"manifests"`
+}
+
+// ManifestDescriptor references an image manifest, in the repository of the
+// manifest list, by the digest of its revision.
+type ManifestDescriptor struct {
+	// Digest identifies the manifest revision
+	Digest digest.Digest `json:"digest"`
+
+	// Platform is the platform on which the image runs
+	Platform Platform `json:"platform"`
+}
+
+// Platform describes the platform on which an image is intended to run.
+type Platform struct {
+	// Architecture is the cpu architecture, such as amd64 or arm
+	Architecture string `json:"architecture"`
+
+	// OS is the operating system, such as linux
+	OS string `json:"os"`
+
+	// Variant optionally distinguishes versions of the architecture, such as
+	// v7 for arm
+	Variant string `json:"variant,omitempty"`
+}
+
+// String returns the platform as os/architecture, followed by the variant,
+// if set.
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+
+	return s
+}
+
+// Match returns the descriptor of the first manifest for the platform. A
+// platform without a variant matches manifests for any variant.
+func (ml *ManifestList) Match(platform Platform) (ManifestDescriptor, bool) {
+	for _, descriptor := range ml.Manifests {
+		if descriptor.Platform.Architecture != platform.Architecture || descriptor.Platform.OS != platform.OS {
+			continue
+		}
+
+		if platform.Variant != "" && descriptor.Platform.Variant != platform.Variant {
+			continue
+		}
+
+		return descriptor, true
+	}
+
+	return ManifestDescriptor{}, false
+}
+
+// Sign signs the manifest list with the provided private key, returning a
+// SignedManifest with List set.
+func (ml *ManifestList) Sign(pk libtrust.PrivateKey) (*SignedManifest, error) {
+	p, err := json.MarshalIndent(ml, "", "   ")
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := libtrust.NewJSONSignature(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := js.Sign(pk); err != nil {
+		return nil, err
+	}
+
+	pretty, err := js.PrettySignature("signatures")
+	if err != nil {
+		return nil, err
+	}
+
+	var sm SignedManifest
+	if err := json.Unmarshal(pretty, &sm); err != nil {
+		return nil, err
+	}
+
+	return &sm, nil
+}
+
+// verifyManifestList ensures that every manifest referenced by the list is
+// an image manifest known by the named repository, returning an error for
+// each that is not.
+func (ms *manifestStore) verifyManifestList(name string, ml *ManifestList) []error {
+	var errs []error
+
+	if len(ml.Manifests) == 0 {
+		errs = append(errs, fmt.Errorf("manifest list references no manifests"))
+	}
+
+	for _, descriptor := range ml.Manifests {
+		if descriptor.Platform.Architecture == "" || descriptor.Platform.OS == "" {
+			errs = append(errs, fmt.Errorf("manifest %s has an incomplete platform: %s", descriptor.Digest, descriptor.Platform))
+			continue
+		}
+
+		manifest, err := ms.GetByDigest(name, descriptor.Digest)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if manifest.List != nil {
+			errs = append(errs, fmt.Errorf("manifest list cannot reference manifest list %s", descriptor.Digest))
+			continue
+		}
+
+		if manifest.Architecture != "" && manifest.Architecture != descriptor.Platform.Architecture {
+			errs = append(errs, fmt.Errorf("manifest %s is for architecture %s, not %s", descriptor.Digest, manifest.Architecture, descriptor.Platform.Architecture))
+		}
+	}
+
+	return errs
+}
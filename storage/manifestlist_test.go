@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/docker/libtrust"
+
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/docker-registry/storagedriver/inmemory"
+)
+
+// TestManifestList ensures that manifest lists can only reference image
+// manifests in their repository and are returned with the list populated.
+func TestManifestList(t *testing.T) {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	ss, err := NewServicesWithOptions(inmemory.New(), Options{SigningKey: pk})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	ms := ss.Manifests()
+	name := "foo/bar"
+
+	platforms := map[string]Platform{
+		"amd64": {Architecture: "amd64", OS: "linux"},
+		"arm":   {Architecture: "arm", OS: "linux", Variant: "v7"},
+	}
+
+	var descriptors []ManifestDescriptor
+	for tag, platform := range platforms {
+		manifest := Manifest{
+			Versioned: Versioned{
+				SchemaVersion: 1,
+			},
+			Name:         name,
+			Tag:          tag,
+			Architecture: platform.Architecture,
+		}
+
+		sm, err := manifest.Sign(pk)
+		if err != nil {
+			t.Fatalf("unexpected error signing manifest: %v", err)
+		}
+
+		if err := ms.Put(name, tag, sm); err != nil {
+			t.Fatalf("unexpected error putting manifest: %v", err)
+		}
+
+		dgst, err := sm.Digest()
+		if err != nil {
+			t.Fatalf("unexpected error getting manifest digest: %v", err)
+		}
+
+		descriptors = append(descriptors, ManifestDescriptor{Digest: dgst, Platform: platform})
+	}
+
+	list := ManifestList{
+		Versioned: Versioned{
+			SchemaVersion: 1,
+		},
+		MediaType: MediaTypeManifestList,
+		Name:      name,
+		Tag:       "latest",
+		Manifests: descriptors,
+	}
+
+	signedList, err := list.Sign(pk)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest list: %v", err)
+	}
+
+	if err := ms.Put(name, "latest", signedList); err != nil {
+		t.Fatalf("unexpected error putting manifest list: %v", err)
+	}
+
+	fetched, err := ms.Get(name, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest list: %v", err)
+	}
+
+	if fetched.List == nil || len(fetched.List.Manifests) != len(descriptors) {
+		t.Fatalf("expected manifest list to be fetched: %#v", fetched)
+	}
+
+	descriptor, ok := fetched.List.Match(Platform{Architecture: "arm", OS: "linux"})
+	if !ok || descriptor.Platform != platforms["arm"] {
+		t.Fatalf("unexpected match for arm: %#v, %v", descriptor, ok)
+	}
+
+	if _, ok := fetched.List.Match(Platform{Architecture: "arm", OS: "linux", Variant: "v6"}); ok {
+		t.Fatalf("unexpected match for arm v6")
+	}
+
+	// Lists with invalid references are rejected.
+	listDigest, err := signedList.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest list digest: %v", err)
+	}
+
+	for _, invalid := range [][]ManifestDescriptor{
+		nil,
+		{{Digest: digest.Digest("invalid"), Platform: platforms["amd64"]}},
+		{{Digest: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", Platform: platforms["amd64"]}},
+		{{Digest: listDigest, Platform: platforms["amd64"]}},
+		{{Digest: descriptors[0].Digest, Platform: Platform{Architecture: "ppc64le", OS: "linux"}}},
+		{{Digest: descriptors[0].Digest, Platform: Platform{OS: "linux"}}},
+	} {
+		list.Manifests = invalid
+		sm, err := list.Sign(pk)
+		if err != nil {
+			t.Fatalf("unexpected error signing manifest list: %v", err)
+		}
+
+		if err := ms.Put(name, "latest", sm); err == nil {
+			t.Fatalf("expected error putting manifest list referencing %v", invalid)
+		} else if _, ok := err.(ErrManifestVerification); !ok {
+			t.Fatalf("unexpected error putting invalid manifest list: %v", err)
+		}
+	}
+
+	// Copies of the list reference the re-signed manifests.
+	if err := ss.Copy(name, "foo/baz", nil); err != nil {
+		t.Fatalf("unexpected error copying repository: %v", err)
+	}
+
+	copied, err := ms.Get("foo/baz", "latest")
+	if err != nil {
+		t.Fatalf("unexpected error fetching copied manifest list: %v", err)
+	}
+
+	if copied.List == nil || copied.List.Name != "foo/baz" {
+		t.Fatalf("expected manifest list to be copied: %#v", copied)
+	}
+
+	for _, descriptor := range copied.List.Manifests {
+		sm, err := ms.GetByDigest("foo/baz", descriptor.Digest)
+		if err != nil {
+			t.Fatalf("unexpected error fetching copied manifest: %v", err)
+		}
+
+		if sm.Name != "foo/baz" || sm.Architecture != descriptor.Platform.Architecture {
+			t.Fatalf("unexpected manifest referenced by copied list: %#v", sm)
+		}
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"path"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/docker/docker-registry/digest"
 	"github.com/docker/docker-registry/storagedriver"
 	"github.com/docker/libtrust"
 )
@@ -31,6 +34,17 @@ func (err ErrUnknownManifest) Error() string {
 	return fmt.Sprintf("unknown manifest name=%s tag=%s", err.Name, err.Tag)
 }
 
+// ErrUnknownManifestRevision is returned if the manifest revision, identified
+// by digest, is not known by the named repository.
+type ErrUnknownManifestRevision struct {
+	Name     string
+	Revision digest.Digest
+}
+
+func (err ErrUnknownManifestRevision) Error() string {
+	return fmt.Sprintf("unknown manifest name=%s revision=%s", err.Name, err.Revision)
+}
+
 // ErrManifestUnverified is returned when the registry is unable to verify
 // the manifest.
 type ErrManifestUnverified struct{}
@@ -53,10 +67,41 @@ func (errs ErrManifestVerification) Error() string {
 	return fmt.Sprintf("errors verifying manifest: %v", strings.Join(parts, ","))
 }
 
+// TagIndexEntry records a revision referenced by a tag. An entry is added to
+// the tag index each time the tag is updated.
+type TagIndexEntry struct {
+	// Revision is the digest of the manifest revision referenced by the tag.
+	Revision digest.Digest `json:"revision"`
+
+	// Timestamp is the time at which the tag was updated.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Identity identifies the client that updated the tag, if known.
+	Identity string `json:"identity,omitempty"`
+}
+
 type manifestStore struct {
 	driver       storagedriver.StorageDriver
 	pathMapper   *pathMapper
 	layerService LayerService
+	quotas       *quotaStore
+
+	// immutableTags match the tags that may not be changed once they exist.
+	immutableTags []TagRule
+
+	// trustPolicies list the signers trusted for manifests, by repository.
+	trustPolicies []TrustPolicy
+
+	// signingKey signs unsigned manifests when they are put, if
+	// signUnsigned is set.
+	signingKey   libtrust.PrivateKey
+	signUnsigned bool
+
+	// validators are the custom validators run on image manifests.
+	validators []ManifestValidator
+
+	// identity is recorded in the tag index when a tag is updated.
+	identity string
 }
 
 var _ ManifestService = &manifestStore{}
@@ -86,11 +131,16 @@ func (ms *manifestStore) Tags(name string) ([]string, error) {
 		tags = append(tags, filename)
 	}
 
+	sort.Strings(tags)
+
 	return tags, nil
 }
 
 func (ms *manifestStore) Exists(name, tag string) (bool, error) {
-	p, err := ms.path(name, tag)
+	p, err := ms.pathMapper.path(manifestTagCurrentPathSpec{
+		name: name,
+		tag:  tag,
+	})
 	if err != nil {
 		return false, err
 	}
@@ -117,55 +167,245 @@ func (ms *manifestStore) Exists(name, tag string) (bool, error) {
 }
 
 func (ms *manifestStore) Get(name, tag string) (*SignedManifest, error) {
-	p, err := ms.path(name, tag)
+	revision, err := ms.resolveTag(name, tag)
 	if err != nil {
 		return nil, err
 	}
 
-	content, err := ms.driver.GetContent(p)
+	manifest, err := ms.getRevision(revision)
 	if err != nil {
+		return nil, err
+	}
+
+	return ms.mergeSignatures(name, revision, manifest)
+}
+
+func (ms *manifestStore) GetByDigest(name string, dgst digest.Digest) (*SignedManifest, error) {
+	p, err := ms.pathMapper.path(manifestRevisionLinkPathSpec{
+		name:     name,
+		revision: dgst,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the link in the repository before going to the blob store, since
+	// the revision may be present in the blob store under another name.
+	if _, err := ms.driver.Stat(p); err != nil {
 		switch err := err.(type) {
 		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
-			return nil, ErrUnknownManifest{Name: name, Tag: tag}
+			return nil, ErrUnknownManifestRevision{Name: name, Revision: dgst}
 		default:
 			return nil, err
 		}
 	}
 
-	var manifest SignedManifest
+	manifest, err := ms.getRevision(dgst)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := json.Unmarshal(content, &manifest); err != nil {
-		// TODO(stevvooe): Corrupted manifest error?
+	return ms.mergeSignatures(name, dgst, manifest)
+}
+
+func (ms *manifestStore) Put(name, tag string, manifest *SignedManifest) error {
+	// Trust policies apply to the signers of the manifest as submitted, so
+	// that signing an unsigned manifest with the registry key does not make
+	// it trusted.
+	untrusted := ms.checkTrust(name, manifest, false)
+
+	if ms.signUnsigned && !manifest.signed() {
+		if err := ms.sign(manifest); err != nil {
+			return err
+		}
+	}
+
+	revision, err := ms.putRevision(name, tag, manifest, untrusted)
+	if err != nil {
+		return err
+	}
+
+	// Finally, point the tag at the new revision. The previous revision is
+	// still available by digest.
+	return ms.tag(name, tag, revision)
+}
+
+// putRevision verifies the manifest and stores it as a revision of the named
+// repository, returning its digest. The manifest must be verifiable as
+// pushed to tag, but the tag itself is not updated. Untrusted is the result
+// of checking the trust of the manifest as submitted. All manifest revisions
+// are written through putRevision, so that they are subject to the same
+// checks.
+func (ms *manifestStore) putRevision(name, tag string, manifest *SignedManifest, untrusted error) (digest.Digest, error) {
+	if err := ms.verifyManifest(name, tag, manifest, untrusted); err != nil {
+		return "", err
+	}
+
+	revision, err := manifest.Digest()
+	if err != nil {
+		return "", err
+	}
+
+	if err := ms.checkImmutable(name, tag, revision); err != nil {
+		return "", err
+	}
+
+	revisionLinkPath, err := ms.pathMapper.path(manifestRevisionLinkPathSpec{
+		name:     name,
+		revision: revision,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Only new revisions count towards the usage of the repository.
+	var size int64
+	if _, err := ms.driver.Stat(revisionLinkPath); err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			size = int64(len(manifest.Raw))
+		default:
+			return "", err
+		}
+	}
+
+	if size > 0 {
+		if err := ms.quotas.check(name, size); err != nil {
+			return "", err
+		}
+	}
+
+	contentPath, err := ms.pathMapper.path(manifestBlobPathSpec{
+		revision: revision,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Since the content is addressed by the digest of the payload, this will
+	// only replace the signatures of an existing revision. The signatures
+	// are also stored with the repository, so those of earlier pushes are
+	// kept.
+	if err := ms.driver.PutContent(contentPath, manifest.Raw); err != nil {
+		return "", err
+	}
+
+	if err := ms.putSignatures(name, revision, manifest); err != nil {
+		return "", err
+	}
+
+	if err := ms.driver.PutContent(revisionLinkPath, []byte(revision)); err != nil {
+		return "", err
+	}
+
+	ms.quotas.add(name, 0, size)
+
+	return revision, nil
+}
+
+func (ms *manifestStore) TagHistory(name, tag string) ([]TagIndexEntry, error) {
+	if _, err := ms.resolveTag(name, tag); err != nil {
 		return nil, err
 	}
 
-	// TODO(stevvooe): Verify the manifest here?
+	p, err := ms.pathMapper.path(manifestTagIndexPathSpec{
+		name: name,
+		tag:  tag,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	return &manifest, nil
+	entryPaths, err := ms.driver.List(p)
+	if err != nil {
+		switch err := err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// Tags written before the index was introduced have no history.
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	sort.Strings(entryPaths)
+
+	entries := make([]TagIndexEntry, 0, len(entryPaths))
+	for _, entryPath := range entryPaths {
+		content, err := ms.driver.GetContent(entryPath)
+		if err != nil {
+			return nil, err
+		}
+
+		var entry TagIndexEntry
+		if err := json.Unmarshal(content, &entry); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
 }
 
-func (ms *manifestStore) Put(name, tag string, manifest *SignedManifest) error {
-	p, err := ms.path(name, tag)
+func (ms *manifestStore) Retag(name, tag string, revision digest.Digest, admit AdmitFunc) error {
+	history, err := ms.TagHistory(name, tag)
 	if err != nil {
 		return err
 	}
 
-	if err := ms.verifyManifest(name, tag, manifest); err != nil {
+	var found bool
+	for _, entry := range history {
+		if entry.Revision == revision {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return ErrUnknownManifestRevision{Name: name, Revision: revision}
+	}
+
+	if err := ms.checkImmutable(name, tag, revision); err != nil {
+		return err
+	}
+
+	// Make sure the revision is still available before pointing the tag at
+	// it.
+	manifest, err := ms.GetByDigest(name, revision)
+	if err != nil {
 		return err
 	}
 
-	// TODO(stevvooe): Should we get old manifest first? Perhaps, write, then
-	// move to ensure a valid manifest?
+	// The revision may have been admitted under policies that have since
+	// changed, so it is admitted again.
+	if admit != nil {
+		if err := admit(name, tag, manifest); err != nil {
+			return err
+		}
+	}
 
-	return ms.driver.PutContent(p, manifest.Raw)
+	return ms.tag(name, tag, revision)
 }
 
 func (ms *manifestStore) Delete(name, tag string) error {
-	p, err := ms.path(name, tag)
+	if _, err := ms.resolveTag(name, tag); err != nil {
+		return err
+	}
+
+	if err := ms.checkImmutable(name, tag, ""); err != nil {
+		return err
+	}
+
+	p, err := ms.pathMapper.path(manifestTagPathSpec{
+		name: name,
+		tag:  tag,
+	})
 	if err != nil {
 		return err
 	}
 
+	// Only the tag and its history are removed. The revision remains
+	// available by digest until garbage collected, if no longer referenced.
 	if err := ms.driver.Delete(p); err != nil {
 		switch err := err.(type) {
 		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
@@ -178,14 +418,126 @@ func (ms *manifestStore) Delete(name, tag string) error {
 	return nil
 }
 
-func (ms *manifestStore) path(name, tag string) (string, error) {
-	return ms.pathMapper.path(manifestPathSpec{
+// tag points the tag at the revision and records the change in the tag index.
+func (ms *manifestStore) tag(name, tag string, revision digest.Digest) error {
+	return ms.tagAt(name, tag, revision, time.Now())
+}
+
+// tagAt points the tag at the revision, recording the update in the tag
+// index as made at the given time.
+func (ms *manifestStore) tagAt(name, tag string, revision digest.Digest, at time.Time) error {
+	currentPath, err := ms.pathMapper.path(manifestTagCurrentPathSpec{
+		name: name,
+		tag:  tag,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := ms.driver.PutContent(currentPath, []byte(revision)); err != nil {
+		return err
+	}
+
+	entry := TagIndexEntry{
+		Revision:  revision,
+		Timestamp: at.UTC(),
+		Identity:  ms.identity,
+	}
+
+	p, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// Entries are named by the time they are written, zero padded so that
+	// they sort in order.
+	entryPath, err := ms.pathMapper.path(manifestTagIndexEntryPathSpec{
+		name:  name,
+		tag:   tag,
+		entry: fmt.Sprintf("%020d", entry.Timestamp.UnixNano()),
+	})
+	if err != nil {
+		return err
+	}
+
+	return ms.driver.PutContent(entryPath, p)
+}
+
+// resolveTag returns the digest of the revision currently referenced by the
+// tag.
+func (ms *manifestStore) resolveTag(name, tag string) (digest.Digest, error) {
+	p, err := ms.pathMapper.path(manifestTagCurrentPathSpec{
 		name: name,
 		tag:  tag,
 	})
+	if err != nil {
+		return "", err
+	}
+
+	content, err := ms.driver.GetContent(p)
+	if err != nil {
+		switch err := err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			return "", ErrUnknownManifest{Name: name, Tag: tag}
+		default:
+			return "", err
+		}
+	}
+
+	return digest.ParseDigest(string(content))
+}
+
+// getRevision reads the content of the manifest revision. Callers must
+// ensure the revision is linked into the repository before calling.
+func (ms *manifestStore) getRevision(revision digest.Digest) (*SignedManifest, error) {
+	p, err := revisionPath(ms.driver, ms.pathMapper, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ms.driver.GetContent(p)
+	if err != nil {
+		// A missing blob here means the revision link is dangling.
+		return nil, err
+	}
+
+	var manifest SignedManifest
+
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		// TODO(stevvooe): Corrupted manifest error?
+		return nil, err
+	}
+
+	// TODO(stevvooe): Verify the manifest here?
+
+	return &manifest, nil
+}
+
+// revisionPath returns the path of the content of the manifest revision.
+// Revisions stored before manifests were kept apart from layers are found in
+// the blob store.
+func revisionPath(driver storagedriver.StorageDriver, pm *pathMapper, revision digest.Digest) (string, error) {
+	p, err := pm.path(manifestBlobPathSpec{revision: revision})
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := driver.Stat(p); err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			return pm.path(blobPathSpec{digest: revision})
+		default:
+			return "", err
+		}
+	}
+
+	return p, nil
 }
 
-func (ms *manifestStore) verifyManifest(name, tag string, manifest *SignedManifest) error {
+// verifyManifest checks the manifest before it is put to the named repository
+// and tag. Untrusted is the result of checking the trust of the manifest as
+// submitted, reported if its signatures are otherwise valid.
+func (ms *manifestStore) verifyManifest(name, tag string, manifest *SignedManifest, untrusted error) error {
 	// TODO(stevvooe): This verification is present here, but this needs to be
 	// lifted out of the storage infrastructure and moved into a package
 	// oriented towards defining verifiers and reporting them with
@@ -202,10 +554,6 @@ func (ms *manifestStore) verifyManifest(name, tag string, manifest *SignedManife
 		errs = append(errs, fmt.Errorf("tag does not match manifest tag"))
 	}
 
-	// TODO(stevvooe): These pubkeys need to be checked with either Verify or
-	// VerifyWithChains. We need to define the exact source of the CA.
-	// Perhaps, its a configuration value injected into manifest store.
-
 	if _, err := manifest.Verify(); err != nil {
 		switch err {
 		case libtrust.ErrMissingSignatureKey, libtrust.ErrInvalidJSONContent, libtrust.ErrMissingSignatureKey:
@@ -217,6 +565,28 @@ func (ms *manifestStore) verifyManifest(name, tag string, manifest *SignedManife
 				errs = append(errs, err)
 			}
 		}
+	} else if untrusted != nil {
+		errs = append(errs, untrusted)
+	}
+
+	if manifest.List != nil {
+		if len(manifest.FSLayers) > 0 {
+			errs = append(errs, fmt.Errorf("manifest list cannot have layers"))
+		}
+
+		errs = append(errs, ms.verifyManifestList(name, manifest.List)...)
+	} else {
+		for _, validator := range builtinManifestValidators {
+			if err := validator.Validate(name, manifest); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		for _, validator := range ms.validators {
+			if err := validator.Validate(name, manifest); err != nil {
+				errs = append(errs, ErrManifestInvalid{Err: err})
+			}
+		}
 	}
 
 	for _, fsLayer := range manifest.FSLayers {
@@ -0,0 +1,424 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker-registry/storagedriver"
+)
+
+// migrations lists the migrations between storage path versions, in order,
+// with the last converting into storagePathVersion. Any change to the layout
+// that would strand existing content must bump storagePathVersion and add a
+// migration from the previous version here.
+var migrations = []migration{
+	newManifestRevisionsMigration("v2", "v3"),
+}
+
+// migrationCheckpointInterval is the number of items migrated between
+// recording the progress of a migration.
+const migrationCheckpointInterval = 100
+
+// migration converts the content stored with the layout of path version from
+// into the layout of path version to. Content is moved out of the old layout
+// rather than copied, so that a migration does not double the storage used,
+// and no registry may accept writes while a migration runs.
+type migration struct {
+	from, to    string
+	description string
+
+	// items lists the units of work of the migration. Progress is recorded
+	// by item, in lexical order, allowing an interrupted migration to resume
+	// where it left off.
+	items func(mc *migrationContext) ([]string, error)
+
+	// migrate converts a single item into the new layout. An item may be
+	// repeated when a migration is resumed, so this must be idempotent.
+	migrate func(mc *migrationContext, item string) error
+}
+
+// migrationContext provides a migration with access to the old and new
+// layouts.
+type migrationContext struct {
+	driver storagedriver.StorageDriver
+	from   *pathMapper
+	to     *pathMapper
+}
+
+// migrationState is the progress of a migration, stored as json under the
+// new layout.
+type migrationState struct {
+	// Completed is the last item migrated.
+	Completed string `json:"completed,omitempty"`
+
+	// Done is true once all items have been migrated.
+	Done bool `json:"done"`
+
+	// UpdatedAt is the time the progress was last recorded.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ErrMigrationRequired is returned by operations that cannot run safely while
+// content remains stored with the layout of an earlier path version, since
+// they would not see it.
+type ErrMigrationRequired struct {
+	From, To string
+}
+
+func (err ErrMigrationRequired) Error() string {
+	return fmt.Sprintf("content is stored with the layout of path version %s and must be migrated to %s", err.From, err.To)
+}
+
+// MigrationReport describes the migrations run over the storage backend. If
+// DryRun is set, the report is a plan of the migrations that would be run.
+type MigrationReport struct {
+	// DryRun is true if no content was migrated.
+	DryRun bool `json:"dryRun"`
+
+	// Version is the path version used by this registry.
+	Version string `json:"version"`
+
+	// Migrations describes each migration with content to migrate. Content
+	// stored with the layout of an earlier version is migrated through each
+	// later version in turn.
+	Migrations []MigrationStatus `json:"migrations"`
+}
+
+// MigrationStatus describes the progress of a migration between two path
+// versions.
+type MigrationStatus struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Description string `json:"description"`
+
+	// Items is the number of units of work found in the old layout. Items
+	// moved by an earlier, interrupted run are no longer found.
+	Items int `json:"items"`
+
+	// Resumed is the number of items found that were completed by an
+	// earlier, interrupted run, such as content not carried over into the
+	// new layout, which were skipped.
+	Resumed int `json:"resumed"`
+
+	// Pending lists the items that would be migrated by a dry run.
+	Pending []string `json:"pending,omitempty"`
+
+	// Done is true if the migration has completed.
+	Done bool `json:"done"`
+}
+
+// Migrate migrates content stored with the layouts of earlier path versions
+// into the current layout. Each migration records its progress and resumes
+// where it left off if interrupted. If dryRun is true, nothing is written and
+// the report describes the work remaining.
+//
+// Content is moved into the new layout, so a registry using the earlier
+// layout can no longer serve it once migrated. No registry may accept writes
+// during a migration: content pushed to the earlier layout after the
+// migration has passed it would not be carried over.
+func (ss *Services) Migrate(dryRun bool) (*MigrationReport, error) {
+	return runMigrations(ss.driver, ss.pathMapper.root, migrations, dryRun)
+}
+
+// runMigrations runs each migration in turn over the content stored under
+// root.
+func runMigrations(driver storagedriver.StorageDriver, root string, migrations []migration, dryRun bool) (*MigrationReport, error) {
+	report := &MigrationReport{
+		DryRun:  dryRun,
+		Version: storagePathVersion,
+	}
+
+	for _, m := range migrations {
+		mc := newMigrationContext(driver, root, m)
+
+		status, err := mc.run(m, dryRun)
+		if status != nil {
+			report.Migrations = append(report.Migrations, *status)
+		}
+
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// CheckMigrated returns ErrMigrationRequired if content remains stored with
+// the layout of an earlier path version, which is not visible until
+// migrated.
+func (ss *Services) CheckMigrated() error {
+	for _, m := range migrations {
+		pending, err := newMigrationContext(ss.driver, ss.pathMapper.root, m).pending()
+		if err != nil {
+			return err
+		}
+
+		if pending {
+			return ErrMigrationRequired{From: m.from, To: m.to}
+		}
+	}
+
+	return nil
+}
+
+func newMigrationContext(driver storagedriver.StorageDriver, root string, m migration) *migrationContext {
+	return &migrationContext{
+		driver: driver,
+		from:   &pathMapper{root: root, version: m.from},
+		to:     &pathMapper{root: root, version: m.to},
+	}
+}
+
+// pending returns true if the migration has not completed and content is
+// stored with the old layout.
+func (mc *migrationContext) pending() (bool, error) {
+	state, err := mc.getState()
+	if err != nil {
+		return false, err
+	}
+
+	if state.Done {
+		return false, nil
+	}
+
+	fromRoot, err := mc.from.path(versionRootPathSpec{})
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := mc.driver.Stat(fromRoot); err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// run runs or plans the migration, returning its status. If there is no
+// content stored with the old layout, nil is returned.
+func (mc *migrationContext) run(m migration, dryRun bool) (*MigrationStatus, error) {
+	status := &MigrationStatus{
+		From:        m.from,
+		To:          m.to,
+		Description: m.description,
+	}
+
+	state, err := mc.getState()
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Done {
+		status.Done = true
+		return status, nil
+	}
+
+	fromRoot, err := mc.from.path(versionRootPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := mc.driver.Stat(fromRoot); err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// Nothing was stored with the old layout.
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	items, err := m.items(mc)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(items)
+
+	// Skip the items up to and including the last completed.
+	i := 0
+	if state.Completed != "" {
+		i = sort.SearchStrings(items, state.Completed)
+		if i < len(items) && items[i] == state.Completed {
+			i++
+		}
+	}
+
+	status.Items = len(items)
+	status.Resumed = i
+
+	if dryRun {
+		status.Pending = items[i:]
+		return status, nil
+	}
+
+	logrus.Infof("migrate: migrating %d items from %s to %s (%d already migrated)", len(items)-i, m.from, m.to, i)
+
+	for j, item := range items[i:] {
+		if err := m.migrate(mc, item); err != nil {
+			// Record the progress made, so the migration resumes from the
+			// failed item.
+			if err := mc.putState(state); err != nil {
+				logrus.Errorf("migrate: error recording progress: %v", err)
+			}
+
+			return status, err
+		}
+
+		state.Completed = item
+
+		if (j+1)%migrationCheckpointInterval == 0 {
+			if err := mc.putState(state); err != nil {
+				return status, err
+			}
+		}
+	}
+
+	state.Done = true
+	if err := mc.putState(state); err != nil {
+		return status, err
+	}
+
+	status.Done = true
+
+	return status, nil
+}
+
+// getState returns the recorded progress of the migration.
+func (mc *migrationContext) getState() (migrationState, error) {
+	var state migrationState
+
+	statePath, err := mc.to.path(migrationStatePathSpec{from: mc.from.version})
+	if err != nil {
+		return state, err
+	}
+
+	p, err := mc.driver.GetContent(statePath)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// The migration has not been started.
+			return state, nil
+		default:
+			return state, err
+		}
+	}
+
+	if err := json.Unmarshal(p, &state); err != nil {
+		return state, err
+	}
+
+	return state, nil
+}
+
+// putState records the progress of the migration.
+func (mc *migrationContext) putState(state migrationState) error {
+	statePath, err := mc.to.path(migrationStatePathSpec{from: mc.from.version})
+	if err != nil {
+		return err
+	}
+
+	state.UpdatedAt = time.Now().UTC()
+
+	p, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return mc.driver.PutContent(statePath, p)
+}
+
+// newRewriteMigration returns a migration for layout changes that only move
+// content. Each file of the old layout is moved to the path returned by
+// rewrite, with both paths relative to the root of their layout, using the
+// Move operation of the driver so that data is not copied where the driver
+// can avoid it. If rewrite returns an empty path, the file is not carried
+// over and is left in the old layout. The progress of earlier migrations,
+// under the migrations directory, is never moved.
+func newRewriteMigration(from, to, description string, rewrite func(p string) (string, error)) migration {
+	return migration{
+		from:        from,
+		to:          to,
+		description: description,
+		items:       layoutItems,
+		migrate: func(mc *migrationContext, item string) error {
+			rewritten, err := rewrite(item)
+			if err != nil {
+				return err
+			}
+
+			if rewritten == "" {
+				return nil
+			}
+
+			return mc.move(item, rewritten)
+		},
+	}
+}
+
+// layoutItems lists the files of the old layout, relative to its root. The
+// progress of earlier migrations, under the migrations directory, is not
+// listed.
+func layoutItems(mc *migrationContext) ([]string, error) {
+	fromRoot, err := mc.from.path(versionRootPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+
+	var items []string
+	err = walk(mc.driver, fromRoot, func(fileInfo storagedriver.FileInfo) error {
+		item := strings.TrimPrefix(fileInfo.Path(), fromRoot+"/")
+
+		if fileInfo.IsDir() {
+			if item == "migrations" {
+				return errSkipDir
+			}
+
+			return nil
+		}
+
+		items = append(items, item)
+		return nil
+	})
+
+	return items, err
+}
+
+// move moves the file at item, relative to the root of the old layout, to
+// rewritten, relative to the root of the new layout.
+func (mc *migrationContext) move(item, rewritten string) error {
+	fromRoot, err := mc.from.path(versionRootPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	toRoot, err := mc.to.path(versionRootPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	dest := path.Join(toRoot, rewritten)
+	if err := mc.driver.Move(path.Join(fromRoot, item), dest); err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// The item was moved by an earlier run that was interrupted
+			// before recording its progress.
+			if _, statErr := mc.driver.Stat(dest); statErr == nil {
+				return nil
+			}
+		}
+
+		return err
+	}
+
+	return nil
+}
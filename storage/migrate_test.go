@@ -0,0 +1,396 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker-registry/common/testutil"
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/docker-registry/storagedriver"
+	"github.com/docker/docker-registry/storagedriver/inmemory"
+	"github.com/docker/libtrust"
+)
+
+// TestMigrate runs a migration that moves the blob store, ensuring that it
+// can be planned, is resumed after a failure, moves rather than copies
+// content and is only run once.
+func TestMigrate(t *testing.T) {
+	driver := inmemory.New()
+	root := "/storage/testing"
+
+	content := map[string]string{
+		"blob/sha256/ab/abcdef":                        "blob one",
+		"blob/sha256/cd/cdef01":                        "blob two",
+		"repositories/foo/bar/layers/sha256/ab/abcdef": "sha256:abcdef",
+		"uploads/removed/data":                         "dropped by the migration",
+	}
+
+	for p, c := range content {
+		if err := driver.PutContent(path.Join(root, "old", p), []byte(c)); err != nil {
+			t.Fatalf("unexpected error writing content: %v", err)
+		}
+	}
+
+	fail := "blob/sha256/cd/cdef01"
+	m := newRewriteMigration("old", "new", "move blob to blobs", func(p string) (string, error) {
+		if p == fail {
+			fail = ""
+			return "", fmt.Errorf("interrupted")
+		}
+
+		if strings.HasPrefix(p, "uploads/") {
+			return "", nil
+		}
+
+		if strings.HasPrefix(p, "blob/") {
+			return "blobs/" + strings.TrimPrefix(p, "blob/"), nil
+		}
+
+		return p, nil
+	})
+
+	// A dry run plans all items and writes nothing.
+	report, err := runMigrations(driver, root, []migration{m}, true)
+	if err != nil {
+		t.Fatalf("unexpected error planning migration: %v", err)
+	}
+
+	checkMigrationStatus(t, report, MigrationStatus{Items: 4, Pending: []string{
+		"blob/sha256/ab/abcdef",
+		"blob/sha256/cd/cdef01",
+		"repositories/foo/bar/layers/sha256/ab/abcdef",
+		"uploads/removed/data",
+	}})
+
+	if _, err := driver.List(path.Join(root, "new")); err == nil {
+		t.Fatalf("dry run should not write the new layout")
+	}
+
+	// The migration fails on the second item, recording its progress.
+	if _, err := runMigrations(driver, root, []migration{m}, false); err == nil {
+		t.Fatalf("expected migration to fail")
+	}
+
+	report, err = runMigrations(driver, root, []migration{m}, true)
+	if err != nil {
+		t.Fatalf("unexpected error planning migration: %v", err)
+	}
+
+	// The first item has been moved out of the old layout.
+	checkMigrationStatus(t, report, MigrationStatus{Items: 3, Pending: []string{
+		"blob/sha256/cd/cdef01",
+		"repositories/foo/bar/layers/sha256/ab/abcdef",
+		"uploads/removed/data",
+	}})
+
+	// Resuming completes the migration.
+	report, err = runMigrations(driver, root, []migration{m}, false)
+	if err != nil {
+		t.Fatalf("unexpected error resuming migration: %v", err)
+	}
+
+	checkMigrationStatus(t, report, MigrationStatus{Items: 3, Done: true})
+
+	for p, expected := range map[string]string{
+		"blobs/sha256/ab/abcdef":                       "blob one",
+		"blobs/sha256/cd/cdef01":                       "blob two",
+		"repositories/foo/bar/layers/sha256/ab/abcdef": "sha256:abcdef",
+	} {
+		c, err := driver.GetContent(path.Join(root, "new", p))
+		if err != nil {
+			t.Fatalf("unexpected error reading migrated content %q: %v", p, err)
+		}
+
+		if string(c) != expected {
+			t.Fatalf("unexpected migrated content for %q: %q != %q", p, c, expected)
+		}
+	}
+
+	if _, err := driver.GetContent(path.Join(root, "new", "uploads/removed/data")); err == nil {
+		t.Fatalf("dropped content should not be migrated")
+	}
+
+	// Migrated content is moved out of the old layout, while content that
+	// is not carried over is left in place.
+	if _, err := driver.GetContent(path.Join(root, "old", "blob/sha256/ab/abcdef")); err == nil {
+		t.Fatalf("migrated content should be removed from the old layout")
+	}
+
+	if _, err := driver.GetContent(path.Join(root, "old", "uploads/removed/data")); err != nil {
+		t.Fatalf("unexpected error reading dropped content: %v", err)
+	}
+
+	// An item moved by a run interrupted before recording its progress is
+	// skipped.
+	mc := &migrationContext{
+		driver: driver,
+		from:   &pathMapper{root: root, version: "old"},
+		to:     &pathMapper{root: root, version: "new"},
+	}
+
+	if err := m.migrate(mc, "blob/sha256/ab/abcdef"); err != nil {
+		t.Fatalf("unexpected error migrating moved item: %v", err)
+	}
+
+	// A completed migration is not run again.
+	report, err = runMigrations(driver, root, []migration{m}, false)
+	if err != nil {
+		t.Fatalf("unexpected error rerunning migration: %v", err)
+	}
+
+	checkMigrationStatus(t, report, MigrationStatus{Done: true})
+}
+
+// TestMigrateEmpty ensures that migrations without content in the old layout
+// are skipped.
+func TestMigrateEmpty(t *testing.T) {
+	m := newRewriteMigration("old", "new", "nothing to move", func(p string) (string, error) {
+		return p, nil
+	})
+
+	report, err := runMigrations(inmemory.New(), "/storage/testing", []migration{m}, false)
+	if err != nil {
+		t.Fatalf("unexpected error running migration: %v", err)
+	}
+
+	if len(report.Migrations) != 0 {
+		t.Fatalf("unexpected migrations: %v", report.Migrations)
+	}
+}
+
+func checkMigrationStatus(t *testing.T, report *MigrationReport, expected MigrationStatus) {
+	if len(report.Migrations) != 1 {
+		t.Fatalf("unexpected migrations: %v", report.Migrations)
+	}
+
+	status := report.Migrations[0]
+	if status.From != "old" || status.To != "new" {
+		t.Fatalf("unexpected migration versions: %v -> %v", status.From, status.To)
+	}
+
+	if status.Items != expected.Items || status.Resumed != expected.Resumed || status.Done != expected.Done {
+		t.Fatalf("unexpected migration status: %#v != %#v", status, expected)
+	}
+
+	if strings.Join(status.Pending, ",") != strings.Join(expected.Pending, ",") {
+		t.Fatalf("unexpected pending items: %v != %v", status.Pending, expected.Pending)
+	}
+}
+
+// TestMigrateManifestRevisions ensures that repositories stored with the v2
+// layout, with each tag stored as a manifest file, can be read once
+// migrated.
+func TestMigrateManifestRevisions(t *testing.T) {
+	driver := inmemory.New()
+	ss := NewServices(driver)
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	name := "foo/bar"
+	layer, sm := writeBaselineRepository(t, driver, "v2", pk, name, "latest")
+
+	// Until migrated, the repository is not visible.
+	if _, err := ss.Manifests().Get(name, "latest"); err == nil {
+		t.Fatalf("expected error fetching unmigrated manifest")
+	}
+
+	if _, err := ss.Migrate(false); err != nil {
+		t.Fatalf("unexpected error migrating: %v", err)
+	}
+
+	ms := ss.Manifests()
+	tags, err := ms.Tags(name)
+	if err != nil {
+		t.Fatalf("unexpected error listing tags: %v", err)
+	}
+
+	if !reflect.DeepEqual(tags, []string{"latest"}) {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+
+	revision, err := sm.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	fetched, err := ms.Get(name, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error fetching migrated manifest: %v", err)
+	}
+
+	if dgst, err := fetched.Digest(); err != nil || dgst != revision {
+		t.Fatalf("unexpected migrated manifest: %v, %v", dgst, err)
+	}
+
+	if _, err := ms.GetByDigest(name, revision); err != nil {
+		t.Fatalf("unexpected error fetching migrated manifest by digest: %v", err)
+	}
+
+	checkTagHistory(t, ms.(*manifestStore), name, "latest", revision)
+
+	if _, err := ss.Layers().Fetch(name, layer); err != nil {
+		t.Fatalf("unexpected error fetching migrated layer: %v", err)
+	}
+}
+
+// writeBaselineRepository writes a repository with the v2 layout, holding a
+// single layer, referenced by a manifest signed with pk under each of tags.
+// The repository is written under the root of the given path version, so
+// that the layout may also be written where it is not expected. The tarsum
+// of the layer is returned with the last manifest.
+func writeBaselineRepository(t *testing.T, driver storagedriver.StorageDriver, version string, pk libtrust.PrivateKey, name string, tags ...string) (digest.Digest, *SignedManifest) {
+	rs, tarSumStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("unexpected error creating random layer: %v", err)
+	}
+
+	p, err := ioutil.ReadAll(rs)
+	if err != nil {
+		t.Fatalf("unexpected error reading random layer: %v", err)
+	}
+
+	layer := digest.Digest(tarSumStr)
+	hex := layer.Hex()
+	root := path.Join("/docker/registry", version)
+
+	content := map[string][]byte{
+		path.Join(root, "blob/tarsum/v1/sha256", hex[:2], hex):                         p,
+		path.Join(root, "repositories", name, "layers/tarsum/v1/sha256", hex[:2], hex): []byte(layer),
+	}
+
+	var sm *SignedManifest
+	for _, tag := range tags {
+		sm = signTestManifest(t, pk, name, tag, layer)
+		content[path.Join(root, "repositories", name, "manifests", tag)] = sm.Raw
+	}
+
+	for p, c := range content {
+		if err := driver.PutContent(p, c); err != nil {
+			t.Fatalf("unexpected error writing %s: %v", p, err)
+		}
+	}
+
+	return layer, sm
+}
+
+// TestMigrateManifestRevisionsResume runs the registered migrations over a
+// repository stored with the v2 layout, ensuring that they can be planned,
+// resume after being interrupted and leave the repository readable.
+func TestMigrateManifestRevisionsResume(t *testing.T) {
+	driver := &interruptingDriver{StorageDriver: inmemory.New()}
+	ss := NewServices(driver)
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	name := "foo/bar"
+	layer, _ := writeBaselineRepository(t, driver, "v2", pk, name, "latest", "stable")
+
+	hex := layer.Hex()
+	items := []string{
+		path.Join("blob/tarsum/v1/sha256", hex[:2], hex),
+		path.Join("repositories", name, "layers/tarsum/v1/sha256", hex[:2], hex),
+		path.Join("repositories", name, "manifests/latest"),
+		path.Join("repositories", name, "manifests/stable"),
+	}
+
+	report, err := ss.Migrate(true)
+	if err != nil {
+		t.Fatalf("unexpected error planning migration: %v", err)
+	}
+
+	checkRegisteredMigration(t, report, MigrationStatus{Items: 4, Pending: items})
+
+	if _, err := driver.List("/docker/registry/" + storagePathVersion); err == nil {
+		t.Fatalf("dry run should not write the new layout")
+	}
+
+	// The migration is interrupted after storing the first manifest by
+	// revision, before removing it from the old layout.
+	driver.interrupt = path.Join("/docker/registry/v2", items[2])
+	if _, err := ss.Migrate(false); err == nil {
+		t.Fatalf("expected migration to fail")
+	}
+
+	report, err = ss.Migrate(true)
+	if err != nil {
+		t.Fatalf("unexpected error planning migration: %v", err)
+	}
+
+	checkRegisteredMigration(t, report, MigrationStatus{Items: 2, Pending: items[2:]})
+
+	report, err = ss.Migrate(false)
+	if err != nil {
+		t.Fatalf("unexpected error resuming migration: %v", err)
+	}
+
+	checkRegisteredMigration(t, report, MigrationStatus{Items: 2, Done: true})
+
+	ms := ss.Manifests()
+	for _, tag := range []string{"latest", "stable"} {
+		sm, err := ms.Get(name, tag)
+		if err != nil {
+			t.Fatalf("unexpected error fetching migrated manifest %s: %v", tag, err)
+		}
+
+		revision, err := sm.Digest()
+		if err != nil {
+			t.Fatalf("unexpected error getting manifest digest: %v", err)
+		}
+
+		// Storing the interrupted manifest again does not add to its
+		// history.
+		checkTagHistory(t, ms.(*manifestStore), name, tag, revision)
+	}
+
+	checkLayerExists(t, ss, name, layer, true)
+
+	if err := ss.CheckMigrated(); err != nil {
+		t.Fatalf("unexpected error checking migration: %v", err)
+	}
+}
+
+// checkRegisteredMigration checks the status of the only registered
+// migration in report.
+func checkRegisteredMigration(t *testing.T, report *MigrationReport, expected MigrationStatus) {
+	if len(report.Migrations) != 1 {
+		t.Fatalf("unexpected migrations: %v", report.Migrations)
+	}
+
+	status := report.Migrations[0]
+	if status.From != "v2" || status.To != storagePathVersion {
+		t.Fatalf("unexpected migration versions: %v -> %v", status.From, status.To)
+	}
+
+	if status.Items != expected.Items || status.Resumed != expected.Resumed || status.Done != expected.Done {
+		t.Fatalf("unexpected migration status: %#v != %#v", status, expected)
+	}
+
+	if strings.Join(status.Pending, ",") != strings.Join(expected.Pending, ",") {
+		t.Fatalf("unexpected pending items: %v != %v", status.Pending, expected.Pending)
+	}
+}
+
+// interruptingDriver fails the first attempt to delete the path interrupt.
+type interruptingDriver struct {
+	storagedriver.StorageDriver
+	interrupt string
+}
+
+func (d *interruptingDriver) Delete(p string) error {
+	if p == d.interrupt {
+		d.interrupt = ""
+		return fmt.Errorf("interrupted")
+	}
+
+	return d.StorageDriver.Delete(p)
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/docker/docker-registry/common"
+	"github.com/docker/docker-registry/storagedriver"
+)
+
+// newManifestRevisionsMigration returns the migration from the layout
+// storing each tag as a manifest file, at
+// repositories/<name>/manifests/<tag>, to the layout storing manifests by
+// revision, with tags linking to the current revision. Each tagged manifest
+// is stored as a revision and its tag is pointed at it. All other content is
+// moved as is.
+func newManifestRevisionsMigration(from, to string) migration {
+	m := newRewriteMigration(from, to, "store manifests by revision, with tags linking to the current revision", func(p string) (string, error) {
+		return p, nil
+	})
+
+	move := m.migrate
+	m.migrate = func(mc *migrationContext, item string) error {
+		name, tag, ok := parseTaggedManifestItem(item)
+		if !ok {
+			return move(mc, item)
+		}
+
+		return mc.migrateTaggedManifest(name, tag, item)
+	}
+
+	return m
+}
+
+// parseTaggedManifestItem returns the repository name and tag of a manifest
+// stored by tag, given its path relative to the root of the layout. Paths of
+// any other content are not matched.
+func parseTaggedManifestItem(item string) (name, tag string, ok bool) {
+	if !strings.HasPrefix(item, "repositories/") {
+		return "", "", false
+	}
+
+	dir, tag := path.Split(item)
+	if !strings.HasSuffix(dir, "/manifests/") {
+		return "", "", false
+	}
+
+	name = strings.TrimSuffix(strings.TrimPrefix(dir, "repositories/"), "/manifests/")
+	if common.ValidateRespositoryName(name) != nil || common.TagNameRegexp.FindString(tag) != tag {
+		return "", "", false
+	}
+
+	return name, tag, true
+}
+
+// migrateTaggedManifest stores the manifest at item, relative to the root of
+// the old layout, as a revision of the named repository in the new layout,
+// pointing the tag at it, and then removes it from the old layout. The tag
+// index records the update as made when the manifest was last written.
+func (mc *migrationContext) migrateTaggedManifest(name, tag, item string) error {
+	fromRoot, err := mc.from.path(versionRootPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	oldPath := path.Join(fromRoot, item)
+
+	ms := &manifestStore{
+		driver:     mc.driver,
+		pathMapper: mc.to,
+	}
+
+	fi, err := mc.driver.Stat(oldPath)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// The manifest was migrated by an earlier run that was
+			// interrupted before recording its progress.
+			if _, err := ms.resolveTag(name, tag); err == nil {
+				return nil
+			}
+		}
+
+		return err
+	}
+
+	p, err := mc.driver.GetContent(oldPath)
+	if err != nil {
+		return err
+	}
+
+	var manifest SignedManifest
+	if err := json.Unmarshal(p, &manifest); err != nil {
+		return err
+	}
+
+	revision, err := manifest.Digest()
+	if err != nil {
+		return err
+	}
+
+	contentPath, err := ms.pathMapper.path(manifestBlobPathSpec{
+		revision: revision,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := ms.driver.PutContent(contentPath, manifest.Raw); err != nil {
+		return err
+	}
+
+	if err := ms.putSignatures(name, revision, &manifest); err != nil {
+		return err
+	}
+
+	revisionLinkPath, err := ms.pathMapper.path(manifestRevisionLinkPathSpec{
+		name:     name,
+		revision: revision,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := ms.driver.PutContent(revisionLinkPath, []byte(revision)); err != nil {
+		return err
+	}
+
+	if err := ms.tagAt(name, tag, revision, fi.ModTime()); err != nil {
+		return err
+	}
+
+	return mc.driver.Delete(oldPath)
+}
@@ -9,34 +9,94 @@ import (
 	"github.com/docker/docker-registry/digest"
 )
 
-const storagePathVersion = "v2"
+// storagePathVersion is the version of the path layout described below. The
+// v2 layout stored each tag as a manifest file, at
+// repositories/<name>/manifests/<tag>, and is migrated by
+// newManifestRevisionsMigration.
+const storagePathVersion = "v3"
 
 // pathMapper maps paths based on "object names" and their ids. The "object
 // names" mapped by pathMapper are internal to the storage system.
 //
 // The path layout in the storage backend will be roughly as follows:
 //
-//		<root>/v2
+//		<root>/v3
 //			-> repositories/
 // 				-><name>/
 // 					-> manifests/
-// 						<manifests by tag name>
+// 						-> tags/<tag>/current
+// 							<link to the current revision>
+// 						-> tags/<tag>/index/
+// 							<history of revisions referenced by the tag>
+// 						-> revisions/<algorithm>
+// 							<links to manifest revisions in the blob store>
+// 						-> signatures/<algorithm>
+// 							<signatures of each manifest revision>
 // 					-> layers/
 // 						<layer links to blob store>
+// 					-> usage/repository
+// 						<bytes stored by the repository>
+// 					-> usage/namespace
+// 						<bytes stored by the repositories within the namespace>
 //			-> blob/<algorithm>
 //				<split directory content addressable storage>
+//			-> manifests/<algorithm>
+//				<manifest revisions, by the digest of their payload>
+//			-> quarantine/<algorithm>
+//				<blobs found to be corrupt, moved out of the blob store>
+//			-> aliases/<algorithm>
+//				<other digests of content in the blob store>
+//			-> uploads/<uuid>
+//				<data and state of in-progress uploads>
+//			-> migrations/<from version>
+//				<progress of the migration from an earlier layout>
 //
 // There are few important components to this path layout. First, we have the
 // repository store identified by name. This contains the image manifests and
-// a layer store with links to CAS blob ids. Outside of the named repo area,
-// we have the the blob store. It contains the actual layer data and any other
-// data that can be referenced by a CAS id.
+// a layer store with links to CAS blob ids. Manifests are stored by revision,
+// the digest of their signed payload, with each tag linking to the current
+// revision. Outside of the named repo area, we have the the blob store. It
+// contains the actual layer data and any other data that can be referenced by
+// a CAS id. Layers identified by both a tarsum and a sha256 digest are stored
+// once, by sha256, with the aliases directory mapping between the two. The
+// content of manifest revisions is kept apart from the blob store, since a
+// revision is addressed by the digest of its payload rather than of its
+// content, which could otherwise collide with a layer. Finally, the uploads
+// directory holds in-progress
+// layer uploads, when they are kept in the storage driver, and the migrations
+// directory records the progress of migrations from earlier path versions.
 //
 // We cover the path formats implemented by this path mapper below.
 //
-// 	manifestPathSpec: <root>/v2/repositories/<name>/manifests/<tag>
-// 	layerLinkPathSpec: <root>/v2/repositories/<name>/layers/tarsum/<tarsum version>/<tarsum hash alg>/<tarsum hash>
-// 	blobPathSpec: <root>/v2/blob/<algorithm>/<first two hex bytes of digest>/<hex digest>
+// 	versionRootPathSpec: <root>/v3
+// 	repositoriesRootPathSpec: <root>/v3/repositories
+// 	manifestsPathSpec: <root>/v3/repositories/<name>/manifests
+// 	manifestTagsPath: <root>/v3/repositories/<name>/manifests/tags
+// 	manifestTagPathSpec: <root>/v3/repositories/<name>/manifests/tags/<tag>
+// 	manifestTagCurrentPathSpec: <root>/v3/repositories/<name>/manifests/tags/<tag>/current
+// 	manifestTagIndexPathSpec: <root>/v3/repositories/<name>/manifests/tags/<tag>/index
+// 	manifestTagIndexEntryPathSpec: <root>/v3/repositories/<name>/manifests/tags/<tag>/index/<entry>
+// 	manifestRevisionsPathSpec: <root>/v3/repositories/<name>/manifests/revisions
+// 	manifestRevisionLinkPathSpec: <root>/v3/repositories/<name>/manifests/revisions/<algorithm>/<first two hex bytes of digest>/<hex digest>
+// 	manifestSignaturesPathSpec: <root>/v3/repositories/<name>/manifests/signatures/<algorithm>/<first two hex bytes of digest>/<hex digest>
+// 	manifestSignaturePathSpec: <root>/v3/repositories/<name>/manifests/signatures/<algorithm>/<first two hex bytes of digest>/<hex digest>/<signature algorithm>/<signature hex digest>
+// 	layersPathSpec: <root>/v3/repositories/<name>/layers
+// 	layerLinkPathSpec: <root>/v3/repositories/<name>/layers/tarsum/<tarsum version>/<tarsum hash alg>/<first two hex bytes of digest>/<tarsum hash>
+// 	layerLinkPathSpec: <root>/v3/repositories/<name>/layers/<algorithm>/<first two hex bytes of digest>/<hex digest>
+// 	repositoryUsagePathSpec: <root>/v3/repositories/<name>/usage/repository
+// 	namespaceUsagePathSpec: <root>/v3/repositories/<namespace>/usage/namespace
+// 	blobsPathSpec: <root>/v3/blob
+// 	blobPathSpec: <root>/v3/blob/<algorithm>/<first two hex bytes of digest>/<hex digest>
+// 	manifestBlobsPathSpec: <root>/v3/manifests
+// 	manifestBlobPathSpec: <root>/v3/manifests/<algorithm>/<first two hex bytes of digest>/<hex digest>
+// 	quarantinePathSpec: <root>/v3/quarantine/<algorithm>/<first two hex bytes of digest>/<hex digest>
+// 	aliasesPathSpec: <root>/v3/aliases
+// 	aliasPathSpec: <root>/v3/aliases/<algorithm>/<first two hex bytes of digest>/<hex digest>
+// 	uploadsPathSpec: <root>/v3/uploads
+// 	uploadPathSpec: <root>/v3/uploads/<uuid>
+// 	uploadDataPathSpec: <root>/v3/uploads/<uuid>/data
+// 	uploadStatePathSpec: <root>/v3/uploads/<uuid>/state.json
+// 	migrationStatePathSpec: <root>/v3/migrations/<from version>/state.json
 //
 // For more information on the semantic meaning of each path and their
 // contents, please see the path spec documentation.
@@ -64,40 +124,112 @@ func (pm *pathMapper) path(spec pathSpec) (string, error) {
 	repoPrefix := append(rootPrefix, "repositories")
 
 	switch v := spec.(type) {
-	case manifestTagsPath:
+	case versionRootPathSpec:
+		return path.Join(rootPrefix...), nil
+	case repositoriesRootPathSpec:
+		return path.Join(repoPrefix...), nil
+	case manifestsPathSpec:
 		return path.Join(append(repoPrefix, v.name, "manifests")...), nil
-	case manifestPathSpec:
+	case manifestTagsPath:
+		return path.Join(append(repoPrefix, v.name, "manifests", "tags")...), nil
+	case manifestTagPathSpec:
+		return path.Join(append(repoPrefix, v.name, "manifests", "tags", v.tag)...), nil
+	case manifestTagCurrentPathSpec:
 		// TODO(sday): May need to store manifest by architecture.
-		return path.Join(append(repoPrefix, v.name, "manifests", v.tag)...), nil
-	case layerLinkPathSpec:
-		components, err := digestPathComoponents(v.digest)
+		return path.Join(append(repoPrefix, v.name, "manifests", "tags", v.tag, "current")...), nil
+	case manifestTagIndexPathSpec:
+		return path.Join(append(repoPrefix, v.name, "manifests", "tags", v.tag, "index")...), nil
+	case manifestTagIndexEntryPathSpec:
+		return path.Join(append(repoPrefix, v.name, "manifests", "tags", v.tag, "index", v.entry)...), nil
+	case manifestRevisionsPathSpec:
+		return path.Join(append(repoPrefix, v.name, "manifests", "revisions")...), nil
+	case manifestRevisionLinkPathSpec:
+		components, err := digestPathComoponents(v.revision)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(repoPrefix, v.name, "manifests", "revisions"), components...)...), nil
+	case manifestSignaturesPathSpec:
+		components, err := digestPathComoponents(v.revision)
 		if err != nil {
 			return "", err
 		}
 
-		// For now, only map tarsum paths.
-		if components[0] != "tarsum" {
-			// Only tarsum is supported, for now
-			return "", fmt.Errorf("unsupported content digest: %v", v.digest)
+		return path.Join(append(append(repoPrefix, v.name, "manifests", "signatures"), components...)...), nil
+	case manifestSignaturePathSpec:
+		components, err := digestPathComoponents(v.revision)
+		if err != nil {
+			return "", err
+		}
+
+		if err := v.signature.Validate(); err != nil {
+			return "", err
+		}
+
+		components = append(components, v.signature.Algorithm(), v.signature.Hex())
+
+		return path.Join(append(append(repoPrefix, v.name, "manifests", "signatures"), components...)...), nil
+	case layerLinkPathSpec:
+		components, err := digestPathComoponents(v.digest)
+		if err != nil {
+			return "", err
 		}
 
 		layerLinkPathComponents := append(repoPrefix, v.name, "layers")
 
 		return path.Join(append(layerLinkPathComponents, components...)...), nil
+	case layersPathSpec:
+		return path.Join(append(repoPrefix, v.name, "layers")...), nil
+	case repositoryUsagePathSpec:
+		return path.Join(append(repoPrefix, v.name, "usage", "repository")...), nil
+	case namespaceUsagePathSpec:
+		return path.Join(append(repoPrefix, v.namespace, "usage", "namespace")...), nil
+	case blobsPathSpec:
+		return path.Join(append(rootPrefix, "blob")...), nil
 	case blobPathSpec:
 		components, err := digestPathComoponents(v.digest)
 		if err != nil {
 			return "", err
 		}
 
-		// For now, only map tarsum paths.
-		if components[0] != "tarsum" {
-			// Only tarsum is supported, for now
-			return "", fmt.Errorf("unsupported content digest: %v", v.digest)
-		}
-
 		blobPathPrefix := append(rootPrefix, "blob")
 		return path.Join(append(blobPathPrefix, components...)...), nil
+	case manifestBlobsPathSpec:
+		return path.Join(append(rootPrefix, "manifests")...), nil
+	case manifestBlobPathSpec:
+		components, err := digestPathComoponents(v.revision)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(rootPrefix, "manifests"), components...)...), nil
+	case quarantinePathSpec:
+		components, err := digestPathComoponents(v.digest)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(rootPrefix, "quarantine"), components...)...), nil
+	case aliasesPathSpec:
+		return path.Join(append(rootPrefix, "aliases")...), nil
+	case aliasPathSpec:
+		components, err := digestPathComoponents(v.digest)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(rootPrefix, "aliases"), components...)...), nil
+	case uploadsPathSpec:
+		return path.Join(append(rootPrefix, "uploads")...), nil
+	case uploadPathSpec:
+		return path.Join(append(rootPrefix, "uploads", v.uuid)...), nil
+	case uploadDataPathSpec:
+		return path.Join(append(rootPrefix, "uploads", v.uuid, "data")...), nil
+	case uploadStatePathSpec:
+		return path.Join(append(rootPrefix, "uploads", v.uuid, "state.json")...), nil
+	case migrationStatePathSpec:
+		return path.Join(append(rootPrefix, "migrations", v.from, "state.json")...), nil
 	default:
 		// TODO(sday): This is an internal error. Ensure it doesn't escape (panic?).
 		return "", fmt.Errorf("unknown path spec: %#v", v)
@@ -111,6 +243,27 @@ type pathSpec interface {
 	pathSpec()
 }
 
+// versionRootPathSpec describes the directory holding all content stored
+// with the layout of the path version.
+type versionRootPathSpec struct{}
+
+func (versionRootPathSpec) pathSpec() {}
+
+// repositoriesRootPathSpec describes the directory under which all
+// repositories are stored. It is primarily used to walk the set of
+// repositories.
+type repositoriesRootPathSpec struct{}
+
+func (repositoriesRootPathSpec) pathSpec() {}
+
+// manifestsPathSpec describes the directory holding the tags, revisions and
+// signatures of the manifests of the named repository.
+type manifestsPathSpec struct {
+	name string
+}
+
+func (manifestsPathSpec) pathSpec() {}
+
 // manifestTagsPath describes the path elements required to point to the
 // directory with all manifest tags under the repository.
 type manifestTagsPath struct {
@@ -119,14 +272,85 @@ type manifestTagsPath struct {
 
 func (manifestTagsPath) pathSpec() {}
 
-// manifestPathSpec describes the path elements used to build a manifest path.
-// The contents should be a signed manifest json file.
-type manifestPathSpec struct {
+// manifestTagPathSpec describes the directory holding the data for the named
+// tag. Removing this directory removes the tag.
+type manifestTagPathSpec struct {
+	name string
+	tag  string
+}
+
+func (manifestTagPathSpec) pathSpec() {}
+
+// manifestTagCurrentPathSpec describes the link to the manifest revision
+// currently referenced by the tag. The contents should be the digest of the
+// revision, in the same format as a layer link.
+type manifestTagCurrentPathSpec struct {
+	name string
+	tag  string
+}
+
+func (manifestTagCurrentPathSpec) pathSpec() {}
+
+// manifestTagIndexPathSpec describes the directory holding the index of
+// revisions referenced by the tag over time.
+type manifestTagIndexPathSpec struct {
 	name string
 	tag  string
 }
 
-func (manifestPathSpec) pathSpec() {}
+func (manifestTagIndexPathSpec) pathSpec() {}
+
+// manifestTagIndexEntryPathSpec describes a single entry in the tag index. An
+// entry is written each time the tag is updated and is never modified. The
+// entry name must sort in the order the entries were written. The contents
+// are a json encoded TagIndexEntry.
+type manifestTagIndexEntryPathSpec struct {
+	name  string
+	tag   string
+	entry string
+}
+
+func (manifestTagIndexEntryPathSpec) pathSpec() {}
+
+// manifestRevisionsPathSpec describes the directory containing the links to
+// every manifest revision stored in the named repository.
+type manifestRevisionsPathSpec struct {
+	name string
+}
+
+func (manifestRevisionsPathSpec) pathSpec() {}
+
+// manifestRevisionLinkPathSpec describes the link to a manifest revision in
+// the named repository. Its presence indicates that the revision may be
+// fetched from the repository. The content of the revision is stored at its
+// manifestBlobPathSpec and the link contains the digest of the revision.
+type manifestRevisionLinkPathSpec struct {
+	name     string
+	revision digest.Digest
+}
+
+func (manifestRevisionLinkPathSpec) pathSpec() {}
+
+// manifestSignaturesPathSpec describes the directory containing the
+// signatures of a manifest revision in the named repository.
+type manifestSignaturesPathSpec struct {
+	name     string
+	revision digest.Digest
+}
+
+func (manifestSignaturesPathSpec) pathSpec() {}
+
+// manifestSignaturePathSpec describes a single signature of a manifest
+// revision, identified by the digest of the signature. The file contains the
+// signature as a json web signature object, without the payload, which can
+// be combined with the payload of the revision to verify it.
+type manifestSignaturePathSpec struct {
+	name      string
+	revision  digest.Digest
+	signature digest.Digest
+}
+
+func (manifestSignaturePathSpec) pathSpec() {}
 
 // layerLink specifies a path for a layer link, which is a file with a blob
 // id. The layer link will contain a content addressable blob id reference
@@ -147,6 +371,31 @@ type layerLinkPathSpec struct {
 
 func (layerLinkPathSpec) pathSpec() {}
 
+// layersPathSpec describes the directory containing all of the layer links
+// for the named repository.
+type layersPathSpec struct {
+	name string
+}
+
+func (layersPathSpec) pathSpec() {}
+
+// repositoryUsagePathSpec describes the file recording the bytes stored by
+// the named repository, used to enforce quotas. The leading underscore keeps
+// it apart from the names of nested repositories.
+type repositoryUsagePathSpec struct {
+	name string
+}
+
+func (repositoryUsagePathSpec) pathSpec() {}
+
+// namespaceUsagePathSpec describes the file recording the total bytes stored
+// by the repositories within the namespace, used to enforce quotas.
+type namespaceUsagePathSpec struct {
+	namespace string
+}
+
+func (namespaceUsagePathSpec) pathSpec() {}
+
 // blobAlgorithmReplacer does some very simple path sanitization for user
 // input. Mostly, this is to provide some heirachry for tarsum digests. Paths
 // should be "safe" before getting this far due to strict digest requirements
@@ -157,14 +406,103 @@ var blobAlgorithmReplacer = strings.NewReplacer(
 	";", "/",
 )
 
-// blobPath contains the path for the registry global blob store. For now,
-// this contains layer data, exclusively.
+// blobPath contains the path for the registry global blob store. This
+// contains layer data, stored by tarsum or a plain content digest. Manifest
+// revisions stored before they were kept apart from layers are also found
+// here, by the sha256 digest of their payload.
 type blobPathSpec struct {
 	digest digest.Digest
 }
 
 func (blobPathSpec) pathSpec() {}
 
+// blobsPathSpec describes the root directory of the blob store.
+type blobsPathSpec struct{}
+
+func (blobsPathSpec) pathSpec() {}
+
+// manifestBlobsPathSpec describes the root directory of the content of
+// manifest revisions.
+type manifestBlobsPathSpec struct{}
+
+func (manifestBlobsPathSpec) pathSpec() {}
+
+// manifestBlobPathSpec describes the file holding the content of a manifest
+// revision, shared by all repositories linking the revision. The content is
+// the signed manifest as first put, which is not addressed by its own digest
+// but by that of its payload.
+type manifestBlobPathSpec struct {
+	revision digest.Digest
+}
+
+func (manifestBlobPathSpec) pathSpec() {}
+
+// quarantinePathSpec describes the location a blob is moved to when its
+// content is found not to match its digest. Quarantined blobs are kept for
+// inspection and are not served.
+type quarantinePathSpec struct {
+	digest digest.Digest
+}
+
+func (quarantinePathSpec) pathSpec() {}
+
+// aliasesPathSpec describes the root directory of the digest aliases.
+type aliasesPathSpec struct{}
+
+func (aliasesPathSpec) pathSpec() {}
+
+// aliasPathSpec describes a file recording another digest of the content
+// identified by digest. Layers with both a tarsum and a sha256 digest have an
+// alias for each, referring to the other. The format of the contents is as
+// follows:
+//
+// 	<algorithm>:<hex digest of layer data>
+type aliasPathSpec struct {
+	digest digest.Digest
+}
+
+func (aliasPathSpec) pathSpec() {}
+
+// uploadsPathSpec describes the directory holding in-progress uploads kept
+// in the storage driver. Each upload has its own directory, named by the
+// upload uuid.
+type uploadsPathSpec struct{}
+
+func (uploadsPathSpec) pathSpec() {}
+
+// uploadPathSpec describes the directory holding the data and state of an
+// in-progress upload. Removing this directory removes the upload.
+type uploadPathSpec struct {
+	uuid string
+}
+
+func (uploadPathSpec) pathSpec() {}
+
+// uploadDataPathSpec describes the file holding the data received for an
+// in-progress upload.
+type uploadDataPathSpec struct {
+	uuid string
+}
+
+func (uploadDataPathSpec) pathSpec() {}
+
+// uploadStatePathSpec describes the file holding the json encoded
+// LayerUploadState of an in-progress upload.
+type uploadStatePathSpec struct {
+	uuid string
+}
+
+func (uploadStatePathSpec) pathSpec() {}
+
+// migrationStatePathSpec describes the file holding the json encoded progress
+// of the migration from path version from into the layout of the mapped
+// version.
+type migrationStatePathSpec struct {
+	from string
+}
+
+func (migrationStatePathSpec) pathSpec() {}
+
 // digestPathComoponents provides a consistent path breakdown for a given
 // digest. For a generic digest, it will be as follows:
 //
@@ -205,3 +543,36 @@ func digestPathComoponents(dgst digest.Digest) ([]string, error) {
 
 	return append(prefix, suffix...), nil
 }
+
+// digestFromPathComponents is the inverse of digestPathComoponents,
+// recovering the digest from the trailing path components of a layer link or
+// blob path. The components must be relative to the layers or blob
+// directory.
+func digestFromPathComponents(components []string) (digest.Digest, error) {
+	var dgst digest.Digest
+
+	switch {
+	case len(components) == 5 && components[0] == "tarsum":
+		tsi := common.TarSumInfo{
+			Version:   components[1],
+			Algorithm: components[2],
+			Digest:    components[4],
+		}
+
+		if tsi.Version == "v0" {
+			tsi.Version = ""
+		}
+
+		dgst = digest.Digest(tsi.String())
+	case len(components) == 3:
+		dgst = digest.Digest(components[0] + ":" + components[2])
+	default:
+		return "", fmt.Errorf("invalid digest path components: %v", components)
+	}
+
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+
+	return dgst, nil
+}
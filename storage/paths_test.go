@@ -17,11 +17,53 @@ func TestPathMapper(t *testing.T) {
 		err      error
 	}{
 		{
-			spec: manifestPathSpec{
+			spec:     manifestsPathSpec{name: "foo/bar"},
+			expected: "/pathmapper-test/repositories/foo/bar/manifests",
+		},
+		{
+			spec:     repositoryUsagePathSpec{name: "foo/bar"},
+			expected: "/pathmapper-test/repositories/foo/bar/usage/repository",
+		},
+		{
+			spec:     namespaceUsagePathSpec{namespace: "foo"},
+			expected: "/pathmapper-test/repositories/foo/usage/namespace",
+		},
+		{
+			spec: manifestTagCurrentPathSpec{
 				name: "foo/bar",
 				tag:  "thetag",
 			},
-			expected: "/pathmapper-test/repositories/foo/bar/manifests/thetag",
+			expected: "/pathmapper-test/repositories/foo/bar/manifests/tags/thetag/current",
+		},
+		{
+			spec: manifestTagIndexEntryPathSpec{
+				name:  "foo/bar",
+				tag:   "thetag",
+				entry: "01418234560000000000",
+			},
+			expected: "/pathmapper-test/repositories/foo/bar/manifests/tags/thetag/index/01418234560000000000",
+		},
+		{
+			spec: manifestRevisionLinkPathSpec{
+				name:     "foo/bar",
+				revision: digest.Digest("sha256:abcdef0919234"),
+			},
+			expected: "/pathmapper-test/repositories/foo/bar/manifests/revisions/sha256/ab/abcdef0919234",
+		},
+		{
+			spec: manifestSignaturesPathSpec{
+				name:     "foo/bar",
+				revision: digest.Digest("sha256:abcdef0919234"),
+			},
+			expected: "/pathmapper-test/repositories/foo/bar/manifests/signatures/sha256/ab/abcdef0919234",
+		},
+		{
+			spec: manifestSignaturePathSpec{
+				name:      "foo/bar",
+				revision:  digest.Digest("sha256:abcdef0919234"),
+				signature: digest.Digest("sha256:0123456789"),
+			},
+			expected: "/pathmapper-test/repositories/foo/bar/manifests/signatures/sha256/ab/abcdef0919234/sha256/0123456789",
 		},
 		{
 			spec: layerLinkPathSpec{
@@ -30,6 +72,31 @@ func TestPathMapper(t *testing.T) {
 			},
 			expected: "/pathmapper-test/repositories/foo/bar/layers/tarsum/v1/test/ab/abcdef",
 		},
+		{
+			spec: layerLinkPathSpec{
+				name:   "foo/bar",
+				digest: digest.Digest("sha512:abcdef"),
+			},
+			expected: "/pathmapper-test/repositories/foo/bar/layers/sha512/ab/abcdef",
+		},
+		{
+			spec: aliasPathSpec{
+				digest: digest.Digest("tarsum.v1+sha256:abcdef"),
+			},
+			expected: "/pathmapper-test/aliases/tarsum/v1/sha256/ab/abcdef",
+		},
+		{
+			spec: manifestBlobPathSpec{
+				revision: digest.Digest("sha256:abcdef0919234"),
+			},
+			expected: "/pathmapper-test/manifests/sha256/ab/abcdef0919234",
+		},
+		{
+			spec: quarantinePathSpec{
+				digest: digest.Digest("sha256:abcdef"),
+			},
+			expected: "/pathmapper-test/quarantine/sha256/ab/abcdef",
+		},
 		{
 			spec: blobPathSpec{
 				digest: digest.Digest("tarsum.dev+sha512:abcdefabcdefabcdef908909909"),
@@ -48,6 +115,28 @@ func TestPathMapper(t *testing.T) {
 			},
 			expected: "/pathmapper-test/blob/tarsum/v0/sha256/ab/abcdefabcdefabcdef908909909",
 		},
+		{
+			spec: uploadDataPathSpec{
+				uuid: "asdf-asdf-asdf-adsf",
+			},
+			expected: "/pathmapper-test/uploads/asdf-asdf-asdf-adsf/data",
+		},
+		{
+			spec: uploadStatePathSpec{
+				uuid: "asdf-asdf-asdf-adsf",
+			},
+			expected: "/pathmapper-test/uploads/asdf-asdf-asdf-adsf/state.json",
+		},
+		{
+			spec:     versionRootPathSpec{},
+			expected: "/pathmapper-test",
+		},
+		{
+			spec: migrationStatePathSpec{
+				from: "v1",
+			},
+			expected: "/pathmapper-test/migrations/v1/state.json",
+		},
 	} {
 		p, err := pm.path(testcase.spec)
 		if err != nil {
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// PurgeUploads removes the in-progress uploads started before olderThan,
+// returning the state of each purged upload. Uploads without a start time,
+// created by earlier versions of the registry, are always purged. If dryRun
+// is true, the uploads are only reported.
+func (ss *Services) PurgeUploads(olderThan time.Time, dryRun bool) ([]LayerUploadState, error) {
+	uuids, err := ss.layerUploadStore.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []LayerUploadState
+	for _, uuid := range uuids {
+		lus, err := ss.layerUploadStore.GetState(uuid)
+		if err != nil {
+			if err != ErrLayerUploadUnknown {
+				logrus.Warnf("purge: skipping upload %q: %v", uuid, err)
+			}
+
+			// The upload may have been completed or cancelled since listing.
+			continue
+		}
+
+		if !lus.StartedAt.Before(olderThan) {
+			continue
+		}
+
+		if !dryRun {
+			if err := ss.layerUploadStore.DeleteState(uuid); err != nil {
+				if err != ErrLayerUploadUnknown {
+					logrus.Warnf("purge: error removing upload %q: %v", uuid, err)
+				}
+				continue
+			}
+		}
+
+		purged = append(purged, lus)
+	}
+
+	return purged, nil
+}
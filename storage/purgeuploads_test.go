@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker-registry/storagedriver/inmemory"
+)
+
+func TestPurgeUploads(t *testing.T) {
+	driver := inmemory.New()
+	ss, err := NewServicesWithOptions(driver, Options{UploadStore: UploadStoreDriver})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	layers := ss.Layers()
+
+	abandoned, err := layers.Upload("foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+
+	// Backdate the abandoned upload.
+	lus, err := ss.layerUploadStore.GetState(abandoned.UUID())
+	if err != nil {
+		t.Fatalf("unexpected error getting upload state: %v", err)
+	}
+
+	lus.StartedAt = time.Now().Add(-48 * time.Hour)
+	if err := ss.layerUploadStore.SaveState(lus); err != nil {
+		t.Fatalf("unexpected error saving upload state: %v", err)
+	}
+
+	active, err := layers.Upload("foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+
+	olderThan := time.Now().Add(-24 * time.Hour)
+
+	// A dry run should only report the abandoned upload.
+	purged, err := ss.PurgeUploads(olderThan, true)
+	if err != nil {
+		t.Fatalf("unexpected error purging uploads: %v", err)
+	}
+
+	checkPurgedUploads(t, purged, abandoned.UUID())
+
+	if _, err := layers.Resume(abandoned.UUID()); err != nil {
+		t.Fatalf("upload should not be removed by dry run: %v", err)
+	}
+
+	purged, err = ss.PurgeUploads(olderThan, false)
+	if err != nil {
+		t.Fatalf("unexpected error purging uploads: %v", err)
+	}
+
+	checkPurgedUploads(t, purged, abandoned.UUID())
+
+	if _, err := layers.Resume(abandoned.UUID()); err != ErrLayerUploadUnknown {
+		t.Fatalf("expected abandoned upload to be purged, got %v", err)
+	}
+
+	if _, err := layers.Resume(active.UUID()); err != nil {
+		t.Fatalf("unexpected error resuming active upload: %v", err)
+	}
+}
+
+func checkPurgedUploads(t *testing.T, purged []LayerUploadState, expected ...string) {
+	if len(purged) != len(expected) {
+		t.Fatalf("unexpected number of purged uploads: %d != %d", len(purged), len(expected))
+	}
+
+	for i, lus := range purged {
+		if lus.UUID != expected[i] {
+			t.Fatalf("unexpected purged upload: %q != %q", lus.UUID, expected[i])
+		}
+	}
+}
@@ -0,0 +1,617 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker-registry/common"
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/docker-registry/storagedriver"
+)
+
+// The following are the scopes a quota may apply to.
+const (
+	// QuotaScopeRepository limits the bytes stored by a single repository.
+	QuotaScopeRepository = "repository"
+
+	// QuotaScopeNamespace limits the total bytes stored by the repositories
+	// within a namespace.
+	QuotaScopeNamespace = "namespace"
+)
+
+// Quotas configures limits on the bytes stored by repositories. The usage of
+// a repository is the size of the layers linked into it and of its manifest
+// revisions. Content shared by several repositories counts towards the usage
+// of each.
+type Quotas struct {
+	// Repositories maps repository names to their limit, in bytes.
+	Repositories map[string]int64
+
+	// Namespaces maps namespaces to the limit, in bytes, on the total usage
+	// of the repositories within. A repository is within a namespace if its
+	// name is the namespace or has the namespace as a path prefix, such that
+	// "foo/bar" is within "foo" but "foobar" is not.
+	Namespaces map[string]int64
+}
+
+// validate ensures that the quotas name valid repositories and namespaces
+// with positive limits.
+func (quotas Quotas) validate() error {
+	for name, limit := range quotas.Repositories {
+		if err := common.ValidateRespositoryName(name); err != nil {
+			return fmt.Errorf("invalid repository quota name %q: %v", name, err)
+		}
+
+		if limit <= 0 {
+			return fmt.Errorf("repository quota for %q must be positive: %d", name, limit)
+		}
+	}
+
+	for namespace, limit := range quotas.Namespaces {
+		// A namespace may have fewer components than a repository name.
+		for _, component := range strings.Split(namespace, "/") {
+			if !common.RepositoryNameComponentAnchoredRegexp.MatchString(component) {
+				return fmt.Errorf("invalid namespace quota name %q", namespace)
+			}
+		}
+
+		if limit <= 0 {
+			return fmt.Errorf("namespace quota for %q must be positive: %d", namespace, limit)
+		}
+	}
+
+	return nil
+}
+
+// Quota describes a limit applying to a repository, with the current usage
+// it limits.
+type Quota struct {
+	// Scope is either QuotaScopeRepository or QuotaScopeNamespace.
+	Scope string `json:"scope"`
+
+	// Name is the repository or namespace limited by the quota.
+	Name string `json:"name"`
+
+	// Limit is the maximum number of bytes that may be stored.
+	Limit int64 `json:"limit"`
+
+	// Usage is the number of bytes stored by the repository or namespace.
+	Usage int64 `json:"usage"`
+}
+
+// RepositoryUsage describes the bytes stored by a repository and the quotas
+// that apply to it.
+type RepositoryUsage struct {
+	Name string `json:"name"`
+
+	// Layers is the size of the layers linked into the repository.
+	Layers int64 `json:"layers"`
+
+	// Manifests is the size of the manifest revisions of the repository.
+	Manifests int64 `json:"manifests"`
+
+	// Quotas lists the quotas applying to the repository, if any.
+	Quotas []Quota `json:"quotas"`
+}
+
+// ErrQuotaExceeded is returned when storing content in a repository would
+// take its usage, or that of a namespace containing it, over a quota.
+type ErrQuotaExceeded struct {
+	Name string
+
+	// Quota is the quota that would be exceeded.
+	Quota Quota
+
+	// Size is the size of the rejected content.
+	Size int64
+}
+
+func (err ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("storing %d bytes in %s would exceed the %s quota of %s: %d of %d bytes used",
+		err.Size, err.Name, err.Quota.Scope, err.Quota.Name, err.Quota.Usage, err.Quota.Limit)
+}
+
+// Usage returns the bytes stored by the named repository and the quotas
+// that apply to it.
+func (ss *Services) Usage(name string) (*RepositoryUsage, error) {
+	usage, err := ss.quotas.usage(name)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas, err := ss.quotas.quotasFor(name, usage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RepositoryUsage{
+		Name:      name,
+		Layers:    usage.Layers,
+		Manifests: usage.Manifests,
+		Quotas:    quotas,
+	}, nil
+}
+
+// repositoryUsage is the usage of a repository or namespace, recorded as json
+// alongside the repository content.
+type repositoryUsage struct {
+	Layers    int64 `json:"layers"`
+	Manifests int64 `json:"manifests"`
+}
+
+func (ru repositoryUsage) total() int64 {
+	return ru.Layers + ru.Manifests
+}
+
+// quotaStore tracks the usage of repositories and enforces quotas. The usage
+// of each repository is recorded alongside its content and updated as layer
+// links and manifest revisions are written or removed. The total usage of a
+// namespace is recorded once it is first needed, by summing the repositories
+// within it, and updated along with the repositories within it from then on.
+// Usage not yet recorded, such as that of repositories pushed before usage
+// was tracked, is counted from the content of the repository and recorded.
+//
+// Updates made by a registry instance are serialized, but those made by
+// instances sharing the storage are not, so concurrent pushes may let a
+// repository exceed its quota slightly or leave the recorded usage
+// inaccurate. Quotas are checked before content is linked, with the same
+// effect. Garbage collection counts the usage of each repository again and
+// fsck reports and repairs recorded usage that differs from the content.
+//
+// All methods may be called on a nil quotaStore, in which case usage is not
+// tracked and no quotas are enforced.
+type quotaStore struct {
+	driver     storagedriver.StorageDriver
+	pathMapper *pathMapper
+	quotas     Quotas
+
+	// mu serializes updates to the recorded usage.
+	mu sync.Mutex
+}
+
+func newQuotaStore(driver storagedriver.StorageDriver, pathMapper *pathMapper, quotas Quotas) *quotaStore {
+	return &quotaStore{
+		driver:     driver,
+		pathMapper: pathMapper,
+		quotas:     quotas,
+	}
+}
+
+// check returns ErrQuotaExceeded if adding size bytes to the named repository
+// would exceed a quota applying to it.
+func (qs *quotaStore) check(name string, size int64) error {
+	if qs == nil || (len(qs.quotas.Repositories) == 0 && len(qs.quotas.Namespaces) == 0) {
+		return nil
+	}
+
+	usage, err := qs.usage(name)
+	if err != nil {
+		return err
+	}
+
+	quotas, err := qs.quotasFor(name, usage)
+	if err != nil {
+		return err
+	}
+
+	for _, quota := range quotas {
+		if quota.Usage+size > quota.Limit {
+			return ErrQuotaExceeded{
+				Name:  name,
+				Quota: quota,
+				Size:  size,
+			}
+		}
+	}
+
+	return nil
+}
+
+// add adjusts the recorded usage of the named repository, and of the
+// namespaces containing it, by the given number of layer and manifest bytes,
+// which may be negative. It must be called after the change has been made in
+// storage: if the usage of the repository is not recorded yet, it is
+// counted, including the change. The content has already been stored, so
+// failures are logged rather than returned.
+func (qs *quotaStore) add(name string, layers, manifests int64) {
+	if qs == nil {
+		return
+	}
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	change := repositoryUsage{Layers: layers, Manifests: manifests}
+
+	if err := qs.addRepository(name, change); err != nil {
+		logrus.Errorf("quota: error recording usage of %s: %v", name, err)
+	}
+
+	if err := qs.addNamespaces(name, change); err != nil {
+		logrus.Errorf("quota: error recording usage of namespaces containing %s: %v", name, err)
+	}
+}
+
+// addRepository adds change to the recorded usage of the named repository,
+// counting it if it is not recorded. The caller must hold qs.mu.
+func (qs *quotaStore) addRepository(name string, change repositoryUsage) error {
+	usagePath, err := qs.pathMapper.path(repositoryUsagePathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	usage, recorded, err := qs.recorded(usagePath)
+	if err != nil {
+		return err
+	}
+
+	if !recorded {
+		usage, err = qs.count(name)
+		if err != nil {
+			return err
+		}
+
+		return qs.record(usagePath, usage)
+	}
+
+	usage.Layers += change.Layers
+	usage.Manifests += change.Manifests
+
+	return qs.record(usagePath, usage)
+}
+
+// addNamespaces adds change to the recorded usage of each namespace
+// containing the named repository. Namespaces without recorded usage are
+// left to be counted when their usage is needed. The caller must hold qs.mu.
+func (qs *quotaStore) addNamespaces(name string, change repositoryUsage) error {
+	if change == (repositoryUsage{}) {
+		return nil
+	}
+
+	components := strings.Split(name, "/")
+	for i := range components {
+		usagePath, err := qs.pathMapper.path(namespaceUsagePathSpec{namespace: strings.Join(components[:i+1], "/")})
+		if err != nil {
+			return err
+		}
+
+		usage, recorded, err := qs.recorded(usagePath)
+		if err != nil {
+			return err
+		}
+
+		if !recorded {
+			continue
+		}
+
+		usage.Layers += change.Layers
+		usage.Manifests += change.Manifests
+
+		if err := qs.record(usagePath, usage); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recount counts the usage of the named repository again, after changes
+// not tracked by add, such as copying or removing the repository. The
+// difference from the recorded usage is applied to the namespaces containing
+// the repository.
+func (qs *quotaStore) recount(name string) error {
+	if qs == nil {
+		return nil
+	}
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	usagePath, err := qs.pathMapper.path(repositoryUsagePathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	// Repositories without recorded usage had none when the namespaces
+	// containing them were counted.
+	recorded, _, err := qs.recorded(usagePath)
+	if err != nil {
+		return err
+	}
+
+	usage, err := qs.count(name)
+	if err != nil {
+		return err
+	}
+
+	if err := qs.record(usagePath, usage); err != nil {
+		return err
+	}
+
+	return qs.addNamespaces(name, repositoryUsage{
+		Layers:    usage.Layers - recorded.Layers,
+		Manifests: usage.Manifests - recorded.Manifests,
+	})
+}
+
+// quotasFor returns the quotas applying to the named repository, given its
+// usage, in order of increasing scope.
+func (qs *quotaStore) quotasFor(name string, usage repositoryUsage) ([]Quota, error) {
+	if qs == nil {
+		return nil, nil
+	}
+
+	var quotas []Quota
+
+	if limit, ok := qs.quotas.Repositories[name]; ok {
+		quotas = append(quotas, Quota{
+			Scope: QuotaScopeRepository,
+			Name:  name,
+			Limit: limit,
+			Usage: usage.total(),
+		})
+	}
+
+	var namespaces []string
+	for namespace := range qs.quotas.Namespaces {
+		if withinNamespace(name, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	// The most specific namespace first.
+	sort.Sort(sort.Reverse(sort.StringSlice(namespaces)))
+
+	for _, namespace := range namespaces {
+		usage, err := qs.namespaceUsage(namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		quotas = append(quotas, Quota{
+			Scope: QuotaScopeNamespace,
+			Name:  namespace,
+			Limit: qs.quotas.Namespaces[namespace],
+			Usage: usage.total(),
+		})
+	}
+
+	return quotas, nil
+}
+
+// withinNamespace returns true if the named repository is the namespace or
+// is nested under it.
+func withinNamespace(name, namespace string) bool {
+	return name == namespace || strings.HasPrefix(name, namespace+"/")
+}
+
+// usage returns the recorded usage of the named repository, counting and
+// recording it if it is not recorded.
+func (qs *quotaStore) usage(name string) (repositoryUsage, error) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	return qs.repositoryUsage(name)
+}
+
+// repositoryUsage returns the recorded usage of the named repository,
+// counting and recording it if it is not recorded. Repositories without any
+// content are not recorded. The caller must hold qs.mu.
+func (qs *quotaStore) repositoryUsage(name string) (repositoryUsage, error) {
+	usagePath, err := qs.pathMapper.path(repositoryUsagePathSpec{name: name})
+	if err != nil {
+		return repositoryUsage{}, err
+	}
+
+	usage, recorded, err := qs.recorded(usagePath)
+	if err != nil || recorded {
+		return usage, err
+	}
+
+	usage, err = qs.count(name)
+	if err != nil || usage.total() == 0 {
+		return usage, err
+	}
+
+	return usage, qs.record(usagePath, usage)
+}
+
+// namespaceUsage returns the recorded total usage of the repositories within
+// the namespace. If it is not recorded, the usage of each repository within
+// the namespace is summed and recorded.
+func (qs *quotaStore) namespaceUsage(namespace string) (repositoryUsage, error) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	usagePath, err := qs.pathMapper.path(namespaceUsagePathSpec{namespace: namespace})
+	if err != nil {
+		return repositoryUsage{}, err
+	}
+
+	total, recorded, err := qs.recorded(usagePath)
+	if err != nil || recorded {
+		return total, err
+	}
+
+	root, err := qs.pathMapper.path(repositoriesRootPathSpec{})
+	if err != nil {
+		return total, err
+	}
+
+	err = walkRepositoriesFrom(qs.driver, root, path.Join(root, namespace), func(name string) error {
+		usage, err := qs.repositoryUsage(name)
+		if err != nil {
+			return err
+		}
+
+		total.Layers += usage.Layers
+		total.Manifests += usage.Manifests
+		return nil
+	})
+
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// Nothing has been stored in the namespace.
+			return total, nil
+		default:
+			return total, err
+		}
+	}
+
+	return total, qs.record(usagePath, total)
+}
+
+// recorded reads the usage recorded at usagePath. If none is recorded, false
+// is returned.
+func (qs *quotaStore) recorded(usagePath string) (repositoryUsage, bool, error) {
+	var usage repositoryUsage
+
+	p, err := qs.driver.GetContent(usagePath)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			return usage, false, nil
+		default:
+			return usage, false, err
+		}
+	}
+
+	if err := json.Unmarshal(p, &usage); err != nil {
+		return usage, false, err
+	}
+
+	return usage, true, nil
+}
+
+// record writes usage to usagePath. Usage of nothing is recorded by removing
+// the file, so that nothing is left behind for removed repositories.
+func (qs *quotaStore) record(usagePath string, usage repositoryUsage) error {
+	if usage.total() == 0 {
+		if err := qs.driver.Delete(usagePath); err != nil {
+			switch err.(type) {
+			case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			default:
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	p, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+
+	return qs.driver.PutContent(usagePath, p)
+}
+
+// count counts the usage of the named repository from its layer links and
+// manifest revisions.
+func (qs *quotaStore) count(name string) (repositoryUsage, error) {
+	var usage repositoryUsage
+
+	layersPath, err := qs.pathMapper.path(layersPathSpec{name: name})
+	if err != nil {
+		return usage, err
+	}
+
+	err = walk(qs.driver, layersPath, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		size, err := qs.linkedSize(fileInfo.Path(), qs.blobPath)
+		if err != nil {
+			return err
+		}
+
+		usage.Layers += size
+		return nil
+	})
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// The repository has no layers.
+		default:
+			return usage, err
+		}
+	}
+
+	revisionsPath, err := qs.pathMapper.path(manifestRevisionsPathSpec{name: name})
+	if err != nil {
+		return usage, err
+	}
+
+	err = walk(qs.driver, revisionsPath, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		size, err := qs.linkedSize(fileInfo.Path(), qs.revisionPath)
+		if err != nil {
+			return err
+		}
+
+		usage.Manifests += size
+		return nil
+	})
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// The repository has no manifests.
+		default:
+			return usage, err
+		}
+	}
+
+	return usage, nil
+}
+
+// linkedSize returns the size of the content targeted by the link at path p,
+// found at the path returned by target. Links that are invalid or dangling
+// are counted as empty.
+func (qs *quotaStore) linkedSize(p string, target func(digest.Digest) (string, error)) (int64, error) {
+	content, err := qs.driver.GetContent(p)
+	if err != nil {
+		return 0, err
+	}
+
+	linked, err := digest.ParseDigest(string(content))
+	if err != nil {
+		return 0, nil
+	}
+
+	targetPath, err := target(linked)
+	if err != nil {
+		return 0, nil
+	}
+
+	fi, err := qs.driver.Stat(targetPath)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			return 0, nil
+		default:
+			return 0, err
+		}
+	}
+
+	return fi.Size(), nil
+}
+
+// blobPath returns the path of the layer blob identified by dgst.
+func (qs *quotaStore) blobPath(dgst digest.Digest) (string, error) {
+	return qs.pathMapper.path(blobPathSpec{digest: dgst})
+}
+
+// revisionPath returns the path of the content of the manifest revision.
+func (qs *quotaStore) revisionPath(revision digest.Digest) (string, error) {
+	return revisionPath(qs.driver, qs.pathMapper, revision)
+}
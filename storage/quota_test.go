@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/docker/libtrust"
+
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/docker-registry/storagedriver/inmemory"
+)
+
+// TestQuotas ensures that repository and namespace quotas are enforced as
+// layers are uploaded, mounted and deleted, and as manifests are put.
+func TestQuotas(t *testing.T) {
+	driver := inmemory.New()
+	ss, err := NewServicesWithOptions(driver, Options{
+		Quotas: Quotas{
+			Repositories: map[string]int64{
+				"foo/bar": 2500,
+				"bar/baz": 1001,
+			},
+			Namespaces: map[string]int64{
+				"foo": 3500,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	ls := ss.Layers()
+
+	a, aDigest := randomQuotaContent(t)
+	b, bDigest := randomQuotaContent(t)
+	c, cDigest := randomQuotaContent(t)
+
+	// Layers already in the repository are not counted again.
+	checkQuotaUpload(t, ls, "foo/bar", a, aDigest, "")
+	checkQuotaUpload(t, ls, "foo/bar", a, aDigest, "")
+	checkQuotaUpload(t, ls, "foo/bar", b, bDigest, "")
+	checkUsage(t, ss, "foo/bar", 2000, 0)
+
+	checkQuotaUpload(t, ls, "foo/bar", c, cDigest, QuotaScopeRepository)
+	checkUsage(t, ss, "foo/bar", 2000, 0)
+
+	// Mounted layers count towards the namespace.
+	if _, err := ls.Mount("foo/baz", aDigest, "foo/bar"); err != nil {
+		t.Fatalf("unexpected error mounting layer: %v", err)
+	}
+	checkUsage(t, ss, "foo/baz", 1000, 0)
+
+	if _, err := ls.Mount("foo/baz", bDigest, "foo/bar"); err == nil {
+		t.Fatalf("expected mount to exceed namespace quota")
+	} else if qerr, ok := err.(ErrQuotaExceeded); !ok || qerr.Quota.Scope != QuotaScopeNamespace {
+		t.Fatalf("unexpected error mounting layer: %v", err)
+	}
+
+	checkQuotaUpload(t, ls, "foo/baz", c, cDigest, QuotaScopeNamespace)
+
+	// Deleting a layer releases its usage.
+	if err := ls.Delete("foo/bar", bDigest); err != nil {
+		t.Fatalf("unexpected error deleting layer: %v", err)
+	}
+	checkUsage(t, ss, "foo/bar", 1000, 0)
+
+	checkQuotaUpload(t, ls, "foo/baz", c, cDigest, "")
+	checkUsage(t, ss, "foo/baz", 2000, 0)
+
+	usage, err := ss.Usage("foo/baz")
+	if err != nil {
+		t.Fatalf("unexpected error getting usage: %v", err)
+	}
+
+	if len(usage.Quotas) != 1 || usage.Quotas[0] != (Quota{Scope: QuotaScopeNamespace, Name: "foo", Limit: 3500, Usage: 3000}) {
+		t.Fatalf("unexpected quotas: %#v", usage.Quotas)
+	}
+
+	// The manifest takes the repository over its quota.
+	checkQuotaUpload(t, ls, "bar/baz", a, aDigest, "")
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	if err := ss.Manifests().Put("bar/baz", "thetag", signTestManifest(t, pk, "bar/baz", "thetag", aDigest)); err == nil {
+		t.Fatalf("expected manifest to exceed repository quota")
+	} else if _, ok := err.(ErrQuotaExceeded); !ok {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	// Without a quota, the manifest is counted.
+	checkQuotaUpload(t, ls, "other/repo", a, aDigest, "")
+	if err := ss.Manifests().Put("other/repo", "thetag", signTestManifest(t, pk, "other/repo", "thetag", aDigest)); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	usage, err = ss.Usage("other/repo")
+	if err != nil {
+		t.Fatalf("unexpected error getting usage: %v", err)
+	}
+
+	if usage.Layers != 1000 || usage.Manifests == 0 || len(usage.Quotas) != 0 {
+		t.Fatalf("unexpected usage: %#v", usage)
+	}
+
+	// Counting the usage from storage agrees with the recorded usage.
+	for _, name := range []string{"foo/bar", "other/repo", "foo/unknown"} {
+		counted, err := ss.quotas.count(name)
+		if err != nil {
+			t.Fatalf("unexpected error counting usage of %s: %v", name, err)
+		}
+
+		checkUsage(t, ss, name, counted.Layers, counted.Manifests)
+	}
+
+	checkUsage(t, ss, "foo/bar", 1000, 0)
+	checkUsage(t, ss, "foo/unknown", 0, 0)
+}
+
+// TestQuotasShared ensures that the usage recorded by one registry instance
+// is seen immediately by another sharing the storage.
+func TestQuotasShared(t *testing.T) {
+	driver := inmemory.New()
+	options := Options{
+		Quotas: Quotas{
+			Namespaces: map[string]int64{
+				"foo": 1500,
+			},
+		},
+	}
+
+	first, err := NewServicesWithOptions(driver, options)
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	second, err := NewServicesWithOptions(driver, options)
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	a, aDigest := randomQuotaContent(t)
+	b, bDigest := randomQuotaContent(t)
+
+	checkQuotaUpload(t, first.Layers(), "foo/bar", a, aDigest, "")
+	checkUsage(t, second, "foo/bar", 1000, 0)
+
+	// The namespace usage counted and recorded by the second instance is
+	// enforced and then updated by the first.
+	checkQuotaUpload(t, second.Layers(), "foo/baz", b, bDigest, QuotaScopeNamespace)
+
+	if err := first.Layers().Delete("foo/bar", aDigest); err != nil {
+		t.Fatalf("unexpected error deleting layer: %v", err)
+	}
+
+	checkUsage(t, second, "foo/bar", 0, 0)
+	checkQuotaUpload(t, second.Layers(), "foo/baz", b, bDigest, "")
+}
+
+// TestQuotasConcurrent ensures that the usage of concurrent pushes to a
+// repository is tracked without losing any of them.
+func TestQuotasConcurrent(t *testing.T) {
+	ss, err := NewServicesWithOptions(inmemory.New(), Options{
+		Quotas: Quotas{
+			Namespaces: map[string]int64{
+				"foo": 1 << 20,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	// Count the usage, such that pushes update the recorded usage.
+	checkUsage(t, ss, "foo/bar", 0, 0)
+
+	const pushes = 10
+	contents := make([][]byte, pushes)
+	digests := make([]digest.Digest, pushes)
+	for i := range contents {
+		contents[i], digests[i] = randomQuotaContent(t)
+	}
+
+	errs := make(chan error, pushes)
+	for i := range contents {
+		go func(p []byte, dgst digest.Digest) {
+			layerUpload, err := ss.Layers().Upload("foo/bar")
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer layerUpload.Close()
+
+			if _, err := layerUpload.Write(p); err != nil {
+				errs <- err
+				return
+			}
+
+			_, err = layerUpload.Finish(int64(len(p)), dgst)
+			errs <- err
+		}(contents[i], digests[i])
+	}
+
+	for i := 0; i < pushes; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("unexpected error uploading layer: %v", err)
+		}
+	}
+
+	usage, err := ss.Usage("foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting usage: %v", err)
+	}
+
+	expected := Quota{Scope: QuotaScopeNamespace, Name: "foo", Limit: 1 << 20, Usage: pushes * 1000}
+	if usage.Layers != pushes*1000 || len(usage.Quotas) != 1 || usage.Quotas[0] != expected {
+		t.Fatalf("unexpected usage after concurrent pushes: %#v", usage)
+	}
+}
+
+// TestQuotasValidation ensures that invalid quotas are rejected.
+func TestQuotasValidation(t *testing.T) {
+	for _, quotas := range []Quotas{
+		{Repositories: map[string]int64{"foo": 10}},
+		{Repositories: map[string]int64{"foo/bar": 0}},
+		{Namespaces: map[string]int64{"Foo": 10}},
+		{Namespaces: map[string]int64{"foo": -1}},
+	} {
+		if _, err := NewServicesWithOptions(inmemory.New(), Options{Quotas: quotas}); err == nil {
+			t.Fatalf("expected error creating services with quotas %#v", quotas)
+		}
+	}
+}
+
+// randomQuotaContent returns 1000 bytes of random layer content with its
+// sha256 digest.
+func randomQuotaContent(t *testing.T) ([]byte, digest.Digest) {
+	p := make([]byte, 1000)
+	if _, err := rand.Read(p); err != nil {
+		t.Fatalf("unexpected error generating random content: %v", err)
+	}
+
+	h := sha256.New()
+	h.Write(p)
+
+	return p, digest.NewDigest("sha256", h)
+}
+
+// checkQuotaUpload uploads p to the named repository. If scope is set, the
+// upload is expected to exceed a quota of that scope.
+func checkQuotaUpload(t *testing.T, ls LayerService, name string, p []byte, dgst digest.Digest, scope string) {
+	layerUpload, err := ls.Upload(name)
+	if err != nil {
+		t.Fatalf("unexpected error starting layer upload: %v", err)
+	}
+	defer layerUpload.Close()
+
+	if _, err := layerUpload.Write(p); err != nil {
+		t.Fatalf("unexpected error writing layer data: %v", err)
+	}
+
+	_, err = layerUpload.Finish(int64(len(p)), dgst)
+	if scope == "" {
+		if err != nil {
+			t.Fatalf("unexpected error finishing layer upload: %v", err)
+		}
+		return
+	}
+
+	qerr, ok := err.(ErrQuotaExceeded)
+	if !ok {
+		t.Fatalf("expected quota exceeded error, got %v", err)
+	}
+
+	if qerr.Quota.Scope != scope || qerr.Size != int64(len(p)) {
+		t.Fatalf("unexpected quota exceeded error: %#v", qerr)
+	}
+}
+
+func checkUsage(t *testing.T, ss *Services, name string, layers, manifests int64) {
+	usage, err := ss.Usage(name)
+	if err != nil {
+		t.Fatalf("unexpected error getting usage: %v", err)
+	}
+
+	if usage.Layers != layers || usage.Manifests != manifests {
+		t.Fatalf("unexpected usage of %s: %#v, expected %d layer and %d manifest bytes", name, usage, layers, manifests)
+	}
+}
+
+func signTestManifest(t *testing.T, pk libtrust.PrivateKey, name, tag string, layers ...digest.Digest) *SignedManifest {
+	manifest := Manifest{
+		Versioned: Versioned{
+			SchemaVersion: 1,
+		},
+		Name: name,
+		Tag:  tag,
+	}
+
+	for _, layer := range layers {
+		manifest.FSLayers = append(manifest.FSLayers, FSLayer{BlobSum: layer})
+	}
+	manifest.History = testHistory(len(layers))
+
+	sm, err := manifest.Sign(pk)
+	if err != nil {
+		t.Fatalf("error signing manifest: %v", err)
+	}
+
+	return sm
+}
@@ -0,0 +1,550 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker-registry/common"
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/docker-registry/storagedriver"
+)
+
+// ErrRepositoryExists is returned when a repository cannot be created
+// because the name is already in use.
+type ErrRepositoryExists struct {
+	Name string
+}
+
+func (err ErrRepositoryExists) Error() string {
+	return fmt.Sprintf("repository %s already exists", err.Name)
+}
+
+// ErrManifestResignRequired is returned when the manifests of a repository
+// would need to be re-signed to change their name, but no signing key is
+// available.
+type ErrManifestResignRequired struct {
+	Name string
+}
+
+func (err ErrManifestResignRequired) Error() string {
+	return fmt.Sprintf("manifests of %s must be re-signed for a new name, but no signing key is configured", err.Name)
+}
+
+// Copy copies the repository named from to the new repository named to. The
+// layer links, manifest revisions, tags and tag history of the repository
+// are copied, but the layer data in the blob store is shared rather than
+// copied.
+//
+// The name of a repository is part of the signed payload of its manifests.
+// If the repository has manifests, each revision is rewritten with the new
+// name and re-signed with the signing key of the registry, giving it a new
+// digest. Tags, their history and manifest lists are updated to reference
+// the new revisions.
+// If no signing key is configured, ErrManifestResignRequired is returned and
+// nothing is copied. Only repositories holding nothing but layers can be
+// copied without a key.
+//
+// The rewritten revisions are signed by the registry alone: the signatures
+// of the original signers no longer match the payload, so neither the
+// signatures embedded in the revisions nor those added separately are
+// copied. Each revision is put to the destination as if pushed, subject to
+// validation and to the trust policies of the destination, which must trust
+// the signing key of the registry. If admit is not nil, each rewritten
+// revision must also be admitted.
+//
+// The destination must not exist. The copy counts towards the quotas
+// applying to it, like any other push. Every rewritten revision is checked
+// against the trust policies and admitted before anything is written. The
+// operation is not atomic, but if writing the destination fails part way,
+// what was written is removed again.
+func (ss *Services) Copy(from, to string, admit AdmitFunc) error {
+	rc, err := ss.newRepositoryCopier(from, to, false, admit)
+	if err != nil {
+		return err
+	}
+
+	return rc.copy()
+}
+
+// Rename moves the repository named from to the new repository named to. The
+// repository is copied, as by Copy, and then removed under its old name.
+// Repositories nested under the old name are not moved. Uploads in progress
+// to the old name continue to complete there.
+func (ss *Services) Rename(from, to string, admit AdmitFunc) error {
+	rc, err := ss.newRepositoryCopier(from, to, true, admit)
+	if err != nil {
+		return err
+	}
+
+	if err := rc.copy(); err != nil {
+		return err
+	}
+
+	return ss.removeRepository(from)
+}
+
+// repositoryCopier copies the content of one repository to another.
+type repositoryCopier struct {
+	*Services
+	from, to string
+
+	// revisions lists the manifest revisions of the source repository. Once
+	// rewritten, each maps to the digest of the revision to be written to
+	// the destination.
+	revisions map[digest.Digest]digest.Digest
+
+	// admit, if set, decides whether each rewritten revision may be
+	// stored.
+	admit AdmitFunc
+}
+
+// newRepositoryCopier validates that from may be copied to to, before
+// anything is written. If move is true, the content of from is not counted
+// again towards the namespace quotas shared by both names.
+func (ss *Services) newRepositoryCopier(from, to string, move bool, admit AdmitFunc) (*repositoryCopier, error) {
+	for _, name := range []string{from, to} {
+		if err := common.ValidateRespositoryName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if from == to {
+		return nil, ErrRepositoryExists{Name: to}
+	}
+
+	exists, err := ss.repositoryExists(from)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, ErrUnknownRepository{Name: from}
+	}
+
+	exists, err = ss.repositoryExists(to)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		return nil, ErrRepositoryExists{Name: to}
+	}
+
+	rc := &repositoryCopier{
+		Services:  ss,
+		from:      from,
+		to:        to,
+		revisions: make(map[digest.Digest]digest.Digest),
+		admit:     admit,
+	}
+
+	if err := rc.listRevisions(); err != nil {
+		return nil, err
+	}
+
+	if len(rc.revisions) > 0 && ss.signingKey == nil {
+		return nil, ErrManifestResignRequired{Name: from}
+	}
+
+	if err := rc.checkQuotas(move); err != nil {
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+// repositoryExists returns true if the named repository has manifests or
+// layers.
+func (ss *Services) repositoryExists(name string) (bool, error) {
+	revisionsPath, err := ss.pathMapper.path(manifestRevisionsPathSpec{name: name})
+	if err != nil {
+		return false, err
+	}
+
+	layersPath, err := ss.pathMapper.path(layersPathSpec{name: name})
+	if err != nil {
+		return false, err
+	}
+
+	for _, p := range []string{path.Dir(revisionsPath), layersPath} {
+		if _, err := ss.driver.Stat(p); err != nil {
+			switch err.(type) {
+			case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+				continue
+			default:
+				return false, err
+			}
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// listRevisions collects the manifest revisions linked into the source
+// repository.
+func (rc *repositoryCopier) listRevisions() error {
+	revisionsPath, err := rc.pathMapper.path(manifestRevisionsPathSpec{name: rc.from})
+	if err != nil {
+		return err
+	}
+
+	err = walk(rc.driver, revisionsPath, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		content, err := rc.driver.GetContent(fileInfo.Path())
+		if err != nil {
+			return err
+		}
+
+		revision, err := digest.ParseDigest(string(content))
+		if err != nil {
+			return err
+		}
+
+		rc.revisions[revision] = ""
+		return nil
+	})
+
+	switch err.(type) {
+	case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+		// The repository only has layers.
+		return nil
+	}
+
+	return err
+}
+
+// checkQuotas ensures that the usage of the source repository fits within
+// the quotas applying to the destination. When moving, namespace quotas that
+// also contain the source are unaffected.
+func (rc *repositoryCopier) checkQuotas(move bool) error {
+	usage, err := rc.quotas.usage(rc.from)
+	if err != nil {
+		return err
+	}
+
+	quotas, err := rc.quotas.quotasFor(rc.to, repositoryUsage{})
+	if err != nil {
+		return err
+	}
+
+	for _, quota := range quotas {
+		if move && quota.Scope == QuotaScopeNamespace && withinNamespace(rc.from, quota.Name) {
+			continue
+		}
+
+		if quota.Usage+usage.total() > quota.Limit {
+			return ErrQuotaExceeded{
+				Name:  rc.to,
+				Quota: quota,
+				Size:  usage.total(),
+			}
+		}
+	}
+
+	return nil
+}
+
+// copy rewrites the manifest revisions of the source repository, checking
+// each is trusted and admitted by the destination, and then writes the layer
+// links, rewritten revisions and tags to the destination, such that its usage
+// is counted again. If writing fails, the partially written destination is
+// removed.
+func (rc *repositoryCopier) copy() error {
+	ms := rc.Manifests().(*manifestStore)
+
+	// The usage of the source has already been checked against the quotas
+	// of the destination as a whole.
+	ms.quotas = nil
+
+	rewritten, err := rc.rewriteRevisions(ms)
+	if err != nil {
+		return err
+	}
+
+	if err := rc.write(ms, rewritten); err != nil {
+		if err := rc.removeRepository(rc.to); err != nil {
+			logrus.Errorf("copy: error removing partial copy of %s to %s: %v", rc.from, rc.to, err)
+		}
+
+		return err
+	}
+
+	return rc.quotas.recount(rc.to)
+}
+
+// rewriteRevisions rewrites each manifest revision of the source repository
+// with the name of the destination and re-signs it, recording the digest of
+// the rewritten revision. Image manifests are returned before the manifest
+// lists referencing them. Nothing is written: the rewritten revisions are
+// checked against the trust policies of the destination and admitted before
+// any of them are put.
+func (rc *repositoryCopier) rewriteRevisions(ms *manifestStore) ([]*SignedManifest, error) {
+	var rewritten []*SignedManifest
+
+	lists := make(map[digest.Digest]*SignedManifest)
+	for revision := range rc.revisions {
+		sm, err := ms.getRevision(revision)
+		if err != nil {
+			return nil, err
+		}
+
+		if sm.List != nil {
+			lists[revision] = sm
+			continue
+		}
+
+		manifest := sm.Manifest
+		manifest.Name = rc.to
+
+		signed, err := manifest.Sign(rc.signingKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := rc.admitRevision(ms, revision, signed); err != nil {
+			return nil, err
+		}
+
+		rewritten = append(rewritten, signed)
+	}
+
+	// Manifest lists reference image manifests by digest, so are rewritten
+	// once the digests of the rewritten manifests are known.
+	for revision, sm := range lists {
+		list := *sm.List
+		list.Name = rc.to
+		list.Manifests = make([]ManifestDescriptor, len(sm.List.Manifests))
+		for i, descriptor := range sm.List.Manifests {
+			descriptor.Digest = rc.rewritten(descriptor.Digest)
+			list.Manifests[i] = descriptor
+		}
+
+		signed, err := list.Sign(rc.signingKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := rc.admitRevision(ms, revision, signed); err != nil {
+			return nil, err
+		}
+
+		rewritten = append(rewritten, signed)
+	}
+
+	return rewritten, nil
+}
+
+// admitRevision checks the rewritten copy of the manifest revision against
+// the trust policies of the destination and admits it, recording its digest.
+func (rc *repositoryCopier) admitRevision(ms *manifestStore, revision digest.Digest, signed *SignedManifest) error {
+	if err := ms.checkTrust(rc.to, signed, false); err != nil {
+		return ErrManifestVerification{err}
+	}
+
+	if rc.admit != nil {
+		if err := rc.admit(rc.to, signed.Tag, signed); err != nil {
+			return err
+		}
+	}
+
+	dgst, err := signed.Digest()
+	if err != nil {
+		return err
+	}
+
+	rc.revisions[revision] = dgst
+	return nil
+}
+
+// write writes the layer links, the rewritten manifest revisions and the tags
+// of the source repository to the destination. The revisions are put as if
+// pushed, subject to the same checks as a push of the manifest, except for
+// quotas.
+func (rc *repositoryCopier) write(ms *manifestStore, rewritten []*SignedManifest) error {
+	if err := rc.copyLayers(); err != nil {
+		return err
+	}
+
+	for _, signed := range rewritten {
+		if _, err := ms.putRevision(rc.to, signed.Tag, signed, nil); err != nil {
+			return err
+		}
+	}
+
+	return rc.copyTags()
+}
+
+// copyLayers copies the layer links of the source repository. The linked
+// blobs are shared by both repositories.
+func (rc *repositoryCopier) copyLayers() error {
+	fromPath, err := rc.pathMapper.path(layersPathSpec{name: rc.from})
+	if err != nil {
+		return err
+	}
+
+	toPath, err := rc.pathMapper.path(layersPathSpec{name: rc.to})
+	if err != nil {
+		return err
+	}
+
+	err = walk(rc.driver, fromPath, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		content, err := rc.driver.GetContent(fileInfo.Path())
+		if err != nil {
+			return err
+		}
+
+		return rc.driver.PutContent(toPath+strings.TrimPrefix(fileInfo.Path(), fromPath), content)
+	})
+
+	switch err.(type) {
+	case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+		// The repository has no layers.
+		return nil
+	}
+
+	return err
+}
+
+// copyTags copies the tags of the source repository and their history,
+// referencing the rewritten revisions.
+func (rc *repositoryCopier) copyTags() error {
+	tags, err := rc.Manifests().Tags(rc.from)
+	if err != nil {
+		switch err.(type) {
+		case ErrUnknownRepository:
+			return nil
+		default:
+			return err
+		}
+	}
+
+	for _, tag := range tags {
+		currentPath, err := rc.pathMapper.path(manifestTagCurrentPathSpec{name: rc.from, tag: tag})
+		if err != nil {
+			return err
+		}
+
+		content, err := rc.driver.GetContent(currentPath)
+		if err != nil {
+			return err
+		}
+
+		current, err := digest.ParseDigest(string(content))
+		if err != nil {
+			return err
+		}
+
+		currentPath, err = rc.pathMapper.path(manifestTagCurrentPathSpec{name: rc.to, tag: tag})
+		if err != nil {
+			return err
+		}
+
+		if err := rc.driver.PutContent(currentPath, []byte(rc.rewritten(current))); err != nil {
+			return err
+		}
+
+		if err := rc.copyTagIndex(tag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyTagIndex copies the history of the tag, keeping the time and identity
+// of each entry.
+func (rc *repositoryCopier) copyTagIndex(tag string) error {
+	indexPath, err := rc.pathMapper.path(manifestTagIndexPathSpec{name: rc.from, tag: tag})
+	if err != nil {
+		return err
+	}
+
+	entryPaths, err := rc.driver.List(indexPath)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// Tags written before the index was introduced have no history.
+			return nil
+		default:
+			return err
+		}
+	}
+
+	for _, entryPath := range entryPaths {
+		content, err := rc.driver.GetContent(entryPath)
+		if err != nil {
+			return err
+		}
+
+		var entry TagIndexEntry
+		if err := json.Unmarshal(content, &entry); err != nil {
+			return err
+		}
+
+		entry.Revision = rc.rewritten(entry.Revision)
+
+		p, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		copiedPath, err := rc.pathMapper.path(manifestTagIndexEntryPathSpec{name: rc.to, tag: tag, entry: path.Base(entryPath)})
+		if err != nil {
+			return err
+		}
+
+		if err := rc.driver.PutContent(copiedPath, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewritten returns the digest of the copy of the revision. Revisions that
+// are no longer linked into the source repository are left unchanged.
+func (rc *repositoryCopier) rewritten(revision digest.Digest) digest.Digest {
+	if rewritten := rc.revisions[revision]; rewritten != "" {
+		return rewritten
+	}
+
+	return revision
+}
+
+// removeRepository removes the manifests and layer links of the named
+// repository. Repositories nested under the name are left in place.
+func (ss *Services) removeRepository(name string) error {
+	revisionsPath, err := ss.pathMapper.path(manifestRevisionsPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	layersPath, err := ss.pathMapper.path(layersPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []string{path.Dir(revisionsPath), layersPath} {
+		if err := ss.driver.Delete(p); err != nil {
+			switch err.(type) {
+			case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			default:
+				return err
+			}
+		}
+	}
+
+	return ss.quotas.recount(name)
+}
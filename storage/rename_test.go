@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/docker/libtrust"
+
+	"github.com/docker/docker-registry/storagedriver/inmemory"
+)
+
+// TestRenameRepository ensures that renaming a repository moves its layers,
+// manifests and tag history, re-signing the manifests with the new name.
+func TestRenameRepository(t *testing.T) {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	registryKey, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	driver := inmemory.New()
+	ss, err := NewServicesWithOptions(driver, Options{SigningKey: registryKey})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	_, layer, _, err := writeRandomLayer(driver, ss.pathMapper, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error writing layer: %v", err)
+	}
+
+	_, nested, _, err := writeRandomLayer(driver, ss.pathMapper, "foo/bar/nested")
+	if err != nil {
+		t.Fatalf("unexpected error writing layer: %v", err)
+	}
+
+	ms := ss.Manifests().(*manifestStore)
+	for _, tag := range []string{"latest", "latest", "v1"} {
+		if err := ms.Put("foo/bar", tag, signTestManifest(t, pk, "foo/bar", tag, layer)); err != nil {
+			t.Fatalf("unexpected error putting manifest: %v", err)
+		}
+	}
+
+	// ECDSA signatures differ on each signing, so latest has two revisions.
+	history, err := ms.TagHistory("foo/bar", "latest")
+	if err != nil {
+		t.Fatalf("unexpected error fetching tag history: %v", err)
+	}
+
+	if err := ss.Rename("foo/bar", "foo/baz", nil); err != nil {
+		t.Fatalf("unexpected error renaming repository: %v", err)
+	}
+
+	checkTags(t, ms, "foo/baz", "latest", "v1")
+
+	renamed, err := ms.TagHistory("foo/baz", "latest")
+	if err != nil {
+		t.Fatalf("unexpected error fetching tag history: %v", err)
+	}
+
+	if len(renamed) != len(history) {
+		t.Fatalf("unexpected tag history: %#v", renamed)
+	}
+
+	for i, entry := range renamed {
+		if entry.Revision == history[i].Revision || !entry.Timestamp.Equal(history[i].Timestamp) {
+			t.Fatalf("unexpected tag index entry: %#v, renamed from %#v", entry, history[i])
+		}
+
+		sm, err := ms.GetByDigest("foo/baz", entry.Revision)
+		if err != nil {
+			t.Fatalf("unexpected error fetching renamed revision: %v", err)
+		}
+
+		checkRenamedManifest(t, sm, "foo/baz", registryKey)
+	}
+
+	sm, err := ms.Get("foo/baz", "latest")
+	if err != nil {
+		t.Fatalf("unexpected error fetching renamed manifest: %v", err)
+	}
+
+	checkRenamedManifest(t, sm, "foo/baz", registryKey)
+
+	if _, err := ms.Tags("foo/bar"); err == nil {
+		t.Fatalf("expected renamed repository to have no tags")
+	}
+
+	checkLayerExists(t, ss, "foo/bar", layer, false)
+	checkLayerExists(t, ss, "foo/baz", layer, true)
+	checkLayerExists(t, ss, "foo/bar/nested", nested, true)
+
+	usage, err := ss.Usage("foo/baz")
+	if err != nil {
+		t.Fatalf("unexpected error getting usage: %v", err)
+	}
+
+	if usage.Layers == 0 || usage.Manifests == 0 {
+		t.Fatalf("expected usage to be recorded for renamed repository: %#v", usage)
+	}
+
+	if err := ss.Rename("foo/baz", "foo/bar/nested", nil); err == nil {
+		t.Fatalf("expected error renaming to existing repository")
+	} else if _, ok := err.(ErrRepositoryExists); !ok {
+		t.Fatalf("unexpected error renaming to existing repository: %v", err)
+	}
+
+	if err := ss.Rename("foo/bar", "foo/qux", nil); err == nil {
+		t.Fatalf("expected error renaming unknown repository")
+	} else if _, ok := err.(ErrUnknownRepository); !ok {
+		t.Fatalf("unexpected error renaming unknown repository: %v", err)
+	}
+}
+
+// TestCopyRepositoryWithoutSigningKey ensures that only repositories without
+// manifests can be copied without a signing key.
+func TestCopyRepositoryWithoutSigningKey(t *testing.T) {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	driver := inmemory.New()
+	ss := NewServices(driver)
+
+	_, layer, _, err := writeRandomLayer(driver, ss.pathMapper, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error writing layer: %v", err)
+	}
+
+	if err := ss.Copy("foo/bar", "foo/baz", nil); err != nil {
+		t.Fatalf("unexpected error copying repository: %v", err)
+	}
+
+	checkLayerExists(t, ss, "foo/bar", layer, true)
+	checkLayerExists(t, ss, "foo/baz", layer, true)
+
+	ms := ss.Manifests()
+	if err := ms.Put("foo/bar", "latest", signTestManifest(t, pk, "foo/bar", "latest", layer)); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	if err := ss.Copy("foo/bar", "foo/qux", nil); err == nil {
+		t.Fatalf("expected error copying repository with manifests")
+	} else if _, ok := err.(ErrManifestResignRequired); !ok {
+		t.Fatalf("unexpected error copying repository with manifests: %v", err)
+	}
+
+	checkLayerExists(t, ss, "foo/qux", layer, false)
+}
+
+// TestCopyRepositoryTrust ensures that copied manifests are subject to the
+// trust policies of the destination and keep no signatures of the original
+// signers.
+func TestCopyRepositoryTrust(t *testing.T) {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	registryKey, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	driver := inmemory.New()
+	newServices := func(trusted ...libtrust.PublicKey) *Services {
+		ss, err := NewServicesWithOptions(driver, Options{
+			SigningKey: registryKey,
+			TrustPolicies: []TrustPolicy{
+				{
+					Repository: regexp.MustCompile("^trusted/.*$"),
+					Keys:       trusted,
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error creating services: %v", err)
+		}
+
+		return ss
+	}
+
+	ss := newServices(pk.PublicKey())
+
+	_, layer, _, err := writeRandomLayer(driver, ss.pathMapper, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error writing layer: %v", err)
+	}
+
+	ms := ss.Manifests()
+	if err := ms.Put("foo/bar", "latest", signTestManifest(t, pk, "foo/bar", "latest", layer)); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	// The copy is signed by the registry alone, which the destination does
+	// not trust.
+	err = ss.Copy("foo/bar", "trusted/bar", nil)
+	if verr, ok := err.(ErrManifestVerification); !ok || len(verr) != 1 {
+		t.Fatalf("expected copy to untrusting destination to fail: %v", err)
+	} else if _, ok := verr[0].(ErrManifestUntrusted); !ok {
+		t.Fatalf("unexpected error copying repository: %v", err)
+	}
+
+	checkRepositoryNotExists(t, ss, "trusted/bar")
+	checkLayerExists(t, ss, "trusted/bar", layer, false)
+
+	ss = newServices(pk.PublicKey(), registryKey.PublicKey())
+	if err := ss.Copy("foo/bar", "trusted/baz", nil); err != nil {
+		t.Fatalf("unexpected error copying repository: %v", err)
+	}
+
+	sm, err := ss.Manifests().Get("trusted/baz", "latest")
+	if err != nil {
+		t.Fatalf("unexpected error fetching copied manifest: %v", err)
+	}
+
+	checkRenamedManifest(t, sm, "trusted/baz", registryKey)
+}
+
+// TestCopyRepositoryFailure ensures that a copy denied by the admitter or
+// failing part way leaves nothing written to the destination.
+func TestCopyRepositoryFailure(t *testing.T) {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	registryKey, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	driver := inmemory.New()
+	ss, err := NewServicesWithOptions(driver, Options{
+		SigningKey: registryKey,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	_, layer, _, err := writeRandomLayer(driver, ss.pathMapper, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error writing layer: %v", err)
+	}
+
+	_, other, _, err := writeRandomLayer(driver, ss.pathMapper, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error writing layer: %v", err)
+	}
+
+	ms := ss.Manifests()
+	if err := ms.Put("foo/bar", "latest", signTestManifest(t, pk, "foo/bar", "latest", layer)); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	if err := ms.Put("foo/bar", "other", signTestManifest(t, pk, "foo/bar", "other", other)); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	admitter := &testAdmitter{denied: "foo/denied"}
+	if err := ss.Copy("foo/bar", "foo/denied", admitter.admit); err != errTestDenied {
+		t.Fatalf("expected copy to be denied: %v", err)
+	}
+
+	checkRepositoryNotExists(t, ss, "foo/denied")
+
+	// Removing a layer referenced by a manifest fails the copy once the
+	// layer links have been written.
+	if err := ss.Layers().Delete("foo/bar", other); err != nil {
+		t.Fatalf("unexpected error deleting layer: %v", err)
+	}
+
+	if err := ss.Copy("foo/bar", "foo/baz", nil); err == nil {
+		t.Fatalf("expected copy of manifest with unknown layer to fail")
+	} else if _, ok := err.(ErrManifestVerification); !ok {
+		t.Fatalf("unexpected error copying repository: %v", err)
+	}
+
+	checkRepositoryNotExists(t, ss, "foo/baz")
+	checkLayerExists(t, ss, "foo/baz", layer, false)
+	checkUsage(t, ss, "foo/baz", 0, 0)
+}
+
+// checkRepositoryNotExists ensures that nothing is stored in the named
+// repository.
+func checkRepositoryNotExists(t *testing.T, ss *Services, name string) {
+	exists, err := ss.repositoryExists(name)
+	if err != nil {
+		t.Fatalf("unexpected error checking repository: %v", err)
+	}
+
+	if exists {
+		t.Fatalf("unexpected repository %s", name)
+	}
+}
+
+// checkRenamedManifest ensures that the manifest carries the new name and is
+// signed by the registry key.
+func checkRenamedManifest(t *testing.T, sm *SignedManifest, name string, registryKey libtrust.PrivateKey) {
+	if sm.Name != name {
+		t.Fatalf("unexpected manifest name: %q != %q", sm.Name, name)
+	}
+
+	keys, err := sm.Verify()
+	if err != nil {
+		t.Fatalf("unexpected error verifying renamed manifest: %v", err)
+	}
+
+	if len(keys) != 1 || keys[0].KeyID() != registryKey.KeyID() {
+		t.Fatalf("expected renamed manifest to be signed by the registry key: %v", keys)
+	}
+}
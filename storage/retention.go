@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker-registry/storagedriver"
+)
+
+// RetentionPolicy selects tags to delete from the repositories it applies
+// to. A tag is expired if it is not among the KeepLast most recently
+// modified tags matching the policy, or was last modified more than MaxAge
+// ago. If both are set, a tag is only expired if it is older than MaxAge and
+// not among the KeepLast most recent, so that the latest tags are retained
+// regardless of age.
+type RetentionPolicy struct {
+	// Repository matches the names of the repositories to which the policy
+	// applies. If nil, the policy applies to all repositories.
+	Repository *regexp.Regexp
+
+	// Tag matches the tags to which the policy applies. If nil, the policy
+	// applies to all tags.
+	Tag *regexp.Regexp
+
+	// KeepLast is the number of most recently modified tags to retain. Zero
+	// places no limit on the number of tags.
+	KeepLast int
+
+	// MaxAge is the time since its last modification after which a tag is
+	// expired. Zero places no limit on the age of tags.
+	MaxAge time.Duration
+}
+
+// validate ensures that the policy limits the tags to retain.
+func (policy RetentionPolicy) validate() error {
+	if policy.KeepLast < 0 || policy.MaxAge < 0 {
+		return fmt.Errorf("retention policy limits must not be negative: %#v", policy)
+	}
+
+	if policy.KeepLast == 0 && policy.MaxAge == 0 {
+		return fmt.Errorf("retention policy must limit the number or age of tags")
+	}
+
+	return nil
+}
+
+// ExpiredTag describes a tag selected for deletion by a retention policy.
+type ExpiredTag struct {
+	Name string
+	Tag  string
+
+	// Modified is the time at which the tag was last updated.
+	Modified time.Time
+
+	// Err is set if the tag could not be deleted, such as when it is
+	// immutable.
+	Err error
+}
+
+// ExpireTags applies the retention policies to all repositories, deleting
+// the tags expired by any policy as of now. The modification time of a tag
+// is that of the link to its current revision, as reported by the storage
+// driver. Deleting a tag leaves its revisions available by digest until they
+// are garbage collected. If dryRun is true, the expired tags are only
+// reported. Tags that cannot be deleted are reported with the error and do
+// not stop the remaining tags from being expired.
+func (ss *Services) ExpireTags(policies []RetentionPolicy, now time.Time, dryRun bool) ([]ExpiredTag, error) {
+	for _, policy := range policies {
+		if err := policy.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	ms := ss.Manifests().(*manifestStore)
+
+	var expired []ExpiredTag
+	err := walkRepositories(ss.driver, ss.pathMapper, func(name string) error {
+		tags, err := ss.expiredTags(ms, name, policies, now)
+		if err != nil {
+			return err
+		}
+
+		for _, et := range tags {
+			if !dryRun {
+				et.Err = ms.Delete(et.Name, et.Tag)
+				if et.Err != nil {
+					logrus.Warnf("retention: error deleting tag %s:%s: %v", et.Name, et.Tag, et.Err)
+				}
+			}
+
+			expired = append(expired, et)
+		}
+
+		return nil
+	})
+
+	return expired, err
+}
+
+// expiredTags returns the tags of the named repository expired by any of the
+// policies, in lexical order.
+func (ss *Services) expiredTags(ms *manifestStore, name string, policies []RetentionPolicy, now time.Time) ([]ExpiredTag, error) {
+	var applicable []RetentionPolicy
+	for _, policy := range policies {
+		if policy.Repository == nil || policy.Repository.MatchString(name) {
+			applicable = append(applicable, policy)
+		}
+	}
+
+	if len(applicable) == 0 {
+		return nil, nil
+	}
+
+	tags, err := ms.Tags(name)
+	if err != nil {
+		switch err.(type) {
+		case ErrUnknownRepository:
+			// The repository has layers but no tags.
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	var modified []ExpiredTag
+	for _, tag := range tags {
+		p, err := ss.pathMapper.path(manifestTagCurrentPathSpec{name: name, tag: tag})
+		if err != nil {
+			return nil, err
+		}
+
+		fi, err := ss.driver.Stat(p)
+		if err != nil {
+			switch err.(type) {
+			case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+				// The tag was deleted since listing.
+				continue
+			default:
+				return nil, err
+			}
+		}
+
+		modified = append(modified, ExpiredTag{Name: name, Tag: tag, Modified: fi.ModTime()})
+	}
+
+	// Most recently modified first, so that the tags to keep come first.
+	sort.Sort(byModifiedDescending(modified))
+
+	expired := make(map[string]ExpiredTag)
+	for _, policy := range applicable {
+		var matched int
+		for _, et := range modified {
+			if policy.Tag != nil && !policy.Tag.MatchString(et.Tag) {
+				continue
+			}
+			matched++
+
+			beyondLast := policy.KeepLast > 0 && matched > policy.KeepLast
+			tooOld := policy.MaxAge > 0 && now.Sub(et.Modified) > policy.MaxAge
+
+			switch {
+			case policy.KeepLast > 0 && policy.MaxAge > 0:
+				if !beyondLast || !tooOld {
+					continue
+				}
+			case !beyondLast && !tooOld:
+				continue
+			}
+
+			expired[et.Tag] = et
+		}
+	}
+
+	var result []ExpiredTag
+	for _, tag := range tags {
+		if et, ok := expired[tag]; ok {
+			result = append(result, et)
+		}
+	}
+
+	return result, nil
+}
+
+// byModifiedDescending sorts tags by modification time, most recent first.
+// Tags modified at the same time are sorted lexically.
+type byModifiedDescending []ExpiredTag
+
+func (s byModifiedDescending) Len() int      { return len(s) }
+func (s byModifiedDescending) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byModifiedDescending) Less(i, j int) bool {
+	if s[i].Modified.Equal(s[j].Modified) {
+		return s[i].Tag < s[j].Tag
+	}
+
+	return s[i].Modified.After(s[j].Modified)
+}
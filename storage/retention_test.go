@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/docker/libtrust"
+
+	"github.com/docker/docker-registry/storagedriver/inmemory"
+)
+
+// TestExpireTags ensures that retention policies delete the expected tags and
+// that nothing is deleted in a dry run.
+func TestExpireTags(t *testing.T) {
+	ss, err := NewServicesWithOptions(inmemory.New(), Options{
+		ImmutableTags: []TagRule{
+			{Tag: regexp.MustCompile(`^v1$`)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	ms := ss.Manifests()
+	for _, tagged := range []struct {
+		name, tag string
+	}{
+		{"ci/app", "commit-1"},
+		{"ci/app", "commit-2"},
+		{"ci/app", "commit-3"},
+		{"ci/app", "commit-4"},
+		{"ci/app", "latest"},
+		{"ci/app", "v1"},
+		{"other/app", "commit-1"},
+		{"other/app", "commit-2"},
+	} {
+		if err := ms.Put(tagged.name, tagged.tag, signTestManifest(t, pk, tagged.name, tagged.tag)); err != nil {
+			t.Fatalf("unexpected error putting manifest: %v", err)
+		}
+
+		// Give each tag a distinct modification time.
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	keepLast := RetentionPolicy{
+		Repository: regexp.MustCompile(`^ci/.*$`),
+		Tag:        regexp.MustCompile(`^commit-.*$`),
+		KeepLast:   2,
+	}
+
+	now := time.Now()
+	later := now.Add(2 * time.Hour)
+
+	checkExpireTags(t, ss, []RetentionPolicy{keepLast}, now, true, "ci/app:commit-1", "ci/app:commit-2")
+	checkTags(t, ms, "ci/app", "commit-1", "commit-2", "commit-3", "commit-4", "latest", "v1")
+
+	checkExpireTags(t, ss, []RetentionPolicy{keepLast}, now, false, "ci/app:commit-1", "ci/app:commit-2")
+	checkTags(t, ms, "ci/app", "commit-3", "commit-4", "latest", "v1")
+
+	// The most recent tags are kept regardless of age.
+	maxAge := RetentionPolicy{
+		Repository: regexp.MustCompile(`^other/.*$`),
+		MaxAge:     time.Hour,
+	}
+
+	checkExpireTags(t, ss, []RetentionPolicy{maxAge}, now, false)
+
+	keepRecent := maxAge
+	keepRecent.KeepLast = 1
+	checkExpireTags(t, ss, []RetentionPolicy{keepRecent}, later, true, "other/app:commit-1")
+	checkExpireTags(t, ss, []RetentionPolicy{maxAge}, later, true, "other/app:commit-1", "other/app:commit-2")
+
+	// Immutable tags are reported but not deleted.
+	expired, err := ss.ExpireTags([]RetentionPolicy{{Tag: regexp.MustCompile(`^v1$`), MaxAge: time.Hour}}, later, false)
+	if err != nil {
+		t.Fatalf("unexpected error expiring tags: %v", err)
+	}
+
+	if len(expired) != 1 {
+		t.Fatalf("unexpected expired tags: %#v", expired)
+	}
+
+	if _, ok := expired[0].Err.(ErrTagImmutable); !ok {
+		t.Fatalf("expected tag immutable error: %#v", expired[0].Err)
+	}
+
+	checkTags(t, ms, "ci/app", "commit-3", "commit-4", "latest", "v1")
+
+	if _, err := ss.ExpireTags([]RetentionPolicy{{Tag: regexp.MustCompile(`.*`)}}, now, true); err == nil {
+		t.Fatalf("expected error for policy without limits")
+	}
+}
+
+// checkExpireTags runs the policies, ensuring that the expected tags, given
+// as name:tag, are expired without errors.
+func checkExpireTags(t *testing.T, ss *Services, policies []RetentionPolicy, now time.Time, dryRun bool, expected ...string) {
+	expired, err := ss.ExpireTags(policies, now, dryRun)
+	if err != nil {
+		t.Fatalf("unexpected error expiring tags: %v", err)
+	}
+
+	var found []string
+	for _, et := range expired {
+		if et.Err != nil {
+			t.Fatalf("unexpected error expiring %s:%s: %v", et.Name, et.Tag, et.Err)
+		}
+
+		found = append(found, et.Name+":"+et.Tag)
+	}
+
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("unexpected expired tags: %v != %v", found, expected)
+	}
+}
+
+func checkTags(t *testing.T, ms ManifestService, name string, expected ...string) {
+	tags, err := ms.Tags(name)
+	if err != nil {
+		t.Fatalf("unexpected error listing tags: %v", err)
+	}
+
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("unexpected tags: %v != %v", tags, expected)
+	}
+}
@@ -1,8 +1,11 @@
 package storage
 
 import (
+	"fmt"
+
 	"github.com/docker/docker-registry/digest"
 	"github.com/docker/docker-registry/storagedriver"
+	"github.com/docker/libtrust"
 )
 
 // Services provides various services with application-level operations for
@@ -11,12 +14,87 @@ type Services struct {
 	driver           storagedriver.StorageDriver
 	pathMapper       *pathMapper
 	layerUploadStore layerUploadStore
+	digestAlgorithms map[string]bool
+	quotas           *quotaStore
+	immutableTags    []TagRule
+	trustPolicies    []TrustPolicy
+	signingKey       libtrust.PrivateKey
+	signUnsigned     bool
+	validators       []ManifestValidator
+}
+
+// The following are the locations where in-progress layer uploads may be
+// kept, as selected by Options.UploadStore.
+const (
+	// UploadStoreLocal keeps uploads in a temporary directory on the local
+	// filesystem. Uploads can only be resumed on the same registry instance.
+	UploadStoreLocal = "local"
+
+	// UploadStoreDriver keeps uploads in the storage driver, allowing them
+	// to be resumed by any registry instance sharing the storage.
+	UploadStoreDriver = "driver"
+)
+
+// Options configures the behavior of Services. The zero value provides the
+// default behavior.
+type Options struct {
+	// UploadStore selects where in-progress layer uploads are kept. If
+	// empty, UploadStoreLocal is used.
+	UploadStore string
+
+	// DigestAlgorithms lists the digest algorithms accepted to identify
+	// uploaded layers, such as "tarsum.v1+sha256" or "sha256". If empty,
+	// DefaultDigestAlgorithms are accepted.
+	DigestAlgorithms []string
+
+	// Quotas limits the bytes stored by repositories. Usage may be queried
+	// whether or not any quotas are set.
+	Quotas Quotas
+
+	// ImmutableTags lists rules matching tags that may not be changed or
+	// deleted once they exist.
+	ImmutableTags []TagRule
+
+	// TrustPolicies lists the signers trusted for manifests, by repository.
+	// A manifest put to a repository must be trusted by every policy
+	// matching the repository.
+	TrustPolicies []TrustPolicy
+
+	// SigningKey is used to re-sign manifests rewritten by the registry,
+	// such as when a repository is renamed or copied. If nil, operations
+	// requiring manifests to be re-signed are rejected.
+	SigningKey libtrust.PrivateKey
+
+	// SignUnsigned allows unsigned manifests to be put, signing them with
+	// SigningKey, which must be set. Trust policies are checked before
+	// signing, so unsigned manifests are still rejected from repositories
+	// matched by a trust policy, even one trusting SigningKey.
+	SignUnsigned bool
+
+	// ManifestValidators are run on image manifests put to the registry,
+	// after the built-in validation of their history. Errors returned by
+	// the validators are reported as ErrManifestInvalid.
+	ManifestValidators []ManifestValidator
+}
+
+// DefaultDigestAlgorithms are the digest algorithms accepted for uploaded
+// layers, unless configured otherwise. Plain content digests allow storing
+// layers that are not tar archives.
+var DefaultDigestAlgorithms = []string{"tarsum.v1+sha256", "sha256", "sha384", "sha512"}
+
+// supportedDigestAlgorithms are the digest algorithms that may be accepted
+// for uploaded layers.
+var supportedDigestAlgorithms = map[string]bool{
+	"tarsum.v1+sha256": true,
+	"sha256":           true,
+	"sha384":           true,
+	"sha512":           true,
 }
 
 // NewServices creates a new Services object to access docker objects stored
 // in the underlying driver.
 func NewServices(driver storagedriver.StorageDriver) *Services {
-	layerUploadStore, err := newTemporaryLocalFSLayerUploadStore()
+	ss, err := NewServicesWithOptions(driver, Options{})
 
 	if err != nil {
 		// TODO(stevvooe): This failure needs to be understood in the context
@@ -25,34 +103,119 @@ func NewServices(driver storagedriver.StorageDriver) *Services {
 		panic("unable to allocate layerUploadStore: " + err.Error())
 	}
 
+	return ss
+}
+
+// NewServicesWithOptions creates a new Services object to access docker
+// objects stored in the underlying driver, configured by options.
+func NewServicesWithOptions(driver storagedriver.StorageDriver, options Options) (*Services, error) {
+	pm := &pathMapper{
+		// TODO(sday): This should be configurable.
+		root:    "/docker/registry/",
+		version: storagePathVersion,
+	}
+
+	var layerUploadStore layerUploadStore
+	switch options.UploadStore {
+	case "", UploadStoreLocal:
+		var err error
+		layerUploadStore, err = newTemporaryLocalFSLayerUploadStore()
+		if err != nil {
+			return nil, err
+		}
+	case UploadStoreDriver:
+		layerUploadStore = newDriverLayerUploadStore(driver, pm)
+	default:
+		return nil, fmt.Errorf("unknown upload store: %q", options.UploadStore)
+	}
+
+	algorithms := options.DigestAlgorithms
+	if len(algorithms) == 0 {
+		algorithms = DefaultDigestAlgorithms
+	}
+
+	digestAlgorithms := make(map[string]bool)
+	for _, algorithm := range algorithms {
+		if !supportedDigestAlgorithms[algorithm] {
+			return nil, fmt.Errorf("unsupported digest algorithm: %q", algorithm)
+		}
+
+		digestAlgorithms[algorithm] = true
+	}
+
+	if err := options.Quotas.validate(); err != nil {
+		return nil, err
+	}
+
+	if options.SignUnsigned && options.SigningKey == nil {
+		return nil, fmt.Errorf("a signing key is required to sign unsigned manifests")
+	}
+
 	return &Services{
-		driver: driver,
-		pathMapper: &pathMapper{
-			// TODO(sday): This should be configurable.
-			root:    "/docker/registry/",
-			version: storagePathVersion,
-		},
+		driver:           driver,
+		pathMapper:       pm,
 		layerUploadStore: layerUploadStore,
+		digestAlgorithms: digestAlgorithms,
+		quotas:           newQuotaStore(driver, pm, options.Quotas),
+		immutableTags:    options.ImmutableTags,
+		trustPolicies:    options.TrustPolicies,
+		signingKey:       options.SigningKey,
+		signUnsigned:     options.SignUnsigned,
+		validators:       options.ManifestValidators,
+	}, nil
+}
+
+// SigningKey returns the public key of the key with which manifests are
+// signed by the registry, or nil if the registry has no signing key.
+func (ss *Services) SigningKey() libtrust.PublicKey {
+	if ss.signingKey == nil {
+		return nil
 	}
+
+	return ss.signingKey.PublicKey()
 }
 
 // Layers returns an instance of the LayerService. Instantiation is cheap and
 // may be context sensitive in the future. The instance should be used similar
 // to a request local.
 func (ss *Services) Layers() LayerService {
-	return &layerStore{driver: ss.driver, pathMapper: ss.pathMapper, uploadStore: ss.layerUploadStore}
+	return &layerStore{
+		driver:      ss.driver,
+		pathMapper:  ss.pathMapper,
+		uploadStore: ss.layerUploadStore,
+		algorithms:  ss.digestAlgorithms,
+		quotas:      ss.quotas,
+	}
 }
 
 // Manifests returns an instance of ManifestService. Instantiation is cheap and
 // may be context sensitive in the future. The instance should be used similar
 // to a request local.
 func (ss *Services) Manifests() ManifestService {
-	return &manifestStore{driver: ss.driver, pathMapper: ss.pathMapper, layerService: ss.Layers()}
+	return ss.ManifestsAs("")
+}
+
+// ManifestsAs returns an instance of ManifestService that records identity
+// in the tag index when updating tags. The identity should describe the
+// client on whose behalf the instance is acting.
+func (ss *Services) ManifestsAs(identity string) ManifestService {
+	return &manifestStore{
+		driver:        ss.driver,
+		pathMapper:    ss.pathMapper,
+		layerService:  ss.Layers(),
+		quotas:        ss.quotas,
+		immutableTags: ss.immutableTags,
+		trustPolicies: ss.trustPolicies,
+		signingKey:    ss.signingKey,
+		signUnsigned:  ss.signUnsigned,
+		validators:    ss.validators,
+		identity:      identity,
+	}
 }
 
 // ManifestService provides operations on image manifests.
 type ManifestService interface {
-	// Tags lists the tags under the named repository.
+	// Tags lists the tags under the named repository, in lexical order.
 	Tags(name string) ([]string, error)
 
 	// Exists returns true if the layer exists.
@@ -61,11 +224,38 @@ type ManifestService interface {
 	// Get retrieves the named manifest, if it exists.
 	Get(name, tag string) (*SignedManifest, error)
 
-	// Put creates or updates the named manifest.
+	// GetByDigest retrieves the manifest revision identified by dgst from
+	// the named repository, if it exists.
+	GetByDigest(name string, dgst digest.Digest) (*SignedManifest, error)
+
+	// Put creates or updates the named manifest. If the tag is immutable
+	// and references another revision, ErrTagImmutable is returned. A
+	// manifest list may only reference image manifests already stored in
+	// the repository. If the registry signs unsigned manifests, an unsigned
+	// manifest is replaced with the signed manifest that is stored.
 	Put(name, tag string, manifest *SignedManifest) error
 
-	// Delete removes the named manifest, if it exists.
+	// Delete removes the named manifest, if it exists. Immutable tags cannot
+	// be deleted.
 	Delete(name, tag string) error
+
+	// TagHistory returns the entries of the tag index, listing the
+	// revisions referenced by the tag, oldest first.
+	TagHistory(name, tag string) ([]TagIndexEntry, error)
+
+	// Retag points the tag at a revision it has previously referenced.
+	// Immutable tags cannot be changed. If admit is not nil, the revision
+	// must be admitted again.
+	Retag(name, tag string, revision digest.Digest, admit AdmitFunc) error
+
+	// AddSignatures adds the signatures of manifest to the revision of the
+	// named repository with the same payload. Signatures are stored
+	// separately from the revision and merged into it when fetched.
+	AddSignatures(name string, revision digest.Digest, manifest *SignedManifest) error
+
+	// CheckTrust returns ErrManifestUntrusted if a trust policy applying to
+	// the named repository at pull time does not trust the manifest.
+	CheckTrust(name string, manifest *SignedManifest) error
 }
 
 // LayerService provides operations on layer files in a backend storage.
@@ -83,4 +273,22 @@ type LayerService interface {
 	// Resume continues an in progress layer upload, returning the current
 	// state of the upload.
 	Resume(uuid string) (LayerUpload, error)
+
+	// Mount makes the layer identified by digest, already present in the
+	// repository from, available in the repository identified by name,
+	// without uploading it again.
+	Mount(name string, digest digest.Digest, from string) (Layer, error)
+
+	// Delete removes the layer identified by digest from the repository
+	// identified by name. The underlying blob may still be referenced by
+	// other repositories and is left for garbage collection.
+	Delete(name string, digest digest.Digest) error
+
+	// Alias returns the other digest identifying the layer content
+	// identified by digest. Layers uploaded with a tarsum have their sha256
+	// digest as an alias and vice versa, so either may be used to fetch the
+	// layer. Layers uploaded with a sha256 digest are only aliased if
+	// tarsums are accepted and the tarsum was calculated as the data was
+	// written. If no alias is known, ErrUnknownLayer is returned.
+	Alias(digest digest.Digest) (digest.Digest, error)
 }
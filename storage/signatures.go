@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/docker-registry/storagedriver"
+	"github.com/docker/libtrust"
+)
+
+// ErrManifestPayloadMismatch is returned when signatures are added to a
+// manifest revision from a manifest with a different payload.
+type ErrManifestPayloadMismatch struct {
+	Name     string
+	Revision digest.Digest
+
+	// Digest is the digest of the payload that was signed.
+	Digest digest.Digest
+}
+
+func (err ErrManifestPayloadMismatch) Error() string {
+	return fmt.Sprintf("signed payload %s does not match manifest name=%s revision=%s", err.Digest, err.Name, err.Revision)
+}
+
+// AddSignatures adds the signatures of manifest to the stored revision of the
+// named repository. The manifest must have the same payload as the revision
+// and all of its signatures must verify. Signatures already held by the
+// revision are stored once.
+func (ms *manifestStore) AddSignatures(name string, revision digest.Digest, manifest *SignedManifest) error {
+	if _, err := ms.GetByDigest(name, revision); err != nil {
+		return err
+	}
+
+	dgst, err := manifest.Digest()
+	if err != nil {
+		return ErrManifestVerification{ErrManifestUnverified{}}
+	}
+
+	if dgst != revision {
+		return ErrManifestPayloadMismatch{Name: name, Revision: revision, Digest: dgst}
+	}
+
+	if _, err := manifest.Verify(); err != nil {
+		return ErrManifestVerification{ErrManifestUnverified{}}
+	}
+
+	return ms.putSignatures(name, revision, manifest)
+}
+
+// sign replaces the unsigned manifest with the manifest signed by the
+// registry signing key. Only the fields known to the registry are signed.
+func (ms *manifestStore) sign(manifest *SignedManifest) error {
+	var signed *SignedManifest
+	var err error
+	if manifest.List != nil {
+		signed, err = manifest.List.Sign(ms.signingKey)
+	} else {
+		signed, err = manifest.Manifest.Sign(ms.signingKey)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	*manifest = *signed
+	return nil
+}
+
+// putSignatures stores each signature of the manifest revision, keyed by the
+// digest of the signature.
+func (ms *manifestStore) putSignatures(name string, revision digest.Digest, manifest *SignedManifest) error {
+	js, err := libtrust.ParsePrettySignature(manifest.Raw, "signatures")
+	if err != nil {
+		return err
+	}
+
+	signatures, err := js.Signatures()
+	if err != nil {
+		return err
+	}
+
+	for _, signature := range signatures {
+		h := sha256.New()
+		if _, err := h.Write(signature); err != nil {
+			return err
+		}
+
+		p, err := ms.pathMapper.path(manifestSignaturePathSpec{
+			name:      name,
+			revision:  revision,
+			signature: digest.NewDigest("sha256", h),
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := ms.driver.PutContent(p, signature); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// signatures returns the stored signatures of the manifest revision.
+// Revisions stored before signatures were kept separately have none.
+func (ms *manifestStore) signatures(name string, revision digest.Digest) ([][]byte, error) {
+	p, err := ms.pathMapper.path(manifestSignaturesPathSpec{
+		name:     name,
+		revision: revision,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var signatures [][]byte
+	err = walk(ms.driver, p, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+
+		signature, err := ms.driver.GetContent(fileInfo.Path())
+		if err != nil {
+			return err
+		}
+
+		signatures = append(signatures, signature)
+		return nil
+	})
+
+	switch err.(type) {
+	case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+		return nil, nil
+	}
+
+	return signatures, err
+}
+
+// mergeSignatures returns the manifest revision with the stored signatures of
+// the named repository merged into its pretty signature. The payload, and so
+// the digest of the revision, is unchanged.
+func (ms *manifestStore) mergeSignatures(name string, revision digest.Digest, manifest *SignedManifest) (*SignedManifest, error) {
+	stored, err := ms.signatures(name, revision)
+	if err != nil || len(stored) == 0 {
+		return manifest, err
+	}
+
+	js, err := libtrust.ParsePrettySignature(manifest.Raw, "signatures")
+	if err != nil {
+		return nil, err
+	}
+
+	signatures, err := js.Signatures()
+	if err != nil {
+		return nil, err
+	}
+
+	// Stored signatures are kept in the form returned by Signatures, so
+	// duplicates of those embedded in the revision are identical.
+	seen := make(map[string]bool)
+	for _, signature := range signatures {
+		seen[string(signature)] = true
+	}
+
+	embedded := len(signatures)
+	for _, signature := range stored {
+		if !seen[string(signature)] {
+			seen[string(signature)] = true
+			signatures = append(signatures, signature)
+		}
+	}
+
+	if len(signatures) == embedded {
+		return manifest, nil
+	}
+
+	payload, err := js.Payload()
+	if err != nil {
+		return nil, err
+	}
+
+	js, err = libtrust.NewJSONSignature(payload, signatures...)
+	if err != nil {
+		return nil, err
+	}
+
+	pretty, err := js.PrettySignature("signatures")
+	if err != nil {
+		return nil, err
+	}
+
+	var sm SignedManifest
+	if err := json.Unmarshal(pretty, &sm); err != nil {
+		return nil, err
+	}
+
+	return &sm, nil
+}
@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/docker/libtrust"
+
+	"github.com/docker/docker-registry/storagedriver/inmemory"
+)
+
+// TestManifestSignatures ensures that signatures added to a manifest revision
+// are merged into the manifest without changing its digest.
+func TestManifestSignatures(t *testing.T) {
+	ms := NewServices(inmemory.New()).Manifests()
+	name := "foo/bar"
+
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	manifest := Manifest{
+		Versioned: Versioned{
+			SchemaVersion: 1,
+		},
+		Name:         name,
+		Tag:          "latest",
+		Architecture: "amd64",
+	}
+
+	sm, err := manifest.Sign(pk)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	if err := ms.Put(name, "latest", sm); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	revision, err := sm.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	// Sign the same payload with a second key.
+	pk2, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	countersigned := resign(t, sm, pk2)
+
+	if err := ms.AddSignatures(name, revision, countersigned); err != nil {
+		t.Fatalf("unexpected error adding signatures: %v", err)
+	}
+
+	// Adding the same signatures again has no effect.
+	if err := ms.AddSignatures(name, revision, countersigned); err != nil {
+		t.Fatalf("unexpected error adding signatures again: %v", err)
+	}
+
+	for _, fetch := range []func() (*SignedManifest, error){
+		func() (*SignedManifest, error) { return ms.Get(name, "latest") },
+		func() (*SignedManifest, error) { return ms.GetByDigest(name, revision) },
+	} {
+		fetched, err := fetch()
+		if err != nil {
+			t.Fatalf("unexpected error fetching manifest: %v", err)
+		}
+
+		dgst, err := fetched.Digest()
+		if err != nil {
+			t.Fatalf("unexpected error getting fetched manifest digest: %v", err)
+		}
+
+		if dgst != revision {
+			t.Fatalf("fetched manifest digest changed: %v != %v", dgst, revision)
+		}
+
+		keys, err := fetched.Verify()
+		if err != nil {
+			t.Fatalf("unexpected error verifying fetched manifest: %v", err)
+		}
+
+		if len(keys) != 2 {
+			t.Fatalf("expected 2 signing keys, got %d", len(keys))
+		}
+
+		if keys[0].KeyID() == keys[1].KeyID() || !signedBy(keys, pk) || !signedBy(keys, pk2) {
+			t.Fatalf("unexpected signing keys: %v", keys)
+		}
+	}
+
+	// Signatures over a different payload are rejected.
+	manifest.Tag = "other"
+	other, err := manifest.Sign(pk2)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	if err := ms.AddSignatures(name, revision, other); err == nil {
+		t.Fatalf("expected error adding signatures over a different payload")
+	} else if _, ok := err.(ErrManifestPayloadMismatch); !ok {
+		t.Fatalf("unexpected error adding signatures over a different payload: %v", err)
+	}
+
+	// Signatures that do not verify are rejected.
+	var tampered map[string]interface{}
+	if err := json.Unmarshal(countersigned.Raw, &tampered); err != nil {
+		t.Fatalf("unexpected error decoding manifest: %v", err)
+	}
+
+	signatures := tampered["signatures"].([]interface{})
+	first := signatures[0].(map[string]interface{})["signature"].(string)
+	last := signatures[len(signatures)-1].(map[string]interface{})["signature"].(string)
+
+	var unverified SignedManifest
+	if err := json.Unmarshal(bytes.Replace(countersigned.Raw, []byte(last), []byte(first), 1), &unverified); err != nil {
+		t.Fatalf("unexpected error decoding manifest: %v", err)
+	}
+
+	if err := ms.AddSignatures(name, revision, &unverified); err == nil {
+		t.Fatalf("expected error adding unverified signatures")
+	} else if _, ok := err.(ErrManifestVerification); !ok {
+		t.Fatalf("unexpected error adding unverified signatures: %v", err)
+	}
+
+	// Signatures can only be added to existing revisions.
+	if err := ms.AddSignatures("foo/baz", revision, countersigned); err == nil {
+		t.Fatalf("expected error adding signatures to unknown revision")
+	} else if _, ok := err.(ErrUnknownManifestRevision); !ok {
+		t.Fatalf("unexpected error adding signatures to unknown revision: %v", err)
+	}
+}
+
+// resign returns the manifest with an additional signature by pk over the
+// same payload.
+func resign(t *testing.T, sm *SignedManifest, pk libtrust.PrivateKey) *SignedManifest {
+	js, err := libtrust.ParsePrettySignature(sm.Raw, "signatures")
+	if err != nil {
+		t.Fatalf("unexpected error parsing manifest signature: %v", err)
+	}
+
+	if err := js.Sign(pk); err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	pretty, err := js.PrettySignature("signatures")
+	if err != nil {
+		t.Fatalf("unexpected error formatting manifest signature: %v", err)
+	}
+
+	var resigned SignedManifest
+	if err := json.Unmarshal(pretty, &resigned); err != nil {
+		t.Fatalf("unexpected error decoding signed manifest: %v", err)
+	}
+
+	return &resigned
+}
+
+// signedBy returns true if the public key of pk is among keys.
+func signedBy(keys []libtrust.PublicKey, pk libtrust.PrivateKey) bool {
+	for _, key := range keys {
+		if key.KeyID() == pk.KeyID() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TestSignUnsignedManifest ensures that unsigned manifests are only accepted
+// if the registry signs them, and are not trusted by virtue of that
+// signature.
+func TestSignUnsignedManifest(t *testing.T) {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	if _, err := NewServicesWithOptions(inmemory.New(), Options{SignUnsigned: true}); err == nil {
+		t.Fatalf("expected error signing unsigned manifests without a signing key")
+	}
+
+	ss, err := NewServicesWithOptions(inmemory.New(), Options{SigningKey: pk, SignUnsigned: true})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	name := "foo/bar"
+	p, err := json.MarshalIndent(Manifest{
+		Versioned: Versioned{
+			SchemaVersion: 1,
+		},
+		Name:         name,
+		Tag:          "latest",
+		Architecture: "amd64",
+	}, "", "   ")
+	if err != nil {
+		t.Fatalf("unexpected error encoding manifest: %v", err)
+	}
+
+	var unsigned SignedManifest
+	if err := json.Unmarshal(p, &unsigned); err != nil {
+		t.Fatalf("unexpected error decoding manifest: %v", err)
+	}
+
+	if err := NewServices(inmemory.New()).Manifests().Put(name, "latest", &unsigned); err == nil {
+		t.Fatalf("expected error putting unsigned manifest")
+	}
+
+	ms := ss.Manifests()
+	if err := ms.Put(name, "latest", &unsigned); err != nil {
+		t.Fatalf("unexpected error putting unsigned manifest: %v", err)
+	}
+
+	keys, err := unsigned.Verify()
+	if err != nil {
+		t.Fatalf("expected put manifest to be signed: %v", err)
+	}
+
+	if !signedBy(keys, pk) || ss.SigningKey().KeyID() != pk.KeyID() {
+		t.Fatalf("expected manifest to be signed by the registry: %v", keys)
+	}
+
+	revision, err := unsigned.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	fetched, err := ms.Get(name, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest: %v", err)
+	}
+
+	dgst, err := fetched.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting fetched manifest digest: %v", err)
+	}
+
+	if dgst != revision {
+		t.Fatalf("unexpected digest of fetched manifest: %v != %v", dgst, revision)
+	}
+
+	// Trust is checked before signing, so trusting the registry key does
+	// not admit unsigned manifests.
+	ss, err = NewServicesWithOptions(inmemory.New(), Options{
+		SigningKey:   pk,
+		SignUnsigned: true,
+		TrustPolicies: []TrustPolicy{
+			{Keys: []libtrust.PublicKey{pk.PublicKey()}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	unsigned = SignedManifest{}
+	if err := json.Unmarshal(p, &unsigned); err != nil {
+		t.Fatalf("unexpected error decoding manifest: %v", err)
+	}
+
+	err = ss.Manifests().Put(name, "latest", &unsigned)
+	if verr, ok := err.(ErrManifestVerification); !ok || len(verr) != 1 {
+		t.Fatalf("expected untrusted unsigned manifest to be rejected: %v", err)
+	} else if _, ok := verr[0].(ErrManifestUntrusted); !ok {
+		t.Fatalf("unexpected error putting unsigned manifest: %v", err)
+	}
+
+	signed, err := unsigned.Manifest.Sign(pk)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	if err := ss.Manifests().Put(name, "latest", signed); err != nil {
+		t.Fatalf("unexpected error putting manifest signed by a trusted key: %v", err)
+	}
+}
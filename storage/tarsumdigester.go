@@ -0,0 +1,367 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker-registry/digest"
+)
+
+// maxTarSumHeaderSize limits the header data buffered for a single entry,
+// including any extended headers. Archives with larger headers have their
+// tarsum calculated by reading the data on completion.
+const maxTarSumHeaderSize = 2 << 20
+
+// tarSumDigester calculates the tarsum.v1+sha256 of layer data as it is
+// written. The tarsum implementation reads the archive with archive/tar,
+// which cannot be resumed from a saved state. Instead, the digester follows
+// the archive as it is written, handing only the headers of each entry to
+// archive/tar and hashing the entry data itself. Its state may be saved and
+// restored, allowing an upload to be resumed by any registry instance.
+//
+// Archives using features the digester does not follow, such as sparse
+// files and global headers, are marked unsupported. Their tarsum must be
+// calculated by reading the data with layerTarSum.
+type tarSumDigester struct {
+	// offset is the number of bytes written to the digester.
+	offset int64
+
+	// header buffers the data of an incomplete entry header.
+	header []byte
+
+	// remaining is the data of the current entry left to be hashed, followed
+	// by padding bytes to be skipped.
+	remaining int64
+	padding   int64
+
+	// entry hashes the header and data of the current entry.
+	entry hash.Hash
+
+	// sums holds the sha256 sum of each complete entry.
+	sums [][]byte
+
+	// done is set once the end of the archive has been reached. Any further
+	// data is ignored.
+	done bool
+
+	// unsupported is set if the tarsum cannot be calculated as the data is
+	// written.
+	unsupported bool
+}
+
+// tarSumDigesterState is the serialized form of a tarSumDigester.
+type tarSumDigesterState struct {
+	Offset      int64
+	Header      []byte `json:",omitempty"`
+	Remaining   int64  `json:",omitempty"`
+	Padding     int64  `json:",omitempty"`
+	Entry       []byte `json:",omitempty"`
+	Sums        []byte `json:",omitempty"`
+	Done        bool   `json:",omitempty"`
+	Unsupported bool   `json:",omitempty"`
+}
+
+func newTarSumDigester() *tarSumDigester {
+	return &tarSumDigester{}
+}
+
+// restoreTarSumDigester returns a digester from the state saved by state. If
+// no state was saved, a new digester is returned, from the start of the data.
+func restoreTarSumDigester(state []byte) (*tarSumDigester, error) {
+	if len(state) == 0 {
+		return newTarSumDigester(), nil
+	}
+
+	var tss tarSumDigesterState
+	if err := json.Unmarshal(state, &tss); err != nil {
+		return nil, fmt.Errorf("invalid upload tarsum state: %v", err)
+	}
+
+	if len(tss.Sums)%sha256.Size != 0 {
+		return nil, fmt.Errorf("invalid upload tarsum state: sums of %d bytes", len(tss.Sums))
+	}
+
+	tsd := &tarSumDigester{
+		offset:      tss.Offset,
+		header:      tss.Header,
+		remaining:   tss.Remaining,
+		padding:     tss.Padding,
+		done:        tss.Done,
+		unsupported: tss.Unsupported,
+	}
+
+	for p := tss.Sums; len(p) > 0; p = p[sha256.Size:] {
+		tsd.sums = append(tsd.sums, p[:sha256.Size])
+	}
+
+	if tss.Entry != nil {
+		tsd.entry = sha256.New()
+		if err := tsd.entry.(encoding.BinaryUnmarshaler).UnmarshalBinary(tss.Entry); err != nil {
+			return nil, fmt.Errorf("invalid upload tarsum state: %v", err)
+		}
+	}
+
+	return tsd, nil
+}
+
+// state returns the serialized state of the digester, to be restored by
+// restoreTarSumDigester.
+func (tsd *tarSumDigester) state() ([]byte, error) {
+	tss := tarSumDigesterState{
+		Offset:      tsd.offset,
+		Header:      tsd.header,
+		Remaining:   tsd.remaining,
+		Padding:     tsd.padding,
+		Sums:        bytes.Join(tsd.sums, nil),
+		Done:        tsd.done,
+		Unsupported: tsd.unsupported,
+	}
+
+	if tsd.entry != nil {
+		p, err := tsd.entry.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		tss.Entry = p
+	}
+
+	return json.Marshal(tss)
+}
+
+// Write follows the archive through p. Write never fails.
+func (tsd *tarSumDigester) Write(p []byte) (int, error) {
+	n := len(p)
+	tsd.offset += int64(n)
+
+	for len(p) > 0 && !tsd.done && !tsd.unsupported {
+		switch {
+		case tsd.remaining > 0:
+			nn := int64(len(p))
+			if nn > tsd.remaining {
+				nn = tsd.remaining
+			}
+
+			tsd.entry.Write(p[:nn])
+			tsd.remaining -= nn
+			p = p[nn:]
+
+			if tsd.remaining == 0 {
+				tsd.finishEntry()
+			}
+		case tsd.padding > 0:
+			nn := int64(len(p))
+			if nn > tsd.padding {
+				nn = tsd.padding
+			}
+
+			tsd.padding -= nn
+			p = p[nn:]
+		default:
+			p = tsd.readHeader(p)
+		}
+	}
+
+	return n, nil
+}
+
+// readHeader reads the header of the next entry from p, appended to any
+// header data already buffered, returning the data following the header. If
+// the header is incomplete, it is buffered until more data is written.
+func (tsd *tarSumDigester) readHeader(p []byte) []byte {
+	data := p
+	if len(tsd.header) > 0 {
+		tsd.header = append(tsd.header, p...)
+		data = tsd.header
+	}
+
+	hdr, n, err := readTarHeader(data)
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		if len(data) >= 2*512 && isZeroBlocks(data[:2*512]) {
+			// Two zero blocks mark the end of the archive.
+			tsd.done = true
+			tsd.header = nil
+			return nil
+		}
+
+		if len(tsd.header) == 0 {
+			tsd.header = append([]byte(nil), p...)
+		}
+
+		if len(tsd.header) > maxTarSumHeaderSize {
+			tsd.unsupported = true
+			tsd.header = nil
+		}
+
+		return nil
+	default:
+		// The data is not an archive tarsum can read, or uses a
+		// feature the digester does not follow.
+		tsd.unsupported = true
+		tsd.header = nil
+		return nil
+	}
+
+	tsd.header = nil
+
+	if !tarSumFollows(hdr) {
+		tsd.unsupported = true
+		return nil
+	}
+
+	tsd.entry = sha256.New()
+	writeTarSumHeader(tsd.entry, hdr)
+
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		tsd.remaining = hdr.Size
+		tsd.padding = -hdr.Size & (512 - 1)
+	default:
+		// The other entry types have no data in the archive, whatever
+		// their size.
+	}
+
+	if tsd.remaining == 0 {
+		tsd.finishEntry()
+	}
+
+	return data[n:]
+}
+
+// finishEntry records the sum of the current entry.
+func (tsd *tarSumDigester) finishEntry() {
+	tsd.sums = append(tsd.sums, tsd.entry.Sum(nil))
+	tsd.entry = nil
+}
+
+// TarSum returns the tarsum of the archive written so far. If the data is
+// not an archive the digester could follow through to its end, false is
+// returned and the tarsum must be calculated by reading the data.
+func (tsd *tarSumDigester) TarSum() (digest.Digest, bool) {
+	if tsd.unsupported || tsd.remaining > 0 || tsd.padding > 0 {
+		return "", false
+	}
+
+	if !tsd.done && len(tsd.header) > 0 {
+		// Data that does not complete another header, such as a single
+		// zero block, ends the archive as long as archive/tar reports the
+		// end of the archive.
+		if _, _, err := readTarHeader(tsd.header); err != io.EOF {
+			return "", false
+		}
+	}
+
+	sums := make([]string, 0, len(tsd.sums))
+	for _, sum := range tsd.sums {
+		sums = append(sums, hex.EncodeToString(sum))
+	}
+	sort.Strings(sums)
+
+	h := sha256.New()
+	for _, sum := range sums {
+		h.Write([]byte(sum))
+	}
+
+	return digest.NewDigest(layerDigestAlgorithm, h), true
+}
+
+// readTarHeader reads the header of the next entry of the archive from p,
+// including any extended headers, returning it along with the number of
+// bytes of p it takes up.
+func readTarHeader(p []byte) (*tar.Header, int, error) {
+	cr := &countingReader{r: bytes.NewReader(p)}
+
+	hdr, err := tar.NewReader(cr).Next()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return hdr, cr.n, nil
+}
+
+// tarSumFollows reports whether the digester can follow the data of the
+// entry with the header hdr. Sparse files are stored with less data than
+// their size and global headers are not followed by an entry of their own.
+func tarSumFollows(hdr *tar.Header) bool {
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+	default:
+		return false
+	}
+
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// writeTarSumHeader writes the headers of hdr selected by tarsum.v1 to w.
+// The user and group names are left empty and the extended attributes are
+// written in the order used by the tarsum implementation.
+func writeTarSumHeader(w io.Writer, hdr *tar.Header) {
+	xattrKeys := make([]string, len(hdr.Xattrs))
+	for k := range hdr.Xattrs {
+		xattrKeys = append(xattrKeys, k)
+	}
+	sort.Strings(xattrKeys)
+
+	headers := [][2]string{
+		{"name", hdr.Name},
+		{"mode", strconv.FormatInt(hdr.Mode, 10)},
+		{"uid", strconv.Itoa(hdr.Uid)},
+		{"gid", strconv.Itoa(hdr.Gid)},
+		{"size", strconv.FormatInt(hdr.Size, 10)},
+		{"typeflag", string([]byte{hdr.Typeflag})},
+		{"linkname", hdr.Linkname},
+		{"uname", ""},
+		{"gname", ""},
+		{"devmajor", strconv.FormatInt(hdr.Devmajor, 10)},
+		{"devminor", strconv.FormatInt(hdr.Devminor, 10)},
+	}
+
+	for _, k := range xattrKeys {
+		headers = append(headers, [2]string{k, hdr.Xattrs[k]})
+	}
+
+	for _, header := range headers {
+		w.Write([]byte(header[0] + header[1]))
+	}
+}
+
+// isZeroBlocks reports whether p holds only zero bytes.
+func isZeroBlocks(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker-registry/common/testutil"
+)
+
+// TestTarSumDigester ensures the tarsum calculated as data is written
+// matches the tarsum read from the data, when written in chunks of varying
+// size and restored from its saved state between writes.
+func TestTarSumDigester(t *testing.T) {
+	rs, _, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random tar file: %v", err)
+	}
+
+	random, err := ioutil.ReadAll(rs)
+	if err != nil {
+		t.Fatalf("error reading random tar file: %v", err)
+	}
+
+	for _, testcase := range []struct {
+		description string
+		archive     []byte
+		chunkSizes  []int
+	}{
+		{
+			description: "random files",
+			archive:     random,
+			chunkSizes:  []int{4096, 32 << 10, 1000003, len(random)},
+		},
+		{
+			description: "entry types",
+			archive: testTarArchive(t, tar.FormatUnknown, true,
+				&tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+				&tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: 0644, Size: 1000, Uname: "root", Gname: "wheel"},
+				&tar.Header{Name: "etc/empty", Typeflag: tar.TypeReg, Mode: 0600},
+				&tar.Header{Name: "etc/hosts.link", Typeflag: tar.TypeLink, Linkname: "etc/hosts"},
+				&tar.Header{Name: "etc/hosts.symlink", Typeflag: tar.TypeSymlink, Linkname: "hosts"},
+				&tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3},
+				&tar.Header{Name: "dev/sda", Typeflag: tar.TypeBlock, Devmajor: 8},
+				&tar.Header{Name: "run/fifo", Typeflag: tar.TypeFifo},
+				&tar.Header{Name: "aligned", Typeflag: tar.TypeReg, Size: 1024},
+			),
+			chunkSizes: []int{1, 7, 511, 512, 513, 4096},
+		},
+		{
+			description: "pax headers",
+			archive: testTarArchive(t, tar.FormatPAX, true,
+				&tar.Header{Name: strings.Repeat("long/", 60) + "name", Typeflag: tar.TypeReg, Size: 100},
+				&tar.Header{Name: "xattrs", Typeflag: tar.TypeReg, Size: 10, PAXRecords: map[string]string{
+					"SCHILY.xattr.user.b":           "2",
+					"SCHILY.xattr.security.selinux": "system_u:object_r:etc_t:s0",
+				}},
+				&tar.Header{Name: "symlink", Typeflag: tar.TypeSymlink, Linkname: strings.Repeat("target/", 40)},
+			),
+			chunkSizes: []int{1, 100, 512, 4096},
+		},
+		{
+			description: "gnu long names",
+			archive: testTarArchive(t, tar.FormatGNU, true,
+				&tar.Header{Name: strings.Repeat("long/", 60) + "name", Typeflag: tar.TypeReg, Size: 600},
+				&tar.Header{Name: "symlink", Typeflag: tar.TypeSymlink, Linkname: strings.Repeat("target/", 40)},
+			),
+			chunkSizes: []int{1, 512, 4096},
+		},
+		{
+			description: "no end of archive",
+			archive: testTarArchive(t, tar.FormatUnknown, false,
+				&tar.Header{Name: "file", Typeflag: tar.TypeReg, Size: 100},
+			),
+			chunkSizes: []int{1, 512},
+		},
+		{
+			description: "single zero block",
+			archive: append(testTarArchive(t, tar.FormatUnknown, false,
+				&tar.Header{Name: "file", Typeflag: tar.TypeReg, Size: 100},
+			), make([]byte, 512)...),
+			chunkSizes: []int{1, 512},
+		},
+		{
+			description: "empty",
+			chunkSizes:  []int{1},
+		},
+	} {
+		expected, err := layerTarSum(bytes.NewReader(testcase.archive))
+		if err != nil {
+			t.Fatalf("%s: error calculating tarsum: %v", testcase.description, err)
+		}
+
+		for _, chunkSize := range testcase.chunkSizes {
+			for _, restore := range []bool{false, true} {
+				tsd := newTarSumDigester()
+				for p := testcase.archive; len(p) > 0; {
+					n := chunkSize
+					if n > len(p) {
+						n = len(p)
+					}
+
+					tsd.Write(p[:n])
+					p = p[n:]
+
+					if restore {
+						tsd = checkTarSumDigesterState(t, tsd)
+					}
+				}
+
+				tarSum, ok := tsd.TarSum()
+				if !ok {
+					t.Fatalf("%s: expected tarsum in chunks of %d bytes", testcase.description, chunkSize)
+				}
+
+				if tarSum != expected {
+					t.Fatalf("%s: unexpected tarsum in chunks of %d bytes: %v != %v", testcase.description, chunkSize, tarSum, expected)
+				}
+			}
+		}
+	}
+}
+
+// TestTarSumDigesterUnsupported ensures that data the digester cannot
+// follow, or that is not a complete archive, has no tarsum calculated as
+// written.
+func TestTarSumDigesterUnsupported(t *testing.T) {
+	archive := testTarArchive(t, tar.FormatUnknown, true,
+		&tar.Header{Name: "file", Typeflag: tar.TypeReg, Size: 1000},
+	)
+
+	for _, testcase := range []struct {
+		description string
+		data        []byte
+	}{
+		{
+			description: "not an archive",
+			data:        bytes.Repeat([]byte("not a tar archive"), 100),
+		},
+		{
+			description: "truncated data",
+			data:        archive[:1000],
+		},
+		{
+			description: "truncated header",
+			data:        archive[:100],
+		},
+		{
+			description: "global header",
+			data: testTarArchive(t, tar.FormatPAX, true,
+				&tar.Header{Name: "global", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "global"}},
+				&tar.Header{Name: "file", Typeflag: tar.TypeReg, Size: 100},
+			),
+		},
+	} {
+		tsd := newTarSumDigester()
+		tsd.Write(testcase.data)
+
+		if tarSum, ok := tsd.TarSum(); ok {
+			t.Fatalf("%s: unexpected tarsum: %v", testcase.description, tarSum)
+		}
+	}
+}
+
+// checkTarSumDigesterState saves and restores the state of tsd, returning
+// the restored digester.
+func checkTarSumDigesterState(t *testing.T, tsd *tarSumDigester) *tarSumDigester {
+	state, err := tsd.state()
+	if err != nil {
+		t.Fatalf("unexpected error saving tarsum state: %v", err)
+	}
+
+	restored, err := restoreTarSumDigester(state)
+	if err != nil {
+		t.Fatalf("unexpected error restoring tarsum state: %v", err)
+	}
+
+	if restored.offset != tsd.offset {
+		t.Fatalf("unexpected offset of restored tarsum state: %d != %d", restored.offset, tsd.offset)
+	}
+
+	return restored
+}
+
+// testTarArchive returns a tar archive in the given format with an entry for
+// each header, filled with data of the size of the entry. If end is false,
+// the archive is written without its end of archive marker.
+func testTarArchive(t *testing.T, format tar.Format, end bool, headers ...*tar.Header) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for i, hdr := range headers {
+		if hdr.Typeflag != tar.TypeXGlobalHeader {
+			hdr.Format = format
+			hdr.ModTime = time.Unix(int64(i), 0)
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("error writing tar header: %v", err)
+		}
+
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(bytes.Repeat([]byte{byte(i)}, int(hdr.Size))); err != nil {
+				t.Fatalf("error writing tar data: %v", err)
+			}
+		}
+	}
+
+	if !end {
+		if err := tw.Flush(); err != nil {
+			t.Fatalf("error flushing tar archive: %v", err)
+		}
+
+		return buf.Bytes()
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatalf("error closing tar archive: %v", err)
+	}
+
+	return buf.Bytes()
+}
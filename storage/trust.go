@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"crypto/x509"
+	"fmt"
+	"regexp"
+
+	"github.com/docker/libtrust"
+)
+
+// TrustPolicy lists the signers trusted for the manifests of matching
+// repositories. A manifest is trusted by the policy if it has a valid
+// signature by one of Keys or with a certificate chain issued by Roots.
+type TrustPolicy struct {
+	// Repository matches the names of the repositories to which the policy
+	// applies. If nil, the policy applies to all repositories. The
+	// expression should be anchored to match the whole name.
+	Repository *regexp.Regexp
+
+	// Keys are the public keys trusted to sign manifests.
+	Keys []libtrust.PublicKey
+
+	// Roots are the certificate authorities trusted to issue the
+	// certificate chains of signing keys. If nil, chains are not trusted.
+	Roots *x509.CertPool
+
+	// Pull also refuses to serve manifests without a trusted signer. Such
+	// manifests may have been pushed before the policy was configured, or
+	// may later gain trusted signatures.
+	Pull bool
+}
+
+// Matches returns true if the policy applies to the named repository.
+func (policy TrustPolicy) Matches(name string) bool {
+	return policy.Repository == nil || policy.Repository.MatchString(name)
+}
+
+// Trusts returns true if the manifest has a valid signature by a signer
+// trusted by the policy.
+func (policy TrustPolicy) Trusts(manifest *SignedManifest) bool {
+	keys, err := manifest.Verify()
+	if err != nil {
+		return false
+	}
+
+	for _, key := range keys {
+		for _, trusted := range policy.Keys {
+			if key.KeyID() == trusted.KeyID() {
+				return true
+			}
+		}
+	}
+
+	if policy.Roots == nil {
+		return false
+	}
+
+	// VerifyChains ignores signatures without a certificate chain, so the
+	// manifest is only trusted if at least one chain is verified.
+	chains, err := manifest.VerifyChains(policy.Roots)
+	return err == nil && len(chains) > 0
+}
+
+// ErrManifestUntrusted is returned when a manifest of a repository matched by
+// a trust policy has no signature by a trusted signer.
+type ErrManifestUntrusted struct {
+	Name string
+}
+
+func (err ErrManifestUntrusted) Error() string {
+	return fmt.Sprintf("manifest of %s has no trusted signer", err.Name)
+}
+
+// CheckTrust returns ErrManifestUntrusted if a trust policy applying to the
+// named repository at pull time does not trust the manifest.
+func (ms *manifestStore) CheckTrust(name string, manifest *SignedManifest) error {
+	return ms.checkTrust(name, manifest, true)
+}
+
+// checkTrust returns ErrManifestUntrusted unless every trust policy applying
+// to the named repository trusts the manifest. If pull is true, only the
+// policies applying at pull time are checked.
+func (ms *manifestStore) checkTrust(name string, manifest *SignedManifest, pull bool) error {
+	for _, policy := range ms.trustPolicies {
+		if pull && !policy.Pull || !policy.Matches(name) {
+			continue
+		}
+
+		if !policy.Trusts(manifest) {
+			return ErrManifestUntrusted{Name: name}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"crypto/x509"
+	"regexp"
+	"testing"
+
+	"github.com/docker/libtrust"
+
+	"github.com/docker/docker-registry/storagedriver/inmemory"
+)
+
+// TestTrustPolicies ensures that manifests put to repositories matched by
+// trust policies must be signed by a trusted key or certificate chain.
+func TestTrustPolicies(t *testing.T) {
+	trustedKey, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	rootKey, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	roots, err := libtrust.GenerateCACertPool(rootKey, []libtrust.PublicKey{rootKey.PublicKey()})
+	if err != nil {
+		t.Fatalf("unexpected error generating certificate pool: %v", err)
+	}
+
+	ss, err := NewServicesWithOptions(inmemory.New(), Options{
+		TrustPolicies: []TrustPolicy{
+			{
+				Repository: regexp.MustCompile("^library/.*$"),
+				Keys:       []libtrust.PublicKey{trustedKey.PublicKey()},
+			},
+			{
+				Repository: regexp.MustCompile("^certified/.*$"),
+				Roots:      roots,
+				Pull:       true,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	ms := ss.Manifests()
+
+	untrustedKey, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	chainKey, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	leaf, err := libtrust.GenerateCACert(rootKey, chainKey.PublicKey())
+	if err != nil {
+		t.Fatalf("unexpected error generating certificate: %v", err)
+	}
+
+	untrustedLeaf, err := libtrust.GenerateCACert(untrustedKey, chainKey.PublicKey())
+	if err != nil {
+		t.Fatalf("unexpected error generating certificate: %v", err)
+	}
+
+	for _, testcase := range []struct {
+		name    string
+		key     libtrust.PrivateKey
+		chain   []*x509.Certificate
+		trusted bool
+	}{
+		{name: "foo/bar", key: untrustedKey, trusted: true},
+		{name: "library/ubuntu", key: trustedKey, trusted: true},
+		{name: "library/ubuntu", key: untrustedKey},
+		{name: "library/ubuntu", key: chainKey, chain: []*x509.Certificate{leaf}},
+		{name: "certified/ubuntu", key: chainKey, chain: []*x509.Certificate{leaf}, trusted: true},
+		{name: "certified/ubuntu", key: chainKey, chain: []*x509.Certificate{untrustedLeaf}},
+		{name: "certified/ubuntu", key: trustedKey},
+	} {
+		manifest := Manifest{
+			Versioned: Versioned{
+				SchemaVersion: 1,
+			},
+			Name:         testcase.name,
+			Tag:          "latest",
+			Architecture: "amd64",
+		}
+
+		var sm *SignedManifest
+		if testcase.chain != nil {
+			sm, err = manifest.SignWithChain(testcase.key, testcase.chain)
+		} else {
+			sm, err = manifest.Sign(testcase.key)
+		}
+		if err != nil {
+			t.Fatalf("unexpected error signing manifest: %v", err)
+		}
+
+		err = ms.Put(testcase.name, "latest", sm)
+		if testcase.trusted {
+			if err != nil {
+				t.Fatalf("unexpected error putting trusted manifest to %s: %v", testcase.name, err)
+			}
+			continue
+		}
+
+		if err == nil {
+			t.Fatalf("expected error putting untrusted manifest to %s", testcase.name)
+		}
+
+		verificationErrs, ok := err.(ErrManifestVerification)
+		if !ok || len(verificationErrs) != 1 {
+			t.Fatalf("unexpected error putting untrusted manifest to %s: %v", testcase.name, err)
+		}
+
+		if _, ok := verificationErrs[0].(ErrManifestUntrusted); !ok {
+			t.Fatalf("unexpected error putting untrusted manifest to %s: %v", testcase.name, err)
+		}
+	}
+
+	// Only policies enforced at pull time are checked before serving.
+	manifest, err := ms.Get("certified/ubuntu", "latest")
+	if err != nil {
+		t.Fatalf("unexpected error fetching manifest: %v", err)
+	}
+
+	if err := ms.CheckTrust("certified/ubuntu", manifest); err != nil {
+		t.Fatalf("unexpected error checking trusted manifest: %v", err)
+	}
+
+	if err := ms.CheckTrust("library/ubuntu", manifest); err != nil {
+		t.Fatalf("unexpected error checking manifest without pull policy: %v", err)
+	}
+
+	untrusted, err := (&Manifest{
+		Versioned: Versioned{
+			SchemaVersion: 1,
+		},
+		Name: "certified/ubuntu",
+		Tag:  "latest",
+	}).Sign(untrustedKey)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+
+	if err := ms.CheckTrust("certified/ubuntu", untrusted); err == nil {
+		t.Fatalf("expected error checking untrusted manifest")
+	} else if _, ok := err.(ErrManifestUntrusted); !ok {
+		t.Fatalf("unexpected error checking untrusted manifest: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ManifestValidator checks image manifests put to the registry, in addition
+// to the built-in validation of their signatures, layers and history.
+type ManifestValidator interface {
+	// Validate returns an error describing why the manifest may not be put
+	// to the named repository, or nil if it may.
+	Validate(name string, manifest *SignedManifest) error
+}
+
+// ManifestValidatorFunc adapts a function to the ManifestValidator
+// interface.
+type ManifestValidatorFunc func(name string, manifest *SignedManifest) error
+
+// Validate calls f(name, manifest).
+func (f ManifestValidatorFunc) Validate(name string, manifest *SignedManifest) error {
+	return f(name, manifest)
+}
+
+// AdmitFunc returns an error if the manifest may not be stored as a revision
+// of the named repository, referenced by tag. It is passed to operations
+// storing or tagging revisions the caller cannot check in advance, such as
+// pointing a tag back at an earlier revision or copying a repository, where
+// the manifest is the revision as it will be stored. Errors are returned
+// unchanged.
+type AdmitFunc func(name, tag string, manifest *SignedManifest) error
+
+// manifestValidators are the custom validators registered by name.
+var manifestValidators = make(map[string]ManifestValidator)
+
+// RegisterManifestValidator makes a custom manifest validator available by
+// the provided name, such that it can be enabled in the configuration. It is
+// typically called from the init function of the package providing the
+// validator.
+func RegisterManifestValidator(name string, validator ManifestValidator) error {
+	if validator == nil {
+		return fmt.Errorf("nil manifest validator: %s", name)
+	}
+
+	if _, exists := manifestValidators[name]; exists {
+		return fmt.Errorf("name already registered: %s", name)
+	}
+
+	manifestValidators[name] = validator
+
+	return nil
+}
+
+// GetManifestValidator returns the custom manifest validator registered by
+// the provided name.
+func GetManifestValidator(name string) (ManifestValidator, error) {
+	validator, exists := manifestValidators[name]
+	if !exists {
+		return nil, fmt.Errorf("no manifest validator registered with name: %s", name)
+	}
+
+	return validator, nil
+}
+
+// ErrManifestInvalid is returned when a custom validator rejects a manifest.
+type ErrManifestInvalid struct {
+	Err error
+}
+
+func (err ErrManifestInvalid) Error() string {
+	return fmt.Sprintf("invalid manifest: %v", err.Err)
+}
+
+// ErrManifestHistoryInvalid is returned when the v1 compatibility data of a
+// history entry is not a JSON object.
+type ErrManifestHistoryInvalid struct {
+	// Index is the position of the entry in the history.
+	Index int
+	Err   error
+}
+
+func (err ErrManifestHistoryInvalid) Error() string {
+	return fmt.Sprintf("invalid v1 compatibility data in history entry %d: %v", err.Index, err.Err)
+}
+
+// ErrManifestHistoryLength is returned when the history of a manifest does
+// not have an entry for each layer.
+type ErrManifestHistoryLength struct {
+	Layers  int
+	History int
+}
+
+func (err ErrManifestHistoryLength) Error() string {
+	return fmt.Sprintf("manifest has %d layers but %d history entries", err.Layers, err.History)
+}
+
+// ErrManifestParentInvalid is returned when the parent of a history entry is
+// not the image of the next entry, such that the entries do not form a chain
+// from the top layer to the base layer.
+type ErrManifestParentInvalid struct {
+	// Index is the position of the entry in the history.
+	Index int
+	ID    string
+
+	// Parent is the parent recorded by the entry and Expected the parent
+	// required by the next entry. Both are empty for the base layer.
+	Parent   string
+	Expected string
+}
+
+func (err ErrManifestParentInvalid) Error() string {
+	if err.ID == "" {
+		return fmt.Sprintf("history entry %d has no image id", err.Index)
+	}
+
+	return fmt.Sprintf("history entry %d of image %s has parent %q, expected %q", err.Index, err.ID, err.Parent, err.Expected)
+}
+
+// v1Image holds the fields of the v1 compatibility data describing the image
+// of a history entry.
+type v1Image struct {
+	ID     string `json:"id"`
+	Parent string `json:"parent,omitempty"`
+}
+
+// builtinManifestValidators are run on every image manifest put to the
+// registry, before any custom validators.
+var builtinManifestValidators = []ManifestValidator{
+	ManifestValidatorFunc(validateHistory),
+	ManifestValidatorFunc(validateHistoryLength),
+	ManifestValidatorFunc(validateParentChain),
+}
+
+// validateHistory ensures that the v1 compatibility data of each history
+// entry is a JSON object.
+func validateHistory(name string, manifest *SignedManifest) error {
+	for i, entry := range manifest.History {
+		var image map[string]interface{}
+		if err := json.Unmarshal([]byte(entry.V1Compatibility), &image); err != nil {
+			return ErrManifestHistoryInvalid{Index: i, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// validateHistoryLength ensures that the history has an entry for each
+// layer of the manifest.
+func validateHistoryLength(name string, manifest *SignedManifest) error {
+	if len(manifest.History) != len(manifest.FSLayers) {
+		return ErrManifestHistoryLength{Layers: len(manifest.FSLayers), History: len(manifest.History)}
+	}
+
+	return nil
+}
+
+// validateParentChain ensures that each history entry identifies its image
+// and that its parent is the image of the next entry, with the last entry
+// being the base image. Malformed entries are left to validateHistory.
+func validateParentChain(name string, manifest *SignedManifest) error {
+	images := make([]v1Image, len(manifest.History))
+	for i, entry := range manifest.History {
+		if err := json.Unmarshal([]byte(entry.V1Compatibility), &images[i]); err != nil {
+			return nil
+		}
+	}
+
+	for i, image := range images {
+		var expected string
+		if i+1 < len(images) {
+			expected = images[i+1].ID
+		}
+
+		if image.ID == "" || image.Parent != expected {
+			return ErrManifestParentInvalid{Index: i, ID: image.ID, Parent: image.Parent, Expected: expected}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,322 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker-registry/digest"
+	"github.com/docker/libtrust"
+
+	"github.com/docker/docker-registry/storagedriver/inmemory"
+)
+
+// TestManifestValidation ensures that image manifests with invalid history
+// are rejected with an error describing the problem and that custom
+// validators are run.
+func TestManifestValidation(t *testing.T) {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	name := "foo/bar"
+	rejected := fmt.Errorf("architecture required")
+	ss, err := NewServicesWithOptions(inmemory.New(), Options{
+		ManifestValidators: []ManifestValidator{
+			ManifestValidatorFunc(func(name string, manifest *SignedManifest) error {
+				if manifest.Architecture == "" {
+					return rejected
+				}
+
+				return nil
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	layers := make([]FSLayer, 2)
+	for i := range layers {
+		_, _, dgst := uploadTestLayer(t, ss.Layers(), name, true)
+		layers[i] = FSLayer{BlobSum: dgst}
+	}
+
+	ms := ss.Manifests()
+	for _, testcase := range []struct {
+		description  string
+		architecture string
+		history      []ManifestHistory
+		expected     error
+	}{
+		{
+			description:  "valid history",
+			architecture: "amd64",
+			history:      testHistory(2),
+		},
+		{
+			description:  "missing history",
+			architecture: "amd64",
+			history:      testHistory(1),
+			expected:     ErrManifestHistoryLength{Layers: 2, History: 1},
+		},
+		{
+			description:  "malformed history",
+			architecture: "amd64",
+			history: []ManifestHistory{
+				{V1Compatibility: `{"id": "b", "parent": "a"}`},
+				{V1Compatibility: `{"id": "a"`},
+			},
+			expected: ErrManifestHistoryInvalid{Index: 1},
+		},
+		{
+			description:  "missing image id",
+			architecture: "amd64",
+			history: []ManifestHistory{
+				{V1Compatibility: `{"id": "b", "parent": "a"}`},
+				{V1Compatibility: `{}`},
+			},
+			expected: ErrManifestParentInvalid{Index: 0, ID: "b", Parent: "a"},
+		},
+		{
+			description:  "broken parent chain",
+			architecture: "amd64",
+			history: []ManifestHistory{
+				{V1Compatibility: `{"id": "c", "parent": "a"}`},
+				{V1Compatibility: `{"id": "b"}`},
+			},
+			expected: ErrManifestParentInvalid{Index: 0, ID: "c", Parent: "a", Expected: "b"},
+		},
+		{
+			description:  "base image with parent",
+			architecture: "amd64",
+			history: []ManifestHistory{
+				{V1Compatibility: `{"id": "b", "parent": "a"}`},
+				{V1Compatibility: `{"id": "a", "parent": "z"}`},
+			},
+			expected: ErrManifestParentInvalid{Index: 1, ID: "a", Parent: "z"},
+		},
+		{
+			description: "custom validator",
+			history:     testHistory(2),
+			expected:    ErrManifestInvalid{Err: rejected},
+		},
+	} {
+		manifest := Manifest{
+			Versioned: Versioned{
+				SchemaVersion: 1,
+			},
+			Name:         name,
+			Tag:          "latest",
+			Architecture: testcase.architecture,
+			FSLayers:     layers,
+			History:      testcase.history,
+		}
+
+		sm, err := manifest.Sign(pk)
+		if err != nil {
+			t.Fatalf("unexpected error signing manifest: %v", err)
+		}
+
+		err = ms.Put(name, "latest", sm)
+		if testcase.expected == nil {
+			if err != nil {
+				t.Fatalf("%s: unexpected error putting manifest: %v", testcase.description, err)
+			}
+			continue
+		}
+
+		verificationErrs, ok := err.(ErrManifestVerification)
+		if !ok || len(verificationErrs) != 1 {
+			t.Fatalf("%s: unexpected error putting manifest: %v", testcase.description, err)
+		}
+
+		// The error of the malformed entry depends on the JSON decoder.
+		if historyErr, ok := verificationErrs[0].(ErrManifestHistoryInvalid); ok {
+			historyErr.Err = nil
+			verificationErrs[0] = historyErr
+		}
+
+		if verificationErrs[0] != testcase.expected {
+			t.Fatalf("%s: unexpected error putting manifest: %#v != %#v", testcase.description, verificationErrs[0], testcase.expected)
+		}
+	}
+}
+
+// TestRegisterManifestValidator ensures that custom validators can be
+// registered once under a name.
+func TestRegisterManifestValidator(t *testing.T) {
+	validator := ManifestValidatorFunc(func(name string, manifest *SignedManifest) error {
+		return nil
+	})
+
+	if err := RegisterManifestValidator("test", validator); err != nil {
+		t.Fatalf("unexpected error registering validator: %v", err)
+	}
+
+	if err := RegisterManifestValidator("test", validator); err == nil {
+		t.Fatalf("expected error registering validator twice")
+	}
+
+	if _, err := GetManifestValidator("test"); err != nil {
+		t.Fatalf("unexpected error getting validator: %v", err)
+	}
+
+	if _, err := GetManifestValidator("unknown"); err == nil {
+		t.Fatalf("expected error getting unknown validator")
+	}
+}
+
+// testAdmitter records the manifests it is asked to admit and denies those
+// with revoked digests or put to a denied repository.
+type testAdmitter struct {
+	admitted []admission
+	revoked  map[digest.Digest]bool
+	denied   string
+}
+
+type admission struct {
+	name, tag string
+	manifest  *SignedManifest
+}
+
+var errTestDenied = fmt.Errorf("denied")
+
+// admit is an AdmitFunc recording the admitted manifests.
+func (ta *testAdmitter) admit(name, tag string, manifest *SignedManifest) error {
+	dgst, err := manifest.Digest()
+	if err != nil {
+		return err
+	}
+
+	if name == ta.denied || ta.revoked[dgst] {
+		return errTestDenied
+	}
+
+	ta.admitted = append(ta.admitted, admission{name: name, tag: tag, manifest: manifest})
+	return nil
+}
+
+// TestManifestAdmission ensures that revisions are admitted as stored when a
+// tag is pointed back at them and when they are copied, and that denied
+// revisions are not stored.
+func TestManifestAdmission(t *testing.T) {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	registryKey, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error generating private key: %v", err)
+	}
+
+	admitter := &testAdmitter{
+		revoked: make(map[digest.Digest]bool),
+		denied:  "denied/bar",
+	}
+
+	driver := inmemory.New()
+	ss, err := NewServicesWithOptions(driver, Options{
+		SigningKey: registryKey,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating services: %v", err)
+	}
+
+	name := "foo/bar"
+	_, layer, _, err := writeRandomLayer(driver, ss.pathMapper, name)
+	if err != nil {
+		t.Fatalf("unexpected error writing layer: %v", err)
+	}
+
+	_, other, _, err := writeRandomLayer(driver, ss.pathMapper, name)
+	if err != nil {
+		t.Fatalf("unexpected error writing layer: %v", err)
+	}
+
+	ms := ss.Manifests()
+	original := signTestManifest(t, pk, name, "latest", layer)
+	if err := ms.Put(name, "latest", original); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	first, err := original.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	updated := signTestManifest(t, pk, name, "latest", layer, other)
+	if err := ms.Put(name, "latest", updated); err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+
+	second, err := updated.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest digest: %v", err)
+	}
+
+	// Rolling back to a revision that is no longer admitted fails.
+	admitter.revoked[first] = true
+	if err := ms.Retag(name, "latest", first, admitter.admit); err != errTestDenied {
+		t.Fatalf("expected denied retag to fail: %v", err)
+	}
+
+	checkTagHistory(t, ms.(*manifestStore), name, "latest", first, second)
+
+	delete(admitter.revoked, first)
+	if err := ms.Retag(name, "latest", first, admitter.admit); err != nil {
+		t.Fatalf("unexpected error retagging manifest: %v", err)
+	}
+
+	if len(admitter.admitted) != 1 || admitter.admitted[0].name != name || admitter.admitted[0].tag != "latest" {
+		t.Fatalf("unexpected admissions of retag: %+v", admitter.admitted)
+	}
+
+	// Copies are admitted under the new name, as re-signed by the registry.
+	admitter.admitted = nil
+	if err := ss.Copy(name, "foo/baz", admitter.admit); err != nil {
+		t.Fatalf("unexpected error copying repository: %v", err)
+	}
+
+	if len(admitter.admitted) != 2 {
+		t.Fatalf("unexpected admissions: %d != 2", len(admitter.admitted))
+	}
+
+	for _, admitted := range admitter.admitted {
+		if admitted.name != "foo/baz" {
+			t.Fatalf("unexpected admission of copy: %+v", admitted)
+		}
+
+		keys, err := admitted.manifest.Verify()
+		if err != nil {
+			t.Fatalf("expected admitted manifest to be signed: %v", err)
+		}
+
+		if !signedBy(keys, registryKey) {
+			t.Fatalf("expected admitted manifest to be signed by the registry: %v", keys)
+		}
+	}
+
+	if err := ss.Copy(name, admitter.denied, admitter.admit); err != errTestDenied {
+		t.Fatalf("expected denied copy to fail: %v", err)
+	}
+
+	checkRepositoryNotExists(t, ss, admitter.denied)
+}
+
+// testHistory returns the history of a chain of n images, from the top
+// image to the base image, for the layers of test manifests.
+func testHistory(n int) []ManifestHistory {
+	history := make([]ManifestHistory, n)
+	for i := range history {
+		image := fmt.Sprintf(`{"id": "%064d"`, n-i)
+		if i+1 < n {
+			image += fmt.Sprintf(`, "parent": "%064d"`, n-i-1)
+		}
+
+		history[i] = ManifestHistory{V1Compatibility: image + "}"}
+	}
+
+	return history
+}
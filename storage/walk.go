@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker-registry/storagedriver"
+)
+
+// errSkipDir can be returned from a walkFn to indicate that the directory
+// named in the call should not be descended into. It is never returned as an
+// error by walk.
+var errSkipDir = fmt.Errorf("skip this directory")
+
+// walkFn is called by walk for each entry found under the walked path. If
+// the entry is a directory, returning errSkipDir prevents walk from
+// descending into it. Any other error aborts the walk.
+type walkFn func(fileInfo storagedriver.FileInfo) error
+
+// walk recursively descends from the path, calling f for each file and
+// directory, in lexical order. The root path itself is not passed to f.
+//
+// The storagedriver has no native walk support, so this is implemented with
+// List and Stat calls. This can be slow on remote backends and should only be
+// used for maintenance operations.
+func walk(driver storagedriver.StorageDriver, from string, f walkFn) error {
+	children, err := driver.List(from)
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(children)
+
+	for _, child := range children {
+		fileInfo, err := driver.Stat(child)
+		if err != nil {
+			return err
+		}
+
+		err = f(fileInfo)
+		if err == errSkipDir {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if fileInfo.IsDir() {
+			if err := walk(driver, child, f); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// walkRepositories calls f with the name of each repository in the storage
+// backend. A repository is identified as a directory under the repositories
+// root that has a manifests or layers child. Since repository names may be
+// nested, walking continues into the other children of a repository
+// directory. Names are not passed to f in any particular order.
+func walkRepositories(driver storagedriver.StorageDriver, pm *pathMapper, f func(name string) error) error {
+	root, err := pm.path(repositoriesRootPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	if err := walkRepositoriesFrom(driver, root, root, f); err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, *storagedriver.PathNotFoundError:
+			// No repositories have been created, yet.
+			return nil
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkRepositoriesFrom(driver storagedriver.StorageDriver, root, dir string, f func(name string) error) error {
+	children, err := driver.List(dir)
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(children)
+
+	var isRepository bool
+	var subdirs []string
+	for _, child := range children {
+		switch path.Base(child) {
+		case "manifests", "layers":
+			isRepository = true
+		case "usage":
+			// Recorded usage of the repository or namespace.
+		default:
+			fi, err := driver.Stat(child)
+			if err != nil {
+				return err
+			}
+
+			if fi.IsDir() {
+				subdirs = append(subdirs, child)
+			}
+		}
+	}
+
+	if isRepository {
+		if err := f(strings.TrimPrefix(dir, root+"/")); err != nil {
+			return err
+		}
+	}
+
+	for _, subdir := range subdirs {
+		if err := walkRepositoriesFrom(driver, root, subdir, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkRepositoriesAfter calls f with the name of each repository under dir
+// sorting after last, in lexical order. Directories holding only names up to
+// last are not listed, so that a caller paging through the repositories
+// does not walk the pages before. Returning an error from f stops the walk.
+func walkRepositoriesAfter(driver storagedriver.StorageDriver, root, dir, last string, f func(name string) error) error {
+	children, err := driver.List(dir)
+	if err != nil {
+		return err
+	}
+
+	// A name sorts before the names nested under it, but not always
+	// directly: foo < foo-bar < foo/bar. Each child is visited in the order
+	// of its name, to check whether it is a repository, and of its name
+	// followed by a slash, to walk the names nested under it.
+	var keys []string
+	for _, child := range children {
+		switch path.Base(child) {
+		case "manifests", "layers", "usage":
+			continue
+		}
+
+		name := strings.TrimPrefix(child, root+"/")
+		keys = append(keys, name, name+"/")
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := strings.TrimSuffix(key, "/")
+		if key == name && name <= last {
+			continue
+		}
+
+		if key != name && key < last && !strings.HasPrefix(last, key) {
+			// All names nested under the child sort before last.
+			continue
+		}
+
+		child := path.Join(root, name)
+		fi, err := driver.Stat(child)
+		if err != nil {
+			return err
+		}
+
+		if !fi.IsDir() {
+			continue
+		}
+
+		if key != name {
+			if err := walkRepositoriesAfter(driver, root, child, last, f); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		isRepository, err := isRepositoryDir(driver, child)
+		if err != nil {
+			return err
+		}
+
+		if isRepository {
+			if err := f(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isRepositoryDir returns true if dir has a manifests or layers child.
+func isRepositoryDir(driver storagedriver.StorageDriver, dir string) (bool, error) {
+	children, err := driver.List(dir)
+	if err != nil {
+		return false, err
+	}
+
+	for _, child := range children {
+		switch path.Base(child) {
+		case "manifests", "layers":
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
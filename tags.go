@@ -2,9 +2,13 @@ package registry
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
 
 	"github.com/docker/docker-registry/api/v2"
+	"github.com/docker/docker-registry/digest"
 	"github.com/docker/docker-registry/storage"
 	"github.com/gorilla/handlers"
 )
@@ -30,9 +34,18 @@ type tagsAPIResponse struct {
 	Tags []string `json:"tags"`
 }
 
-// GetTags returns a json list of tags for a specific image name.
+// GetTags returns a json list of tags for a specific image name, in lexical
+// order. The list is paginated if the "n" parameter is provided.
 func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
+
+	n, last, err := paginationParameters(r)
+	if err != nil {
+		th.Errors.Push(v2.ErrorCodePaginationInvalid, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	manifests := th.services.Manifests()
 
 	tags, err := manifests.Tags(th.Name)
@@ -47,6 +60,32 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Skip the tags up to and including last.
+	i := sort.SearchStrings(tags, last)
+	if i < len(tags) && tags[i] == last {
+		i++
+	}
+	tags = tags[i:]
+
+	if n > 0 && len(tags) > n {
+		tags = tags[:n]
+
+		nextURL, err := th.urlBuilder.BuildTagsURL(th.Name, url.Values{
+			"n":    []string{fmt.Sprint(n)},
+			"last": []string{tags[n-1]},
+		})
+		if err != nil {
+			th.Errors.PushErr(err)
+			return
+		}
+
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL))
+	}
+
+	if tags == nil {
+		tags = []string{}
+	}
+
 	enc := json.NewEncoder(w)
 	if err := enc.Encode(tagsAPIResponse{
 		Name: th.Name,
@@ -56,3 +95,106 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// tagHistoryDispatcher constructs the tag history handler api endpoint.
+func tagHistoryDispatcher(ctx *Context, r *http.Request) http.Handler {
+	tagHistoryHandler := &tagHistoryHandler{
+		Context: ctx,
+		Tag:     ctx.vars["tag"],
+	}
+
+	tagHistoryHandler.log = tagHistoryHandler.log.WithField("tag", tagHistoryHandler.Tag)
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(tagHistoryHandler.GetTagHistory),
+		"PUT": http.HandlerFunc(tagHistoryHandler.PutTagRevision),
+	}
+}
+
+// tagHistoryHandler handles requests for the history of a tag.
+type tagHistoryHandler struct {
+	*Context
+
+	Tag string
+}
+
+type tagHistoryAPIResponse struct {
+	Name    string                  `json:"name"`
+	Tag     string                  `json:"tag"`
+	History []storage.TagIndexEntry `json:"history"`
+}
+
+type tagRevisionAPIRequest struct {
+	Revision digest.Digest `json:"revision"`
+}
+
+// GetTagHistory returns the revisions referenced by the tag, oldest first.
+func (thh *tagHistoryHandler) GetTagHistory(w http.ResponseWriter, r *http.Request) {
+	manifests := thh.services.Manifests()
+
+	history, err := manifests.TagHistory(thh.Name, thh.Tag)
+	if err != nil {
+		switch err := err.(type) {
+		case storage.ErrUnknownManifest:
+			w.WriteHeader(http.StatusNotFound)
+			thh.Errors.Push(v2.ErrorCodeManifestUnknown, err)
+		default:
+			thh.Errors.PushErr(err)
+		}
+		return
+	}
+
+	if history == nil {
+		history = []storage.TagIndexEntry{}
+	}
+
+	if err := serveJSON(w, tagHistoryAPIResponse{
+		Name:    thh.Name,
+		Tag:     thh.Tag,
+		History: history,
+	}); err != nil {
+		thh.Errors.PushErr(err)
+		return
+	}
+}
+
+// PutTagRevision points the tag at a revision from its history.
+func (thh *tagHistoryHandler) PutTagRevision(w http.ResponseWriter, r *http.Request) {
+	var req tagRevisionAPIRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		thh.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Revision.Validate(); err != nil {
+		thh.Errors.Push(v2.ErrorCodeDigestInvalid, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	manifests := thh.services.ManifestsAs(thh.identity)
+	if err := manifests.Retag(thh.Name, thh.Tag, req.Revision, thh.admission.admitFunc(thh.identity)); err != nil {
+		switch err := err.(type) {
+		case storage.ErrUnknownManifest, storage.ErrUnknownManifestRevision:
+			thh.Errors.Push(v2.ErrorCodeManifestUnknown, err)
+			w.WriteHeader(http.StatusNotFound)
+		case storage.ErrTagImmutable:
+			thh.Errors.Push(v2.ErrorCodeTagImmutable, err)
+			w.WriteHeader(http.StatusConflict)
+		case errAdmissionDenied:
+			thh.Errors.Push(v2.ErrorCodeManifestDenied, err)
+			w.WriteHeader(http.StatusForbidden)
+		case errAdmissionUnavailable:
+			thh.Errors.Push(v2.ErrorCodeAdmissionUnavailable, err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			thh.Errors.PushErr(err)
+		}
+		return
+	}
+
+	w.Header().Set("Docker-Content-Digest", req.Revision.String())
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,44 @@
+package registry
+
+import (
+	"net/http"
+
+	"github.com/docker/docker-registry/storage"
+	"github.com/gorilla/handlers"
+)
+
+// usageDispatcher constructs the usage handler api endpoint.
+func usageDispatcher(ctx *Context, r *http.Request) http.Handler {
+	usageHandler := &usageHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(usageHandler.GetUsage),
+	}
+}
+
+// usageHandler handles requests for the storage usage of a repository.
+type usageHandler struct {
+	*Context
+}
+
+// GetUsage returns the bytes stored by the repository and the quotas that
+// apply to it. Repositories without any content report no usage.
+func (uh *usageHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := uh.services.Usage(uh.Name)
+	if err != nil {
+		uh.Errors.PushErr(err)
+		return
+	}
+
+	if usage.Quotas == nil {
+		// Always report a list, so clients need not distinguish null.
+		usage.Quotas = []storage.Quota{}
+	}
+
+	if err := serveJSON(w, usage); err != nil {
+		uh.Errors.PushErr(err)
+		return
+	}
+}